@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+
+	"3xui-bot/internal/adapters/payment"
+)
+
+func main() {
+	var addr, publicURL, webhookURL string
+	flag.StringVar(&addr, "addr", ":8081", "Address to listen on")
+	flag.StringVar(&publicURL, "public-url", "http://localhost:8081", "Public URL used in confirmation links")
+	flag.StringVar(&webhookURL, "webhook-url", "", "Bot webhook URL to deliver payment notifications to")
+	flag.Parse()
+
+	stub := payment.NewYooKassaStub(
+		publicURL,
+		os.Getenv("PAYMENT_SHOP_ID"),
+		os.Getenv("PAYMENT_SECRET_KEY"),
+		webhookURL,
+		os.Getenv("PAYMENT_WEBHOOK_SECRET"),
+	)
+
+	log.Printf("YooKassa stub listening on %s (API: %s/v3)", addr, publicURL)
+
+	if err := http.ListenAndServe(addr, stub.Handler()); err != nil {
+		log.Fatalf("Stub server error: %v", err)
+	}
+}
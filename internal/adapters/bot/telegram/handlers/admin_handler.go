@@ -0,0 +1,490 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/usecase"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const refundUsageText = "Использование:\n" +
+	"/refund <payment_id> [сумма] <причина> - возврат (без суммы - полный)\n" +
+	"/refunds <payment_id> - история возвратов\n" +
+	"/receipt <payment_id> - повторно отправить чек"
+
+const promoUsageText = "Использование:\n" +
+	"/promo_create <код> <percent|fixed> <значение> [days=N] [uses=N] [per_user=N] [until=ДД.ММ.ГГГГ] [plans=id1,id2]\n" +
+	"/promos - список промокодов\n" +
+	"/promo_disable <код> - отключить промокод\n" +
+	"/promo_enable <код> - включить промокод"
+
+const reconcileUsageText = "Использование:\n" +
+	"/reconcile - сверка подключений с панелями без изменений\n" +
+	"/reconcile apply - сверка с исправлением расхождений"
+
+type AdminHandler struct {
+	bot       *tgbotapi.BotAPI
+	paymentUC *usecase.PaymentUseCase
+	promoUC   *usecase.PromoCodeUseCase
+	reconUC   *usecase.ReconciliationUseCase
+	adminIDs  map[int64]struct{}
+}
+
+func NewAdminHandler(
+	bot *tgbotapi.BotAPI,
+	paymentUC *usecase.PaymentUseCase,
+	promoUC *usecase.PromoCodeUseCase,
+	reconUC *usecase.ReconciliationUseCase,
+	adminIDs []int64,
+) *AdminHandler {
+	ids := make(map[int64]struct{}, len(adminIDs))
+	for _, adminID := range adminIDs {
+		ids[adminID] = struct{}{}
+	}
+
+	return &AdminHandler{
+		bot:       bot,
+		paymentUC: paymentUC,
+		promoUC:   promoUC,
+		reconUC:   reconUC,
+		adminIDs:  ids,
+	}
+}
+
+func (h *AdminHandler) IsAdmin(userID int64) bool {
+	_, ok := h.adminIDs[userID]
+
+	return ok
+}
+
+func (h *AdminHandler) HandleRefund(ctx context.Context, message *tgbotapi.Message) error {
+	args := strings.Fields(message.CommandArguments())
+	if len(args) < 2 {
+
+		return h.reply(message.Chat.ID, refundUsageText)
+	}
+
+	dto := usecase.RefundPaymentDTO{
+		PaymentID: args[0],
+		AdminID:   message.From.ID,
+	}
+
+	reasonArgs := args[1:]
+	if amount, err := strconv.ParseFloat(strings.ReplaceAll(args[1], ",", "."), 64); err == nil {
+		dto.Amount = amount
+		reasonArgs = args[2:]
+	}
+	dto.Reason = strings.Join(reasonArgs, " ")
+
+	if dto.Amount < 0 || dto.Reason == "" {
+
+		return h.reply(message.Chat.ID, refundUsageText)
+	}
+
+	slog.Info("Admin refund requested", "admin_id", dto.AdminID, "payment_id", dto.PaymentID, "amount", dto.Amount)
+
+	result, err := h.paymentUC.RefundPayment(ctx, dto)
+	if err != nil {
+		slog.Error("Failed to refund payment", "admin_id", dto.AdminID, "payment_id", dto.PaymentID, "error", err)
+
+		if errors.Is(err, usecase.ErrRefundPending) {
+
+			return h.reply(message.Chat.ID, h.pendingRefundText(ctx, dto.PaymentID, err))
+		}
+
+		return h.reply(message.Chat.ID, refundErrorText(err))
+	}
+
+	var text strings.Builder
+	text.WriteString("✅ Возврат выполнен\n\n")
+	text.WriteString(fmt.Sprintf("Платеж: %s\n", result.Payment.ID))
+	text.WriteString(fmt.Sprintf("Пользователь: %d\n", result.Payment.UserID))
+	text.WriteString(fmt.Sprintf("Сумма возврата: %s\n", formatRefundAmount(result.Payment.Currency, result.Refund.Amount)))
+	text.WriteString(fmt.Sprintf("Статус платежа: %s\n", result.Payment.Status))
+	text.WriteString(fmt.Sprintf("ID возврата: %s\n", result.Refund.ExternalRefundID))
+
+	switch {
+	case result.Subscription == nil:
+		text.WriteString("Подписка: не найдена\n")
+	case result.Subscription.IsActive:
+		text.WriteString(fmt.Sprintf("Подписка: сокращена до %s\n", result.Subscription.EndDate.Format("02.01.2006 15:04")))
+	default:
+		text.WriteString("Подписка: деактивирована\n")
+	}
+
+	if result.VPNUpdateErr != nil {
+		text.WriteString(fmt.Sprintf("\n⚠️ Не удалось обновить пользователя в панели: %v", result.VPNUpdateErr))
+	}
+
+	return h.reply(message.Chat.ID, text.String())
+}
+
+func (h *AdminHandler) pendingRefundText(ctx context.Context, paymentID string, err error) string {
+	payment, paymentErr := h.paymentUC.GetPayment(ctx, paymentID)
+	refunds, refundsErr := h.paymentUC.GetPaymentRefunds(ctx, paymentID)
+	if paymentErr != nil || refundsErr != nil {
+
+		return refundErrorText(err)
+	}
+
+	for _, refund := range refunds {
+		if !refund.IsPending() {
+			continue
+		}
+
+		return fmt.Sprintf("⏳ По платежу %s уже есть незавершенный возврат на %s\nПричина: %s\n\n"+
+			"Новый возврат не создан. Чтобы завершить начатый, повторите команду с той же суммой и причиной:\n/refund %s %s %s",
+			payment.ID,
+			formatRefundAmount(payment.Currency, refund.Amount),
+			refund.Reason,
+			payment.ID,
+			strconv.FormatFloat(refund.Amount, 'f', -1, 64),
+			refund.Reason,
+		)
+	}
+
+	return refundErrorText(err)
+}
+
+func (h *AdminHandler) HandleRefundHistory(ctx context.Context, message *tgbotapi.Message) error {
+	paymentID := strings.TrimSpace(message.CommandArguments())
+	if paymentID == "" {
+
+		return h.reply(message.Chat.ID, refundUsageText)
+	}
+
+	payment, err := h.paymentUC.GetPayment(ctx, paymentID)
+	if err != nil {
+		if errors.Is(err, usecase.ErrNotFound) {
+
+			return h.reply(message.Chat.ID, "❌ Платеж не найден")
+		}
+
+		return fmt.Errorf("failed to get payment: %w", err)
+	}
+
+	refunds, err := h.paymentUC.GetPaymentRefunds(ctx, paymentID)
+	if err != nil {
+
+		return fmt.Errorf("failed to get refunds: %w", err)
+	}
+
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("💸 Возвраты по платежу %s\n", payment.ID))
+	text.WriteString(fmt.Sprintf("Сумма платежа: %s, статус: %s\n\n", formatRefundAmount(payment.Currency, payment.Amount), payment.Status))
+
+	if len(refunds) == 0 {
+		text.WriteString("Возвратов не было")
+	}
+
+	for i, refund := range refunds {
+		initiator := "автоматически"
+		if refund.AdminID != 0 {
+			initiator = fmt.Sprintf("админ %d", refund.AdminID)
+		}
+
+		if refund.IsPending() {
+			initiator += ", ⏳ ожидает подтверждения провайдера"
+		}
+
+		text.WriteString(fmt.Sprintf("%d. %s - %s, %s\n   Причина: %s\n",
+			i+1,
+			refund.CreatedAt.Format("02.01.2006 15:04"),
+			formatRefundAmount(payment.Currency, refund.Amount),
+			initiator,
+			refund.Reason,
+		))
+	}
+
+	return h.reply(message.Chat.ID, text.String())
+}
+
+func (h *AdminHandler) HandleResendReceipt(ctx context.Context, message *tgbotapi.Message) error {
+	paymentID := strings.TrimSpace(message.CommandArguments())
+	if paymentID == "" {
+
+		return h.reply(message.Chat.ID, refundUsageText)
+	}
+
+	slog.Info("Admin receipt resend requested", "admin_id", message.From.ID, "payment_id", paymentID)
+
+	payment, err := h.paymentUC.ResendReceipt(ctx, paymentID)
+	if err != nil {
+		slog.Error("Receipt resend failed", "payment_id", paymentID, "error", err)
+
+		return h.reply(message.Chat.ID, receiptErrorText(err))
+	}
+
+	return h.reply(message.Chat.ID, fmt.Sprintf("🧾 Чек по платежу %s отправлен на %s\nСтатус: %s", payment.ID, payment.ReceiptEmail, payment.ReceiptStatus))
+}
+
+func (h *AdminHandler) HandleReconcile(ctx context.Context, message *tgbotapi.Message) error {
+	args := strings.Fields(message.CommandArguments())
+	if len(args) > 1 || (len(args) == 1 && args[0] != "apply") {
+
+		return h.reply(message.Chat.ID, reconcileUsageText)
+	}
+
+	dryRun := len(args) == 0
+
+	slog.Info("Admin reconciliation requested", "admin_id", message.From.ID, "dry_run", dryRun)
+
+	report, err := h.reconUC.Reconcile(ctx, dryRun)
+	if err != nil {
+		slog.Error("Failed to reconcile VPN connections", "admin_id", message.From.ID, "error", err)
+
+		return h.reply(message.Chat.ID, fmt.Sprintf("❌ Не удалось выполнить сверку: %v", err))
+	}
+
+	return h.reply(message.Chat.ID, usecase.ReconciliationSummaryText(report))
+}
+
+func (h *AdminHandler) HandlePromoCommand(ctx context.Context, message *tgbotapi.Message) error {
+	switch message.Command() {
+	case "promo_create":
+
+		return h.handlePromoCreate(ctx, message)
+	case "promo_enable":
+
+		return h.handlePromoSetActive(ctx, message, true)
+	case "promo_disable":
+
+		return h.handlePromoSetActive(ctx, message, false)
+	default:
+
+		return h.handlePromoList(ctx, message)
+	}
+}
+
+func (h *AdminHandler) handlePromoCreate(ctx context.Context, message *tgbotapi.Message) error {
+	dto, err := parsePromoCreateArgs(strings.Fields(message.CommandArguments()))
+	if err != nil {
+
+		return h.reply(message.Chat.ID, fmt.Sprintf("❌ %v\n\n%s", err, promoUsageText))
+	}
+
+	promo, err := h.promoUC.CreatePromoCode(ctx, dto)
+	if errors.Is(err, usecase.ErrPromoCodeAlreadyExists) {
+
+		return h.reply(message.Chat.ID, "❌ Такой промокод уже существует")
+	}
+	if errors.Is(err, usecase.ErrInvalidInput) {
+
+		return h.reply(message.Chat.ID, fmt.Sprintf("❌ Некорректные параметры: %v\n\n%s", err, promoUsageText))
+	}
+	if err != nil {
+
+		return fmt.Errorf("failed to create promo code: %w", err)
+	}
+
+	slog.Info("Promo code created", "admin_id", message.From.ID, "code", promo.Code)
+
+	return h.reply(message.Chat.ID, "✅ Промокод создан\n\n"+formatPromoCode(promo))
+}
+
+func (h *AdminHandler) handlePromoSetActive(ctx context.Context, message *tgbotapi.Message, isActive bool) error {
+	code := strings.TrimSpace(message.CommandArguments())
+	if code == "" {
+
+		return h.reply(message.Chat.ID, promoUsageText)
+	}
+
+	promo, err := h.promoUC.SetPromoCodeActive(ctx, code, isActive)
+	if errors.Is(err, usecase.ErrNotFound) {
+
+		return h.reply(message.Chat.ID, "❌ Промокод не найден")
+	}
+	if err != nil {
+
+		return fmt.Errorf("failed to update promo code: %w", err)
+	}
+
+	slog.Info("Promo code updated", "admin_id", message.From.ID, "code", promo.Code, "is_active", isActive)
+
+	return h.reply(message.Chat.ID, "✅ Промокод обновлен\n\n"+formatPromoCode(promo))
+}
+
+func (h *AdminHandler) handlePromoList(ctx context.Context, message *tgbotapi.Message) error {
+	promos, err := h.promoUC.GetPromoCodes(ctx)
+	if err != nil {
+
+		return fmt.Errorf("failed to get promo codes: %w", err)
+	}
+
+	if len(promos) == 0 {
+
+		return h.reply(message.Chat.ID, "Промокодов пока нет\n\n"+promoUsageText)
+	}
+
+	var text strings.Builder
+	text.WriteString("🎟 Промокоды\n\n")
+	for _, promo := range promos {
+		text.WriteString(formatPromoCode(promo))
+		text.WriteString("\n\n")
+	}
+
+	return h.reply(message.Chat.ID, text.String())
+}
+
+func parsePromoCreateArgs(args []string) (usecase.CreatePromoCodeDTO, error) {
+	dto := usecase.CreatePromoCodeDTO{MaxUsesPerUser: 1}
+	if len(args) < 3 {
+
+		return dto, fmt.Errorf("не хватает параметров")
+	}
+
+	dto.Code = args[0]
+	dto.DiscountType = core.PromoDiscountType(strings.ToLower(args[1]))
+
+	value, err := strconv.ParseFloat(strings.ReplaceAll(args[2], ",", "."), 64)
+	if err != nil {
+
+		return dto, fmt.Errorf("некорректное значение скидки: %s", args[2])
+	}
+	dto.DiscountValue = value
+
+	for _, arg := range args[3:] {
+		key, val, ok := strings.Cut(arg, "=")
+		if !ok {
+
+			return dto, fmt.Errorf("некорректный параметр: %s", arg)
+		}
+
+		switch key {
+		case "days", "uses", "per_user":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+
+				return dto, fmt.Errorf("некорректное число в параметре %s", key)
+			}
+
+			switch key {
+			case "days":
+				dto.BonusDays = n
+			case "uses":
+				dto.MaxUses = n
+			default:
+				dto.MaxUsesPerUser = n
+			}
+		case "until":
+			expiresAt, err := time.ParseInLocation("02.01.2006", val, time.Local)
+			if err != nil {
+
+				return dto, fmt.Errorf("некорректная дата: %s", val)
+			}
+			expiresAt = expiresAt.AddDate(0, 0, 1)
+			dto.ExpiresAt = &expiresAt
+		case "plans":
+			for _, planID := range strings.Split(val, ",") {
+				if planID = strings.TrimSpace(planID); planID != "" {
+					dto.PlanIDs = append(dto.PlanIDs, planID)
+				}
+			}
+		default:
+
+			return dto, fmt.Errorf("неизвестный параметр: %s", key)
+		}
+	}
+
+	return dto, nil
+}
+
+func formatPromoCode(promo *core.PromoCode) string {
+	var text strings.Builder
+
+	status := "✅"
+	if !promo.IsActive || promo.IsExpired() || promo.IsExhausted() {
+		status = "❌"
+	}
+	text.WriteString(fmt.Sprintf("%s %s: ", status, promo.Code))
+
+	if core.PromoDiscountType(promo.DiscountType) == core.PromoDiscountPercent {
+		text.WriteString(fmt.Sprintf("скидка %.0f%%", promo.DiscountValue))
+	} else {
+		text.WriteString(fmt.Sprintf("скидка %.2f ₽", promo.DiscountValue))
+	}
+	if promo.BonusDays > 0 {
+		text.WriteString(fmt.Sprintf(", +%d дн.", promo.BonusDays))
+	}
+
+	uses := "∞"
+	if promo.MaxUses > 0 {
+		uses = strconv.Itoa(promo.MaxUses)
+	}
+	text.WriteString(fmt.Sprintf("\nИспользований: %d/%s", promo.UsedCount, uses))
+	if promo.MaxUsesPerUser > 0 {
+		text.WriteString(fmt.Sprintf(", на пользователя: %d", promo.MaxUsesPerUser))
+	}
+
+	if promo.ExpiresAt != nil {
+		text.WriteString(fmt.Sprintf("\nДействует до: %s", promo.ExpiresAt.Format("02.01.2006 15:04")))
+	}
+	if len(promo.PlanIDs) > 0 {
+		text.WriteString(fmt.Sprintf("\nТарифы: %s", strings.Join(promo.PlanIDs, ", ")))
+	}
+
+	return text.String()
+}
+
+func (h *AdminHandler) reply(chatID int64, text string) error {
+	_, err := h.bot.Send(tgbotapi.NewMessage(chatID, text))
+
+	return err
+}
+
+func refundErrorText(err error) string {
+	switch {
+	case errors.Is(err, usecase.ErrNotFound):
+
+		return "❌ Платеж не найден"
+	case errors.Is(err, usecase.ErrPaymentNotRefundable):
+
+		return "❌ Этот платеж нельзя вернуть: он не оплачен или уже полностью возвращен"
+	case errors.Is(err, usecase.ErrRefundPending):
+
+		return "⏳ По этому платежу уже есть незавершенный возврат с другой суммой или причиной. Посмотрите его в /refunds и повторите команду с теми же данными"
+	case errors.Is(err, usecase.ErrInvalidAmount):
+
+		return "❌ Некорректная сумма возврата. Она не может превышать остаток платежа, а платежи в Stars возвращаются только полностью"
+	case errors.Is(err, usecase.ErrInvalidInput):
+
+		return "❌ Укажите причину возврата\n\n" + refundUsageText
+	default:
+
+		return fmt.Sprintf("❌ Не удалось выполнить возврат: %v", err)
+	}
+}
+
+func receiptErrorText(err error) string {
+	switch {
+	case errors.Is(err, usecase.ErrNotFound):
+
+		return "❌ Платеж не найден"
+	case errors.Is(err, usecase.ErrReceiptNotAvailable):
+
+		return "❌ Чек можно отправить только по оплаченному платежу картой или СБП"
+	case errors.Is(err, usecase.ErrReceiptEmailRequired):
+
+		return "❌ У пользователя не указан email для чека"
+	default:
+
+		return fmt.Sprintf("❌ Не удалось отправить чек: %v", err)
+	}
+}
+
+func formatRefundAmount(currency string, amount float64) string {
+	if currency == core.CurrencyStars {
+
+		return fmt.Sprintf("%.0f ⭐", amount)
+	}
+
+	return fmt.Sprintf("%.2f ₽", amount)
+}
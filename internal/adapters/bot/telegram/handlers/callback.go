@@ -21,10 +21,12 @@ func NewCallbackHandler(
 	vpnUC *usecase.VPNUseCase,
 	referralUC *usecase.ReferralUseCase,
 	notifUC *usecase.NotificationUseCase,
+	balanceUC *usecase.BalanceUseCase,
+	trafficUC *usecase.TrafficUseCase,
 	bot *tgbotapi.BotAPI,
 ) *CallbackHandler {
 	msgService := service.NewMessageService(bot)
-	router := callback.NewRouter(userUC, subUC, paymentUC, vpnUC, referralUC, notifUC, msgService)
+	router := callback.NewRouter(userUC, subUC, paymentUC, vpnUC, referralUC, notifUC, balanceUC, trafficUC, msgService)
 
 	return &CallbackHandler{
 		router: router,
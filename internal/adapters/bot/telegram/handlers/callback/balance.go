@@ -0,0 +1,118 @@
+package callback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"3xui-bot/internal/adapters/bot/telegram/ui"
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/usecase"
+)
+
+var topUpAmounts = []int{100, 300, 500, 1000}
+
+func (h *BaseHandler) HandleOpenBalance(ctx context.Context, userID, chatID int64, messageID int) error {
+	slog.Info("Handling open balance", "user_id", userID)
+
+	balance, err := h.balanceUC.GetBalance(ctx, userID)
+	if err != nil {
+		h.logError(err, "GetBalance")
+
+		return h.sendError(chatID, "❌ Не удалось получить баланс. Попробуйте позже.")
+	}
+
+	transactions, err := h.balanceUC.GetRecentTransactions(ctx, userID)
+	if err != nil {
+		h.logError(err, "GetRecentTransactions")
+	}
+
+	text := ui.GetBalanceText(balance, transactions)
+	keyboard := ui.GetBalanceKeyboard(topUpAmounts)
+
+	return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, text, keyboard)
+}
+
+func (h *BaseHandler) HandleTopUpAmount(ctx context.Context, userID, chatID int64, messageID int, amount int) error {
+	slog.Info("Handling top up amount", "amount", amount, "user_id", userID)
+
+	h.cancelEmailInput(userID)
+
+	return h.msg.EditMessageText(ctx, chatID, messageID, ui.GetTopUpMethodText(amount), ui.GetTopUpMethodKeyboard(amount))
+}
+
+func (h *BaseHandler) HandleTopUpPayment(ctx context.Context, userID, chatID int64, messageID int, amount int, method core.PaymentMethod) error {
+	slog.Info("Handling top up payment", "amount", amount, "method", method, "user_id", userID)
+
+	payment, paymentURL, err := h.paymentUC.CreateTopUpPayment(ctx, userID, float64(amount), method)
+	if errors.Is(err, usecase.ErrInvalidAmount) {
+		text := fmt.Sprintf("❌ Сумма пополнения должна быть от %.0f₽ до %.0f₽", usecase.MinTopUpAmount, usecase.MaxTopUpAmount)
+
+		return h.msg.EditMessageText(ctx, chatID, messageID, text, ui.GetBalanceKeyboard(topUpAmounts))
+	}
+	if errors.Is(err, usecase.ErrReceiptEmailRequired) {
+
+		return h.requestReceiptEmail(ctx, userID, chatID, messageID, topUpCallback(amount, method), fmt.Sprintf("%s%d", ui.CallbackPrefixTopUpAmount, amount))
+	}
+	if err != nil {
+		h.logError(err, "CreateTopUpPayment")
+
+		return h.sendError(chatID, "❌ Не удалось создать платеж. Попробуйте позже.")
+	}
+
+	slog.Info("Top up payment created", "payment_id", payment.ID, "external_id", payment.ExternalID, "method", method, "user_id", userID)
+
+	text := ui.GetTopUpLinkText(payment)
+	keyboard := ui.GetPaymentLinkKeyboard(paymentURL, payment.ID)
+
+	return h.msg.EditMessageText(ctx, chatID, messageID, text, keyboard)
+}
+
+func (h *BaseHandler) HandlePayBalance(ctx context.Context, userID, chatID int64, messageID int, planID string) error {
+	slog.Info("Handling pay from balance", "plan_id", planID, "user_id", userID)
+
+	result, err := h.paymentUC.PayPlanFromBalance(ctx, userID, planID, h.appliedPromoCode(userID, planID))
+	if text, ok := promoErrorText(err); ok {
+		h.clearAppliedPromo(userID)
+
+		return h.msg.EditMessageText(ctx, chatID, messageID, text, ui.GetPaymentMethodKeyboard(planID, false))
+	}
+	if errors.Is(err, usecase.ErrInsufficientBalance) {
+
+		amount := 0.0
+		if quote, err := h.paymentUC.QuotePlan(ctx, userID, planID, h.appliedPromoCode(userID, planID)); err == nil {
+			amount = quote.Amount
+		}
+
+		return h.showInsufficientBalance(ctx, userID, chatID, messageID, amount, ui.CallbackPrefixSelectPlan+planID)
+	}
+	if errors.Is(err, usecase.ErrPanelUnavailable) {
+		h.logError(err, "PayPlanFromBalance")
+
+		return h.sendError(chatID, ui.GetServerUnavailableText())
+	}
+	if err != nil {
+		h.logError(err, "PayPlanFromBalance")
+
+		return h.sendError(chatID, "❌ Не удалось оплатить подписку с баланса. Попробуйте позже.")
+	}
+
+	h.clearAppliedPromo(userID)
+
+	text := fmt.Sprintf("✅ Оплата с баланса прошла успешно!\n\n🎉 Подписка '%s' активирована на %d дней", result.Plan.Name, result.Plan.Days+result.Payment.BonusDays)
+	if result.VPNConnection != nil {
+		text += fmt.Sprintf("\n🔐 VPN: %s", result.VPNConnection.Name)
+	}
+
+	return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, text, ui.GetBackToSubscriptionsKeyboard())
+}
+
+func (h *BaseHandler) showInsufficientBalance(ctx context.Context, userID, chatID int64, messageID int, amount float64, backCallback string) error {
+	balance, err := h.balanceUC.GetBalance(ctx, userID)
+	if err != nil {
+		h.logError(err, "GetBalance")
+	}
+
+	return h.msg.EditMessageText(ctx, chatID, messageID, ui.GetInsufficientBalanceText(balance, amount), ui.GetInsufficientBalanceKeyboard(backCallback))
+}
@@ -20,11 +20,21 @@ type BaseHandler struct {
 	vpnUC         *usecase.VPNUseCase
 	referralUC    *usecase.ReferralUseCase
 	notifUC       *usecase.NotificationUseCase
+	balanceUC     *usecase.BalanceUseCase
+	trafficUC     *usecase.TrafficUseCase
 	msg           *service.MessageService
 	renamingUsers map[int64]string
+	promoInput    map[int64]string
+	emailInput    map[int64]string
+	appliedPromos map[int64]appliedPromo
 	mu            sync.RWMutex
 }
 
+type appliedPromo struct {
+	planID string
+	code   string
+}
+
 func NewBaseHandler(
 	userUC *usecase.UserUseCase,
 	subUC *usecase.SubscriptionUseCase,
@@ -32,6 +42,8 @@ func NewBaseHandler(
 	vpnUC *usecase.VPNUseCase,
 	referralUC *usecase.ReferralUseCase,
 	notifUC *usecase.NotificationUseCase,
+	balanceUC *usecase.BalanceUseCase,
+	trafficUC *usecase.TrafficUseCase,
 	msg *service.MessageService,
 ) *BaseHandler {
 
@@ -42,8 +54,13 @@ func NewBaseHandler(
 		vpnUC:         vpnUC,
 		referralUC:    referralUC,
 		notifUC:       notifUC,
+		balanceUC:     balanceUC,
+		trafficUC:     trafficUC,
 		msg:           msg,
 		renamingUsers: make(map[int64]string),
+		promoInput:    make(map[int64]string),
+		emailInput:    make(map[int64]string),
+		appliedPromos: make(map[int64]appliedPromo),
 	}
 }
 
@@ -121,7 +138,7 @@ func (h *BaseHandler) getPlans(ctx context.Context) ([]*core.Plan, error) {
 
 func (h *BaseHandler) getVPNConnectionsBySubscriptionID(ctx context.Context, subscriptionID string) ([]*core.VPNConnection, error) {
 
-	return []*core.VPNConnection{}, nil
+	return h.vpnUC.GetSubscriptionVPNWithStats(ctx, subscriptionID)
 }
 
 func (h *BaseHandler) createSubscription(ctx context.Context, dto usecase.CreateSubscriptionDTO) (interface{}, error) {
@@ -36,6 +36,7 @@ func (h *BaseHandler) HandleOpenMenu(ctx context.Context, userID, chatID int64,
 
 func (h *BaseHandler) HandleOpenProfile(ctx context.Context, userID, chatID int64, messageID int) error {
 	slog.Info("Handling open profile", "user_id", userID)
+	h.cancelEmailInput(userID)
 	user, err := h.getUser(ctx, userID)
 	if err != nil {
 		h.logError(err, "GetUser")
@@ -52,7 +53,11 @@ func (h *BaseHandler) HandleOpenProfile(ctx context.Context, userID, chatID int6
 			}
 		}
 	}
-	text := ui.GetProfileText(user, isPremium, "", "")
+	balance, err := h.balanceUC.GetBalance(ctx, userID)
+	if err != nil {
+		h.logError(err, "GetBalance")
+	}
+	text := ui.GetProfileText(user, isPremium, "", "", balance)
 	keyboard := ui.GetProfileKeyboard(isPremium)
 
 	return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, text, keyboard)
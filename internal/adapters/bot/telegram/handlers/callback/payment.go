@@ -0,0 +1,140 @@
+package callback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"3xui-bot/internal/adapters/bot/telegram/ui"
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/usecase"
+)
+
+func (h *BaseHandler) startProviderPayment(ctx context.Context, userID, chatID int64, messageID int, planID string, method core.PaymentMethod) error {
+	plan, err := h.getPlan(ctx, planID)
+	if err != nil {
+		h.logError(err, "GetPlan")
+
+		return err
+	}
+
+	payment, paymentURL, err := h.paymentUC.CreatePaymentForPlan(ctx, userID, planID, method, h.appliedPromoCode(userID, planID))
+	if text, ok := promoErrorText(err); ok {
+		h.clearAppliedPromo(userID)
+
+		return h.msg.EditMessageText(ctx, chatID, messageID, text, ui.GetPaymentMethodKeyboard(planID, false))
+	}
+	if errors.Is(err, usecase.ErrReceiptEmailRequired) {
+
+		return h.requestReceiptEmail(ctx, userID, chatID, messageID, payPlanCallback(planID, method), ui.CallbackPrefixSelectPlan+planID)
+	}
+	if err != nil {
+		h.logError(err, "CreatePaymentForPlan")
+
+		return h.sendError(chatID, "❌ Не удалось создать платеж. Попробуйте позже.")
+	}
+
+	h.clearAppliedPromo(userID)
+
+	if payment.IsCompleted() {
+
+		return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, ui.GetFreePaymentText(plan, payment), ui.GetBackToSubscriptionsKeyboard())
+	}
+
+	slog.Info("Payment created", "payment_id", payment.ID, "external_id", payment.ExternalID, "method", method, "user_id", userID)
+
+	text := ui.GetPaymentLinkText(plan, payment)
+	keyboard := ui.GetPaymentLinkKeyboard(paymentURL, payment.ID)
+
+	return h.msg.EditMessageText(ctx, chatID, messageID, text, keyboard)
+}
+
+func (h *BaseHandler) HandlePaymentCheck(ctx context.Context, userID, chatID int64, messageID int, paymentID string) error {
+	slog.Info("Handling payment check", "payment_id", paymentID, "user_id", userID)
+
+	payment, err := h.paymentUC.GetPayment(ctx, paymentID)
+	if err != nil {
+		h.logError(err, "GetPayment")
+
+		return h.sendError(chatID, "❌ Платеж не найден")
+	}
+
+	if payment.UserID != userID {
+
+		return usecase.ErrUnauthorized
+	}
+
+	status, err := h.paymentUC.CheckPayment(ctx, paymentID)
+	switch {
+	case errors.Is(err, usecase.ErrPaymentAlreadyPaid):
+
+		return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, "✅ Этот платеж уже обработан", ui.GetBackToSubscriptionsKeyboard())
+	case errors.Is(err, usecase.ErrPaymentCancelled), errors.Is(err, usecase.ErrPaymentFailed):
+
+		return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, "❌ Платеж отменен или не прошел. Попробуйте оформить подписку заново.", ui.GetBackToPricingKeyboard())
+	case errors.Is(err, usecase.ErrPanelUnavailable):
+		h.logError(err, "CheckPayment")
+
+		return h.sendError(chatID, "⏳ Оплата получена, но VPN-сервер временно недоступен. Нажмите «Проверить оплату» через несколько минут — подписка будет активирована.")
+	case err != nil:
+		h.logError(err, "CheckPayment")
+
+		return h.sendError(chatID, "❌ Не удалось проверить платеж. Попробуйте позже.")
+	}
+
+	if status != core.PaymentStatusCompleted {
+
+		return h.msg.SendMessage(ctx, chatID, ui.GetPaymentPendingText())
+	}
+
+	if payment.IsTopUp() {
+		text := fmt.Sprintf("✅ Баланс пополнен на %.0f₽", payment.Amount)
+
+		return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, text, ui.GetBackToBalanceKeyboard())
+	}
+
+	plan, err := h.getPlan(ctx, payment.PlanID)
+	if err != nil {
+		h.logError(err, "GetPlan")
+		plan = &core.Plan{Name: "Неизвестный план"}
+	}
+
+	text := fmt.Sprintf("✅ Оплата успешна!\n\n🎉 Подписка '%s' активирована на %d дней", plan.Name, plan.Days+payment.BonusDays)
+
+	return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, text, ui.GetBackToSubscriptionsKeyboard())
+}
+
+func (h *BaseHandler) HandlePaymentCancel(ctx context.Context, userID, chatID int64, messageID int, paymentID string) error {
+	slog.Info("Handling payment cancel", "payment_id", paymentID, "user_id", userID)
+
+	payment, err := h.paymentUC.GetPayment(ctx, paymentID)
+	if err != nil {
+		h.logError(err, "GetPayment")
+
+		return h.sendError(chatID, "❌ Платеж не найден")
+	}
+
+	if payment.UserID != userID {
+
+		return usecase.ErrUnauthorized
+	}
+
+	if !payment.IsPending() {
+
+		return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, "ℹ️ Этот платеж уже нельзя отменить", ui.GetBackToPricingKeyboard())
+	}
+
+	err = h.paymentUC.CancelPendingPayment(ctx, paymentID)
+	if errors.Is(err, usecase.ErrPaymentAlreadyPaid) {
+
+		return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, "✅ Платеж уже оплачен, отменить его нельзя. Подписка активирована.", ui.GetBackToSubscriptionsKeyboard())
+	}
+	if err != nil {
+		h.logError(err, "CancelPendingPayment")
+
+		return h.sendError(chatID, "❌ Не удалось отменить платеж")
+	}
+
+	return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, "❌ Платеж отменен", ui.GetBackToPricingKeyboard())
+}
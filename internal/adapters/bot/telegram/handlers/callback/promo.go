@@ -0,0 +1,144 @@
+package callback
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"3xui-bot/internal/adapters/bot/telegram/ui"
+	"3xui-bot/internal/usecase"
+)
+
+func (h *BaseHandler) HandlePromoEnter(ctx context.Context, userID, chatID int64, messageID int, planID string) error {
+	slog.Info("Handling promo enter", "plan_id", planID, "user_id", userID)
+
+	plan, err := h.getPlan(ctx, planID)
+	if err != nil {
+		h.logError(err, "GetPlan")
+
+		return err
+	}
+
+	h.mu.Lock()
+	h.promoInput[userID] = planID
+	h.mu.Unlock()
+
+	return h.msg.EditMessageText(ctx, chatID, messageID, ui.GetPromoCodeInputText(plan), ui.GetPromoCodeInputKeyboard(planID))
+}
+
+func (h *BaseHandler) HandlePromoClear(ctx context.Context, userID, chatID int64, messageID int, planID string) error {
+	slog.Info("Handling promo clear", "plan_id", planID, "user_id", userID)
+
+	h.mu.Lock()
+	delete(h.appliedPromos, userID)
+	h.mu.Unlock()
+
+	return h.showPaymentMethods(ctx, userID, chatID, messageID, planID)
+}
+
+func (h *BaseHandler) HandlePromoCodeInput(ctx context.Context, userID, chatID int64, code string) (bool, error) {
+	h.mu.Lock()
+	planID, waiting := h.promoInput[userID]
+	delete(h.promoInput, userID)
+	h.mu.Unlock()
+
+	if !waiting {
+
+		return false, nil
+	}
+
+	code = usecase.NormalizePromoCode(code)
+	slog.Info("Handling promo code input", "plan_id", planID, "code", code, "user_id", userID)
+
+	quote, err := h.paymentUC.QuotePlan(ctx, userID, planID, code)
+	if err != nil {
+		text, ok := promoErrorText(err)
+		if !ok {
+			h.logError(err, "QuotePlan")
+			text = "❌ Не удалось проверить промокод. Попробуйте позже."
+		}
+
+		return true, h.msg.SendMessageWithKeyboard(ctx, chatID, text, ui.GetPaymentMethodKeyboard(planID, false))
+	}
+
+	h.mu.Lock()
+	h.appliedPromos[userID] = appliedPromo{planID: planID, code: quote.PromoCode.Code}
+	h.mu.Unlock()
+
+	text := ui.GetPaymentMethodWithPromoText(quote.Plan, quote.PromoCode, quote.Amount, quote.DiscountAmount, quote.BonusDays)
+
+	return true, h.msg.SendMessageWithKeyboard(ctx, chatID, text, ui.GetPaymentMethodKeyboard(planID, true))
+}
+
+func (h *BaseHandler) showPaymentMethods(ctx context.Context, userID, chatID int64, messageID int, planID string) error {
+	h.mu.Lock()
+	delete(h.promoInput, userID)
+	delete(h.emailInput, userID)
+	h.mu.Unlock()
+
+	if code := h.appliedPromoCode(userID, planID); code != "" {
+		quote, err := h.paymentUC.QuotePlan(ctx, userID, planID, code)
+		if err == nil {
+			text := ui.GetPaymentMethodWithPromoText(quote.Plan, quote.PromoCode, quote.Amount, quote.DiscountAmount, quote.BonusDays)
+
+			return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, text, ui.GetPaymentMethodKeyboard(planID, true))
+		}
+
+		slog.Info("Applied promo code is no longer valid", "code", code, "user_id", userID, "error", err)
+		h.clearAppliedPromo(userID)
+	}
+
+	plan, err := h.getPlan(ctx, planID)
+	if err != nil {
+		h.logError(err, "GetPlan")
+
+		return err
+	}
+
+	text := ui.GetPaymentMethodText(plan)
+	keyboard := ui.GetPaymentMethodKeyboard(planID, false)
+
+	return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, text, keyboard)
+}
+
+func (h *BaseHandler) appliedPromoCode(userID int64, planID string) string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	promo, ok := h.appliedPromos[userID]
+	if !ok || promo.planID != planID {
+
+		return ""
+	}
+
+	return promo.code
+}
+
+func (h *BaseHandler) clearAppliedPromo(userID int64) {
+	h.mu.Lock()
+	delete(h.appliedPromos, userID)
+	h.mu.Unlock()
+}
+
+func promoErrorText(err error) (string, bool) {
+	switch {
+	case errors.Is(err, usecase.ErrPromoCodeInvalid):
+
+		return "❌ Промокод не найден или отключен", true
+	case errors.Is(err, usecase.ErrPromoCodeExpired):
+
+		return "⌛ Срок действия промокода истек", true
+	case errors.Is(err, usecase.ErrPromoCodeExhausted):
+
+		return "❌ Промокод больше не действует: лимит использований исчерпан", true
+	case errors.Is(err, usecase.ErrPromoCodeNotApplicable):
+
+		return "❌ Промокод не действует для этого тарифа", true
+	case errors.Is(err, usecase.ErrPromoCodeAlreadyUsed):
+
+		return "❌ Вы уже использовали этот промокод или у вас есть неоплаченный платеж с ним", true
+	default:
+
+		return "", false
+	}
+}
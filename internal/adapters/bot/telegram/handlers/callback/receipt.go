@@ -0,0 +1,85 @@
+package callback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"3xui-bot/internal/adapters/bot/telegram/ui"
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/usecase"
+)
+
+func (h *BaseHandler) HandleSetEmail(ctx context.Context, userID, chatID int64, messageID int) error {
+	slog.Info("Handling set email", "user_id", userID)
+
+	h.mu.Lock()
+	h.emailInput[userID] = "open_profile"
+	h.mu.Unlock()
+
+	return h.msg.EditMessageText(ctx, chatID, messageID, ui.GetReceiptEmailInputText(false), ui.GetReceiptEmailInputKeyboard("open_profile"))
+}
+
+func (h *BaseHandler) requestReceiptEmail(ctx context.Context, userID, chatID int64, messageID int, continueCallback, cancelCallback string) error {
+	slog.Info("Receipt email required before checkout", "user_id", userID, "continue", continueCallback)
+
+	h.mu.Lock()
+	h.emailInput[userID] = continueCallback
+	h.mu.Unlock()
+
+	return h.msg.EditMessageText(ctx, chatID, messageID, ui.GetReceiptEmailInputText(true), ui.GetReceiptEmailInputKeyboard(cancelCallback))
+}
+
+func (h *BaseHandler) HandleReceiptEmailInput(ctx context.Context, userID, chatID int64, email string) (bool, error) {
+	h.mu.Lock()
+	continueCallback, waiting := h.emailInput[userID]
+	h.mu.Unlock()
+
+	if !waiting {
+
+		return false, nil
+	}
+
+	user, err := h.userUC.SetEmail(ctx, userID, email)
+	if errors.Is(err, usecase.ErrInvalidEmail) {
+
+		return true, h.msg.SendMessage(ctx, chatID, "❌ Некорректный email. Отправьте адрес в формате name@example.com")
+	}
+
+	h.cancelEmailInput(userID)
+
+	if err != nil {
+		h.logError(err, "SetEmail")
+
+		return true, h.msg.SendMessage(ctx, chatID, "❌ Не удалось сохранить email. Попробуйте позже.")
+	}
+
+	slog.Info("Receipt email saved", "user_id", userID)
+
+	return true, h.msg.SendMessageWithKeyboard(ctx, chatID, ui.GetReceiptEmailSavedText(user.Email), ui.GetReceiptEmailSavedKeyboard(continueCallback))
+}
+
+func (h *BaseHandler) cancelEmailInput(userID int64) {
+	h.mu.Lock()
+	delete(h.emailInput, userID)
+	h.mu.Unlock()
+}
+
+func payPlanCallback(planID string, method core.PaymentMethod) string {
+	if method == core.PaymentMethodSBP {
+
+		return ui.CallbackPrefixPaySBP + planID
+	}
+
+	return ui.CallbackPrefixPayCard + planID
+}
+
+func topUpCallback(amount int, method core.PaymentMethod) string {
+	if method == core.PaymentMethodSBP {
+
+		return fmt.Sprintf("%s%d", ui.CallbackPrefixTopUpSBP, amount)
+	}
+
+	return fmt.Sprintf("%s%d", ui.CallbackPrefixTopUpCard, amount)
+}
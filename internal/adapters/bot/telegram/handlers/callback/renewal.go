@@ -0,0 +1,76 @@
+package callback
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"3xui-bot/internal/adapters/bot/telegram/ui"
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/usecase"
+)
+
+func (h *BaseHandler) HandleSetAutoRenew(ctx context.Context, userID, chatID int64, messageID int, subscriptionID string, enabled bool) error {
+	slog.Info("Handling set auto-renew", "subscription_id", subscriptionID, "enabled", enabled, "user_id", userID)
+
+	subscription, err := h.subUC.SetAutoRenew(ctx, userID, subscriptionID, enabled)
+	if errors.Is(err, usecase.ErrSubscriptionNotActive) {
+
+		return h.sendError(chatID, "❌ Автопродление можно включить только для активной подписки")
+	}
+	if err != nil {
+		h.logError(err, "SetAutoRenew")
+
+		return h.sendError(chatID, "❌ Не удалось изменить автопродление. Попробуйте позже.")
+	}
+
+	return h.showAutoRenew(ctx, userID, chatID, messageID, subscription)
+}
+
+func (h *BaseHandler) HandleUnlinkCard(ctx context.Context, userID, chatID int64, messageID int, subscriptionID string) error {
+	slog.Info("Handling unlink card", "subscription_id", subscriptionID, "user_id", userID)
+
+	subscription, err := h.getSubscription(ctx, userID, subscriptionID)
+	if err != nil {
+		h.logError(err, "GetSubscription")
+
+		return err
+	}
+
+	if subscription.UserID != userID {
+
+		return usecase.ErrUnauthorized
+	}
+
+	if err := h.paymentUC.DeleteSavedPaymentMethod(ctx, userID); err != nil {
+		h.logError(err, "DeleteSavedPaymentMethod")
+
+		return h.sendError(chatID, "❌ Не удалось отвязать карту. Попробуйте позже.")
+	}
+
+	return h.showAutoRenew(ctx, userID, chatID, messageID, subscription)
+}
+
+func (h *BaseHandler) showAutoRenew(ctx context.Context, userID, chatID int64, messageID int, subscription *core.Subscription) error {
+	plan, err := h.getPlan(ctx, subscription.PlanID)
+	if err != nil {
+		h.logError(err, "GetPlan")
+
+		return err
+	}
+
+	savedMethod, err := h.paymentUC.GetSavedPaymentMethod(ctx, userID)
+	if err != nil && !errors.Is(err, usecase.ErrNotFound) {
+		h.logError(err, "GetSavedPaymentMethod")
+	}
+
+	balance, err := h.balanceUC.GetBalance(ctx, userID)
+	if err != nil {
+		h.logError(err, "GetBalance")
+	}
+
+	text := ui.GetAutoRenewText(subscription, plan, savedMethod, balance)
+	keyboard := ui.GetAutoRenewKeyboard(subscription, savedMethod != nil)
+
+	return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, text, keyboard)
+}
@@ -28,9 +28,11 @@ func NewRouter(
 	vpnUC *usecase.VPNUseCase,
 	referralUC *usecase.ReferralUseCase,
 	notifUC *usecase.NotificationUseCase,
+	balanceUC *usecase.BalanceUseCase,
+	trafficUC *usecase.TrafficUseCase,
 	msg *service.MessageService,
 ) *Router {
-	baseHandler := NewBaseHandler(userUC, subUC, paymentUC, vpnUC, referralUC, notifUC, msg)
+	baseHandler := NewBaseHandler(userUC, subUC, paymentUC, vpnUC, referralUC, notifUC, balanceUC, trafficUC, msg)
 
 	router := &Router{
 		baseHandler: baseHandler,
@@ -47,6 +49,8 @@ func (r *Router) setupRoutes() {
 
 	r.routes["open_menu"] = r.baseHandler.HandleOpenMenu
 	r.routes["open_profile"] = r.baseHandler.HandleOpenProfile
+	r.routes["open_balance"] = r.baseHandler.HandleOpenBalance
+	r.routes["set_email"] = r.baseHandler.HandleSetEmail
 	r.routes["open_pricing"] = r.baseHandler.HandleOpenPricing
 	r.routes["open_support"] = r.baseHandler.HandleOpenSupport
 	r.routes["show_instruction"] = r.baseHandler.HandleShowInstruction
@@ -105,6 +109,42 @@ func (r *Router) handleParameterizedCallback(ctx context.Context, userID, chatID
 		return r.baseHandler.HandlePayStars(ctx, userID, chatID, messageID, planID)
 	}
 
+	if planID, ok := ui.ParsePayBalanceCallback(callbackData); ok {
+
+		return r.baseHandler.HandlePayBalance(ctx, userID, chatID, messageID, planID)
+	}
+
+	if amount, ok := ui.ParseTopUpAmountCallback(callbackData); ok {
+
+		return r.baseHandler.HandleTopUpAmount(ctx, userID, chatID, messageID, amount)
+	}
+	if amount, ok := ui.ParseTopUpCardCallback(callbackData); ok {
+
+		return r.baseHandler.HandleTopUpPayment(ctx, userID, chatID, messageID, amount, core.PaymentMethodCard)
+	}
+	if amount, ok := ui.ParseTopUpSBPCallback(callbackData); ok {
+
+		return r.baseHandler.HandleTopUpPayment(ctx, userID, chatID, messageID, amount, core.PaymentMethodSBP)
+	}
+
+	if planID, ok := ui.ParsePromoEnterCallback(callbackData); ok {
+
+		return r.baseHandler.HandlePromoEnter(ctx, userID, chatID, messageID, planID)
+	}
+	if planID, ok := ui.ParsePromoClearCallback(callbackData); ok {
+
+		return r.baseHandler.HandlePromoClear(ctx, userID, chatID, messageID, planID)
+	}
+
+	if paymentID, ok := ui.ParsePaymentCheckCallback(callbackData); ok {
+
+		return r.baseHandler.HandlePaymentCheck(ctx, userID, chatID, messageID, paymentID)
+	}
+	if paymentID, ok := ui.ParsePaymentCancelCallback(callbackData); ok {
+
+		return r.baseHandler.HandlePaymentCancel(ctx, userID, chatID, messageID, paymentID)
+	}
+
 	if planID, ok := ui.ParseCreatePlanCallback(callbackData); ok {
 
 		return r.baseHandler.HandleCreateSubscriptionByPlan(ctx, userID, chatID, messageID, planID)
@@ -130,11 +170,52 @@ func (r *Router) handleParameterizedCallback(ctx context.Context, userID, chatID
 		return r.baseHandler.HandleDeleteSubscription(ctx, userID, chatID, messageID, subscriptionID)
 	}
 
+	if subscriptionID, ok := ui.ParseAutoRenewOnCallback(callbackData); ok {
+
+		return r.baseHandler.HandleSetAutoRenew(ctx, userID, chatID, messageID, subscriptionID, true)
+	}
+
+	if subscriptionID, ok := ui.ParseAutoRenewOffCallback(callbackData); ok {
+
+		return r.baseHandler.HandleSetAutoRenew(ctx, userID, chatID, messageID, subscriptionID, false)
+	}
+
+	if subscriptionID, ok := ui.ParseUnlinkCardCallback(callbackData); ok {
+
+		return r.baseHandler.HandleUnlinkCard(ctx, userID, chatID, messageID, subscriptionID)
+	}
+
+	if subscriptionID, ok := ui.ParseBuyTrafficCallback(callbackData); ok {
+
+		return r.baseHandler.HandleBuyTraffic(ctx, userID, chatID, messageID, subscriptionID)
+	}
+	if subscriptionID, ok := ui.ParseConfirmTrafficCallback(callbackData); ok {
+
+		return r.baseHandler.HandleConfirmTraffic(ctx, userID, chatID, messageID, subscriptionID)
+	}
+
 	if planID, subscriptionID, ok := ui.ParseExtendPlanCallback(callbackData); ok {
 
 		return r.baseHandler.HandleExtendSubscriptionByPlan(ctx, userID, chatID, messageID, planID, subscriptionID)
 	}
 
+	if subscriptionID, ok := ui.ParseCreateKeyCallback(callbackData); ok {
+
+		return r.baseHandler.HandleCreateKey(ctx, userID, chatID, messageID, subscriptionID)
+	}
+	if subscriptionID, serverName, ok := ui.ParseKeyLocationCallback(callbackData); ok {
+
+		return r.baseHandler.HandleCreateKeyOnServer(ctx, userID, chatID, messageID, subscriptionID, serverName)
+	}
+	if vpnID, ok := ui.ParseChangeLocationCallback(callbackData); ok {
+
+		return r.baseHandler.HandleChangeLocation(ctx, userID, chatID, messageID, vpnID)
+	}
+	if vpnID, serverName, ok := ui.ParseMoveKeyCallback(callbackData); ok {
+
+		return r.baseHandler.HandleMoveKey(ctx, userID, chatID, messageID, vpnID, serverName)
+	}
+
 	if configID, ok := ui.ParseViewConfigCallback(callbackData); ok {
 
 		return r.handleViewConfig(ctx, userID, chatID, messageID, configID)
@@ -152,6 +233,16 @@ func (r *Router) handleParameterizedCallback(ctx context.Context, userID, chatID
 }
 
 func (r *Router) HandleTextMessage(ctx context.Context, userID int64, chatID int64, messageText string) (bool, error) {
+	if handled, err := r.baseHandler.HandlePromoCodeInput(ctx, userID, chatID, messageText); handled {
+
+		return true, err
+	}
+
+	if handled, err := r.baseHandler.HandleReceiptEmailInput(ctx, userID, chatID, messageText); handled {
+
+		return true, err
+	}
+
 	r.baseHandler.mu.RLock()
 	subscriptionID, isRenaming := r.baseHandler.renamingUsers[userID]
 	r.baseHandler.mu.RUnlock()
@@ -211,25 +302,37 @@ func (r *Router) handleViewConfig(ctx context.Context, userID, chatID int64, mes
 	return r.baseHandler.msg.DeleteAndSendMessage(ctx, chatID, messageID, text, nil)
 }
 
-func (r *Router) handleConnectionGuide(ctx context.Context, userID, chatID int64, messageID int, configID string) error {
-	slog.Info("Handling connection guide", "config_id", configID, "user_id", userID)
-	text := "📖 Руководство по подключению\n\nКонфигурация: " + configID
+func (r *Router) handleConnectionGuide(ctx context.Context, userID, chatID int64, messageID int, subscriptionID string) error {
+	slog.Info("Handling connection guide", "subscription_id", subscriptionID, "user_id", userID)
 
-	return r.baseHandler.msg.DeleteAndSendMessage(ctx, chatID, messageID, text, nil)
-}
+	if _, err := r.baseHandler.getSubscription(ctx, userID, subscriptionID); err != nil {
+		r.baseHandler.logError(err, "GetSubscription")
 
-func (r *Router) handleCreateSubscriptionByPlan(ctx context.Context, userID, chatID int64, messageID int, planID string) error {
-	slog.Info("Handling create subscription by plan", "plan_id", planID, "user_id", userID)
+		return r.baseHandler.sendError(chatID, "❌ Подписка не найдена")
+	}
 
-	plan, err := r.baseHandler.getPlan(ctx, planID)
+	connections, err := r.baseHandler.getVPNConnectionsBySubscriptionID(ctx, subscriptionID)
 	if err != nil {
-		r.baseHandler.logError(err, "GetPlan")
+		r.baseHandler.logError(err, "GetVPNConnections")
+		connections = []*core.VPNConnection{}
+	}
 
-		return err
+	subscriptionURL := ""
+	for _, conn := range connections {
+		if conn.SubscriptionURL != "" {
+			subscriptionURL = conn.SubscriptionURL
+			break
+		}
 	}
 
-	text := ui.GetPaymentMethodText(plan)
-	keyboard := ui.GetPaymentMethodKeyboard(planID)
+	text := ui.GetInstructionWithConnectionText(subscriptionURL)
+	keyboard := ui.GetConnectionGuideKeyboard(subscriptionID, connections)
 
-	return r.baseHandler.msg.DeleteAndSendMessage(ctx, chatID, messageID, text, keyboard)
+	return r.baseHandler.msg.DeleteAndSendMessageWithMarkdownV2(ctx, chatID, messageID, text, keyboard)
+}
+
+func (r *Router) handleCreateSubscriptionByPlan(ctx context.Context, userID, chatID int64, messageID int, planID string) error {
+	slog.Info("Handling create subscription by plan", "plan_id", planID, "user_id", userID)
+
+	return r.baseHandler.showPaymentMethods(ctx, userID, chatID, messageID, planID)
 }
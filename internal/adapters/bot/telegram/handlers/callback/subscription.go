@@ -2,6 +2,7 @@ package callback
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"time"
@@ -47,21 +48,23 @@ func (h *BaseHandler) HandleCreateSubscription(ctx context.Context, userID, chat
 func (h *BaseHandler) HandleSelectPlan(ctx context.Context, userID, chatID int64, messageID int, planID string) error {
 	slog.Info("Handling select plan", "plan_id", planID, "user_id", userID)
 
-	plan, err := h.getPlan(ctx, planID)
-	if err != nil {
-		h.logError(err, "GetPlan")
+	return h.showPaymentMethods(ctx, userID, chatID, messageID, planID)
+}
 
-		return err
-	}
+func (h *BaseHandler) HandlePayCard(ctx context.Context, userID, chatID int64, messageID int, planID string) error {
+	slog.Info("Handling pay card", "plan_id", planID, "user_id", userID)
 
-	text := ui.GetPaymentMethodText(plan)
-	keyboard := ui.GetPaymentMethodKeyboard(planID)
+	return h.startProviderPayment(ctx, userID, chatID, messageID, planID, core.PaymentMethodCard)
+}
 
-	return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, text, keyboard)
+func (h *BaseHandler) HandlePaySBP(ctx context.Context, userID, chatID int64, messageID int, planID string) error {
+	slog.Info("Handling pay SBP", "plan_id", planID, "user_id", userID)
+
+	return h.startProviderPayment(ctx, userID, chatID, messageID, planID, core.PaymentMethodSBP)
 }
 
-func (h *BaseHandler) HandlePayCard(ctx context.Context, userID, chatID int64, messageID int, planID string) error {
-	slog.Info("Handling pay card", "plan_id", planID, "user_id", userID)
+func (h *BaseHandler) HandlePayStars(ctx context.Context, userID, chatID int64, messageID int, planID string) error {
+	slog.Info("Handling pay stars", "plan_id", planID, "user_id", userID)
 
 	plan, err := h.getPlan(ctx, planID)
 	if err != nil {
@@ -70,62 +73,42 @@ func (h *BaseHandler) HandlePayCard(ctx context.Context, userID, chatID int64, m
 		return err
 	}
 
-	slog.Info("Creating MOCK card payment (auto-success)", "user_id", userID, "plan_id", planID)
+	payment, err := h.paymentUC.CreateStarsPayment(ctx, userID, planID, h.appliedPromoCode(userID, planID))
+	if errors.Is(err, usecase.ErrInvalidAmount) {
 
-	now := time.Now()
-
-	subscriptionName := h.generateSubscriptionName(plan)
-
-	dto := usecase.CreateSubscriptionDTO{
-		UserID:    userID,
-		Name:      subscriptionName,
-		PlanID:    planID,
-		Days:      plan.Days,
-		StartDate: now,
-		EndDate:   now.AddDate(0, 0, plan.Days),
-		IsActive:  true,
+		return h.msg.EditMessageText(ctx, chatID, messageID, ui.GetStarsUnavailableText(plan), ui.GetBackToPricingKeyboard())
 	}
+	if text, ok := promoErrorText(err); ok {
+		h.clearAppliedPromo(userID)
 
-	subscription, err := h.createSubscription(ctx, dto)
-	if err != nil {
-		h.logError(err, "CreateSubscription")
-
-		return h.sendError(chatID, "Ошибка создания подписки")
+		return h.msg.EditMessageText(ctx, chatID, messageID, text, ui.GetPaymentMethodKeyboard(planID, false))
 	}
+	if err != nil {
+		h.logError(err, "CreateStarsPayment")
 
-	if sub, ok := subscription.(*core.Subscription); ok && sub != nil {
-		_, err = h.createVPNForSubscription(ctx, userID, sub.ID)
-		if err != nil {
-			h.logError(err, "CreateVPN")
-		}
+		return h.sendError(chatID, "❌ Не удалось создать счет. Попробуйте позже.")
 	}
 
-	text := fmt.Sprintf("✅ Оплата успешна!\n\n🎉 Подписка '%s' активирована на %d дней", plan.Name, plan.Days)
-	keyboard := ui.GetBackToSubscriptionsKeyboard()
-
-	return h.msg.EditMessageText(ctx, chatID, messageID, text, keyboard)
-}
+	h.clearAppliedPromo(userID)
 
-func (h *BaseHandler) HandlePaySBP(ctx context.Context, userID, chatID int64, messageID int, planID string) error {
-	slog.Info("Handling pay SBP", "plan_id", planID, "user_id", userID)
+	if payment.IsCompleted() {
 
-	return h.HandlePayCard(ctx, userID, chatID, messageID, planID)
-}
+		return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, ui.GetFreePaymentText(plan, payment), ui.GetBackToSubscriptionsKeyboard())
+	}
 
-func (h *BaseHandler) HandlePayStars(ctx context.Context, userID, chatID int64, messageID int, planID string) error {
-	slog.Info("Handling pay stars", "plan_id", planID, "user_id", userID)
+	slog.Info("Stars payment created", "payment_id", payment.ID, "stars", payment.Amount, "user_id", userID)
 
-	plan, err := h.getPlan(ctx, planID)
-	if err != nil {
-		h.logError(err, "GetPlan")
+	payload := ui.StarsInvoicePayloadPrefix + payment.ID
+	if err := h.msg.SendInvoice(ctx, chatID, ui.GetStarsInvoiceTitle(plan), ui.GetStarsInvoiceDescription(plan), payload, core.CurrencyStars, int(payment.Amount)); err != nil {
+		h.logError(err, "SendInvoice")
+		_ = h.paymentUC.ProcessPaymentCancellation(ctx, payment.ID)
 
-		return err
+		return h.sendError(chatID, "❌ Не удалось выставить счет. Попробуйте позже.")
 	}
 
-	text := fmt.Sprintf("⭐ Оплата Telegram Stars\n\n💰 Сумма: %.0f₽\n⏰ План: %s (%d дней)\n\n🚧 Функция в разработке", plan.Price, plan.Name, plan.Days)
-	keyboard := ui.GetBackToPricingKeyboard()
+	_ = h.msg.DeleteMessage(ctx, chatID, messageID)
 
-	return h.msg.EditMessageText(ctx, chatID, messageID, text, keyboard)
+	return nil
 }
 
 func (h *BaseHandler) HandleViewSubscription(ctx context.Context, userID, chatID int64, messageID int, subscriptionID string) error {
@@ -193,7 +176,12 @@ func (h *BaseHandler) HandleExtendSubscription(ctx context.Context, userID, chat
 		return err
 	}
 
-	text := ui.GetExtendSubscriptionText(subscription)
+	balance, err := h.balanceUC.GetBalance(ctx, userID)
+	if err != nil {
+		h.logError(err, "GetBalance")
+	}
+
+	text := ui.GetExtendSubscriptionText(subscription, balance)
 	keyboard := ui.GetExtendSubscriptionKeyboard(subscriptionID, plans)
 
 	return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, text, keyboard)
@@ -225,16 +213,18 @@ func (h *BaseHandler) HandleExtendSubscriptionByPlan(ctx context.Context, userID
 		return err
 	}
 
-	slog.Info("Creating MOCK extension (auto-success)", "user_id", userID, "plan_id", planID, "subscription_id", subscriptionID)
+	subscription, err := h.paymentUC.ExtendSubscriptionFromBalance(ctx, userID, subscriptionID, planID)
+	if errors.Is(err, usecase.ErrInsufficientBalance) {
 
-	err = h.extendSubscription(ctx, userID, subscriptionID, plan.Days)
+		return h.showInsufficientBalance(ctx, userID, chatID, messageID, plan.Price, ui.CallbackPrefixExtendSubscription+subscriptionID)
+	}
 	if err != nil {
-		h.logError(err, "ExtendSubscription")
+		h.logError(err, "ExtendSubscriptionFromBalance")
 
 		return h.sendError(chatID, "Ошибка продления подписки")
 	}
 
-	text := fmt.Sprintf("✅ Подписка продлена на %d дней!", plan.Days)
+	text := fmt.Sprintf("✅ Подписка продлена на %d дней!\n📅 Действует до: %s\n💵 Списано с баланса: %.0f₽", plan.Days, subscription.EndDate.Format("02.01.2006"), plan.Price)
 	keyboard := ui.GetBackToSubscriptionsKeyboard()
 
 	return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, text, keyboard)
@@ -261,23 +251,8 @@ func (h *BaseHandler) generateSubscriptionName(plan *core.Plan) string {
 	return fmt.Sprintf("%s (%s)", baseName, dateStr)
 }
 
-func (h *BaseHandler) extendSubscription(ctx context.Context, userID int64, subscriptionID string, days int) error {
-
-	return h.subUC.ExtendSubscription(ctx, userID, subscriptionID, days)
-}
-
 func (h *BaseHandler) HandleCreateSubscriptionByPlan(ctx context.Context, userID, chatID int64, messageID int, planID string) error {
 	slog.Info("Handling create subscription by plan", "plan_id", planID, "user_id", userID)
 
-	plan, err := h.getPlan(ctx, planID)
-	if err != nil {
-		h.logError(err, "GetPlan")
-
-		return err
-	}
-
-	text := ui.GetPaymentMethodText(plan)
-	keyboard := ui.GetPaymentMethodKeyboard(planID)
-
-	return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, text, keyboard)
+	return h.showPaymentMethods(ctx, userID, chatID, messageID, planID)
 }
@@ -0,0 +1,76 @@
+package callback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"3xui-bot/internal/adapters/bot/telegram/ui"
+	"3xui-bot/internal/usecase"
+)
+
+func (h *BaseHandler) HandleBuyTraffic(ctx context.Context, userID, chatID int64, messageID int, subscriptionID string) error {
+	slog.Info("Handling buy traffic", "subscription_id", subscriptionID, "user_id", userID)
+
+	addon := h.trafficUC.Addon()
+	if !addon.Enabled() {
+
+		return h.sendError(chatID, "❌ Докупка трафика сейчас недоступна")
+	}
+
+	subscription, err := h.getSubscription(ctx, userID, subscriptionID)
+	if err != nil {
+		h.logError(err, "GetSubscription")
+
+		return err
+	}
+
+	if subscription.UserID != userID {
+
+		return usecase.ErrUnauthorized
+	}
+
+	balance, err := h.balanceUC.GetBalance(ctx, userID)
+	if err != nil {
+		h.logError(err, "GetBalance")
+	}
+
+	return h.msg.EditMessageText(ctx, chatID, messageID, ui.GetTrafficAddonText(subscription, addon.GB, addon.Price, balance), ui.GetTrafficAddonKeyboard(subscriptionID))
+}
+
+func (h *BaseHandler) HandleConfirmTraffic(ctx context.Context, userID, chatID int64, messageID int, subscriptionID string) error {
+	slog.Info("Handling confirm traffic", "subscription_id", subscriptionID, "user_id", userID)
+
+	addon := h.trafficUC.Addon()
+	subscription, conn, err := h.trafficUC.BuyTrafficAddon(ctx, userID, subscriptionID)
+	if errors.Is(err, usecase.ErrInsufficientBalance) {
+
+		return h.showInsufficientBalance(ctx, userID, chatID, messageID, addon.Price, ui.CallbackPrefixBuyTraffic+subscriptionID)
+	}
+	if errors.Is(err, usecase.ErrSubscriptionNotActive) {
+
+		return h.sendError(chatID, "❌ Докупить трафик можно только для активной подписки")
+	}
+	if errors.Is(err, usecase.ErrTrafficAddonUnavailable) {
+
+		return h.sendError(chatID, "❌ Для этой подписки докупка трафика недоступна")
+	}
+	if errors.Is(err, usecase.ErrPanelUnavailable) {
+		h.logError(err, "BuyTrafficAddon")
+
+		return h.sendError(chatID, ui.GetServerUnavailableText())
+	}
+	if err != nil {
+		h.logError(err, "BuyTrafficAddon")
+
+		return h.sendError(chatID, "❌ Не удалось докупить трафик. Попробуйте позже.")
+	}
+
+	text := fmt.Sprintf("✅ Трафик добавлен!\n\n📦 +%d ГБ к ключу '%s' (подписка '%s')\n💳 Списано с баланса: %.0f₽", addon.GB, conn.GetDisplayName(), subscription.GetDisplayName(), addon.Price)
+	if conn.TrafficAddonExpiresAt != nil {
+		text += fmt.Sprintf("\n⏳ Действует до обнуления трафика %s", conn.TrafficAddonExpiresAt.Format("02.01.2006"))
+	}
+
+	return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, text, ui.GetBackToSubscriptionsKeyboard())
+}
@@ -2,20 +2,188 @@ package callback
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 
 	"3xui-bot/internal/adapters/bot/telegram/ui"
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/usecase"
 )
 
 func (h *BaseHandler) HandleOpenKeys(ctx context.Context, userID, chatID int64, messageID int) error {
 	slog.Info("Handling open keys", "user_id", userID)
 
-	text := ui.GetKeysText()
-	keyboard := ui.GetKeysKeyboard()
+	connections, err := h.vpnUC.GetUserKeys(ctx, userID)
+	if err != nil {
+		h.logError(err, "GetUserKeys")
+
+		return h.sendError(chatID, "❌ Не удалось загрузить ключи. Попробуйте позже.")
+	}
+
+	locations, err := h.vpnUC.GetLocations(ctx)
+	if err != nil {
+		h.logError(err, "GetLocations")
+	}
+
+	subscriptions, err := h.getUserSubscriptions(ctx, userID)
+	if err != nil {
+		h.logError(err, "GetUserSubscriptions")
+	}
+
+	withoutKeys := subscriptionsWithoutKeys(subscriptions, connections)
+	text := ui.GetKeysText(connections, locations, withoutKeys)
+	keyboard := ui.GetKeysKeyboard(connections, withoutKeys, len(locations) > 1)
+
+	return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, text, keyboard)
+}
+
+func (h *BaseHandler) HandleCreateKey(ctx context.Context, userID, chatID int64, messageID int, subscriptionID string) error {
+	slog.Info("Handling create key", "subscription_id", subscriptionID, "user_id", userID)
+
+	locations, err := h.vpnUC.GetLocations(ctx)
+	if err != nil {
+		h.logError(err, "GetLocations")
+
+		return h.sendError(chatID, "❌ Не удалось загрузить список серверов. Попробуйте позже.")
+	}
+
+	if len(locations) <= 1 {
+
+		return h.HandleCreateKeyOnServer(ctx, userID, chatID, messageID, subscriptionID, "")
+	}
+
+	text := ui.GetLocationPickerText(locations, "")
+	keyboard := ui.GetLocationPickerKeyboard(locations, ui.CallbackPrefixKeyLocation, subscriptionID, "")
 
 	return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, text, keyboard)
 }
 
+func (h *BaseHandler) HandleCreateKeyOnServer(ctx context.Context, userID, chatID int64, messageID int, subscriptionID, serverName string) error {
+	slog.Info("Handling create key on server", "subscription_id", subscriptionID, "server", serverName, "user_id", userID)
+
+	conn, err := h.vpnUC.CreateUserKey(ctx, userID, subscriptionID, serverName)
+	if err != nil {
+		text, ok := locationErrorText(err)
+		if !ok {
+			h.logError(err, "CreateUserKey")
+			text = "❌ Не удалось создать ключ. Попробуйте позже."
+		}
+
+		return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, text, ui.GetKeyLocationResultKeyboard())
+	}
+
+	locations, err := h.vpnUC.GetLocations(ctx)
+	if err != nil {
+		h.logError(err, "GetLocations")
+	}
+
+	return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, ui.GetKeyLocationResultText(conn, locations, false), ui.GetKeyLocationResultKeyboard())
+}
+
+func (h *BaseHandler) HandleChangeLocation(ctx context.Context, userID, chatID int64, messageID int, vpnID string) error {
+	slog.Info("Handling change location", "vpn_id", vpnID, "user_id", userID)
+
+	connections, err := h.vpnUC.GetUserKeys(ctx, userID)
+	if err != nil {
+		h.logError(err, "GetUserKeys")
+
+		return h.sendError(chatID, "❌ Не удалось загрузить ключи. Попробуйте позже.")
+	}
+
+	var current *core.VPNConnection
+	for _, conn := range connections {
+		if conn.ID == vpnID {
+			current = conn
+		}
+	}
+	if current == nil {
+
+		return h.sendError(chatID, "❌ Ключ не найден")
+	}
+
+	locations, err := h.vpnUC.GetLocations(ctx)
+	if err != nil {
+		h.logError(err, "GetLocations")
+
+		return h.sendError(chatID, "❌ Не удалось загрузить список серверов. Попробуйте позже.")
+	}
+
+	text := ui.GetLocationPickerText(locations, current.ServerName)
+	keyboard := ui.GetLocationPickerKeyboard(locations, ui.CallbackPrefixMoveKey, vpnID, current.ServerName)
+
+	return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, text, keyboard)
+}
+
+func (h *BaseHandler) HandleMoveKey(ctx context.Context, userID, chatID int64, messageID int, vpnID, serverName string) error {
+	slog.Info("Handling move key", "vpn_id", vpnID, "server", serverName, "user_id", userID)
+
+	conn, err := h.vpnUC.MoveVPNConnection(ctx, userID, vpnID, serverName)
+	if err != nil {
+		text, ok := locationErrorText(err)
+		if !ok {
+			h.logError(err, "MoveVPNConnection")
+			text = "❌ Не удалось сменить локацию. Ключ остался на прежнем сервере, попробуйте позже."
+		}
+
+		return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, text, ui.GetKeyLocationResultKeyboard())
+	}
+
+	locations, err := h.vpnUC.GetLocations(ctx)
+	if err != nil {
+		h.logError(err, "GetLocations")
+	}
+
+	return h.msg.DeleteAndSendMessage(ctx, chatID, messageID, ui.GetKeyLocationResultText(conn, locations, true), ui.GetKeyLocationResultKeyboard())
+}
+
+func subscriptionsWithoutKeys(subscriptions []*core.Subscription, connections []*core.VPNConnection) []*core.Subscription {
+	withKeys := make(map[string]bool)
+	for _, conn := range connections {
+		withKeys[conn.SubscriptionID] = true
+	}
+
+	var result []*core.Subscription
+	for _, sub := range subscriptions {
+		if sub.IsActive && !sub.IsExpired() && !withKeys[sub.ID] {
+			result = append(result, sub)
+		}
+	}
+
+	return result
+}
+
+func locationErrorText(err error) (string, bool) {
+	switch {
+	case errors.Is(err, usecase.ErrPanelUnavailable):
+
+		return ui.GetServerUnavailableText(), true
+	case errors.Is(err, usecase.ErrServerFull):
+
+		return "🚫 На этом сервере нет свободных мест. Выберите другую локацию.", true
+	case errors.Is(err, usecase.ErrNoServersAvailable):
+
+		return "🚫 Сейчас на всех серверах нет свободных мест. Попробуйте позже.", true
+	case errors.Is(err, usecase.ErrServerNotFound):
+
+		return "❌ Сервер не найден. Выберите локацию из списка.", true
+	case errors.Is(err, usecase.ErrSameServer):
+
+		return "ℹ️ Ключ уже находится в этой локации.", true
+	case errors.Is(err, usecase.ErrSubscriptionNotActive):
+
+		return "❌ Подписка неактивна. Продлите ее, чтобы создать ключ.", true
+	case errors.Is(err, usecase.ErrVPNConfigLimitReached):
+
+		return "ℹ️ Для этой подписки ключ уже создан.", true
+	case errors.Is(err, usecase.ErrNotFound):
+
+		return "❌ Ключ или подписка не найдены", true
+	default:
+
+		return "", false
+	}
+}
+
 func (h *BaseHandler) HandleMyConfigs(ctx context.Context, userID, chatID int64, messageID int) error {
 	slog.Info("Handling my configs", "user_id", userID)
 
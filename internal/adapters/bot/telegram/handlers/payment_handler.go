@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 
+	"3xui-bot/internal/core"
 	"3xui-bot/internal/usecase"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -29,7 +31,7 @@ func NewPaymentHandler(
 func (h *PaymentHandler) HandleSelectPlan(ctx context.Context, userID int64, chatID int64, planID string) error {
 	slog.Info("User selected plan", "user_id", userID, "plan_id", planID)
 
-	payment, paymentURL, err := h.paymentUC.CreatePaymentForPlan(ctx, userID, planID)
+	payment, paymentURL, err := h.paymentUC.CreatePaymentForPlan(ctx, userID, planID, core.PaymentMethodCard, "")
 	if err != nil {
 
 		return fmt.Errorf("failed to create payment: %w", err)
@@ -67,14 +69,20 @@ func (h *PaymentHandler) HandleSelectPlan(ctx context.Context, userID int64, cha
 	return nil
 }
 
-func (h *PaymentHandler) HandlePaymentCheck(ctx context.Context, userID int64, chatID int64, messageID int, paymentID string, planID string) error {
+func (h *PaymentHandler) HandlePaymentCheck(ctx context.Context, userID int64, chatID int64, messageID int, paymentID string) error {
 	slog.Info("Checking payment %s for user %d", paymentID, userID)
 
-	if err := h.paymentUC.ProcessPaymentSuccess(ctx, paymentID, planID); err != nil {
+	status, err := h.paymentUC.CheckPayment(ctx, paymentID)
+	if err != nil || status != core.PaymentStatusCompleted {
 		msg := tgbotapi.NewMessage(chatID, "❌ Платеж не найден или еще не обработан. Попробуйте позже.")
 		h.bot.Send(msg)
 
-		return fmt.Errorf("failed to process payment: %w", err)
+		if err != nil {
+
+			return fmt.Errorf("failed to process payment: %w", err)
+		}
+
+		return nil
 	}
 
 	deleteMsg := tgbotapi.NewDeleteMessage(chatID, messageID)
@@ -99,7 +107,14 @@ func (h *PaymentHandler) HandlePaymentCheck(ctx context.Context, userID int64, c
 func (h *PaymentHandler) HandlePaymentCancel(ctx context.Context, userID int64, chatID int64, messageID int, paymentID string) error {
 	slog.Info("Cancelling payment %s for user %d", paymentID, userID)
 
-	if err := h.paymentUC.ProcessPaymentCancellation(ctx, paymentID); err != nil {
+	err := h.paymentUC.CancelPendingPayment(ctx, paymentID)
+	if errors.Is(err, usecase.ErrPaymentAlreadyPaid) {
+		msg := tgbotapi.NewMessage(chatID, "✅ Платеж уже оплачен, отменить его нельзя.")
+		h.bot.Send(msg)
+
+		return nil
+	}
+	if err != nil {
 
 		return fmt.Errorf("failed to cancel payment: %w", err)
 	}
@@ -116,22 +131,71 @@ func (h *PaymentHandler) HandlePaymentCancel(ctx context.Context, userID int64,
 	return nil
 }
 
-func (h *PaymentHandler) HandlePaymentWebhook(ctx context.Context, paymentID string, planID string, status string) error {
-	slog.Info("Received webhook for payment %s with status %s", paymentID, status)
+func (h *PaymentHandler) HandlePaymentWebhook(ctx context.Context, paymentID string, externalID string, status string, signed bool) error {
+	slog.Info("Received payment webhook", "payment_id", paymentID, "status", status, "signed", signed)
+
+	payment, err := h.resolveWebhookPayment(ctx, paymentID, externalID)
+	if err != nil {
+
+		return err
+	}
 
 	switch status {
-	case "succeeded", "completed":
+	case "succeeded", "completed", "waiting_for_capture":
+		_, err := h.paymentUC.CheckPayment(ctx, payment.ID)
 
-		return h.paymentUC.ProcessPaymentSuccess(ctx, paymentID, planID)
+		return err
 	case "failed":
+		if !signed {
 
-		return h.paymentUC.ProcessPaymentFailure(ctx, paymentID)
+			return h.verifyWebhookStatus(ctx, payment)
+		}
+
+		return h.paymentUC.ProcessPaymentFailure(ctx, payment.ID)
 	case "cancelled", "canceled":
+		if !signed {
+
+			return h.verifyWebhookStatus(ctx, payment)
+		}
 
-		return h.paymentUC.ProcessPaymentCancellation(ctx, paymentID)
+		return h.paymentUC.ProcessPaymentCancellation(ctx, payment.ID)
 	default:
 		slog.Info("Unknown payment status", "status", status)
 
 		return nil
 	}
 }
+
+func (h *PaymentHandler) verifyWebhookStatus(ctx context.Context, payment *core.Payment) error {
+	status, err := h.paymentUC.CheckPayment(ctx, payment.ID)
+	if err != nil {
+
+		return err
+	}
+
+	if status == core.PaymentStatusPending || status == core.PaymentStatusWaitingForCapture {
+		slog.Warn("Unsigned payment webhook does not match provider status", "payment_id", payment.ID, "provider_status", status)
+	}
+
+	return nil
+}
+
+func (h *PaymentHandler) resolveWebhookPayment(ctx context.Context, paymentID string, externalID string) (*core.Payment, error) {
+	if paymentID == "" {
+
+		return h.paymentUC.GetPaymentByExternalID(ctx, externalID)
+	}
+
+	payment, err := h.paymentUC.GetPayment(ctx, paymentID)
+	if err != nil {
+
+		return nil, err
+	}
+
+	if externalID != "" && payment.ExternalID != "" && payment.ExternalID != externalID {
+
+		return nil, fmt.Errorf("external ID mismatch for payment %s: %w", paymentID, usecase.ErrInvalidInput)
+	}
+
+	return payment, nil
+}
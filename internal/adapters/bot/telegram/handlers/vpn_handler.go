@@ -1,29 +1,38 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"strings"
 
+	"3xui-bot/internal/adapters/bot/telegram/ui"
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/pkg/qr"
+	"3xui-bot/internal/ports"
 	"3xui-bot/internal/usecase"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 type VPNHandler struct {
-	bot   *tgbotapi.BotAPI
-	vpnUC *usecase.VPNUseCase
+	bot      *tgbotapi.BotAPI
+	notifier ports.Notifier
+	vpnUC    *usecase.VPNUseCase
 }
 
 func NewVPNHandler(
 	bot *tgbotapi.BotAPI,
+	notifier ports.Notifier,
 	vpnUC *usecase.VPNUseCase,
 ) *VPNHandler {
 
 	return &VPNHandler{
-		bot:   bot,
-		vpnUC: vpnUC,
+		bot:      bot,
+		notifier: notifier,
+		vpnUC:    vpnUC,
 	}
 }
 
@@ -87,7 +96,7 @@ func (h *VPNHandler) HandleShowVPNs(ctx context.Context, userID int64, chatID in
 		row := tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData(
 				fmt.Sprintf("📥 %s", vpn.Name),
-				fmt.Sprintf("vpn_config_%s", vpn.ID),
+				ui.CallbackPrefixVPNConfig+vpn.ID,
 			),
 		)
 		rows = append(rows, row)
@@ -108,11 +117,15 @@ func (h *VPNHandler) HandleShowVPNs(ctx context.Context, userID int64, chatID in
 }
 
 func (h *VPNHandler) HandleGetVPNConfig(ctx context.Context, userID int64, chatID int64, vpnID string) error {
-	slog.Info("Getting VPN config %s for user %d", vpnID, userID)
+	slog.Info("Getting VPN config", "vpn_id", vpnID, "user_id", userID)
 
 	vpn, err := h.vpnUC.GetVPNConnectionWithStats(ctx, vpnID)
 	if err != nil {
-		msg := tgbotapi.NewMessage(chatID, "❌ VPN подключение не найдено.")
+		text := "❌ VPN подключение не найдено."
+		if errors.Is(err, usecase.ErrPanelUnavailable) {
+			text = ui.GetServerUnavailableText()
+		}
+		msg := tgbotapi.NewMessage(chatID, text)
 		h.bot.Send(msg)
 
 		return fmt.Errorf("failed to get VPN: %w", err)
@@ -125,50 +138,60 @@ func (h *VPNHandler) HandleGetVPNConfig(ctx context.Context, userID int64, chatI
 		return fmt.Errorf("unauthorized access to VPN")
 	}
 
-	configText := fmt.Sprintf(
-		"🔐 *Конфигурация VPN: %s*\n\n"+
-			"Username: `%s`\n"+
-			"Статус: %s\n\n"+
-			"📝 *Инструкция по подключению:*\n"+
-			"1. Скачайте приложение VPN клиента\n"+
-			"2. Импортируйте конфигурацию\n"+
-			"3. Подключитесь к серверу\n\n"+
-			"⚠️ Не делитесь конфигурацией с другими!",
-		vpn.Name,
-		vpn.MarzbanUsername,
-		vpn.Status,
-	)
-
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("📊 Статистика", fmt.Sprintf("vpn_stats_%s", vpn.ID)),
-			tgbotapi.NewInlineKeyboardButtonData("🔄 Обновить", fmt.Sprintf("vpn_refresh_%s", vpn.ID)),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("◀️ Назад", "vpn_list"),
-		),
-	)
-
-	msg := tgbotapi.NewMessage(chatID, configText)
-	msg.ParseMode = "Markdown"
-	msg.ReplyMarkup = keyboard
+	msg := tgbotapi.NewMessage(chatID, ui.GetVPNConfigText(vpn))
+	msg.ParseMode = "MarkdownV2"
+	msg.ReplyMarkup = ui.GetVPNConfigKeyboard(vpn.ID)
 
 	if _, err := h.bot.Send(msg); err != nil {
 
 		return fmt.Errorf("failed to send message: %w", err)
 	}
 
+	h.sendQRCodes(ctx, chatID, vpn)
+
 	return nil
 }
 
+func (h *VPNHandler) sendQRCodes(ctx context.Context, chatID int64, vpn *core.VPNConnection) {
+	if vpn.SubscriptionURL != "" {
+		h.sendQRCode(ctx, chatID, vpn.SubscriptionURL, ui.GetSubscriptionQRCaption(vpn))
+	}
+
+	for _, link := range vpn.Links {
+		h.sendQRCode(ctx, chatID, link, ui.GetLinkQRCaption(vpn, link))
+	}
+}
+
+func (h *VPNHandler) sendQRCode(ctx context.Context, chatID int64, content, caption string) {
+	image, err := qr.PNG(content, qr.DefaultSize)
+	if err != nil {
+		slog.Warn("Failed to generate QR code", "chat_id", chatID, "error", err)
+
+		return
+	}
+
+	if err := h.notifier.SendPhotoFromReader(ctx, chatID, bytes.NewReader(image), caption, nil); err != nil {
+		slog.Warn("Failed to send QR code", "chat_id", chatID, "error", err)
+	}
+}
+
 func (h *VPNHandler) HandleVPNStats(ctx context.Context, userID int64, chatID int64, messageID int, vpnID string) error {
 	slog.Info("Showing stats for VPN", "vpn_id", vpnID)
 
 	vpn, err := h.vpnUC.GetVPNConnectionWithStats(ctx, vpnID)
+	if errors.Is(err, usecase.ErrPanelUnavailable) {
+		h.bot.Send(tgbotapi.NewMessage(chatID, ui.GetServerUnavailableText()))
+
+		return fmt.Errorf("failed to get VPN: %w", err)
+	}
 	if err != nil {
 
 		return fmt.Errorf("failed to get VPN: %w", err)
 	}
+	if vpn.TelegramUserID != userID {
+
+		return fmt.Errorf("unauthorized access to VPN")
+	}
 
 	usedGB := 0.0
 	limitGB := 0.0
@@ -203,8 +226,8 @@ func (h *VPNHandler) HandleVPNStats(ctx context.Context, userID int64, chatID in
 
 	keyboard := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("🔄 Обновить", fmt.Sprintf("vpn_stats_%s", vpnID)),
-			tgbotapi.NewInlineKeyboardButtonData("◀️ Назад", fmt.Sprintf("vpn_config_%s", vpnID)),
+			tgbotapi.NewInlineKeyboardButtonData("🔄 Обновить", ui.CallbackPrefixVPNStats+vpnID),
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Назад", ui.CallbackPrefixVPNConfig+vpnID),
 		),
 	)
 	editMsg.ReplyMarkup = &keyboard
@@ -221,6 +244,9 @@ func (h *VPNHandler) HandleVPNRefresh(ctx context.Context, userID int64, chatID
 	slog.Info("Refreshing VPN", "vpn_id", vpnID)
 
 	if err := h.vpnUC.SyncVPNStatus(ctx, vpnID); err != nil {
+		if errors.Is(err, usecase.ErrPanelUnavailable) {
+			h.bot.Send(tgbotapi.NewMessage(chatID, ui.GetServerUnavailableText()))
+		}
 
 		return fmt.Errorf("failed to sync VPN: %w", err)
 	}
@@ -2,10 +2,10 @@ package telegram
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
-	"strings"
-	"time"
+	"strconv"
 
 	"3xui-bot/internal/adapters/bot/telegram/handlers"
 	"3xui-bot/internal/adapters/bot/telegram/ui"
@@ -30,6 +30,7 @@ type Router struct {
 	callbackHandler *handlers.CallbackHandler
 	paymentHandler  *handlers.PaymentHandler
 	vpnHandler      *handlers.VPNHandler
+	adminHandler    *handlers.AdminHandler
 }
 
 func NewRouter(
@@ -41,6 +42,11 @@ func NewRouter(
 	vpnUC *usecase.VPNUseCase,
 	referralUC *usecase.ReferralUseCase,
 	notifUC *usecase.NotificationUseCase,
+	promoUC *usecase.PromoCodeUseCase,
+	balanceUC *usecase.BalanceUseCase,
+	reconUC *usecase.ReconciliationUseCase,
+	trafficUC *usecase.TrafficUseCase,
+	adminIDs []int64,
 ) *Router {
 	r := &Router{
 		bot:        bot,
@@ -54,9 +60,10 @@ func NewRouter(
 	}
 
 	r.startHandler = handlers.NewStartHandler(bot, notifier, userUC, subUC)
-	r.callbackHandler = handlers.NewCallbackHandler(userUC, subUC, paymentUC, vpnUC, referralUC, notifUC, bot)
+	r.callbackHandler = handlers.NewCallbackHandler(userUC, subUC, paymentUC, vpnUC, referralUC, notifUC, balanceUC, trafficUC, bot)
 	r.paymentHandler = handlers.NewPaymentHandler(bot, paymentUC)
-	r.vpnHandler = handlers.NewVPNHandler(bot, vpnUC)
+	r.vpnHandler = handlers.NewVPNHandler(bot, notifier, vpnUC)
+	r.adminHandler = handlers.NewAdminHandler(bot, paymentUC, promoUC, reconUC, adminIDs)
 
 	return r
 }
@@ -101,6 +108,41 @@ func (r *Router) handleCommand(ctx context.Context, message *tgbotapi.Message) e
 	case "vpn":
 
 		return r.vpnHandler.HandleShowVPNs(ctx, message.From.ID, message.Chat.ID)
+	case "refund":
+		if !r.adminHandler.IsAdmin(message.From.ID) {
+
+			return r.handleUnknownCommand(ctx, message)
+		}
+
+		return r.adminHandler.HandleRefund(ctx, message)
+	case "refunds":
+		if !r.adminHandler.IsAdmin(message.From.ID) {
+
+			return r.handleUnknownCommand(ctx, message)
+		}
+
+		return r.adminHandler.HandleRefundHistory(ctx, message)
+	case "receipt":
+		if !r.adminHandler.IsAdmin(message.From.ID) {
+
+			return r.handleUnknownCommand(ctx, message)
+		}
+
+		return r.adminHandler.HandleResendReceipt(ctx, message)
+	case "promo_create", "promos", "promo_enable", "promo_disable":
+		if !r.adminHandler.IsAdmin(message.From.ID) {
+
+			return r.handleUnknownCommand(ctx, message)
+		}
+
+		return r.adminHandler.HandlePromoCommand(ctx, message)
+	case "reconcile":
+		if !r.adminHandler.IsAdmin(message.From.ID) {
+
+			return r.handleUnknownCommand(ctx, message)
+		}
+
+		return r.adminHandler.HandleReconcile(ctx, message)
 	default:
 
 		return r.handleUnknownCommand(ctx, message)
@@ -112,6 +154,11 @@ func (r *Router) handleCallback(ctx context.Context, callback *tgbotapi.Callback
 		_ = botPort.AnswerCallback(ctx, callback.ID, "", false)
 	}
 
+	if handled, err := r.handleVPNCallback(ctx, callback); handled {
+
+		return err
+	}
+
 	update := tgbotapi.Update{
 		CallbackQuery: callback,
 	}
@@ -119,6 +166,36 @@ func (r *Router) handleCallback(ctx context.Context, callback *tgbotapi.Callback
 	return r.callbackHandler.Handle(ctx, update)
 }
 
+func (r *Router) handleVPNCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) (bool, error) {
+	if callback.Message == nil {
+
+		return false, nil
+	}
+
+	userID := callback.From.ID
+	chatID := callback.Message.Chat.ID
+	messageID := callback.Message.MessageID
+
+	if callback.Data == ui.CallbackVPNList {
+
+		return true, r.vpnHandler.HandleShowVPNs(ctx, userID, chatID)
+	}
+	if vpnID, ok := ui.ParseVPNConfigCallback(callback.Data); ok {
+
+		return true, r.vpnHandler.HandleGetVPNConfig(ctx, userID, chatID, vpnID)
+	}
+	if vpnID, ok := ui.ParseVPNStatsCallback(callback.Data); ok {
+
+		return true, r.vpnHandler.HandleVPNStats(ctx, userID, chatID, messageID, vpnID)
+	}
+	if vpnID, ok := ui.ParseVPNRefreshCallback(callback.Data); ok {
+
+		return true, r.vpnHandler.HandleVPNRefresh(ctx, userID, chatID, messageID, vpnID)
+	}
+
+	return false, nil
+}
+
 func (r *Router) handleStart(ctx context.Context, message *tgbotapi.Message) error {
 
 	return r.startHandler.Handle(ctx, message)
@@ -145,12 +222,30 @@ func (r *Router) handlePreCheckout(ctx context.Context, query *tgbotapi.PreCheck
 		"total_amount", query.TotalAmount,
 		"payload", query.InvoicePayload)
 
-	config := tgbotapi.PreCheckoutConfig{
-		PreCheckoutQueryID: query.ID,
-		OK:                 true,
+	paymentID, ok := ui.ParseStarsInvoicePayload(query.InvoicePayload)
+	if !ok {
+		slog.Warn("Pre-checkout query with unknown payload", "payload", query.InvoicePayload)
+
+		return r.answerPreCheckout(query.ID, "Счет не найден. Оформите подписку заново.")
 	}
 
-	_, err := r.bot.Request(config)
+	if err := r.paymentUC.ValidateStarsCheckout(ctx, query.From.ID, paymentID, query.Currency, query.TotalAmount); err != nil {
+		slog.Warn("Pre-checkout validation failed", "payment_id", paymentID, "user_id", query.From.ID, "error", err)
+
+		return r.answerPreCheckout(query.ID, preCheckoutErrorMessage(err))
+	}
+
+	return r.answerPreCheckout(query.ID, "")
+}
+
+func (r *Router) answerPreCheckout(queryID string, errorMessage string) error {
+	params := tgbotapi.Params{
+		"pre_checkout_query_id": queryID,
+		"ok":                    strconv.FormatBool(errorMessage == ""),
+	}
+	params.AddNonEmpty("error_message", errorMessage)
+
+	_, err := r.bot.MakeRequest("answerPreCheckoutQuery", params)
 	if err != nil {
 		slog.Error("Failed to answer pre-checkout query", "error", err)
 	}
@@ -158,6 +253,29 @@ func (r *Router) handlePreCheckout(ctx context.Context, query *tgbotapi.PreCheck
 	return err
 }
 
+func preCheckoutErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, usecase.ErrPaymentAlreadyPaid):
+
+		return "Этот счет уже оплачен."
+	case errors.Is(err, usecase.ErrPaymentCancelled), errors.Is(err, usecase.ErrPaymentFailed):
+
+		return "Счет больше не действителен. Оформите подписку заново."
+	case errors.Is(err, usecase.ErrPlanNotActive):
+
+		return "Тариф больше недоступен. Выберите другой тариф."
+	case errors.Is(err, usecase.ErrInvalidAmount):
+
+		return "Сумма счета изменилась. Оформите подписку заново."
+	case errors.Is(err, usecase.ErrUnauthorized), errors.Is(err, usecase.ErrNotFound):
+
+		return "Счет не найден. Оформите подписку заново."
+	default:
+
+		return "Не удалось проверить платеж. Попробуйте позже."
+	}
+}
+
 func (r *Router) handleSuccessfulPayment(ctx context.Context, message *tgbotapi.Message) error {
 	payment := message.SuccessfulPayment
 	userID := message.From.ID
@@ -170,80 +288,48 @@ func (r *Router) handleSuccessfulPayment(ctx context.Context, message *tgbotapi.
 		"payload", payment.InvoicePayload,
 		"telegram_payment_charge_id", payment.TelegramPaymentChargeID)
 
-	var planID string
-	if _, err := fmt.Sscanf(payment.InvoicePayload, "plan_%s", &planID); err == nil {
-		if idx := strings.Index(planID, "_user_"); idx > 0 {
-			planID = planID[:idx]
-		}
-	} else {
+	paymentID, ok := ui.ParseStarsInvoicePayload(payment.InvoicePayload)
+	if !ok {
 		slog.Error("Failed to parse payload", "payload", payment.InvoicePayload)
 		r.notifier.Send(ctx, chatID, "❌ Ошибка обработки платежа. Обратитесь в поддержку.", nil)
 
-		return err
+		return fmt.Errorf("unknown invoice payload: %s", payment.InvoicePayload)
 	}
 
-	plan, err := r.subUC.GetPlanByID(ctx, planID)
-	if err != nil {
-		slog.Error("Failed to get plan", "plan_id", planID, "error", err)
-		r.notifier.Send(ctx, chatID, "❌ План не найден. Обратитесь в поддержку.", nil)
+	result, err := r.paymentUC.ProcessStarsPayment(ctx, paymentID, payment.TelegramPaymentChargeID)
+	if errors.Is(err, usecase.ErrPaymentAlreadyPaid) {
+		slog.Info("Stars payment already processed", "payment_id", paymentID)
 
-		return err
+		return nil
 	}
-
-	slog.Info("Creating subscription after successful Stars payment",
-		"user_id", userID,
-		"plan_id", planID,
-		"charge_id", payment.TelegramPaymentChargeID)
-
-	subscription, err := r.subUC.CreateSubscription(ctx, usecase.CreateSubscriptionDTO{
-		UserID:    userID,
-		PlanID:    plan.ID,
-		Name:      fmt.Sprintf("%s (Stars)", plan.Name),
-		StartDate: time.Now(),
-		EndDate:   time.Now().AddDate(0, 0, plan.Days),
-		IsActive:  true,
-	})
 	if err != nil {
-		slog.Error("Failed to create subscription after Stars payment",
+		slog.Error("Failed to process Stars payment",
 			"error", err,
 			"user_id", userID,
-			"plan_id", planID)
-		r.notifier.Send(ctx, chatID, "❌ Не удалось создать подписку. Деньги будут возвращены. Обратитесь в поддержку.", nil)
-
-		return err
-	}
-
-	slog.Info("Subscription created successfully",
-		"subscription_id", subscription.ID,
-		"user_id", userID,
-		"plan_id", planID,
-		"end_date", subscription.EndDate.Format("2006-01-02 15:04:05"))
+			"payment_id", paymentID)
 
-	vpnConnection, err := r.vpnUC.CreateVPNForSubscription(ctx, userID, subscription.ID)
-	if err != nil {
-		slog.Error("Failed to create VPN", "error", err)
-		text := fmt.Sprintf(`💎 Оплата Stars - Успешно! ✅
+		if refundErr := r.paymentUC.RefundStarsPayment(ctx, paymentID, payment.TelegramPaymentChargeID); refundErr != nil {
+			slog.Error("Failed to refund Stars payment", "payment_id", paymentID, "error", refundErr)
+			r.notifier.Send(ctx, chatID, "❌ Не удалось активировать подписку. Обратитесь в поддержку, мы вернем Stars.", nil)
 
-📦 План: %s
-💎 Оплачено: %d Stars
-
-⚠️ Подписка создана, но не удалось создать VPN конфигурацию.
-Обратитесь в поддержку.`, plan.Name, payment.TotalAmount)
+			return err
+		}
 
-		r.notifier.Send(ctx, chatID, text, ui.GetMainMenuWithProfileKeyboard(true))
+		r.notifier.Send(ctx, chatID, fmt.Sprintf("❌ Не удалось активировать подписку. %d Stars возвращены на ваш счет.", payment.TotalAmount), ui.GetMainMenuWithProfileKeyboard(true))
 
 		return err
 	}
 
-	slog.Info("VPN created successfully",
-		"vpn_id", vpnConnection.ID,
-		"marzban_username", vpnConnection.MarzbanUsername,
-		"subscription_id", subscription.ID)
+	slog.Info("Stars payment processed",
+		"payment_id", paymentID,
+		"subscription_id", result.Subscription.ID,
+		"vpn_id", result.VPNConnection.ID,
+		"marzban_username", result.VPNConnection.MarzbanUsername)
 
 	text := fmt.Sprintf(`🎉 Оплата Stars завершена успешно!
 
 📦 План: %s
-💎 Оплачено: %d Stars (%.0f₽)
+💎 Оплачено: %d Stars
 ⏰ Длительность: %d дней
 📅 Действует до: %s
 
@@ -251,12 +337,11 @@ func (r *Router) handleSuccessfulPayment(ctx context.Context, message *tgbotapi.
 🔑 VPN ключ создан: %s
 
 Перейдите в "💳 Мои подписки" для получения конфигурации и настройки VPN.`,
-		plan.Name,
+		result.Plan.Name,
 		payment.TotalAmount,
-		plan.Price,
-		plan.Days,
-		subscription.EndDate.Format("02.01.2006 15:04"),
-		vpnConnection.Name)
+		result.Plan.Days,
+		result.Subscription.EndDate.Format("02.01.2006 15:04"),
+		result.VPNConnection.Name)
 
 	keyboard := ui.GetMainMenuWithProfileKeyboard(true)
 
@@ -339,6 +424,11 @@ func (r *Router) ReferralUC() *usecase.ReferralUseCase {
 	return r.referralUC
 }
 
+func (r *Router) PaymentHandler() *handlers.PaymentHandler {
+
+	return r.paymentHandler
+}
+
 func (r *Router) EditMessageText(ctx context.Context, chatID int64, messageID int, text string, replyMarkup interface{}) error {
 	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
 	if replyMarkup != nil {
@@ -78,6 +78,16 @@ func (s *MessageService) EditMessageWithMarkdownV2(ctx context.Context, chatID i
 	return err
 }
 
+func (s *MessageService) SendInvoice(ctx context.Context, chatID int64, title, description, payload, currency string, amount int) error {
+	invoice := tgbotapi.NewInvoice(chatID, title, description, payload, "", "", currency, []tgbotapi.LabeledPrice{
+		{Label: title, Amount: amount},
+	})
+	invoice.SuggestedTipAmounts = []int{}
+	_, err := s.bot.Send(invoice)
+
+	return err
+}
+
 func (s *MessageService) DeleteMessage(ctx context.Context, chatID int64, messageID int) error {
 	msg := tgbotapi.NewDeleteMessage(chatID, messageID)
 	_, err := s.bot.Request(msg)
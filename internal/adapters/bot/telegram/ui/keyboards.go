@@ -66,6 +66,12 @@ func GetProfileKeyboard(isPremium bool) tgbotapi.InlineKeyboardMarkup {
 	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
 		tgbotapi.NewInlineKeyboardButtonData("💳 Мои подписки", "my_subscriptions"),
 	))
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("👛 Баланс и пополнение", CallbackOpenBalance),
+	))
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("✉️ Указать email для чеков", CallbackSetEmail),
+	))
 	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
 		tgbotapi.NewInlineKeyboardButtonData("👥 Реферальная программа", "open_referrals"),
 		tgbotapi.NewInlineKeyboardButtonData("💬 Поддержка", "open_support"),
@@ -93,7 +99,11 @@ func GetPricingKeyboard(plans []*core.Plan) tgbotapi.InlineKeyboardMarkup {
 
 	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
-func GetPaymentMethodKeyboard(planID string) tgbotapi.InlineKeyboardMarkup {
+func GetPaymentMethodKeyboard(planID string, promoApplied bool) tgbotapi.InlineKeyboardMarkup {
+	promoButton := tgbotapi.NewInlineKeyboardButtonData("🎟 Ввести промокод", CallbackPrefixPromoEnter+planID)
+	if promoApplied {
+		promoButton = tgbotapi.NewInlineKeyboardButtonData("🗑 Убрать промокод", CallbackPrefixPromoClear+planID)
+	}
 
 	return tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
@@ -105,11 +115,29 @@ func GetPaymentMethodKeyboard(planID string) tgbotapi.InlineKeyboardMarkup {
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("💎 Stars", fmt.Sprintf("pay_stars_%s", planID)),
 		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("👛 С баланса", CallbackPrefixPayBalance+planID),
+		),
+		tgbotapi.NewInlineKeyboardRow(promoButton),
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "open_pricing"),
 		),
 	)
 }
+func GetPaymentLinkKeyboard(paymentURL, paymentID string) tgbotapi.InlineKeyboardMarkup {
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonURL("💳 Перейти к оплате", paymentURL),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Я оплатил", CallbackPrefixPaymentCheck+paymentID),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("❌ Отменить", CallbackPrefixPaymentCancel+paymentID),
+		),
+	)
+}
 func GetSubscriptionsKeyboard(subscriptions []*core.Subscription) tgbotapi.InlineKeyboardMarkup {
 	var rows [][]tgbotapi.InlineKeyboardButton
 	if len(subscriptions) == 0 {
@@ -189,20 +217,30 @@ func GetCreateSubscriptionKeyboard(plans []*core.Plan) tgbotapi.InlineKeyboardMa
 
 	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
-func GetKeysKeyboard() tgbotapi.InlineKeyboardMarkup {
+func GetKeysKeyboard(connections []*core.VPNConnection, withoutKeys []*core.Subscription, canChangeLocation bool) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, conn := range connections {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔑 Подключить: "+conn.Name, CallbackPrefixVPNConfig+conn.ID),
+		))
+	}
+	if canChangeLocation {
+		for _, conn := range connections {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("🌍 Сменить локацию: "+conn.Name, CallbackPrefixChangeLocation+conn.ID),
+			))
+		}
+	}
+	for _, sub := range withoutKeys {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("➕ Создать ключ: "+sub.GetDisplayName(), CallbackPrefixCreateKey+sub.ID),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "open_profile"),
+	))
 
-	return tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("🔑 WireGuard", "create_wireguard"),
-			tgbotapi.NewInlineKeyboardButtonData("🔑 Shadowsocks", "create_shadowsocks"),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("📋 Мои конфиги", "my_configs"),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "open_profile"),
-		),
-	)
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
 func GetReferralsKeyboard() tgbotapi.InlineKeyboardMarkup {
 
@@ -302,19 +340,20 @@ func GetInstructionText() string {
 💡 Если возникли проблемы - обратитесь в поддержку!`
 }
 
-func GetInstructionWithConnectionText(subscriptionID string) string {
-	connectionURL := fmt.Sprintf("https://3xui.com/connect/%s", subscriptionID)
-
-	return fmt.Sprintf(`📖 Инструкция по подключению
-
-🔗 Ваша ссылка для подключения:
-`+"`%s`"+`
-
-🔹 Как начать:
+func GetInstructionWithConnectionText(subscriptionURL string) string {
+	var text strings.Builder
+	text.WriteString(EscapeMarkdownV2("📖 Инструкция по подключению") + "\n\n")
+	if subscriptionURL != "" {
+		text.WriteString(EscapeMarkdownV2("🔗 Ваша ссылка на подписку:") + "\n")
+		text.WriteString(CodeMarkdownV2(subscriptionURL) + "\n\n")
+	} else {
+		text.WriteString(EscapeMarkdownV2("🔗 Ссылка появится после создания ключа в разделе «Ключи и локации».") + "\n\n")
+	}
+	text.WriteString(EscapeMarkdownV2(`🔹 Как начать:
 1. Скачайте приложение для вашей платформы
 2. Откройте приложение
-3. Нажмите "Добавить конфигурацию"
-4. Отсканируйте QR-код или вставьте ссылку выше
+3. Нажмите "Добавить подписку" или "Импорт из буфера"
+4. Вставьте ссылку выше или отсканируйте QR-код ключа
 5. Нажмите "Подключиться"
 
 🔹 Рекомендуемые приложения:
@@ -335,13 +374,104 @@ func GetInstructionWithConnectionText(subscriptionID string) string {
 • V2Box (бесплатно)
 • FoXray (бесплатно)
 
-💡 Если возникли проблемы - обратитесь в поддержку!`, connectionURL)
+💡 Если возникли проблемы - обратитесь в поддержку!`))
+
+	return text.String()
+}
+func GetConnectionGuideKeyboard(subscriptionID string, connections []*core.VPNConnection) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, conn := range connections {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📷 Ссылки и QR: "+conn.Name, CallbackPrefixVPNConfig+conn.ID),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", CallbackPrefixViewSubscription+subscriptionID),
+	))
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+func GetVPNConfigText(vpn *core.VPNConnection) string {
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("🔐 *Конфигурация VPN: %s*\n\n", EscapeMarkdownV2(vpn.Name)))
+	text.WriteString(fmt.Sprintf("Статус: %s\n\n", EscapeMarkdownV2(vpn.Status)))
+	if vpn.SubscriptionURL != "" {
+		text.WriteString("🔗 *Ссылка на подписку:*\n")
+		text.WriteString(CodeMarkdownV2(vpn.SubscriptionURL) + "\n")
+		text.WriteString(EscapeMarkdownV2("Добавьте ее в приложение как подписку — настройки будут обновляться автоматически.") + "\n\n")
+	}
+	if len(vpn.Links) > 0 {
+		text.WriteString("🔑 *Ключи подключения:*\n")
+		for _, link := range vpn.Links {
+			text.WriteString(fmt.Sprintf("%s:\n%s\n", EscapeMarkdownV2(LinkProtocol(link)), CodeMarkdownV2(link)))
+		}
+		text.WriteString("\n")
+	}
+	if vpn.SubscriptionURL == "" && len(vpn.Links) == 0 {
+		text.WriteString(EscapeMarkdownV2("⚠️ Панель не вернула ссылки подключения. Нажмите «Обновить» или обратитесь в поддержку.") + "\n\n")
+	} else {
+		text.WriteString(EscapeMarkdownV2("📷 QR-коды для сканирования отправлены ниже.") + "\n\n")
+	}
+	text.WriteString(EscapeMarkdownV2("⚠️ Не делитесь конфигурацией с другими!"))
+
+	return text.String()
+}
+func GetVPNConfigKeyboard(vpnID string) tgbotapi.InlineKeyboardMarkup {
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📊 Статистика", CallbackPrefixVPNStats+vpnID),
+			tgbotapi.NewInlineKeyboardButtonData("🔄 Обновить", CallbackPrefixVPNRefresh+vpnID),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Назад", CallbackVPNList),
+		),
+	)
 }
-func GetProfileText(user *core.User, isPremium bool, statusText, subUntilText string) string {
+func GetSubscriptionQRCaption(vpn *core.VPNConnection) string {
+
+	return fmt.Sprintf("📷 QR-код подписки «%s»", vpn.Name)
+}
+func GetLinkQRCaption(vpn *core.VPNConnection, link string) string {
+
+	return fmt.Sprintf("📷 %s — «%s»", LinkProtocol(link), vpn.Name)
+}
+func LinkProtocol(link string) string {
+	scheme, _, found := strings.Cut(link, "://")
+	if !found {
+
+		return "Ссылка"
+	}
+
+	switch strings.ToLower(scheme) {
+	case "vless":
+
+		return "VLESS"
+	case "vmess":
+
+		return "VMess"
+	case "trojan":
+
+		return "Trojan"
+	case "ss":
+
+		return "Shadowsocks"
+	default:
+
+		return strings.ToUpper(scheme)
+	}
+}
+func GetProfileText(user *core.User, isPremium bool, statusText, subUntilText string, balance float64) string {
 	text := "👤 Ваш профиль\n\n"
 	text += fmt.Sprintf("🆔 ID: %d\n", user.TelegramID)
 	text += fmt.Sprintf("👋 Имя: %s\n", user.GetDisplayName())
 	text += fmt.Sprintf("🌐 Язык: %s\n", user.LanguageCode)
+	text += fmt.Sprintf("👛 Баланс: %.2f₽\n", balance)
+	if user.Email != "" {
+		text += fmt.Sprintf("📧 Email для чеков: %s\n", user.Email)
+	} else {
+		text += "📧 Email для чеков: не указан\n"
+	}
 	text += fmt.Sprintf("📊 Статус: %s\n", statusText)
 	if isPremium && subUntilText != "" {
 		text += fmt.Sprintf("⏰ Подписка до: %s\n", subUntilText)
@@ -361,6 +491,71 @@ func GetPaymentMethodText(plan *core.Plan) string {
 ⏰ Длительность: %s
 Выберите способ оплаты:`, plan.Name, plan.Price, FormatDuration(plan.Days))
 }
+func GetPaymentMethodWithPromoText(plan *core.Plan, promo *core.PromoCode, amount, discount float64, bonusDays int) string {
+	text := fmt.Sprintf(`💳 Оплата подписки
+📦 План: %s
+🎟 Промокод: %s
+`, plan.Name, promo.Code)
+
+	if discount > 0 {
+		text += fmt.Sprintf("💵 Сумма: %.0f₽ → %.0f₽ (скидка %.0f₽)\n", plan.Price, amount, discount)
+	} else {
+		text += fmt.Sprintf("💵 Сумма: %.0f₽\n", amount)
+	}
+
+	if bonusDays > 0 {
+		text += fmt.Sprintf("⏰ Длительность: %s + %s в подарок\n", FormatDuration(plan.Days), FormatDuration(bonusDays))
+	} else {
+		text += fmt.Sprintf("⏰ Длительность: %s\n", FormatDuration(plan.Days))
+	}
+
+	return text + "Выберите способ оплаты:"
+}
+func GetPromoCodeInputText(plan *core.Plan) string {
+
+	return fmt.Sprintf(`🎟 Промокод для тарифа «%s»
+Отправьте промокод следующим сообщением.`, plan.Name)
+}
+func GetPaymentLinkText(plan *core.Plan, payment *core.Payment) string {
+
+	return fmt.Sprintf(`💳 Оплата подписки
+📦 План: %s
+💵 Сумма: %.0f₽
+⏰ Длительность: %s
+1️⃣ Нажмите «Перейти к оплате» и завершите платеж
+2️⃣ Вернитесь в бот и нажмите «Я оплатил»
+Подписка будет активирована сразу после подтверждения платежа.`, plan.Name, payment.Amount, FormatDuration(plan.Days+payment.BonusDays))
+}
+func GetFreePaymentText(plan *core.Plan, payment *core.Payment) string {
+
+	return fmt.Sprintf(`🎉 Подписка активирована по промокоду!
+📦 План: %s
+⏰ Длительность: %s
+Перейдите в «Мои подписки», чтобы получить ключ.`, plan.Name, FormatDuration(plan.Days+payment.BonusDays))
+}
+func GetStarsInvoiceTitle(plan *core.Plan) string {
+	title := []rune(fmt.Sprintf("VPN: %s", plan.Name))
+	if len(title) > 32 {
+		title = title[:32]
+	}
+
+	return string(title)
+}
+func GetStarsInvoiceDescription(plan *core.Plan) string {
+
+	return fmt.Sprintf("Подписка «%s» на %s. После оплаты VPN ключ будет создан автоматически.", plan.Name, FormatDuration(plan.Days))
+}
+func GetStarsUnavailableText(plan *core.Plan) string {
+
+	return fmt.Sprintf(`⭐ Оплата Telegram Stars
+📦 План: %s
+Оплата Stars для этого тарифа недоступна. Выберите другой способ оплаты.`, plan.Name)
+}
+func GetPaymentPendingText() string {
+
+	return `⏳ Платеж еще не подтвержден
+Если вы уже оплатили, подождите минуту и нажмите «Я оплатил» еще раз.`
+}
 func GetSubscriptionsText(subscriptions []*core.Subscription) string {
 	text := "*🔑 Список ваших подписок:*\n\n"
 	if len(subscriptions) == 0 {
@@ -386,6 +581,11 @@ func GetSubscriptionsText(subscriptions []*core.Subscription) string {
 
 	return text
 }
+func CodeMarkdownV2(text string) string {
+	replacer := strings.NewReplacer("\\", "\\\\", "`", "\\`")
+
+	return "`" + replacer.Replace(text) + "`"
+}
 func EscapeMarkdownV2(text string) string {
 	replacer := strings.NewReplacer(
 		"_", "\\_",
@@ -461,13 +661,15 @@ func GetRenameSubscriptionText(sub *core.Subscription) string {
 Текущее название: %s
 Введите новое название для подписки:`, sub.GetDisplayName())
 }
-func GetExtendSubscriptionText(sub *core.Subscription) string {
+func GetExtendSubscriptionText(sub *core.Subscription, balance float64) string {
 	text := fmt.Sprintf(`📈 Продление подписки
 Подписка: %s
 Текущее окончание: %s
+👛 Оплата с баланса, доступно: %.2f₽
 Выберите период продления:`,
 		sub.GetDisplayName(),
-		sub.EndDate.Format("02.01.2006"))
+		sub.EndDate.Format("02.01.2006"),
+		balance)
 
 	return text
 }
@@ -482,14 +684,108 @@ func GetDeleteSubscriptionText(sub *core.Subscription) string {
 		sub.GetDisplayName(),
 		sub.GetStatusText())
 }
-func GetKeysText() string {
+func GetKeysText(connections []*core.VPNConnection, locations []core.ServerLocation, withoutKeys []*core.Subscription) string {
+	var text strings.Builder
+	text.WriteString("🔑 Управление ключами\n\n")
+	if len(connections) == 0 {
+		text.WriteString("У вас пока нет VPN ключей.\n")
+	}
+	for _, conn := range connections {
+		status := "✅"
+		if !conn.IsActive {
+			status = "⏸"
+		}
+		text.WriteString(fmt.Sprintf("%s %s\n📍 Локация: %s\n\n", status, conn.Name, locationName(locations, conn.ServerName)))
+	}
+	if len(withoutKeys) > 0 {
+		text.WriteString("\n➕ Для активных подписок без ключа можно создать ключ и выбрать страну сервера.")
+	} else if len(connections) == 0 {
+		text.WriteString("Оформите подписку, чтобы получить ключ.")
+	}
+
+	return text.String()
+}
+func GetLocationPickerText(locations []core.ServerLocation, currentServer string) string {
+	var text strings.Builder
+	if currentServer == "" {
+		text.WriteString("🌍 Выберите локацию сервера для нового ключа:\n\n")
+	} else {
+		text.WriteString(fmt.Sprintf("🌍 Смена локации\n\nТекущая локация: %s\n", locationName(locations, currentServer)))
+		text.WriteString("Ключ будет перенесен на новый сервер с сохранением срока действия и оставшегося трафика. Ссылку подключения нужно будет обновить в приложении.\n\n")
+	}
+	for _, location := range locations {
+		text.WriteString(fmt.Sprintf("%s — %s\n", location.Server.DisplayName(), locationLoadText(location)))
+	}
+
+	return text.String()
+}
+func GetLocationPickerKeyboard(locations []core.ServerLocation, callbackPrefix, targetID, currentServer string) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, location := range locations {
+		name := location.Server.DisplayName()
+		switch {
+		case location.Server.Name == currentServer:
+			name = "✅ " + name
+		case location.IsFull():
+			name = "🚫 " + name
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(name, LocationCallback(callbackPrefix, targetID, location.Server.Name)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", CallbackOpenKeys),
+	))
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+func GetKeyLocationResultText(conn *core.VPNConnection, locations []core.ServerLocation, moved bool) string {
+	if moved {
+
+		return fmt.Sprintf("✅ Ключ «%s» перенесен\n📍 Новая локация: %s\n\nОбновите подписку в приложении, чтобы получить новые настройки подключения.", conn.Name, locationName(locations, conn.ServerName))
+	}
+
+	return fmt.Sprintf("✅ Ключ «%s» создан\n📍 Локация: %s", conn.Name, locationName(locations, conn.ServerName))
+}
+func GetKeyLocationResultKeyboard() tgbotapi.InlineKeyboardMarkup {
 
-	return `🔑 Управление ключами
-Здесь вы можете:
-• Создавать новые VPN конфигурации
-• Просматривать существующие конфиги
-• Управлять доступом к серверам
-Выберите тип конфигурации:`
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔑 Мои ключи", CallbackOpenKeys),
+		),
+	)
+}
+func locationName(locations []core.ServerLocation, serverName string) string {
+	for _, location := range locations {
+		if location.Server.Name == serverName {
+
+			return location.Server.DisplayName()
+		}
+	}
+
+	return serverName
+}
+func locationLoadText(location core.ServerLocation) string {
+	if location.Server.Capacity <= 0 {
+
+		return fmt.Sprintf("👥 %d ключей", location.Users)
+	}
+
+	load := location.LoadPercent()
+	switch {
+	case location.IsFull():
+
+		return "🔴 нет свободных мест"
+	case load >= 80:
+
+		return fmt.Sprintf("🟠 загрузка %d%%", load)
+	case load >= 50:
+
+		return fmt.Sprintf("🟡 загрузка %d%%", load)
+	default:
+
+		return fmt.Sprintf("🟢 загрузка %d%%", load)
+	}
 }
 func GetReferralsText() string {
 
@@ -635,15 +931,24 @@ func GetSubscriptionDetailText(subscription *core.Subscription, plan *core.Plan,
 		text.WriteString("✅ *Статус:* Активна\n")
 		endDate := EscapeMarkdownV2(subscription.EndDate.Format("02.01.06, 15:04"))
 		text.WriteString(fmt.Sprintf("📅 *Активна до:* %s\n", endDate))
+		if subscription.AutoRenew {
+			text.WriteString("🔄 *Автопродление:* включено\n")
+		} else {
+			text.WriteString("🔄 *Автопродление:* выключено\n")
+		}
 	} else {
 		text.WriteString("❌ *Статус:* Неактивна\n")
 	}
 	startDate := EscapeMarkdownV2(subscription.StartDate.Format("02.01.06"))
 	text.WriteString(fmt.Sprintf("📅 *Создана:* %s\n\n", startDate))
 	if subscription.IsActive {
-		connectionURL := fmt.Sprintf("https://3xui.com/connect/%s", subscription.ID)
-		text.WriteString("*🔗 URL подключения:*\n")
-		text.WriteString(fmt.Sprintf("`%s`", connectionURL))
+		for _, config := range vpnConfigs {
+			if config.SubscriptionURL == "" {
+				continue
+			}
+			text.WriteString(fmt.Sprintf("*🔗 Подписка %s:*\n", EscapeMarkdownV2(config.Name)))
+			text.WriteString(CodeMarkdownV2(config.SubscriptionURL) + "\n")
+		}
 	}
 
 	return text.String()
@@ -654,6 +959,9 @@ func GetSubscriptionDetailKeyboard(subscription *core.Subscription, vpnConfigs [
 		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("📖 Инструкция по подключению", fmt.Sprintf("connection_guide_%s", subscription.ID)),
 		))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🌍 Ключи и локации", CallbackOpenKeys),
+		))
 	}
 	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
 		tgbotapi.NewInlineKeyboardButtonData("✏️ Переименовать", fmt.Sprintf("rename_subscription_%s", subscription.ID)),
@@ -662,6 +970,15 @@ func GetSubscriptionDetailKeyboard(subscription *core.Subscription, vpnConfigs [
 		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("🔄 Продлить подписку", fmt.Sprintf("extend_subscription_%s", subscription.ID)),
 		))
+		if subscription.AutoRenew {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("⏹ Отключить автопродление", CallbackPrefixAutoRenewOff+subscription.ID),
+			))
+		} else {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("♻️ Включить автопродление", CallbackPrefixAutoRenewOn+subscription.ID),
+			))
+		}
 	}
 	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
 		tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад к подпискам", "my_subscriptions"),
@@ -739,6 +1056,215 @@ func GetUnknownCommandKeyboard() tgbotapi.InlineKeyboardMarkup {
 
 	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
+func GetPromoCodeInputKeyboard(planID string) tgbotapi.InlineKeyboardMarkup {
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", CallbackPrefixSelectPlan+planID),
+		),
+	)
+}
+func GetBalanceText(balance float64, transactions []*core.BalanceTransaction) string {
+	text := fmt.Sprintf("👛 Ваш баланс: %.2f₽\n", balance)
+	text += "Балансом можно оплатить подписку или продление. Реферальные вознаграждения зачисляются сюда же.\n"
+
+	if len(transactions) > 0 {
+		text += "\n🧾 Последние операции:\n"
+		for _, transaction := range transactions {
+			text += fmt.Sprintf("%s %+.2f₽ — %s\n", transaction.CreatedAt.Format("02.01.2006"), transaction.Amount, GetBalanceTransactionTypeText(transaction.Type))
+		}
+	}
+
+	return text + "\nВыберите сумму пополнения:"
+}
+func GetBalanceTransactionTypeText(txType string) string {
+	switch core.BalanceTransactionType(txType) {
+	case core.BalanceTransactionTopUp:
+
+		return "пополнение"
+	case core.BalanceTransactionPayment:
+
+		return "оплата"
+	case core.BalanceTransactionReferralReward:
+
+		return "реферальное вознаграждение"
+	case core.BalanceTransactionRefund:
+
+		return "возврат"
+	case core.BalanceTransactionTrafficAddon:
+
+		return "дополнительный трафик"
+	default:
+
+		return txType
+	}
+}
+func GetBalanceKeyboard(amounts []int) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+	for _, amount := range amounts {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%d₽", amount), fmt.Sprintf("%s%d", CallbackPrefixTopUpAmount, amount)))
+		if len(row) == 2 {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(row...))
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(row...))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", CallbackOpenProfile),
+	))
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+func GetTopUpMethodText(amount int) string {
+
+	return fmt.Sprintf(`👛 Пополнение баланса
+💵 Сумма: %d₽
+Выберите способ оплаты:`, amount)
+}
+func GetTopUpMethodKeyboard(amount int) tgbotapi.InlineKeyboardMarkup {
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("💳 Картой", fmt.Sprintf("%s%d", CallbackPrefixTopUpCard, amount)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🏦 СБП", fmt.Sprintf("%s%d", CallbackPrefixTopUpSBP, amount)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", CallbackOpenBalance),
+		),
+	)
+}
+func GetTopUpLinkText(payment *core.Payment) string {
+
+	return fmt.Sprintf(`👛 Пополнение баланса
+💵 Сумма: %.0f₽
+1️⃣ Нажмите «Перейти к оплате» и завершите платеж
+2️⃣ Вернитесь в бот и нажмите «Я оплатил»
+Средства поступят на баланс сразу после подтверждения платежа.`, payment.Amount)
+}
+func GetInsufficientBalanceText(balance, amount float64) string {
+
+	return fmt.Sprintf(`❌ Недостаточно средств на балансе
+👛 Баланс: %.2f₽
+💵 Нужно: %.2f₽
+Пополните баланс или выберите другой способ оплаты.`, balance, amount)
+}
+func GetInsufficientBalanceKeyboard(backCallback string) tgbotapi.InlineKeyboardMarkup {
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("👛 Пополнить баланс", CallbackOpenBalance),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", backCallback),
+		),
+	)
+}
+func GetAutoRenewText(sub *core.Subscription, plan *core.Plan, savedMethod *core.SavedPaymentMethod, balance float64) string {
+	if !sub.AutoRenew {
+
+		return fmt.Sprintf(`⏹ Автопродление отключено
+Подписка: %s
+Действует до: %s
+Продлить подписку можно вручную в любой момент.`,
+			sub.GetDisplayName(),
+			sub.EndDate.Format("02.01.2006"))
+	}
+
+	text := fmt.Sprintf(`♻️ Автопродление включено
+Подписка: %s
+Тариф: %s — %.0f₽ за %s
+Незадолго до окончания (%s) мы спишем оплату и продлим подписку.
+`,
+		sub.GetDisplayName(),
+		plan.Name, plan.Price, FormatDuration(plan.Days),
+		sub.EndDate.Format("02.01.2006"))
+
+	text += fmt.Sprintf("\n👛 Сначала спишем с баланса (сейчас %.2f₽)\n", balance)
+	if savedMethod != nil {
+		text += fmt.Sprintf("💳 Если на балансе не хватит средств — с карты %s\n", savedMethod.Title)
+	} else {
+		text += "💳 Сохраненной карты нет: оплатите любую подписку картой, чтобы привязать ее, или пополните баланс заранее\n"
+	}
+
+	return text
+}
+func GetAutoRenewKeyboard(sub *core.Subscription, hasSavedMethod bool) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if sub.AutoRenew && hasSavedMethod {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🗑 Отвязать карту", CallbackPrefixUnlinkCard+sub.ID),
+		))
+	}
+	if sub.AutoRenew {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("👛 Пополнить баланс", CallbackOpenBalance),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⬅️ К подписке", CallbackPrefixViewSubscription+sub.ID),
+	))
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+func GetTrafficAddonText(sub *core.Subscription, gb int, price, balance float64) string {
+
+	return fmt.Sprintf(`➕ Дополнительный трафик
+Подписка: %s
+Пакет: %d ГБ — %.0f₽
+👛 Баланс: %.2f₽
+Трафик добавится к ключу, который израсходовал больше всего, и будет действовать до ближайшего обнуления трафика (или до конца подписки, если трафик не обнуляется).`,
+		sub.GetDisplayName(), gb, price, balance)
+}
+func GetTrafficAddonKeyboard(subscriptionID string) tgbotapi.InlineKeyboardMarkup {
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Оплатить с баланса", CallbackPrefixConfirmTraffic+subscriptionID),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⬅️ К подписке", CallbackPrefixViewSubscription+subscriptionID),
+		),
+	)
+}
+func GetServerUnavailableText() string {
+
+	return "⏳ Сервер временно недоступен. Мы уже знаем о проблеме, попробуйте через несколько минут."
+}
+func GetReceiptEmailInputText(required bool) string {
+	text := "📧 Email для кассовых чеков\n\n"
+	if required {
+		text += "По закону (54-ФЗ) мы обязаны отправить вам кассовый чек об оплате. Чтобы продолжить оплату, укажите email.\n\n"
+	}
+
+	return text + "Отправьте адрес следующим сообщением, например: name@example.com"
+}
+func GetReceiptEmailInputKeyboard(cancelCallback string) tgbotapi.InlineKeyboardMarkup {
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", cancelCallback),
+		),
+	)
+}
+func GetReceiptEmailSavedText(email string) string {
+
+	return fmt.Sprintf("✅ Email сохранен: %s\n\nЧеки об оплате будут приходить на этот адрес.", email)
+}
+func GetReceiptEmailSavedKeyboard(continueCallback string) tgbotapi.InlineKeyboardMarkup {
+	button := tgbotapi.NewInlineKeyboardButtonData("⬅️ В профиль", "open_profile")
+	if continueCallback != "open_profile" {
+		button = tgbotapi.NewInlineKeyboardButtonData("➡️ Продолжить оплату", continueCallback)
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(button),
+	)
+}
 func GetCancelKeyboard() tgbotapi.InlineKeyboardMarkup {
 
 	return tgbotapi.NewInlineKeyboardMarkup(
@@ -773,3 +1299,12 @@ func GetBackToSubscriptionsKeyboard() tgbotapi.InlineKeyboardMarkup {
 		),
 	)
 }
+
+func GetBackToBalanceKeyboard() tgbotapi.InlineKeyboardMarkup {
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("👛 К балансу", CallbackOpenBalance),
+		),
+	)
+}
@@ -1,5 +1,10 @@
 package ui
 
+import (
+	"strconv"
+	"strings"
+)
+
 const (
 	CommandStart = "start"
 	CommandHelp  = "help"
@@ -21,6 +26,9 @@ const (
 	CallbackMyReferrals        = "my_referrals"
 	CallbackMyReferralLink     = "my_referral_link"
 	CallbackReferralRanking    = "referral_ranking"
+	CallbackOpenBalance        = "open_balance"
+	CallbackSetEmail           = "set_email"
+	CallbackVPNList            = "vpn_list"
 )
 
 const (
@@ -29,20 +37,49 @@ const (
 	CallbackPrefixCreatePlan = "create_plan_"
 	CallbackPrefixExtendPlan = "extend_plan_"
 
-	CallbackPrefixPayCard  = "pay_card_"
-	CallbackPrefixPaySBP   = "pay_sbp_"
-	CallbackPrefixPayStars = "pay_stars_"
+	CallbackPrefixPayCard    = "pay_card_"
+	CallbackPrefixPaySBP     = "pay_sbp_"
+	CallbackPrefixPayStars   = "pay_stars_"
+	CallbackPrefixPayBalance = "pay_balance_"
+
+	CallbackPrefixTopUpAmount = "topup_amount_"
+	CallbackPrefixTopUpCard   = "topup_card_"
+	CallbackPrefixTopUpSBP    = "topup_sbp_"
+
+	CallbackPrefixPromoEnter = "promo_enter_"
+	CallbackPrefixPromoClear = "promo_clear_"
+
+	CallbackPrefixPaymentCheck  = "payment_check_"
+	CallbackPrefixPaymentCancel = "payment_cancel_"
+
+	StarsInvoicePayloadPrefix = "stars_"
 
 	CallbackPrefixViewSubscription   = "view_subscription_"
 	CallbackPrefixRenameSubscription = "rename_subscription_"
 	CallbackPrefixExtendSubscription = "extend_subscription_"
 	CallbackPrefixDeleteSubscription = "delete_subscription_"
 
+	CallbackPrefixAutoRenewOn  = "auto_renew_on_"
+	CallbackPrefixAutoRenewOff = "auto_renew_off_"
+	CallbackPrefixUnlinkCard   = "unlink_card_"
+
+	CallbackPrefixBuyTraffic     = "buy_traffic_"
+	CallbackPrefixConfirmTraffic = "confirm_traffic_"
+
 	CallbackPrefixCreateWireguard   = "create_wireguard"
 	CallbackPrefixCreateShadowsocks = "create_shadowsocks"
 	CallbackPrefixViewConfig        = "view_config_"
 	CallbackPrefixDeleteConfig      = "delete_config_"
 	CallbackPrefixConnectionGuide   = "connection_guide_"
+
+	CallbackPrefixCreateKey      = "create_key_"
+	CallbackPrefixKeyLocation    = "key_loc_"
+	CallbackPrefixChangeLocation = "change_loc_"
+	CallbackPrefixMoveKey        = "move_key_"
+
+	CallbackPrefixVPNConfig  = "vpn_config_"
+	CallbackPrefixVPNStats   = "vpn_stats_"
+	CallbackPrefixVPNRefresh = "vpn_refresh_"
 )
 
 func ParsePlanCallback(callbackData string) (planID string, ok bool) {
@@ -99,6 +136,135 @@ func ParsePayStarsCallback(callbackData string) (planID string, ok bool) {
 	return "", false
 }
 
+func ParsePaymentCheckCallback(callbackData string) (paymentID string, ok bool) {
+	if len(callbackData) > len(CallbackPrefixPaymentCheck) && callbackData[:len(CallbackPrefixPaymentCheck)] == CallbackPrefixPaymentCheck {
+
+		return callbackData[len(CallbackPrefixPaymentCheck):], true
+	}
+
+	return "", false
+}
+
+func ParsePaymentCancelCallback(callbackData string) (paymentID string, ok bool) {
+	if len(callbackData) > len(CallbackPrefixPaymentCancel) && callbackData[:len(CallbackPrefixPaymentCancel)] == CallbackPrefixPaymentCancel {
+
+		return callbackData[len(CallbackPrefixPaymentCancel):], true
+	}
+
+	return "", false
+}
+
+func ParsePayBalanceCallback(callbackData string) (planID string, ok bool) {
+	if len(callbackData) > len(CallbackPrefixPayBalance) && callbackData[:len(CallbackPrefixPayBalance)] == CallbackPrefixPayBalance {
+
+		return callbackData[len(CallbackPrefixPayBalance):], true
+	}
+
+	return "", false
+}
+
+func ParseTopUpAmountCallback(callbackData string) (amount int, ok bool) {
+
+	return parseAmountCallback(callbackData, CallbackPrefixTopUpAmount)
+}
+
+func ParseTopUpCardCallback(callbackData string) (amount int, ok bool) {
+
+	return parseAmountCallback(callbackData, CallbackPrefixTopUpCard)
+}
+
+func ParseTopUpSBPCallback(callbackData string) (amount int, ok bool) {
+
+	return parseAmountCallback(callbackData, CallbackPrefixTopUpSBP)
+}
+
+func ParseAutoRenewOnCallback(callbackData string) (subscriptionID string, ok bool) {
+	if len(callbackData) > len(CallbackPrefixAutoRenewOn) && callbackData[:len(CallbackPrefixAutoRenewOn)] == CallbackPrefixAutoRenewOn {
+
+		return callbackData[len(CallbackPrefixAutoRenewOn):], true
+	}
+
+	return "", false
+}
+
+func ParseAutoRenewOffCallback(callbackData string) (subscriptionID string, ok bool) {
+	if len(callbackData) > len(CallbackPrefixAutoRenewOff) && callbackData[:len(CallbackPrefixAutoRenewOff)] == CallbackPrefixAutoRenewOff {
+
+		return callbackData[len(CallbackPrefixAutoRenewOff):], true
+	}
+
+	return "", false
+}
+
+func ParseUnlinkCardCallback(callbackData string) (subscriptionID string, ok bool) {
+	if len(callbackData) > len(CallbackPrefixUnlinkCard) && callbackData[:len(CallbackPrefixUnlinkCard)] == CallbackPrefixUnlinkCard {
+
+		return callbackData[len(CallbackPrefixUnlinkCard):], true
+	}
+
+	return "", false
+}
+
+func ParseBuyTrafficCallback(callbackData string) (subscriptionID string, ok bool) {
+	if len(callbackData) > len(CallbackPrefixBuyTraffic) && callbackData[:len(CallbackPrefixBuyTraffic)] == CallbackPrefixBuyTraffic {
+
+		return callbackData[len(CallbackPrefixBuyTraffic):], true
+	}
+
+	return "", false
+}
+
+func ParseConfirmTrafficCallback(callbackData string) (subscriptionID string, ok bool) {
+	if len(callbackData) > len(CallbackPrefixConfirmTraffic) && callbackData[:len(CallbackPrefixConfirmTraffic)] == CallbackPrefixConfirmTraffic {
+
+		return callbackData[len(CallbackPrefixConfirmTraffic):], true
+	}
+
+	return "", false
+}
+
+func parseAmountCallback(callbackData, prefix string) (int, bool) {
+	if len(callbackData) <= len(prefix) || callbackData[:len(prefix)] != prefix {
+
+		return 0, false
+	}
+
+	amount, err := strconv.Atoi(callbackData[len(prefix):])
+	if err != nil || amount <= 0 {
+
+		return 0, false
+	}
+
+	return amount, true
+}
+
+func ParsePromoEnterCallback(callbackData string) (planID string, ok bool) {
+	if len(callbackData) > len(CallbackPrefixPromoEnter) && callbackData[:len(CallbackPrefixPromoEnter)] == CallbackPrefixPromoEnter {
+
+		return callbackData[len(CallbackPrefixPromoEnter):], true
+	}
+
+	return "", false
+}
+
+func ParsePromoClearCallback(callbackData string) (planID string, ok bool) {
+	if len(callbackData) > len(CallbackPrefixPromoClear) && callbackData[:len(CallbackPrefixPromoClear)] == CallbackPrefixPromoClear {
+
+		return callbackData[len(CallbackPrefixPromoClear):], true
+	}
+
+	return "", false
+}
+
+func ParseStarsInvoicePayload(payload string) (paymentID string, ok bool) {
+	if len(payload) > len(StarsInvoicePayloadPrefix) && payload[:len(StarsInvoicePayloadPrefix)] == StarsInvoicePayloadPrefix {
+
+		return payload[len(StarsInvoicePayloadPrefix):], true
+	}
+
+	return "", false
+}
+
 func ParseExtendPlanCallback(callbackData string) (planID, subscriptionID string, ok bool) {
 	if len(callbackData) > len(CallbackPrefixExtendPlan) && callbackData[:len(CallbackPrefixExtendPlan)] == CallbackPrefixExtendPlan {
 		rest := callbackData[len(CallbackPrefixExtendPlan):]
@@ -176,3 +342,76 @@ func ParseConnectionGuideCallback(callbackData string) (subscriptionID string, o
 
 	return "", false
 }
+
+func ParseCreateKeyCallback(callbackData string) (subscriptionID string, ok bool) {
+	if len(callbackData) > len(CallbackPrefixCreateKey) && callbackData[:len(CallbackPrefixCreateKey)] == CallbackPrefixCreateKey {
+
+		return callbackData[len(CallbackPrefixCreateKey):], true
+	}
+
+	return "", false
+}
+
+func ParseChangeLocationCallback(callbackData string) (vpnID string, ok bool) {
+	if len(callbackData) > len(CallbackPrefixChangeLocation) && callbackData[:len(CallbackPrefixChangeLocation)] == CallbackPrefixChangeLocation {
+
+		return callbackData[len(CallbackPrefixChangeLocation):], true
+	}
+
+	return "", false
+}
+
+func ParseVPNConfigCallback(callbackData string) (vpnID string, ok bool) {
+	if len(callbackData) > len(CallbackPrefixVPNConfig) && callbackData[:len(CallbackPrefixVPNConfig)] == CallbackPrefixVPNConfig {
+
+		return callbackData[len(CallbackPrefixVPNConfig):], true
+	}
+
+	return "", false
+}
+
+func ParseVPNStatsCallback(callbackData string) (vpnID string, ok bool) {
+	if len(callbackData) > len(CallbackPrefixVPNStats) && callbackData[:len(CallbackPrefixVPNStats)] == CallbackPrefixVPNStats {
+
+		return callbackData[len(CallbackPrefixVPNStats):], true
+	}
+
+	return "", false
+}
+
+func ParseVPNRefreshCallback(callbackData string) (vpnID string, ok bool) {
+	if len(callbackData) > len(CallbackPrefixVPNRefresh) && callbackData[:len(CallbackPrefixVPNRefresh)] == CallbackPrefixVPNRefresh {
+
+		return callbackData[len(CallbackPrefixVPNRefresh):], true
+	}
+
+	return "", false
+}
+
+func ParseKeyLocationCallback(callbackData string) (subscriptionID, serverName string, ok bool) {
+
+	return parseLocationCallback(callbackData, CallbackPrefixKeyLocation)
+}
+
+func ParseMoveKeyCallback(callbackData string) (vpnID, serverName string, ok bool) {
+
+	return parseLocationCallback(callbackData, CallbackPrefixMoveKey)
+}
+
+func LocationCallback(prefix, targetID, serverName string) string {
+
+	return prefix + targetID + "_" + serverName
+}
+
+func parseLocationCallback(callbackData, prefix string) (targetID, serverName string, ok bool) {
+	if len(callbackData) > len(prefix) && callbackData[:len(prefix)] == prefix {
+		rest := callbackData[len(prefix):]
+
+		if idx := strings.LastIndex(rest, "_"); idx > 0 && idx < len(rest)-1 {
+
+			return rest[:idx], rest[idx+1:], true
+		}
+	}
+
+	return "", "", false
+}
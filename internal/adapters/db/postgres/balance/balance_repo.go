@@ -0,0 +1,100 @@
+package balance
+
+import (
+	"context"
+	"fmt"
+
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/usecase"
+
+	transactorPgx "github.com/Thiht/transactor/pgx"
+)
+
+type Balance struct {
+	dbGetter transactorPgx.DBGetter
+}
+
+func NewBalance(dbGetter transactorPgx.DBGetter) *Balance {
+
+	return &Balance{
+		dbGetter: dbGetter,
+	}
+}
+
+func (b *Balance) CreateTransaction(ctx context.Context, transaction *core.BalanceTransaction) error {
+	query := `
+		INSERT INTO balance_transactions (id, user_id, amount, type, payment_id, description, created_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7)`
+
+	_, err := b.dbGetter(ctx).Exec(ctx, query,
+		transaction.ID, transaction.UserID, transaction.Amount, transaction.Type,
+		transaction.PaymentID, transaction.Description, transaction.CreatedAt,
+	)
+
+	if err != nil {
+
+		return fmt.Errorf("failed to create balance transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (b *Balance) GetBalance(ctx context.Context, userID int64) (float64, error) {
+	query := `SELECT COALESCE(SUM(amount), 0) FROM balance_transactions WHERE user_id = $1`
+
+	var balance float64
+	if err := b.dbGetter(ctx).QueryRow(ctx, query, userID).Scan(&balance); err != nil {
+
+		return 0, fmt.Errorf("failed to get balance: %w", err)
+	}
+
+	return balance, nil
+}
+
+func (b *Balance) GetBalanceForUpdate(ctx context.Context, userID int64) (float64, error) {
+	lockQuery := `SELECT telegram_id FROM users WHERE telegram_id = $1 FOR UPDATE`
+
+	var lockedID int64
+	if err := b.dbGetter(ctx).QueryRow(ctx, lockQuery, userID).Scan(&lockedID); err != nil {
+
+		return 0, usecase.ErrNotFound
+	}
+
+	return b.GetBalance(ctx, userID)
+}
+
+func (b *Balance) GetTransactionsByUserID(ctx context.Context, userID int64, limit int) ([]*core.BalanceTransaction, error) {
+	query := `
+		SELECT id, user_id, amount, type, COALESCE(payment_id, ''), COALESCE(description, ''), created_at
+		FROM balance_transactions WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2`
+
+	rows, err := b.dbGetter(ctx).Query(ctx, query, userID, limit)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get balance transactions: %w", err)
+	}
+	defer rows.Close()
+
+	var transactions []*core.BalanceTransaction
+	for rows.Next() {
+		transaction := &core.BalanceTransaction{}
+		err := rows.Scan(
+			&transaction.ID, &transaction.UserID, &transaction.Amount, &transaction.Type,
+			&transaction.PaymentID, &transaction.Description, &transaction.CreatedAt,
+		)
+		if err != nil {
+
+			return nil, fmt.Errorf("failed to scan balance transaction: %w", err)
+		}
+		transactions = append(transactions, transaction)
+	}
+
+	if err = rows.Err(); err != nil {
+
+		return nil, fmt.Errorf("error iterating balance transactions: %w", err)
+	}
+
+	return transactions, nil
+}
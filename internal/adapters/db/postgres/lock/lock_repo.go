@@ -0,0 +1,68 @@
+package lock
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	transactorPgx "github.com/Thiht/transactor/pgx"
+)
+
+type SchedulerLock struct {
+	dbGetter transactorPgx.DBGetter
+}
+
+func NewSchedulerLock(dbGetter transactorPgx.DBGetter) *SchedulerLock {
+
+	return &SchedulerLock{
+		dbGetter: dbGetter,
+	}
+}
+
+func (l *SchedulerLock) AcquireLock(ctx context.Context, jobName, holder string, ttl, minInterval time.Duration) (bool, error) {
+	query := `
+		INSERT INTO scheduler_locks (job_name, holder, locked_until, last_run_at, updated_at)
+		VALUES ($1, $2, NOW() + make_interval(secs => $3), NOW(), NOW())
+		ON CONFLICT (job_name) DO UPDATE
+		SET holder = EXCLUDED.holder, locked_until = EXCLUDED.locked_until, last_run_at = NOW(), updated_at = NOW()
+		WHERE scheduler_locks.locked_until <= NOW()
+		AND (scheduler_locks.last_run_at IS NULL OR scheduler_locks.last_run_at <= NOW() - make_interval(secs => $4))`
+
+	result, err := l.dbGetter(ctx).Exec(ctx, query, jobName, holder, ttl.Seconds(), minInterval.Seconds())
+	if err != nil {
+
+		return false, fmt.Errorf("failed to acquire scheduler lock: %w", err)
+	}
+
+	return result.RowsAffected() > 0, nil
+}
+
+func (l *SchedulerLock) RenewLock(ctx context.Context, jobName, holder string, ttl time.Duration) (bool, error) {
+	query := `
+		UPDATE scheduler_locks
+		SET locked_until = NOW() + make_interval(secs => $3), updated_at = NOW()
+		WHERE job_name = $1 AND holder = $2 AND locked_until > NOW()`
+
+	result, err := l.dbGetter(ctx).Exec(ctx, query, jobName, holder, ttl.Seconds())
+	if err != nil {
+
+		return false, fmt.Errorf("failed to renew scheduler lock: %w", err)
+	}
+
+	return result.RowsAffected() > 0, nil
+}
+
+func (l *SchedulerLock) ReleaseLock(ctx context.Context, jobName, holder string) error {
+	query := `
+		UPDATE scheduler_locks
+		SET locked_until = NOW(), updated_at = NOW()
+		WHERE job_name = $1 AND holder = $2`
+
+	_, err := l.dbGetter(ctx).Exec(ctx, query, jobName, holder)
+	if err != nil {
+
+		return fmt.Errorf("failed to release scheduler lock: %w", err)
+	}
+
+	return nil
+}
@@ -3,6 +3,7 @@ package notification
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"3xui-bot/internal/core"
 	"3xui-bot/internal/usecase"
@@ -184,3 +185,15 @@ func (n *Notification) DeleteNotification(ctx context.Context, id string) error
 
 	return nil
 }
+
+func (n *Notification) DeleteReadNotificationsOlderThan(ctx context.Context, before time.Time) (int64, error) {
+	query := `DELETE FROM notifications WHERE is_read = true AND created_at < $1`
+
+	result, err := n.dbGetter(ctx).Exec(ctx, query, before)
+	if err != nil {
+
+		return 0, fmt.Errorf("failed to delete old notifications: %w", err)
+	}
+
+	return result.RowsAffected(), nil
+}
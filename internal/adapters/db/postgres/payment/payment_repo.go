@@ -3,6 +3,7 @@ package payment
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"3xui-bot/internal/core"
 	"3xui-bot/internal/usecase"
@@ -23,13 +24,13 @@ func NewPayment(dbGetter transactorPgx.DBGetter) *Payment {
 
 func (p *Payment) CreatePayment(ctx context.Context, payment *core.Payment) error {
 	query := `
-		INSERT INTO payments (id, user_id, amount, currency, payment_method, description, status, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+		INSERT INTO payments (id, user_id, plan_id, subscription_id, amount, currency, payment_method, purpose, external_id, idempotency_key, promo_code_id, discount_amount, bonus_days, description, status, receipt_email, receipt_status, created_at, updated_at)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, $6, $7, $8, NULLIF($9, ''), NULLIF($10, ''), NULLIF($11, ''), $12, $13, $14, $15, NULLIF($16, ''), NULLIF($17, ''), $18, $19)`
 
 	_, err := p.dbGetter(ctx).Exec(ctx, query,
-		payment.ID, payment.UserID, payment.Amount, payment.Currency,
-		payment.PaymentMethod, payment.Description, payment.Status,
-		payment.CreatedAt, payment.UpdatedAt,
+		payment.ID, payment.UserID, payment.PlanID, payment.SubscriptionID, payment.Amount, payment.Currency,
+		payment.PaymentMethod, payment.Purpose, payment.ExternalID, payment.IdempotencyKey, payment.PromoCodeID, payment.DiscountAmount,
+		payment.BonusDays, payment.Description, payment.Status, payment.ReceiptEmail, payment.ReceiptStatus, payment.CreatedAt, payment.UpdatedAt,
 	)
 
 	if err != nil {
@@ -42,13 +43,60 @@ func (p *Payment) CreatePayment(ctx context.Context, payment *core.Payment) erro
 
 func (p *Payment) GetPaymentByID(ctx context.Context, id string) (*core.Payment, error) {
 	query := `
-		SELECT id, user_id, amount, currency, payment_method, description, status, created_at, updated_at
+		SELECT id, user_id, COALESCE(plan_id, ''), COALESCE(subscription_id, ''), amount, currency, payment_method, purpose, COALESCE(external_id, ''), COALESCE(idempotency_key, ''), COALESCE(promo_code_id, ''), discount_amount, bonus_days, description, status, COALESCE(receipt_email, ''), COALESCE(receipt_status, ''), created_at, updated_at
 		FROM payments WHERE id = $1`
 
 	payment := &core.Payment{}
 	err := p.dbGetter(ctx).QueryRow(ctx, query, id).Scan(
-		&payment.ID, &payment.UserID, &payment.Amount, &payment.Currency,
-		&payment.PaymentMethod, &payment.Description, &payment.Status,
+		&payment.ID, &payment.UserID, &payment.PlanID, &payment.SubscriptionID, &payment.Amount, &payment.Currency,
+		&payment.PaymentMethod, &payment.Purpose, &payment.ExternalID, &payment.IdempotencyKey,
+		&payment.PromoCodeID, &payment.DiscountAmount, &payment.BonusDays, &payment.Description, &payment.Status,
+		&payment.ReceiptEmail, &payment.ReceiptStatus,
+		&payment.CreatedAt, &payment.UpdatedAt,
+	)
+
+	if err != nil {
+
+		return nil, usecase.ErrNotFound
+	}
+
+	return payment, nil
+}
+
+func (p *Payment) GetPaymentByIDForUpdate(ctx context.Context, id string) (*core.Payment, error) {
+	query := `
+		SELECT id, user_id, COALESCE(plan_id, ''), COALESCE(subscription_id, ''), amount, currency, payment_method, purpose, COALESCE(external_id, ''), COALESCE(idempotency_key, ''), COALESCE(promo_code_id, ''), discount_amount, bonus_days, description, status, COALESCE(receipt_email, ''), COALESCE(receipt_status, ''), created_at, updated_at
+		FROM payments WHERE id = $1
+		FOR UPDATE`
+
+	payment := &core.Payment{}
+	err := p.dbGetter(ctx).QueryRow(ctx, query, id).Scan(
+		&payment.ID, &payment.UserID, &payment.PlanID, &payment.SubscriptionID, &payment.Amount, &payment.Currency,
+		&payment.PaymentMethod, &payment.Purpose, &payment.ExternalID, &payment.IdempotencyKey,
+		&payment.PromoCodeID, &payment.DiscountAmount, &payment.BonusDays, &payment.Description, &payment.Status,
+		&payment.ReceiptEmail, &payment.ReceiptStatus,
+		&payment.CreatedAt, &payment.UpdatedAt,
+	)
+
+	if err != nil {
+
+		return nil, usecase.ErrNotFound
+	}
+
+	return payment, nil
+}
+
+func (p *Payment) GetPaymentByExternalID(ctx context.Context, externalID string) (*core.Payment, error) {
+	query := `
+		SELECT id, user_id, COALESCE(plan_id, ''), COALESCE(subscription_id, ''), amount, currency, payment_method, purpose, COALESCE(external_id, ''), COALESCE(idempotency_key, ''), COALESCE(promo_code_id, ''), discount_amount, bonus_days, description, status, COALESCE(receipt_email, ''), COALESCE(receipt_status, ''), created_at, updated_at
+		FROM payments WHERE external_id = $1`
+
+	payment := &core.Payment{}
+	err := p.dbGetter(ctx).QueryRow(ctx, query, externalID).Scan(
+		&payment.ID, &payment.UserID, &payment.PlanID, &payment.SubscriptionID, &payment.Amount, &payment.Currency,
+		&payment.PaymentMethod, &payment.Purpose, &payment.ExternalID, &payment.IdempotencyKey,
+		&payment.PromoCodeID, &payment.DiscountAmount, &payment.BonusDays, &payment.Description, &payment.Status,
+		&payment.ReceiptEmail, &payment.ReceiptStatus,
 		&payment.CreatedAt, &payment.UpdatedAt,
 	)
 
@@ -62,7 +110,7 @@ func (p *Payment) GetPaymentByID(ctx context.Context, id string) (*core.Payment,
 
 func (p *Payment) GetPaymentsByUserID(ctx context.Context, userID int64) ([]*core.Payment, error) {
 	query := `
-		SELECT id, user_id, amount, currency, payment_method, description, status, created_at, updated_at
+		SELECT id, user_id, COALESCE(plan_id, ''), COALESCE(subscription_id, ''), amount, currency, payment_method, purpose, COALESCE(external_id, ''), COALESCE(idempotency_key, ''), COALESCE(promo_code_id, ''), discount_amount, bonus_days, description, status, COALESCE(receipt_email, ''), COALESCE(receipt_status, ''), created_at, updated_at
 		FROM payments WHERE user_id = $1
 		ORDER BY created_at DESC`
 
@@ -77,8 +125,10 @@ func (p *Payment) GetPaymentsByUserID(ctx context.Context, userID int64) ([]*cor
 	for rows.Next() {
 		payment := &core.Payment{}
 		err := rows.Scan(
-			&payment.ID, &payment.UserID, &payment.Amount, &payment.Currency,
-			&payment.PaymentMethod, &payment.Description, &payment.Status,
+			&payment.ID, &payment.UserID, &payment.PlanID, &payment.SubscriptionID, &payment.Amount, &payment.Currency,
+			&payment.PaymentMethod, &payment.Purpose, &payment.ExternalID, &payment.IdempotencyKey,
+			&payment.PromoCodeID, &payment.DiscountAmount, &payment.BonusDays, &payment.Description, &payment.Status,
+			&payment.ReceiptEmail, &payment.ReceiptStatus,
 			&payment.CreatedAt, &payment.UpdatedAt,
 		)
 		if err != nil {
@@ -96,16 +146,73 @@ func (p *Payment) GetPaymentsByUserID(ctx context.Context, userID int64) ([]*cor
 	return payments, nil
 }
 
+func (p *Payment) GetPendingPaymentsOlderThan(ctx context.Context, cutoff time.Time) ([]*core.Payment, error) {
+	query := `
+		SELECT id, user_id, COALESCE(plan_id, ''), COALESCE(subscription_id, ''), amount, currency, payment_method, purpose, COALESCE(external_id, ''), COALESCE(idempotency_key, ''), COALESCE(promo_code_id, ''), discount_amount, bonus_days, description, status, COALESCE(receipt_email, ''), COALESCE(receipt_status, ''), created_at, updated_at
+		FROM payments
+		WHERE status IN ('pending', 'waiting_for_capture') AND created_at < $1
+		ORDER BY created_at ASC`
+
+	rows, err := p.dbGetter(ctx).Query(ctx, query, cutoff)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get pending payments: %w", err)
+	}
+	defer rows.Close()
+
+	var payments []*core.Payment
+	for rows.Next() {
+		payment := &core.Payment{}
+		err := rows.Scan(
+			&payment.ID, &payment.UserID, &payment.PlanID, &payment.SubscriptionID, &payment.Amount, &payment.Currency,
+			&payment.PaymentMethod, &payment.Purpose, &payment.ExternalID, &payment.IdempotencyKey,
+			&payment.PromoCodeID, &payment.DiscountAmount, &payment.BonusDays, &payment.Description, &payment.Status,
+			&payment.ReceiptEmail, &payment.ReceiptStatus,
+			&payment.CreatedAt, &payment.UpdatedAt,
+		)
+		if err != nil {
+
+			return nil, fmt.Errorf("failed to scan payment: %w", err)
+		}
+		payments = append(payments, payment)
+	}
+
+	if err = rows.Err(); err != nil {
+
+		return nil, fmt.Errorf("error iterating payments: %w", err)
+	}
+
+	return payments, nil
+}
+
+func (p *Payment) CountPendingPaymentsBySubscriptionID(ctx context.Context, subscriptionID string) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM payments
+		WHERE subscription_id = $1 AND status IN ('pending', 'waiting_for_capture')`
+
+	var count int
+	if err := p.dbGetter(ctx).QueryRow(ctx, query, subscriptionID).Scan(&count); err != nil {
+
+		return 0, fmt.Errorf("failed to count pending payments: %w", err)
+	}
+
+	return count, nil
+}
+
 func (p *Payment) UpdatePayment(ctx context.Context, payment *core.Payment) error {
 	query := `
 		UPDATE payments
-		SET amount = $2, currency = $3, payment_method = $4,
-		    description = $5, status = $6, updated_at = $7
+		SET plan_id = NULLIF($2, ''), subscription_id = NULLIF($3, ''), amount = $4, currency = $5, payment_method = $6,
+		    purpose = $7, external_id = NULLIF($8, ''), idempotency_key = NULLIF($9, ''), promo_code_id = NULLIF($10, ''),
+		    discount_amount = $11, bonus_days = $12, description = $13, status = $14,
+		    receipt_email = NULLIF($15, ''), receipt_status = NULLIF($16, ''), updated_at = $17
 		WHERE id = $1`
 
 	result, err := p.dbGetter(ctx).Exec(ctx, query,
-		payment.ID, payment.Amount, payment.Currency, payment.PaymentMethod,
-		payment.Description, payment.Status, payment.UpdatedAt,
+		payment.ID, payment.PlanID, payment.SubscriptionID, payment.Amount, payment.Currency, payment.PaymentMethod,
+		payment.Purpose, payment.ExternalID, payment.IdempotencyKey, payment.PromoCodeID, payment.DiscountAmount, payment.BonusDays,
+		payment.Description, payment.Status, payment.ReceiptEmail, payment.ReceiptStatus, payment.UpdatedAt,
 	)
 
 	if err != nil {
@@ -121,6 +228,23 @@ func (p *Payment) UpdatePayment(ctx context.Context, payment *core.Payment) erro
 	return nil
 }
 
+func (p *Payment) UpdateReceipt(ctx context.Context, id, email, status string) error {
+	query := `UPDATE payments SET receipt_email = NULLIF($2, ''), receipt_status = NULLIF($3, ''), updated_at = NOW() WHERE id = $1`
+
+	result, err := p.dbGetter(ctx).Exec(ctx, query, id, email, status)
+	if err != nil {
+
+		return fmt.Errorf("failed to update payment receipt: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+
+		return usecase.ErrNotFound
+	}
+
+	return nil
+}
+
 func (p *Payment) UpdatePaymentStatus(ctx context.Context, id, status string) error {
 	query := `UPDATE payments SET status = $2, updated_at = NOW() WHERE id = $1`
 
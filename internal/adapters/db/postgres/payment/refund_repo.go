@@ -0,0 +1,145 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/usecase"
+
+	transactorPgx "github.com/Thiht/transactor/pgx"
+)
+
+type PaymentRefund struct {
+	dbGetter transactorPgx.DBGetter
+}
+
+func NewPaymentRefund(dbGetter transactorPgx.DBGetter) *PaymentRefund {
+
+	return &PaymentRefund{
+		dbGetter: dbGetter,
+	}
+}
+
+func (p *PaymentRefund) CreateRefund(ctx context.Context, refund *core.PaymentRefund) error {
+	query := `
+		INSERT INTO payment_refunds (id, payment_id, admin_id, amount, reason, external_refund_id, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8)`
+
+	_, err := p.dbGetter(ctx).Exec(ctx, query,
+		refund.ID, refund.PaymentID, refund.AdminID, refund.Amount,
+		refund.Reason, refund.ExternalRefundID, refund.Status, refund.CreatedAt,
+	)
+
+	if err != nil {
+
+		return fmt.Errorf("failed to create payment refund: %w", err)
+	}
+
+	return nil
+}
+
+func (p *PaymentRefund) UpdateRefund(ctx context.Context, refund *core.PaymentRefund) error {
+	query := `
+		UPDATE payment_refunds
+		SET external_refund_id = NULLIF($2, ''), status = $3
+		WHERE id = $1`
+
+	result, err := p.dbGetter(ctx).Exec(ctx, query, refund.ID, refund.ExternalRefundID, refund.Status)
+	if err != nil {
+
+		return fmt.Errorf("failed to update payment refund: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+
+		return usecase.ErrNotFound
+	}
+
+	return nil
+}
+
+func (p *PaymentRefund) GetRefundByID(ctx context.Context, id string) (*core.PaymentRefund, error) {
+	query := `
+		SELECT id, payment_id, admin_id, amount, reason, COALESCE(external_refund_id, ''), status, created_at
+		FROM payment_refunds WHERE id = $1`
+
+	refund := &core.PaymentRefund{}
+	err := p.dbGetter(ctx).QueryRow(ctx, query, id).Scan(
+		&refund.ID, &refund.PaymentID, &refund.AdminID, &refund.Amount,
+		&refund.Reason, &refund.ExternalRefundID, &refund.Status, &refund.CreatedAt,
+	)
+
+	if err != nil {
+
+		return nil, usecase.ErrNotFound
+	}
+
+	return refund, nil
+}
+
+func (p *PaymentRefund) GetPendingRefundByPaymentID(ctx context.Context, paymentID string) (*core.PaymentRefund, error) {
+	query := `
+		SELECT id, payment_id, admin_id, amount, reason, COALESCE(external_refund_id, ''), status, created_at
+		FROM payment_refunds WHERE payment_id = $1 AND status = 'pending'`
+
+	refund := &core.PaymentRefund{}
+	err := p.dbGetter(ctx).QueryRow(ctx, query, paymentID).Scan(
+		&refund.ID, &refund.PaymentID, &refund.AdminID, &refund.Amount,
+		&refund.Reason, &refund.ExternalRefundID, &refund.Status, &refund.CreatedAt,
+	)
+
+	if err != nil {
+
+		return nil, usecase.ErrNotFound
+	}
+
+	return refund, nil
+}
+
+func (p *PaymentRefund) GetRefundsByPaymentID(ctx context.Context, paymentID string) ([]*core.PaymentRefund, error) {
+	query := `
+		SELECT id, payment_id, admin_id, amount, reason, COALESCE(external_refund_id, ''), status, created_at
+		FROM payment_refunds WHERE payment_id = $1
+		ORDER BY created_at ASC`
+
+	rows, err := p.dbGetter(ctx).Query(ctx, query, paymentID)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get payment refunds: %w", err)
+	}
+	defer rows.Close()
+
+	var refunds []*core.PaymentRefund
+	for rows.Next() {
+		refund := &core.PaymentRefund{}
+		err := rows.Scan(
+			&refund.ID, &refund.PaymentID, &refund.AdminID, &refund.Amount,
+			&refund.Reason, &refund.ExternalRefundID, &refund.Status, &refund.CreatedAt,
+		)
+		if err != nil {
+
+			return nil, fmt.Errorf("failed to scan payment refund: %w", err)
+		}
+		refunds = append(refunds, refund)
+	}
+
+	if err = rows.Err(); err != nil {
+
+		return nil, fmt.Errorf("error iterating payment refunds: %w", err)
+	}
+
+	return refunds, nil
+}
+
+func (p *PaymentRefund) GetTotalRefundedAmount(ctx context.Context, paymentID string) (float64, error) {
+	query := `SELECT COALESCE(SUM(amount), 0) FROM payment_refunds WHERE payment_id = $1`
+
+	var total float64
+	if err := p.dbGetter(ctx).QueryRow(ctx, query, paymentID).Scan(&total); err != nil {
+
+		return 0, fmt.Errorf("failed to get refunded amount: %w", err)
+	}
+
+	return total, nil
+}
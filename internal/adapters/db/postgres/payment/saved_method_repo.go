@@ -0,0 +1,74 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/usecase"
+
+	transactorPgx "github.com/Thiht/transactor/pgx"
+)
+
+type SavedPaymentMethod struct {
+	dbGetter transactorPgx.DBGetter
+}
+
+func NewSavedPaymentMethod(dbGetter transactorPgx.DBGetter) *SavedPaymentMethod {
+
+	return &SavedPaymentMethod{
+		dbGetter: dbGetter,
+	}
+}
+
+func (p *SavedPaymentMethod) SaveMethod(ctx context.Context, method *core.SavedPaymentMethod) error {
+	query := `
+		INSERT INTO saved_payment_methods (user_id, provider_method_id, method, title, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id) DO UPDATE
+		SET provider_method_id = EXCLUDED.provider_method_id, method = EXCLUDED.method,
+		    title = EXCLUDED.title, updated_at = EXCLUDED.updated_at`
+
+	_, err := p.dbGetter(ctx).Exec(ctx, query,
+		method.UserID, method.ProviderMethodID, method.Method, method.Title,
+		method.CreatedAt, method.UpdatedAt,
+	)
+
+	if err != nil {
+
+		return fmt.Errorf("failed to save payment method: %w", err)
+	}
+
+	return nil
+}
+
+func (p *SavedPaymentMethod) GetMethodByUserID(ctx context.Context, userID int64) (*core.SavedPaymentMethod, error) {
+	query := `
+		SELECT user_id, provider_method_id, method, title, created_at, updated_at
+		FROM saved_payment_methods WHERE user_id = $1`
+
+	method := &core.SavedPaymentMethod{}
+	err := p.dbGetter(ctx).QueryRow(ctx, query, userID).Scan(
+		&method.UserID, &method.ProviderMethodID, &method.Method, &method.Title,
+		&method.CreatedAt, &method.UpdatedAt,
+	)
+
+	if err != nil {
+
+		return nil, usecase.ErrNotFound
+	}
+
+	return method, nil
+}
+
+func (p *SavedPaymentMethod) DeleteMethod(ctx context.Context, userID int64) error {
+	query := `DELETE FROM saved_payment_methods WHERE user_id = $1`
+
+	_, err := p.dbGetter(ctx).Exec(ctx, query, userID)
+	if err != nil {
+
+		return fmt.Errorf("failed to delete payment method: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package promo
+
+import (
+	"context"
+	"fmt"
+
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/usecase"
+
+	transactorPgx "github.com/Thiht/transactor/pgx"
+)
+
+type PromoCode struct {
+	dbGetter transactorPgx.DBGetter
+}
+
+func NewPromoCode(dbGetter transactorPgx.DBGetter) *PromoCode {
+
+	return &PromoCode{
+		dbGetter: dbGetter,
+	}
+}
+
+func (p *PromoCode) CreatePromoCode(ctx context.Context, promo *core.PromoCode) error {
+	query := `
+		INSERT INTO promo_codes (id, code, discount_type, discount_value, bonus_days, max_uses, max_uses_per_user, used_count, plan_ids, expires_at, is_active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`
+
+	_, err := p.dbGetter(ctx).Exec(ctx, query,
+		promo.ID, promo.Code, promo.DiscountType, promo.DiscountValue, promo.BonusDays,
+		promo.MaxUses, promo.MaxUsesPerUser, promo.UsedCount, planIDs(promo.PlanIDs),
+		promo.ExpiresAt, promo.IsActive, promo.CreatedAt, promo.UpdatedAt,
+	)
+
+	if err != nil {
+
+		return fmt.Errorf("failed to create promo code: %w", err)
+	}
+
+	return nil
+}
+
+func (p *PromoCode) GetPromoCodeByCode(ctx context.Context, code string) (*core.PromoCode, error) {
+	query := `
+		SELECT id, code, discount_type, discount_value, bonus_days, max_uses, max_uses_per_user, used_count, plan_ids, expires_at, is_active, created_at, updated_at
+		FROM promo_codes WHERE code = $1`
+
+	promo := &core.PromoCode{}
+	err := p.dbGetter(ctx).QueryRow(ctx, query, code).Scan(
+		&promo.ID, &promo.Code, &promo.DiscountType, &promo.DiscountValue, &promo.BonusDays,
+		&promo.MaxUses, &promo.MaxUsesPerUser, &promo.UsedCount, &promo.PlanIDs,
+		&promo.ExpiresAt, &promo.IsActive, &promo.CreatedAt, &promo.UpdatedAt,
+	)
+
+	if err != nil {
+
+		return nil, usecase.ErrNotFound
+	}
+
+	return promo, nil
+}
+
+func (p *PromoCode) GetPromoCodeByIDForUpdate(ctx context.Context, id string) (*core.PromoCode, error) {
+	query := `
+		SELECT id, code, discount_type, discount_value, bonus_days, max_uses, max_uses_per_user, used_count, plan_ids, expires_at, is_active, created_at, updated_at
+		FROM promo_codes WHERE id = $1
+		FOR UPDATE`
+
+	promo := &core.PromoCode{}
+	err := p.dbGetter(ctx).QueryRow(ctx, query, id).Scan(
+		&promo.ID, &promo.Code, &promo.DiscountType, &promo.DiscountValue, &promo.BonusDays,
+		&promo.MaxUses, &promo.MaxUsesPerUser, &promo.UsedCount, &promo.PlanIDs,
+		&promo.ExpiresAt, &promo.IsActive, &promo.CreatedAt, &promo.UpdatedAt,
+	)
+
+	if err != nil {
+
+		return nil, usecase.ErrNotFound
+	}
+
+	return promo, nil
+}
+
+func (p *PromoCode) GetPromoCodes(ctx context.Context) ([]*core.PromoCode, error) {
+	query := `
+		SELECT id, code, discount_type, discount_value, bonus_days, max_uses, max_uses_per_user, used_count, plan_ids, expires_at, is_active, created_at, updated_at
+		FROM promo_codes
+		ORDER BY created_at DESC`
+
+	rows, err := p.dbGetter(ctx).Query(ctx, query)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get promo codes: %w", err)
+	}
+	defer rows.Close()
+
+	var promos []*core.PromoCode
+	for rows.Next() {
+		promo := &core.PromoCode{}
+		err := rows.Scan(
+			&promo.ID, &promo.Code, &promo.DiscountType, &promo.DiscountValue, &promo.BonusDays,
+			&promo.MaxUses, &promo.MaxUsesPerUser, &promo.UsedCount, &promo.PlanIDs,
+			&promo.ExpiresAt, &promo.IsActive, &promo.CreatedAt, &promo.UpdatedAt,
+		)
+		if err != nil {
+
+			return nil, fmt.Errorf("failed to scan promo code: %w", err)
+		}
+		promos = append(promos, promo)
+	}
+
+	if err = rows.Err(); err != nil {
+
+		return nil, fmt.Errorf("error iterating promo codes: %w", err)
+	}
+
+	return promos, nil
+}
+
+func (p *PromoCode) UpdatePromoCode(ctx context.Context, promo *core.PromoCode) error {
+	query := `
+		UPDATE promo_codes
+		SET discount_type = $2, discount_value = $3, bonus_days = $4, max_uses = $5, max_uses_per_user = $6,
+		    used_count = $7, plan_ids = $8, expires_at = $9, is_active = $10, updated_at = $11
+		WHERE id = $1`
+
+	result, err := p.dbGetter(ctx).Exec(ctx, query,
+		promo.ID, promo.DiscountType, promo.DiscountValue, promo.BonusDays, promo.MaxUses, promo.MaxUsesPerUser,
+		promo.UsedCount, planIDs(promo.PlanIDs), promo.ExpiresAt, promo.IsActive, promo.UpdatedAt,
+	)
+
+	if err != nil {
+
+		return fmt.Errorf("failed to update promo code: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+
+		return usecase.ErrNotFound
+	}
+
+	return nil
+}
+
+func (p *PromoCode) CreatePromoCodeUsage(ctx context.Context, usage *core.PromoCodeUsage) error {
+	query := `
+		INSERT INTO promo_code_usages (id, promo_code_id, user_id, payment_id, created_at)
+		VALUES ($1, $2, $3, $4, $5)`
+
+	_, err := p.dbGetter(ctx).Exec(ctx, query,
+		usage.ID, usage.PromoCodeID, usage.UserID, usage.PaymentID, usage.CreatedAt,
+	)
+
+	if err != nil {
+
+		return fmt.Errorf("failed to create promo code usage: %w", err)
+	}
+
+	return nil
+}
+
+func (p *PromoCode) CountUserPromoCodeUsages(ctx context.Context, promoCodeID string, userID int64) (int, error) {
+	query := `SELECT COUNT(*) FROM promo_code_usages WHERE promo_code_id = $1 AND user_id = $2`
+
+	var count int
+	if err := p.dbGetter(ctx).QueryRow(ctx, query, promoCodeID, userID).Scan(&count); err != nil {
+
+		return 0, fmt.Errorf("failed to count promo code usages: %w", err)
+	}
+
+	return count, nil
+}
+
+func (p *PromoCode) CountPendingPromoCodePayments(ctx context.Context, promoCodeID string, userID int64) (int, int, error) {
+	query := `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE user_id = $2)
+		FROM payments
+		WHERE promo_code_id = $1 AND status IN ('pending', 'waiting_for_capture')`
+
+	var total, byUser int
+	if err := p.dbGetter(ctx).QueryRow(ctx, query, promoCodeID, userID).Scan(&total, &byUser); err != nil {
+
+		return 0, 0, fmt.Errorf("failed to count pending promo code payments: %w", err)
+	}
+
+	return total, byUser, nil
+}
+
+func planIDs(ids []string) []string {
+	if ids == nil {
+
+		return []string{}
+	}
+
+	return ids
+}
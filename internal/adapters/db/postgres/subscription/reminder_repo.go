@@ -0,0 +1,47 @@
+package subscription
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	transactorPgx "github.com/Thiht/transactor/pgx"
+)
+
+type Reminder struct {
+	dbGetter transactorPgx.DBGetter
+}
+
+func NewReminder(dbGetter transactorPgx.DBGetter) *Reminder {
+
+	return &Reminder{
+		dbGetter: dbGetter,
+	}
+}
+
+func (r *Reminder) MarkReminderSent(ctx context.Context, subscriptionID string, offsetHours int, endDate time.Time) (bool, error) {
+	query := `
+		INSERT INTO subscription_reminders (subscription_id, offset_hours, end_date, sent_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (subscription_id, offset_hours, end_date) DO NOTHING`
+
+	result, err := r.dbGetter(ctx).Exec(ctx, query, subscriptionID, offsetHours, endDate, time.Now())
+	if err != nil {
+
+		return false, fmt.Errorf("failed to mark reminder as sent: %w", err)
+	}
+
+	return result.RowsAffected() > 0, nil
+}
+
+func (r *Reminder) DeleteRemindersBefore(ctx context.Context, before time.Time) (int64, error) {
+	query := `DELETE FROM subscription_reminders WHERE end_date < $1`
+
+	result, err := r.dbGetter(ctx).Exec(ctx, query, before)
+	if err != nil {
+
+		return 0, fmt.Errorf("failed to delete old reminders: %w", err)
+	}
+
+	return result.RowsAffected(), nil
+}
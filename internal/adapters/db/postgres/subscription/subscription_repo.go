@@ -3,6 +3,7 @@ package subscription
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"3xui-bot/internal/core"
 	"3xui-bot/internal/usecase"
@@ -23,12 +24,16 @@ func NewSubscription(dbGetter transactorPgx.DBGetter) *Subscription {
 
 func (s *Subscription) CreateSubscription(ctx context.Context, subscription *core.Subscription) error {
 	query := `
-		INSERT INTO subscriptions (id, user_id, name, plan_id, start_date, end_date, is_active, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+		INSERT INTO subscriptions (id, user_id, name, plan_id, start_date, end_date, is_active,
+		                           auto_renew, renewal_attempts, next_renewal_at, status, grace_ends_at, suspended_at,
+		                           created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`
 
 	_, err := s.dbGetter(ctx).Exec(ctx, query,
 		subscription.ID, subscription.UserID, subscription.Name, subscription.PlanID,
 		subscription.StartDate, subscription.EndDate, subscription.IsActive,
+		subscription.AutoRenew, subscription.RenewalAttempts, subscription.NextRenewalAt,
+		subscription.GetStatus(), subscription.GraceEndsAt, subscription.SuspendedAt,
 		subscription.CreatedAt, subscription.UpdatedAt,
 	)
 
@@ -42,13 +47,17 @@ func (s *Subscription) CreateSubscription(ctx context.Context, subscription *cor
 
 func (s *Subscription) GetSubscriptionByID(ctx context.Context, id string) (*core.Subscription, error) {
 	query := `
-		SELECT id, user_id, name, plan_id, start_date, end_date, is_active, created_at, updated_at
+		SELECT id, user_id, name, plan_id, start_date, end_date, is_active,
+		       auto_renew, renewal_attempts, next_renewal_at, status, grace_ends_at, suspended_at,
+		       created_at, updated_at
 		FROM subscriptions WHERE id = $1`
 
 	subscription := &core.Subscription{}
 	err := s.dbGetter(ctx).QueryRow(ctx, query, id).Scan(
 		&subscription.ID, &subscription.UserID, &subscription.Name, &subscription.PlanID,
 		&subscription.StartDate, &subscription.EndDate, &subscription.IsActive,
+		&subscription.AutoRenew, &subscription.RenewalAttempts, &subscription.NextRenewalAt,
+		&subscription.Status, &subscription.GraceEndsAt, &subscription.SuspendedAt,
 		&subscription.CreatedAt, &subscription.UpdatedAt,
 	)
 
@@ -62,7 +71,9 @@ func (s *Subscription) GetSubscriptionByID(ctx context.Context, id string) (*cor
 
 func (s *Subscription) GetSubscriptionsByUserID(ctx context.Context, userID int64) ([]*core.Subscription, error) {
 	query := `
-		SELECT id, user_id, name, plan_id, start_date, end_date, is_active, created_at, updated_at
+		SELECT id, user_id, name, plan_id, start_date, end_date, is_active,
+		       auto_renew, renewal_attempts, next_renewal_at, status, grace_ends_at, suspended_at,
+		       created_at, updated_at
 		FROM subscriptions WHERE user_id = $1
 		ORDER BY created_at DESC`
 
@@ -79,6 +90,8 @@ func (s *Subscription) GetSubscriptionsByUserID(ctx context.Context, userID int6
 		err := rows.Scan(
 			&subscription.ID, &subscription.UserID, &subscription.Name, &subscription.PlanID,
 			&subscription.StartDate, &subscription.EndDate, &subscription.IsActive,
+			&subscription.AutoRenew, &subscription.RenewalAttempts, &subscription.NextRenewalAt,
+			&subscription.Status, &subscription.GraceEndsAt, &subscription.SuspendedAt,
 			&subscription.CreatedAt, &subscription.UpdatedAt,
 		)
 		if err != nil {
@@ -98,7 +111,9 @@ func (s *Subscription) GetSubscriptionsByUserID(ctx context.Context, userID int6
 
 func (s *Subscription) GetActiveSubscriptionByUserID(ctx context.Context, userID int64) (*core.Subscription, error) {
 	query := `
-		SELECT id, user_id, name, plan_id, start_date, end_date, is_active, created_at, updated_at
+		SELECT id, user_id, name, plan_id, start_date, end_date, is_active,
+		       auto_renew, renewal_attempts, next_renewal_at, status, grace_ends_at, suspended_at,
+		       created_at, updated_at
 		FROM subscriptions
 		WHERE user_id = $1 AND is_active = true AND end_date > NOW()
 		ORDER BY created_at DESC
@@ -108,6 +123,8 @@ func (s *Subscription) GetActiveSubscriptionByUserID(ctx context.Context, userID
 	err := s.dbGetter(ctx).QueryRow(ctx, query, userID).Scan(
 		&subscription.ID, &subscription.UserID, &subscription.Name, &subscription.PlanID,
 		&subscription.StartDate, &subscription.EndDate, &subscription.IsActive,
+		&subscription.AutoRenew, &subscription.RenewalAttempts, &subscription.NextRenewalAt,
+		&subscription.Status, &subscription.GraceEndsAt, &subscription.SuspendedAt,
 		&subscription.CreatedAt, &subscription.UpdatedAt,
 	)
 
@@ -123,12 +140,16 @@ func (s *Subscription) UpdateSubscription(ctx context.Context, subscription *cor
 	query := `
 		UPDATE subscriptions
 		SET name = $2, plan_id = $3, start_date = $4, end_date = $5,
-		    is_active = $6, updated_at = $7
+		    is_active = $6, auto_renew = $7, renewal_attempts = $8,
+		    next_renewal_at = $9, status = $10, grace_ends_at = $11,
+		    suspended_at = $12, updated_at = $13
 		WHERE id = $1`
 
 	result, err := s.dbGetter(ctx).Exec(ctx, query,
 		subscription.ID, subscription.Name, subscription.PlanID,
 		subscription.StartDate, subscription.EndDate, subscription.IsActive,
+		subscription.AutoRenew, subscription.RenewalAttempts, subscription.NextRenewalAt,
+		subscription.GetStatus(), subscription.GraceEndsAt, subscription.SuspendedAt,
 		subscription.UpdatedAt,
 	)
 
@@ -145,6 +166,192 @@ func (s *Subscription) UpdateSubscription(ctx context.Context, subscription *cor
 	return nil
 }
 
+func (s *Subscription) GetSubscriptionsDueForRenewal(ctx context.Context, chargeBefore, now time.Time) ([]*core.Subscription, error) {
+	query := `
+		SELECT id, user_id, name, plan_id, start_date, end_date, is_active,
+		       auto_renew, renewal_attempts, next_renewal_at, status, grace_ends_at, suspended_at,
+		       created_at, updated_at
+		FROM subscriptions
+		WHERE auto_renew = true AND is_active = true AND end_date <= $1
+		  AND (next_renewal_at IS NULL OR next_renewal_at <= $2)
+		ORDER BY end_date ASC`
+
+	rows, err := s.dbGetter(ctx).Query(ctx, query, chargeBefore, now)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get subscriptions due for renewal: %w", err)
+	}
+	defer rows.Close()
+
+	var subscriptions []*core.Subscription
+	for rows.Next() {
+		subscription := &core.Subscription{}
+		err := rows.Scan(
+			&subscription.ID, &subscription.UserID, &subscription.Name, &subscription.PlanID,
+			&subscription.StartDate, &subscription.EndDate, &subscription.IsActive,
+			&subscription.AutoRenew, &subscription.RenewalAttempts, &subscription.NextRenewalAt,
+			&subscription.Status, &subscription.GraceEndsAt, &subscription.SuspendedAt,
+			&subscription.CreatedAt, &subscription.UpdatedAt,
+		)
+		if err != nil {
+
+			return nil, fmt.Errorf("failed to scan subscription: %w", err)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	if err = rows.Err(); err != nil {
+
+		return nil, fmt.Errorf("error iterating subscriptions: %w", err)
+	}
+
+	return subscriptions, nil
+}
+
+func (s *Subscription) GetExpiredActiveSubscriptions(ctx context.Context, now time.Time, afterID string, limit int) ([]*core.Subscription, error) {
+	query := `
+		SELECT id, user_id, name, plan_id, start_date, end_date, is_active,
+		       auto_renew, renewal_attempts, next_renewal_at, status, grace_ends_at, suspended_at,
+		       created_at, updated_at
+		FROM subscriptions
+		WHERE is_active = true AND end_date <= $1 AND id > $2
+		ORDER BY id ASC
+		LIMIT $3`
+
+	rows, err := s.dbGetter(ctx).Query(ctx, query, now, afterID, limit)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get expired subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var subscriptions []*core.Subscription
+	for rows.Next() {
+		subscription := &core.Subscription{}
+		err := rows.Scan(
+			&subscription.ID, &subscription.UserID, &subscription.Name, &subscription.PlanID,
+			&subscription.StartDate, &subscription.EndDate, &subscription.IsActive,
+			&subscription.AutoRenew, &subscription.RenewalAttempts, &subscription.NextRenewalAt,
+			&subscription.Status, &subscription.GraceEndsAt, &subscription.SuspendedAt,
+			&subscription.CreatedAt, &subscription.UpdatedAt,
+		)
+		if err != nil {
+
+			return nil, fmt.Errorf("failed to scan subscription: %w", err)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	if err = rows.Err(); err != nil {
+
+		return nil, fmt.Errorf("error iterating subscriptions: %w", err)
+	}
+
+	return subscriptions, nil
+}
+
+func (s *Subscription) GetSubscriptionsExpiringBetween(ctx context.Context, from, to time.Time) ([]*core.Subscription, error) {
+	query := `
+		SELECT id, user_id, name, plan_id, start_date, end_date, is_active,
+		       auto_renew, renewal_attempts, next_renewal_at, status, grace_ends_at, suspended_at,
+		       created_at, updated_at
+		FROM subscriptions
+		WHERE is_active = true AND auto_renew = false AND end_date > $1 AND end_date <= $2
+		ORDER BY end_date ASC`
+
+	rows, err := s.dbGetter(ctx).Query(ctx, query, from, to)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get expiring subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var subscriptions []*core.Subscription
+	for rows.Next() {
+		subscription := &core.Subscription{}
+		err := rows.Scan(
+			&subscription.ID, &subscription.UserID, &subscription.Name, &subscription.PlanID,
+			&subscription.StartDate, &subscription.EndDate, &subscription.IsActive,
+			&subscription.AutoRenew, &subscription.RenewalAttempts, &subscription.NextRenewalAt,
+			&subscription.Status, &subscription.GraceEndsAt, &subscription.SuspendedAt,
+			&subscription.CreatedAt, &subscription.UpdatedAt,
+		)
+		if err != nil {
+
+			return nil, fmt.Errorf("failed to scan subscription: %w", err)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	if err = rows.Err(); err != nil {
+
+		return nil, fmt.Errorf("error iterating subscriptions: %w", err)
+	}
+
+	return subscriptions, nil
+}
+
+func (s *Subscription) GetSuspendedSubscriptions(ctx context.Context, suspendedBefore time.Time, afterID string, limit int) ([]*core.Subscription, error) {
+	query := `
+		SELECT id, user_id, name, plan_id, start_date, end_date, is_active,
+		       auto_renew, renewal_attempts, next_renewal_at, status, grace_ends_at, suspended_at,
+		       created_at, updated_at
+		FROM subscriptions
+		WHERE status = 'suspended' AND suspended_at <= $1 AND id > $2
+		ORDER BY id ASC
+		LIMIT $3`
+
+	rows, err := s.dbGetter(ctx).Query(ctx, query, suspendedBefore, afterID, limit)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get suspended subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var subscriptions []*core.Subscription
+	for rows.Next() {
+		subscription := &core.Subscription{}
+		err := rows.Scan(
+			&subscription.ID, &subscription.UserID, &subscription.Name, &subscription.PlanID,
+			&subscription.StartDate, &subscription.EndDate, &subscription.IsActive,
+			&subscription.AutoRenew, &subscription.RenewalAttempts, &subscription.NextRenewalAt,
+			&subscription.Status, &subscription.GraceEndsAt, &subscription.SuspendedAt,
+			&subscription.CreatedAt, &subscription.UpdatedAt,
+		)
+		if err != nil {
+
+			return nil, fmt.Errorf("failed to scan subscription: %w", err)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	if err = rows.Err(); err != nil {
+
+		return nil, fmt.Errorf("error iterating subscriptions: %w", err)
+	}
+
+	return subscriptions, nil
+}
+
+func (s *Subscription) UpdateSubscriptionStatus(ctx context.Context, subscription *core.Subscription, from core.SubscriptionStatus) (bool, error) {
+	query := `
+		UPDATE subscriptions
+		SET status = $2, is_active = $3, grace_ends_at = $4, suspended_at = $5, updated_at = $6
+		WHERE id = $1 AND status = $7 AND end_date = $8`
+
+	result, err := s.dbGetter(ctx).Exec(ctx, query,
+		subscription.ID, subscription.GetStatus(), subscription.IsActive,
+		subscription.GraceEndsAt, subscription.SuspendedAt, subscription.UpdatedAt,
+		from, subscription.EndDate,
+	)
+	if err != nil {
+
+		return false, fmt.Errorf("failed to update subscription status: %w", err)
+	}
+
+	return result.RowsAffected() > 0, nil
+}
+
 func (s *Subscription) DeleteSubscription(ctx context.Context, id string) error {
 	query := `DELETE FROM subscriptions WHERE id = $1`
 
@@ -170,7 +377,9 @@ func NewPlan(dbGetter transactorPgx.DBGetter) *Plan {
 
 func (p *Plan) GetAll(ctx context.Context) ([]*core.Plan, error) {
 	query := `
-		SELECT id, name, description, price, days, is_active
+		SELECT id, name, description, price, stars_price, days, is_active,
+		       traffic_limit_gb, data_limit_reset_strategy, protocols, inbound_tags,
+		       device_limit, COALESCE(marzban_template_id, 0)
 		FROM plans WHERE is_active = true
 		ORDER BY days ASC`
 
@@ -186,7 +395,9 @@ func (p *Plan) GetAll(ctx context.Context) ([]*core.Plan, error) {
 		plan := &core.Plan{}
 		err := rows.Scan(
 			&plan.ID, &plan.Name, &plan.Description, &plan.Price,
-			&plan.Days, &plan.IsActive,
+			&plan.StarsPrice, &plan.Days, &plan.IsActive,
+			&plan.TrafficLimitGB, &plan.DataLimitResetStrategy, &plan.Protocols, &plan.InboundTags,
+			&plan.DeviceLimit, &plan.MarzbanTemplateID,
 		)
 		if err != nil {
 
@@ -205,13 +416,17 @@ func (p *Plan) GetAll(ctx context.Context) ([]*core.Plan, error) {
 
 func (p *Plan) GetPlanByID(ctx context.Context, id string) (*core.Plan, error) {
 	query := `
-		SELECT id, name, description, price, days, is_active
+		SELECT id, name, description, price, stars_price, days, is_active,
+		       traffic_limit_gb, data_limit_reset_strategy, protocols, inbound_tags,
+		       device_limit, COALESCE(marzban_template_id, 0)
 		FROM plans WHERE id = $1`
 
 	plan := &core.Plan{}
 	err := p.dbGetter(ctx).QueryRow(ctx, query, id).Scan(
 		&plan.ID, &plan.Name, &plan.Description, &plan.Price,
-		&plan.Days, &plan.IsActive,
+		&plan.StarsPrice, &plan.Days, &plan.IsActive,
+		&plan.TrafficLimitGB, &plan.DataLimitResetStrategy, &plan.Protocols, &plan.InboundTags,
+		&plan.DeviceLimit, &plan.MarzbanTemplateID,
 	)
 
 	if err != nil {
@@ -24,12 +24,12 @@ func NewUser(dbGetter transactorPgx.DBGetter) *User {
 
 func (u *User) CreateUser(ctx context.Context, user *core.User) error {
 	query := `
-		INSERT INTO users (telegram_id, username, first_name, last_name, language_code, is_blocked, has_trial, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+		INSERT INTO users (telegram_id, username, first_name, last_name, language_code, is_blocked, has_trial, email, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9, $10)`
 
 	_, err := u.dbGetter(ctx).Exec(ctx, query,
 		user.TelegramID, user.Username, user.FirstName, user.LastName,
-		user.LanguageCode, user.IsBlocked, user.HasTrial, user.CreatedAt, user.UpdatedAt,
+		user.LanguageCode, user.IsBlocked, user.HasTrial, user.Email, user.CreatedAt, user.UpdatedAt,
 	)
 
 	if err != nil {
@@ -47,14 +47,14 @@ func (u *User) GetUserByID(ctx context.Context, id int64) (*core.User, error) {
 
 func (u *User) GetUserByTelegramID(ctx context.Context, telegramID int64) (*core.User, error) {
 	query := `
-		SELECT telegram_id, username, first_name, last_name, language_code, is_blocked, has_trial, created_at, updated_at
+		SELECT telegram_id, username, first_name, last_name, language_code, is_blocked, has_trial, COALESCE(email, ''), created_at, updated_at
 		FROM users WHERE telegram_id = $1`
 
 	user := &core.User{}
 	err := u.dbGetter(ctx).QueryRow(ctx, query, telegramID).Scan(
 		&user.TelegramID, &user.Username, &user.FirstName,
 		&user.LastName, &user.LanguageCode, &user.IsBlocked, &user.HasTrial,
-		&user.CreatedAt, &user.UpdatedAt,
+		&user.Email, &user.CreatedAt, &user.UpdatedAt,
 	)
 
 	if err != nil {
@@ -69,12 +69,12 @@ func (u *User) UpdateUser(ctx context.Context, user *core.User) error {
 	query := `
 		UPDATE users
 		SET username = $2, first_name = $3, last_name = $4, language_code = $5,
-		    is_blocked = $6, has_trial = $7, updated_at = $8
+		    is_blocked = $6, has_trial = $7, email = NULLIF($8, ''), updated_at = $9
 		WHERE telegram_id = $1`
 
 	result, err := u.dbGetter(ctx).Exec(ctx, query,
 		user.TelegramID, user.Username, user.FirstName, user.LastName,
-		user.LanguageCode, user.IsBlocked, user.HasTrial, user.UpdatedAt,
+		user.LanguageCode, user.IsBlocked, user.HasTrial, user.Email, user.UpdatedAt,
 	)
 
 	if err != nil {
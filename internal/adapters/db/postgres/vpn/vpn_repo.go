@@ -25,11 +25,11 @@ func NewVPNConnection(dbGetter transactorPgx.DBGetter) *VPNConnection {
 
 func (v *VPNConnection) CreateVPNConnection(ctx context.Context, conn *core.VPNConnection) error {
 	query := `
-		INSERT INTO vpn_connections (id, telegram_user_id, marzban_username, name, is_active, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+		INSERT INTO vpn_connections (id, telegram_user_id, subscription_id, marzban_username, server_name, name, is_active, created_at, updated_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, NULLIF($5, ''), $6, $7, $8, $9)`
 
 	_, err := v.dbGetter(ctx).Exec(ctx, query,
-		conn.ID, conn.TelegramUserID, conn.MarzbanUsername, conn.Name,
+		conn.ID, conn.TelegramUserID, conn.SubscriptionID, conn.MarzbanUsername, conn.ServerName, conn.Name,
 		conn.IsActive, conn.CreatedAt, conn.UpdatedAt,
 	)
 	if err != nil {
@@ -42,7 +42,7 @@ func (v *VPNConnection) CreateVPNConnection(ctx context.Context, conn *core.VPNC
 
 func (v *VPNConnection) GetVPNConnectionsByTelegramUserID(ctx context.Context, telegramUserID int64) ([]*core.VPNConnection, error) {
 	query := `
-		SELECT id, telegram_user_id, marzban_username, name, is_active, created_at, updated_at
+		SELECT id, telegram_user_id, COALESCE(subscription_id, ''), marzban_username, COALESCE(server_name, ''), name, is_active, traffic_warning_percent, traffic_addon_bytes, traffic_addon_expires_at, created_at, updated_at
 		FROM vpn_connections WHERE telegram_user_id = $1 ORDER BY created_at DESC`
 
 	rows, err := v.dbGetter(ctx).Query(ctx, query, telegramUserID)
@@ -56,8 +56,8 @@ func (v *VPNConnection) GetVPNConnectionsByTelegramUserID(ctx context.Context, t
 	for rows.Next() {
 		conn := &core.VPNConnection{}
 		err := rows.Scan(
-			&conn.ID, &conn.TelegramUserID, &conn.MarzbanUsername, &conn.Name,
-			&conn.IsActive, &conn.CreatedAt, &conn.UpdatedAt,
+			&conn.ID, &conn.TelegramUserID, &conn.SubscriptionID, &conn.MarzbanUsername, &conn.ServerName, &conn.Name,
+			&conn.IsActive, &conn.TrafficWarningPercent, &conn.TrafficAddonBytes, &conn.TrafficAddonExpiresAt, &conn.CreatedAt, &conn.UpdatedAt,
 		)
 		if err != nil {
 
@@ -74,26 +74,80 @@ func (v *VPNConnection) GetVPNConnectionsByTelegramUserID(ctx context.Context, t
 }
 
 func (v *VPNConnection) GetVPNConnectionsBySubscriptionID(ctx context.Context, subscriptionID string) ([]*core.VPNConnection, error) {
-	var telegramUserID int64
-	query := `SELECT user_id FROM subscriptions WHERE id = $1`
-	err := v.dbGetter(ctx).QueryRow(ctx, query, subscriptionID).Scan(&telegramUserID)
+	query := `
+		SELECT id, telegram_user_id, COALESCE(subscription_id, ''), marzban_username, COALESCE(server_name, ''), name, is_active, traffic_warning_percent, traffic_addon_bytes, traffic_addon_expires_at, created_at, updated_at
+		FROM vpn_connections WHERE subscription_id = $1 ORDER BY created_at DESC`
+
+	rows, err := v.dbGetter(ctx).Query(ctx, query, subscriptionID)
 	if err != nil {
 
-		return nil, fmt.Errorf("failed to get user_id from subscription: %w", err)
+		return nil, fmt.Errorf("failed to get VPN connections by subscription: %w", err)
+	}
+	defer rows.Close()
+
+	var connections []*core.VPNConnection
+	for rows.Next() {
+		conn := &core.VPNConnection{}
+		err := rows.Scan(
+			&conn.ID, &conn.TelegramUserID, &conn.SubscriptionID, &conn.MarzbanUsername, &conn.ServerName, &conn.Name,
+			&conn.IsActive, &conn.TrafficWarningPercent, &conn.TrafficAddonBytes, &conn.TrafficAddonExpiresAt, &conn.CreatedAt, &conn.UpdatedAt,
+		)
+		if err != nil {
+
+			return nil, fmt.Errorf("failed to scan VPN connection: %w", err)
+		}
+		connections = append(connections, conn)
+	}
+	if err = rows.Err(); err != nil {
+
+		return nil, fmt.Errorf("error iterating VPN connections: %w", err)
 	}
 
-	return v.GetVPNConnectionsByTelegramUserID(ctx, telegramUserID)
+	return connections, nil
+}
+
+func (v *VPNConnection) GetAllVPNConnections(ctx context.Context) ([]*core.VPNConnection, error) {
+	query := `
+		SELECT id, telegram_user_id, COALESCE(subscription_id, ''), marzban_username, COALESCE(server_name, ''), name, is_active, traffic_warning_percent, traffic_addon_bytes, traffic_addon_expires_at, created_at, updated_at
+		FROM vpn_connections ORDER BY created_at`
+
+	rows, err := v.dbGetter(ctx).Query(ctx, query)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get VPN connections: %w", err)
+	}
+	defer rows.Close()
+
+	var connections []*core.VPNConnection
+	for rows.Next() {
+		conn := &core.VPNConnection{}
+		err := rows.Scan(
+			&conn.ID, &conn.TelegramUserID, &conn.SubscriptionID, &conn.MarzbanUsername, &conn.ServerName, &conn.Name,
+			&conn.IsActive, &conn.TrafficWarningPercent, &conn.TrafficAddonBytes, &conn.TrafficAddonExpiresAt, &conn.CreatedAt, &conn.UpdatedAt,
+		)
+		if err != nil {
+
+			return nil, fmt.Errorf("failed to scan VPN connection: %w", err)
+		}
+		connections = append(connections, conn)
+	}
+	if err = rows.Err(); err != nil {
+
+		return nil, fmt.Errorf("error iterating VPN connections: %w", err)
+	}
+
+	return connections, nil
 }
 
 func (v *VPNConnection) GetVPNConnectionByID(ctx context.Context, id string) (*core.VPNConnection, error) {
 	query := `
-		SELECT id, telegram_user_id, marzban_username, name, is_active, created_at, updated_at
+		SELECT id, telegram_user_id, COALESCE(subscription_id, ''), marzban_username, COALESCE(server_name, ''), name, is_active, traffic_warning_percent, traffic_addon_bytes, traffic_addon_expires_at, created_at, updated_at
 		FROM vpn_connections WHERE id = $1`
 
 	conn := &core.VPNConnection{}
 	err := v.dbGetter(ctx).QueryRow(ctx, query, id).Scan(
-		&conn.ID, &conn.TelegramUserID, &conn.MarzbanUsername, &conn.Name,
-		&conn.IsActive, &conn.CreatedAt, &conn.UpdatedAt,
+		&conn.ID, &conn.TelegramUserID, &conn.SubscriptionID, &conn.MarzbanUsername, &conn.ServerName, &conn.Name,
+		&conn.IsActive, &conn.TrafficWarningPercent, &conn.TrafficAddonBytes, &conn.TrafficAddonExpiresAt, &conn.CreatedAt, &conn.UpdatedAt,
 	)
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -109,13 +163,13 @@ func (v *VPNConnection) GetVPNConnectionByID(ctx context.Context, id string) (*c
 
 func (v *VPNConnection) GetVPNConnectionByMarzbanUsername(ctx context.Context, marzbanUsername string) (*core.VPNConnection, error) {
 	query := `
-		SELECT id, telegram_user_id, marzban_username, name, is_active, created_at, updated_at
+		SELECT id, telegram_user_id, COALESCE(subscription_id, ''), marzban_username, COALESCE(server_name, ''), name, is_active, traffic_warning_percent, traffic_addon_bytes, traffic_addon_expires_at, created_at, updated_at
 		FROM vpn_connections WHERE marzban_username = $1`
 
 	conn := &core.VPNConnection{}
 	err := v.dbGetter(ctx).QueryRow(ctx, query, marzbanUsername).Scan(
-		&conn.ID, &conn.TelegramUserID, &conn.MarzbanUsername, &conn.Name,
-		&conn.IsActive, &conn.CreatedAt, &conn.UpdatedAt,
+		&conn.ID, &conn.TelegramUserID, &conn.SubscriptionID, &conn.MarzbanUsername, &conn.ServerName, &conn.Name,
+		&conn.IsActive, &conn.TrafficWarningPercent, &conn.TrafficAddonBytes, &conn.TrafficAddonExpiresAt, &conn.CreatedAt, &conn.UpdatedAt,
 	)
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -145,6 +199,89 @@ func (v *VPNConnection) UpdateVPNConnectionName(ctx context.Context, id, name st
 	return nil
 }
 
+func (v *VPNConnection) UpdateVPNConnectionStatus(ctx context.Context, id string, isActive bool) error {
+	query := `UPDATE vpn_connections SET is_active = $2, updated_at = $3 WHERE id = $1`
+
+	result, err := v.dbGetter(ctx).Exec(ctx, query, id, isActive, time.Now())
+	if err != nil {
+
+		return fmt.Errorf("failed to update VPN connection status: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+
+		return usecase.ErrNotFound
+	}
+
+	return nil
+}
+
+func (v *VPNConnection) UpdateVPNConnectionServer(ctx context.Context, id, serverName string) error {
+	query := `UPDATE vpn_connections SET server_name = NULLIF($2, ''), updated_at = $3 WHERE id = $1`
+
+	result, err := v.dbGetter(ctx).Exec(ctx, query, id, serverName, time.Now())
+	if err != nil {
+
+		return fmt.Errorf("failed to update VPN connection server: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+
+		return usecase.ErrNotFound
+	}
+
+	return nil
+}
+
+func (v *VPNConnection) UpdateVPNConnectionTrafficWarning(ctx context.Context, id string, percent int) error {
+	query := `UPDATE vpn_connections SET traffic_warning_percent = $2, updated_at = $3 WHERE id = $1`
+
+	result, err := v.dbGetter(ctx).Exec(ctx, query, id, percent, time.Now())
+	if err != nil {
+
+		return fmt.Errorf("failed to update VPN connection traffic warning: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+
+		return usecase.ErrNotFound
+	}
+
+	return nil
+}
+
+func (v *VPNConnection) AddVPNConnectionTrafficAddon(ctx context.Context, id string, bytes int64, expiresAt *time.Time) error {
+	query := `
+		UPDATE vpn_connections
+		SET traffic_addon_bytes = traffic_addon_bytes + $2, traffic_addon_expires_at = $3, updated_at = $4
+		WHERE id = $1`
+
+	result, err := v.dbGetter(ctx).Exec(ctx, query, id, bytes, expiresAt, time.Now())
+	if err != nil {
+
+		return fmt.Errorf("failed to add VPN connection traffic add-on: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+
+		return usecase.ErrNotFound
+	}
+
+	return nil
+}
+
+func (v *VPNConnection) ClearVPNConnectionTrafficAddon(ctx context.Context, id string) error {
+	query := `UPDATE vpn_connections SET traffic_addon_bytes = 0, traffic_addon_expires_at = NULL, updated_at = $2 WHERE id = $1`
+
+	result, err := v.dbGetter(ctx).Exec(ctx, query, id, time.Now())
+	if err != nil {
+
+		return fmt.Errorf("failed to clear VPN connection traffic add-on: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+
+		return usecase.ErrNotFound
+	}
+
+	return nil
+}
+
 func (v *VPNConnection) DeleteVPNConnection(ctx context.Context, id string) error {
 	query := `DELETE FROM vpn_connections WHERE id = $1`
 
@@ -171,7 +308,7 @@ func (v *VPNConnection) DeleteVPNConnectionByMarzbanUsername(ctx context.Context
 
 func (v *VPNConnection) GetActiveVPNConnections(ctx context.Context, telegramUserID int64) ([]*core.VPNConnection, error) {
 	query := `
-		SELECT id, telegram_user_id, marzban_username, name, is_active, created_at, updated_at
+		SELECT id, telegram_user_id, COALESCE(subscription_id, ''), marzban_username, COALESCE(server_name, ''), name, is_active, traffic_warning_percent, traffic_addon_bytes, traffic_addon_expires_at, created_at, updated_at
 		FROM vpn_connections WHERE telegram_user_id = $1 AND is_active = TRUE ORDER BY created_at DESC`
 
 	rows, err := v.dbGetter(ctx).Query(ctx, query, telegramUserID)
@@ -185,8 +322,76 @@ func (v *VPNConnection) GetActiveVPNConnections(ctx context.Context, telegramUse
 	for rows.Next() {
 		conn := &core.VPNConnection{}
 		err := rows.Scan(
-			&conn.ID, &conn.TelegramUserID, &conn.MarzbanUsername, &conn.Name,
-			&conn.IsActive, &conn.CreatedAt, &conn.UpdatedAt,
+			&conn.ID, &conn.TelegramUserID, &conn.SubscriptionID, &conn.MarzbanUsername, &conn.ServerName, &conn.Name,
+			&conn.IsActive, &conn.TrafficWarningPercent, &conn.TrafficAddonBytes, &conn.TrafficAddonExpiresAt, &conn.CreatedAt, &conn.UpdatedAt,
+		)
+		if err != nil {
+
+			return nil, fmt.Errorf("failed to scan VPN connection: %w", err)
+		}
+		connections = append(connections, conn)
+	}
+	if err = rows.Err(); err != nil {
+
+		return nil, fmt.Errorf("error iterating VPN connections: %w", err)
+	}
+
+	return connections, nil
+}
+
+func (v *VPNConnection) CountVPNConnectionsByServer(ctx context.Context) (map[string]int, error) {
+	query := `
+		SELECT COALESCE(server_name, ''), COUNT(*)
+		FROM vpn_connections WHERE is_active = TRUE
+		GROUP BY COALESCE(server_name, '')`
+
+	rows, err := v.dbGetter(ctx).Query(ctx, query)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to count VPN connections by server: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var serverName string
+		var count int
+		if err := rows.Scan(&serverName, &count); err != nil {
+
+			return nil, fmt.Errorf("failed to scan VPN connection count: %w", err)
+		}
+		counts[serverName] = count
+	}
+	if err = rows.Err(); err != nil {
+
+		return nil, fmt.Errorf("error iterating VPN connection counts: %w", err)
+	}
+
+	return counts, nil
+}
+
+func (v *VPNConnection) GetExpiredActiveVPNConnections(ctx context.Context, now time.Time, afterID string, limit int) ([]*core.VPNConnection, error) {
+	query := `
+		SELECT c.id, c.telegram_user_id, COALESCE(c.subscription_id, ''), c.marzban_username, COALESCE(c.server_name, ''), c.name, c.is_active, c.traffic_warning_percent, c.traffic_addon_bytes, c.traffic_addon_expires_at, c.created_at, c.updated_at
+		FROM vpn_connections c
+		JOIN subscriptions s ON s.id = c.subscription_id
+		WHERE c.is_active = TRUE AND (s.is_active = FALSE OR s.end_date <= $1) AND c.id > $2
+		ORDER BY c.id ASC
+		LIMIT $3`
+
+	rows, err := v.dbGetter(ctx).Query(ctx, query, now, afterID, limit)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get expired VPN connections: %w", err)
+	}
+	defer rows.Close()
+
+	var connections []*core.VPNConnection
+	for rows.Next() {
+		conn := &core.VPNConnection{}
+		err := rows.Scan(
+			&conn.ID, &conn.TelegramUserID, &conn.SubscriptionID, &conn.MarzbanUsername, &conn.ServerName, &conn.Name,
+			&conn.IsActive, &conn.TrafficWarningPercent, &conn.TrafficAddonBytes, &conn.TrafficAddonExpiresAt, &conn.CreatedAt, &conn.UpdatedAt,
 		)
 		if err != nil {
 
@@ -3,14 +3,36 @@ package marzban
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"math/rand/v2"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"3xui-bot/internal/core"
+	"3xui-bot/internal/pkg/breaker"
+)
+
+const (
+	fallbackTokenLifetime = 23 * time.Hour
+	tokenExpirySkew       = time.Minute
+
+	maxRetryAttempts = 3
+	retryBaseDelay   = 200 * time.Millisecond
+	retryMaxDelay    = 2 * time.Second
+
+	breakerFailureThreshold = 5
+	breakerOpenTimeout      = 30 * time.Second
+
+	marzbanTimeLayout = "2006-01-02T15:04:05"
 )
 
 type MarzbanRepository struct {
@@ -18,8 +40,11 @@ type MarzbanRepository struct {
 	httpClient *http.Client
 	username   string
 	password   string
-	token      string
-	tokenExp   time.Time
+	breaker    *breaker.Breaker
+
+	mu       sync.Mutex
+	token    string
+	tokenExp time.Time
 }
 
 func NewMarzbanRepository(baseURL, username, password string) *MarzbanRepository {
@@ -31,6 +56,7 @@ func NewMarzbanRepository(baseURL, username, password string) *MarzbanRepository
 		},
 		username: username,
 		password: password,
+		breaker:  breaker.New(breakerFailureThreshold, breakerOpenTimeout),
 	}
 }
 
@@ -40,6 +66,13 @@ func (m *MarzbanRepository) Authenticate(ctx context.Context) error {
 }
 
 func (m *MarzbanRepository) Login(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.login(ctx)
+}
+
+func (m *MarzbanRepository) login(ctx context.Context) error {
 	formData := url.Values{}
 	formData.Set("username", m.username)
 	formData.Set("password", m.password)
@@ -55,14 +88,13 @@ func (m *MarzbanRepository) Login(ctx context.Context) error {
 	resp, err := m.httpClient.Do(req)
 	if err != nil {
 
-		return fmt.Errorf("failed to execute login request: %w", err)
+		return transportError(ctx, "execute login request", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
 
-		return fmt.Errorf("login failed with status %d: %s", resp.StatusCode, string(body))
+		return newResponseError(resp, "login")
 	}
 
 	var loginResp struct {
@@ -75,35 +107,172 @@ func (m *MarzbanRepository) Login(ctx context.Context) error {
 		return fmt.Errorf("failed to decode login response: %w", err)
 	}
 
+	if loginResp.AccessToken == "" {
+
+		return fmt.Errorf("login response has no access token")
+	}
+
 	m.token = loginResp.AccessToken
-	m.tokenExp = time.Now().Add(23 * time.Hour)
+	m.tokenExp = tokenExpiry(loginResp.AccessToken, time.Now())
 
 	return nil
 }
 
-func (m *MarzbanRepository) ensureToken(ctx context.Context) error {
-	if m.token == "" || time.Now().After(m.tokenExp) {
+func tokenExpiry(token string, now time.Time) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
 
-		return m.Login(ctx)
+		return now.Add(fallbackTokenLifetime)
 	}
 
-	return nil
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+
+		return now.Add(fallbackTokenLifetime)
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+
+		return now.Add(fallbackTokenLifetime)
+	}
+
+	return time.Unix(claims.Exp, 0).Add(-tokenExpirySkew)
 }
 
-func (m *MarzbanRepository) makeRequest(ctx context.Context, method, endpoint string, body interface{}) (*http.Response, error) {
-	if err := m.ensureToken(ctx); err != nil {
+func (m *MarzbanRepository) validToken(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-		return nil, err
+	if m.token == "" || !time.Now().Before(m.tokenExp) {
+		if err := m.login(ctx); err != nil {
+
+			return "", err
+		}
 	}
 
-	var reqBody io.Reader
+	return m.token, nil
+}
+
+func (m *MarzbanRepository) refreshToken(ctx context.Context, rejected string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token != "" && m.token != rejected && time.Now().Before(m.tokenExp) {
+
+		return m.token, nil
+	}
+
+	if err := m.login(ctx); err != nil {
+
+		return "", err
+	}
+
+	return m.token, nil
+}
+
+func (m *MarzbanRepository) makeRequest(ctx context.Context, method, endpoint string, body interface{}) (*http.Response, error) {
+	var payload []byte
 	if body != nil {
 		jsonData, err := json.Marshal(body)
 		if err != nil {
 
 			return nil, fmt.Errorf("failed to marshal request body: %w", err)
 		}
-		reqBody = bytes.NewBuffer(jsonData)
+		payload = jsonData
+	}
+
+	attempts := 1
+	if isIdempotent(method) {
+		attempts = maxRetryAttempts
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			if err := waitBackoff(ctx, attempt); err != nil {
+
+				return nil, err
+			}
+		}
+
+		resp, err := m.send(ctx, method, endpoint, payload)
+		if err == nil && !isUnavailableStatus(resp.StatusCode) {
+
+			return resp, nil
+		}
+
+		if err != nil {
+			lastErr = err
+		} else {
+			lastErr = newResponseError(resp, method+" "+endpoint)
+			resp.Body.Close()
+		}
+
+		if !errors.Is(lastErr, ErrPanelUnavailable) || errors.Is(lastErr, breaker.ErrOpen) {
+			break
+		}
+
+		slog.Warn("Marzban request failed, retrying", "method", method, "endpoint", endpoint, "attempt", attempt+1, "error", lastErr)
+	}
+
+	return nil, lastErr
+}
+
+func (m *MarzbanRepository) send(ctx context.Context, method, endpoint string, payload []byte) (*http.Response, error) {
+	if err := m.breaker.Allow(); err != nil {
+
+		return nil, fmt.Errorf("%w: %w", ErrPanelUnavailable, err)
+	}
+
+	resp, err := m.sendAuthorized(ctx, method, endpoint, payload)
+	switch {
+	case ctx.Err() != nil:
+		m.breaker.Cancel()
+	case errors.Is(err, ErrPanelUnavailable), err == nil && isUnavailableStatus(resp.StatusCode):
+		m.breaker.Failure()
+	default:
+		m.breaker.Success()
+	}
+
+	return resp, err
+}
+
+func (m *MarzbanRepository) sendAuthorized(ctx context.Context, method, endpoint string, payload []byte) (*http.Response, error) {
+	token, err := m.validToken(ctx)
+	if err != nil {
+
+		return nil, err
+	}
+
+	resp, err := m.doRequest(ctx, method, endpoint, payload, token)
+	if err != nil {
+
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusUnauthorized {
+
+		return resp, nil
+	}
+
+	resp.Body.Close()
+
+	token, err = m.refreshToken(ctx, token)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to refresh token: %w", err)
+	}
+
+	return m.doRequest(ctx, method, endpoint, payload, token)
+}
+
+func (m *MarzbanRepository) doRequest(ctx context.Context, method, endpoint string, payload []byte, token string) (*http.Response, error) {
+	var reqBody io.Reader
+	if payload != nil {
+		reqBody = bytes.NewReader(payload)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, method, m.baseURL+endpoint, reqBody)
@@ -112,42 +281,58 @@ func (m *MarzbanRepository) makeRequest(ctx context.Context, method, endpoint st
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+m.token)
-	if body != nil {
+	req.Header.Set("Authorization", "Bearer "+token)
+	if payload != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
 
 	resp, err := m.httpClient.Do(req)
 	if err != nil {
 
-		return nil, fmt.Errorf("failed to execute request: %w", err)
+		return nil, transportError(ctx, "execute request", err)
 	}
 
-	if resp.StatusCode == http.StatusUnauthorized {
-		resp.Body.Close()
-		if err := m.Login(ctx); err != nil {
+	return resp, nil
+}
 
-			return nil, fmt.Errorf("failed to refresh token: %w", err)
-		}
+func transportError(ctx context.Context, operation string, err error) error {
+	if ctx.Err() != nil {
 
-		if body != nil {
-			jsonData, _ := json.Marshal(body)
-			reqBody = bytes.NewBuffer(jsonData)
-		}
-		req, err = http.NewRequestWithContext(ctx, method, m.baseURL+endpoint, reqBody)
-		if err != nil {
+		return fmt.Errorf("failed to %s: %w", operation, ctx.Err())
+	}
 
-			return nil, fmt.Errorf("failed to create retry request: %w", err)
-		}
-		req.Header.Set("Authorization", "Bearer "+m.token)
-		if body != nil {
-			req.Header.Set("Content-Type", "application/json")
-		}
+	return fmt.Errorf("%w: failed to %s: %w", ErrPanelUnavailable, operation, err)
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
 
-		return m.httpClient.Do(req)
+		return true
+	default:
+
+		return false
 	}
+}
 
-	return resp, nil
+func waitBackoff(ctx context.Context, attempt int) error {
+	delay := retryBaseDelay << (attempt - 1)
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	delay = delay/2 + rand.N(delay/2+1)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+
+		return ctx.Err()
+	case <-timer.C:
+
+		return nil
+	}
 }
 
 func (m *MarzbanRepository) CreateUser(ctx context.Context, userData *core.MarzbanUserData) (*core.MarzbanUserData, error) {
@@ -158,13 +343,13 @@ func (m *MarzbanRepository) CreateUser(ctx context.Context, userData *core.Marzb
 	}
 	defer resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
-
 	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
 
-		return nil, fmt.Errorf("failed to create user with status %d: %s", resp.StatusCode, string(body))
+		return nil, newResponseError(resp, "create user")
 	}
 
+	body, _ := io.ReadAll(resp.Body)
+
 	var createdUser core.MarzbanUserData
 	if err := json.Unmarshal(body, &createdUser); err != nil {
 
@@ -184,13 +369,12 @@ func (m *MarzbanRepository) GetUser(ctx context.Context, username string) (*core
 
 	if resp.StatusCode == http.StatusNotFound {
 
-		return nil, fmt.Errorf("user not found")
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, username)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
 
-		return nil, fmt.Errorf("failed to get user with status %d: %s", resp.StatusCode, string(body))
+		return nil, newResponseError(resp, "get user")
 	}
 
 	var userData core.MarzbanUserData
@@ -212,13 +396,12 @@ func (m *MarzbanRepository) UpdateUser(ctx context.Context, username string, use
 
 	if resp.StatusCode == http.StatusNotFound {
 
-		return nil, fmt.Errorf("user not found")
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, username)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
 
-		return nil, fmt.Errorf("failed to update user with status %d: %s", resp.StatusCode, string(body))
+		return nil, newResponseError(resp, "update user")
 	}
 
 	var updatedUser core.MarzbanUserData
@@ -244,202 +427,319 @@ func (m *MarzbanRepository) DeleteUser(ctx context.Context, username string) err
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
 
-		return fmt.Errorf("failed to delete user with status %d: %s", resp.StatusCode, string(body))
+		return newResponseError(resp, "delete user")
 	}
 
 	return nil
 }
 
-func (m *MarzbanRepository) GetUsers(ctx context.Context, offset, limit int) ([]*core.MarzbanUserData, error) {
-	endpoint := fmt.Sprintf("/api/users?offset=%d&limit=%d", offset, limit)
-	resp, err := m.makeRequest(ctx, "GET", endpoint, nil)
-	if err != nil {
-
-		return nil, err
+func (m *MarzbanRepository) GetUsers(ctx context.Context, query core.MarzbanUsersQuery) (*core.MarzbanUsersPage, error) {
+	params := url.Values{}
+	if query.Offset > 0 {
+		params.Set("offset", strconv.Itoa(query.Offset))
+	}
+	if query.Limit > 0 {
+		params.Set("limit", strconv.Itoa(query.Limit))
+	}
+	for _, username := range query.Usernames {
+		params.Add("username", username)
+	}
+	if query.Search != "" {
+		params.Set("search", query.Search)
+	}
+	if query.Status != "" {
+		params.Set("status", string(query.Status))
+	}
+	if query.Sort != "" {
+		params.Set("sort", query.Sort)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
+	var page core.MarzbanUsersPage
+	if err := m.call(ctx, http.MethodGet, withQuery("/api/users", params), nil, "get users", &page); err != nil {
 
-		return nil, fmt.Errorf("failed to get users with status %d: %s", resp.StatusCode, string(body))
+		return nil, err
 	}
 
-	var users []*core.MarzbanUserData
-	if err := json.NewDecoder(resp.Body).Decode(&users); err != nil {
+	return &page, nil
+}
+
+func (m *MarzbanRepository) GetStats(ctx context.Context) (*core.MarzbanSystemStats, error) {
+	var stats core.MarzbanSystemStats
+	if err := m.call(ctx, http.MethodGet, "/api/system", nil, "get system stats", &stats); err != nil {
 
-		return nil, fmt.Errorf("failed to decode users response: %w", err)
+		return nil, err
 	}
 
-	return users, nil
+	return &stats, nil
 }
 
-func (m *MarzbanRepository) GetSystemStats(ctx context.Context) (map[string]interface{}, error) {
-	resp, err := m.makeRequest(ctx, "GET", "/api/system", nil)
-	if err != nil {
+func (m *MarzbanRepository) GetInbounds(ctx context.Context) (map[string][]core.MarzbanInbound, error) {
+	var inbounds map[string][]core.MarzbanInbound
+	if err := m.call(ctx, http.MethodGet, "/api/inbounds", nil, "get inbounds", &inbounds); err != nil {
 
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
+	return inbounds, nil
+}
 
-		return nil, fmt.Errorf("failed to get system stats with status %d: %s", resp.StatusCode, string(body))
+func (m *MarzbanRepository) GetUserUsage(ctx context.Context, username string, start, end time.Time) ([]core.MarzbanUsage, error) {
+	var usage struct {
+		Username string              `json:"username"`
+		Usages   []core.MarzbanUsage `json:"usages"`
 	}
+	endpoint := withQuery("/api/user/"+url.PathEscape(username)+"/usage", periodParams(start, end))
+	if err := m.call(ctx, http.MethodGet, endpoint, nil, "get user usage", &usage); err != nil {
 
-	var stats map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
-
-		return nil, fmt.Errorf("failed to decode system stats response: %w", err)
+		return nil, userError(err, username)
 	}
 
-	return stats, nil
+	return usage.Usages, nil
 }
 
-func (m *MarzbanRepository) GetUserUsage(ctx context.Context, username string) (map[string]interface{}, error) {
-	resp, err := m.makeRequest(ctx, "GET", "/api/user/"+username+"/usage", nil)
-	if err != nil {
+func (m *MarzbanRepository) GetUsersUsage(ctx context.Context, start, end time.Time) ([]core.MarzbanUsage, error) {
+	var usage struct {
+		Usages []core.MarzbanUsage `json:"usages"`
+	}
+	if err := m.call(ctx, http.MethodGet, withQuery("/api/users/usage", periodParams(start, end)), nil, "get users usage", &usage); err != nil {
 
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNotFound {
+	return usage.Usages, nil
+}
 
-		return nil, fmt.Errorf("user not found")
+func (m *MarzbanRepository) RevokeSubscription(ctx context.Context, username string) (*core.MarzbanUserData, error) {
+	var user core.MarzbanUserData
+	if err := m.call(ctx, http.MethodPost, "/api/user/"+url.PathEscape(username)+"/revoke_sub", nil, "revoke user subscription", &user); err != nil {
+
+		return nil, userError(err, username)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
+	return &user, nil
+}
+
+func (m *MarzbanRepository) ActivateNextPlan(ctx context.Context, username string) (*core.MarzbanUserData, error) {
+	var user core.MarzbanUserData
+	if err := m.call(ctx, http.MethodPost, "/api/user/"+url.PathEscape(username)+"/active-next", nil, "activate next plan", &user); err != nil {
 
-		return nil, fmt.Errorf("failed to get user usage with status %d: %s", resp.StatusCode, string(body))
+		return nil, userError(err, username)
 	}
 
-	var usage map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&usage); err != nil {
+	return &user, nil
+}
+
+func (m *MarzbanRepository) SetOwner(ctx context.Context, username, adminUsername string) (*core.MarzbanUserData, error) {
+	params := url.Values{}
+	params.Set("admin_username", adminUsername)
+
+	var user core.MarzbanUserData
+	endpoint := withQuery("/api/user/"+url.PathEscape(username)+"/set-owner", params)
+	if err := m.call(ctx, http.MethodPut, endpoint, nil, "set user owner", &user); err != nil {
 
-		return nil, fmt.Errorf("failed to decode user usage response: %w", err)
+		return nil, userError(err, username)
 	}
 
-	return usage, nil
+	return &user, nil
 }
 
-func (m *MarzbanRepository) GetInbounds(ctx context.Context) ([]map[string]interface{}, error) {
-	resp, err := m.makeRequest(ctx, "GET", "/api/inbounds", nil)
-	if err != nil {
+func (m *MarzbanRepository) GetExpiredUsers(ctx context.Context, expiredAfter, expiredBefore *time.Time) ([]string, error) {
+	var usernames []string
+	endpoint := withQuery("/api/users/expired", expiredParams(expiredAfter, expiredBefore))
+	if err := m.call(ctx, http.MethodGet, endpoint, nil, "get expired users", &usernames); err != nil {
 
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
+	return usernames, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
+func (m *MarzbanRepository) DeleteExpiredUsers(ctx context.Context, expiredAfter, expiredBefore *time.Time) ([]string, error) {
+	var usernames []string
+	endpoint := withQuery("/api/users/expired", expiredParams(expiredAfter, expiredBefore))
+	if err := m.call(ctx, http.MethodDelete, endpoint, nil, "delete expired users", &usernames); err != nil {
 
-		return nil, fmt.Errorf("failed to get inbounds with status %d: %s", resp.StatusCode, string(body))
+		return nil, err
 	}
 
-	var inboundsArray []map[string]interface{}
-	if err := json.Unmarshal(body, &inboundsArray); err == nil {
+	return usernames, nil
+}
 
-		return inboundsArray, nil
+func (m *MarzbanRepository) GetUserTemplates(ctx context.Context, offset, limit int) ([]core.MarzbanUserTemplate, error) {
+	params := url.Values{}
+	if offset > 0 {
+		params.Set("offset", strconv.Itoa(offset))
+	}
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
 	}
 
-	var inboundsObject map[string]interface{}
-	if err := json.Unmarshal(body, &inboundsObject); err != nil {
+	var templates []core.MarzbanUserTemplate
+	if err := m.call(ctx, http.MethodGet, withQuery("/api/user_template", params), nil, "get user templates", &templates); err != nil {
 
-		return nil, fmt.Errorf("failed to decode inbounds response: %w, body: %s", err, string(body))
+		return nil, err
 	}
 
-	for _, value := range inboundsObject {
-		if arr, ok := value.([]interface{}); ok {
-			result := make([]map[string]interface{}, 0, len(arr))
-			for _, item := range arr {
-				if m, ok := item.(map[string]interface{}); ok {
-					result = append(result, m)
-				}
-			}
+	return templates, nil
+}
 
-			return result, nil
-		}
+func (m *MarzbanRepository) GetUserTemplate(ctx context.Context, templateID int) (*core.MarzbanUserTemplate, error) {
+	var template core.MarzbanUserTemplate
+	if err := m.call(ctx, http.MethodGet, "/api/user_template/"+strconv.Itoa(templateID), nil, "get user template", &template); err != nil {
+
+		return nil, err
 	}
 
-	return nil, fmt.Errorf("unexpected inbounds response format: %s", string(body))
+	return &template, nil
 }
 
-func (m *MarzbanRepository) GetStats(ctx context.Context) (map[string]interface{}, error) {
-	if err := m.ensureTokenValid(ctx); err != nil {
+func (m *MarzbanRepository) CreateUserTemplate(ctx context.Context, template *core.MarzbanUserTemplate) (*core.MarzbanUserTemplate, error) {
+	var created core.MarzbanUserTemplate
+	if err := m.call(ctx, http.MethodPost, "/api/user_template", template, "create user template", &created); err != nil {
 
 		return nil, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", m.baseURL+"/api/system", nil)
-	if err != nil {
+	return &created, nil
+}
+
+func (m *MarzbanRepository) UpdateUserTemplate(ctx context.Context, templateID int, template *core.MarzbanUserTemplate) (*core.MarzbanUserTemplate, error) {
+	var updated core.MarzbanUserTemplate
+	if err := m.call(ctx, http.MethodPut, "/api/user_template/"+strconv.Itoa(templateID), template, "update user template", &updated); err != nil {
 
-		return nil, fmt.Errorf("failed to create stats request: %w", err)
+		return nil, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+m.token)
+	return &updated, nil
+}
 
-	resp, err := m.httpClient.Do(req)
-	if err != nil {
+func (m *MarzbanRepository) DeleteUserTemplate(ctx context.Context, templateID int) error {
+
+	return m.call(ctx, http.MethodDelete, "/api/user_template/"+strconv.Itoa(templateID), nil, "delete user template", nil)
+}
+
+func (m *MarzbanRepository) GetNodes(ctx context.Context) ([]core.MarzbanNode, error) {
+	var nodes []core.MarzbanNode
+	if err := m.call(ctx, http.MethodGet, "/api/nodes", nil, "get nodes", &nodes); err != nil {
 
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
+	return nodes, nil
+}
 
-		return nil, fmt.Errorf("failed to get stats with status %d: %s", resp.StatusCode, string(body))
+func (m *MarzbanRepository) GetNodesUsage(ctx context.Context, start, end time.Time) ([]core.MarzbanNodeUsage, error) {
+	var usage struct {
+		Usages []core.MarzbanNodeUsage `json:"usages"`
 	}
+	if err := m.call(ctx, http.MethodGet, withQuery("/api/nodes/usage", periodParams(start, end)), nil, "get nodes usage", &usage); err != nil {
 
-	var stats map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
-
-		return nil, fmt.Errorf("failed to decode stats: %w", err)
+		return nil, err
 	}
 
-	return stats, nil
+	return usage.Usages, nil
 }
 
-func (m *MarzbanRepository) ResetUserTraffic(ctx context.Context, username string) error {
-	if err := m.ensureTokenValid(ctx); err != nil {
+func (m *MarzbanRepository) GetHosts(ctx context.Context) (map[string][]core.MarzbanHost, error) {
+	var hosts map[string][]core.MarzbanHost
+	if err := m.call(ctx, http.MethodGet, "/api/hosts", nil, "get hosts", &hosts); err != nil {
 
-		return err
+		return nil, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", m.baseURL+"/api/user/"+username+"/reset", nil)
-	if err != nil {
+	return hosts, nil
+}
 
-		return fmt.Errorf("failed to create reset request: %w", err)
+func (m *MarzbanRepository) ModifyHosts(ctx context.Context, hosts map[string][]core.MarzbanHost) (map[string][]core.MarzbanHost, error) {
+	var updated map[string][]core.MarzbanHost
+	if err := m.call(ctx, http.MethodPut, "/api/hosts", hosts, "modify hosts", &updated); err != nil {
+
+		return nil, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+m.token)
+	return updated, nil
+}
 
-	resp, err := m.httpClient.Do(req)
+func (m *MarzbanRepository) call(ctx context.Context, method, endpoint string, body interface{}, operation string, result interface{}) error {
+	resp, err := m.makeRequest(ctx, method, endpoint, body)
 	if err != nil {
 
 		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
 
-		return fmt.Errorf("failed to reset traffic with status %d: %s", resp.StatusCode, string(body))
+		return newResponseError(resp, operation)
+	}
+
+	if result == nil {
+
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+
+		return fmt.Errorf("failed to decode %s response: %w", operation, err)
 	}
 
 	return nil
 }
 
-func (m *MarzbanRepository) ensureTokenValid(ctx context.Context) error {
-	if m.token == "" || time.Now().After(m.tokenExp) {
+func userError(err error, username string) error {
+	if errors.Is(err, ErrNotFound) {
+
+		return fmt.Errorf("%w: %s: %w", ErrUserNotFound, username, err)
+	}
+
+	return err
+}
+
+func withQuery(endpoint string, params url.Values) string {
+	if len(params) == 0 {
+
+		return endpoint
+	}
+
+	return endpoint + "?" + params.Encode()
+}
+
+func periodParams(start, end time.Time) url.Values {
+	params := url.Values{}
+	if !start.IsZero() {
+		params.Set("start", start.UTC().Format(marzbanTimeLayout))
+	}
+	if !end.IsZero() {
+		params.Set("end", end.UTC().Format(marzbanTimeLayout))
+	}
+
+	return params
+}
+
+func expiredParams(expiredAfter, expiredBefore *time.Time) url.Values {
+	params := url.Values{}
+	if expiredAfter != nil {
+		params.Set("expired_after", expiredAfter.UTC().Format(time.RFC3339))
+	}
+	if expiredBefore != nil {
+		params.Set("expired_before", expiredBefore.UTC().Format(time.RFC3339))
+	}
+
+	return params
+}
+
+func (m *MarzbanRepository) ResetUserTraffic(ctx context.Context, username string) error {
+	resp, err := m.makeRequest(ctx, "POST", "/api/user/"+username+"/reset", nil)
+	if err != nil {
+
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
 
-		return m.Login(ctx)
+		return newResponseError(resp, "reset traffic")
 	}
 
 	return nil
@@ -0,0 +1,149 @@
+package marzban
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"3xui-bot/internal/usecase"
+)
+
+var (
+	ErrNotFound         = usecase.ErrNotFound
+	ErrUserNotFound     = usecase.ErrPanelUserNotFound
+	ErrConflict         = usecase.ErrPanelConflict
+	ErrValidation       = usecase.ErrPanelValidation
+	ErrPanelUnavailable = usecase.ErrPanelUnavailable
+)
+
+type APIError struct {
+	Operation  string
+	StatusCode int
+	Body       string
+	kind       error
+}
+
+func (e *APIError) Error() string {
+
+	return fmt.Sprintf("failed to %s with status %d: %s", e.Operation, e.StatusCode, e.Body)
+}
+
+func (e *APIError) Unwrap() error {
+
+	return e.kind
+}
+
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+type ValidationError struct {
+	Operation string
+	Fields    []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		if field.Field == "" {
+			parts = append(parts, field.Message)
+			continue
+		}
+		parts = append(parts, field.Field+": "+field.Message)
+	}
+
+	return fmt.Sprintf("failed to %s: validation error: %s", e.Operation, strings.Join(parts, "; "))
+}
+
+func (e *ValidationError) Unwrap() error {
+
+	return ErrValidation
+}
+
+func newResponseError(resp *http.Response, operation string) error {
+	body, _ := io.ReadAll(resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusUnprocessableEntity:
+
+		return &ValidationError{Operation: operation, Fields: parseValidationFields(body)}
+	case resp.StatusCode == http.StatusConflict:
+
+		return &APIError{Operation: operation, StatusCode: resp.StatusCode, Body: string(body), kind: ErrConflict}
+	case resp.StatusCode == http.StatusNotFound:
+
+		return &APIError{Operation: operation, StatusCode: resp.StatusCode, Body: string(body), kind: ErrNotFound}
+	case isUnavailableStatus(resp.StatusCode):
+
+		return &APIError{Operation: operation, StatusCode: resp.StatusCode, Body: string(body), kind: ErrPanelUnavailable}
+	default:
+
+		return &APIError{Operation: operation, StatusCode: resp.StatusCode, Body: string(body)}
+	}
+}
+
+func parseValidationFields(body []byte) []FieldError {
+	var payload struct {
+		Detail json.RawMessage `json:"detail"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || len(payload.Detail) == 0 {
+
+		return []FieldError{{Message: string(body)}}
+	}
+
+	var message string
+	if err := json.Unmarshal(payload.Detail, &message); err == nil {
+
+		return []FieldError{{Message: message}}
+	}
+
+	var items []struct {
+		Loc []interface{} `json:"loc"`
+		Msg string        `json:"msg"`
+	}
+	if err := json.Unmarshal(payload.Detail, &items); err == nil {
+		fields := make([]FieldError, 0, len(items))
+		for _, item := range items {
+			fields = append(fields, FieldError{Field: validationFieldName(item.Loc), Message: item.Msg})
+		}
+
+		return fields
+	}
+
+	var byField map[string]string
+	if err := json.Unmarshal(payload.Detail, &byField); err == nil {
+		fields := make([]FieldError, 0, len(byField))
+		for field, msg := range byField {
+			fields = append(fields, FieldError{Field: field, Message: msg})
+		}
+		sort.Slice(fields, func(i, j int) bool {
+
+			return fields[i].Field < fields[j].Field
+		})
+
+		return fields
+	}
+
+	return []FieldError{{Message: string(payload.Detail)}}
+}
+
+func validationFieldName(loc []interface{}) string {
+	parts := make([]string, 0, len(loc))
+	for i, part := range loc {
+		if i == 0 && part == "body" {
+			continue
+		}
+		parts = append(parts, fmt.Sprint(part))
+	}
+
+	return strings.Join(parts, ".")
+}
+
+func isUnavailableStatus(statusCode int) bool {
+
+	return statusCode >= http.StatusInternalServerError
+}
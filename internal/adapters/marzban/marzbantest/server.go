@@ -0,0 +1,1024 @@
+package marzbantest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"3xui-bot/internal/core"
+)
+
+const (
+	DefaultUsername = "admin"
+	DefaultPassword = "admin"
+
+	masterNodeName       = "Master"
+	defaultTokenLifetime = time.Hour
+)
+
+type Request struct {
+	Method  string
+	Path    string
+	Pattern string
+	Status  int
+}
+
+type Option func(*Server)
+
+func WithCredentials(username, password string) Option {
+
+	return func(s *Server) {
+		s.username = username
+		s.password = password
+	}
+}
+
+func WithInbounds(inbounds map[string][]core.MarzbanInbound) Option {
+
+	return func(s *Server) {
+		s.inbounds = inbounds
+	}
+}
+
+func WithSpec(spec *Spec) Option {
+
+	return func(s *Server) {
+		s.spec = spec
+	}
+}
+
+func WithTokenLifetime(lifetime time.Duration) Option {
+
+	return func(s *Server) {
+		s.tokenLifetime = lifetime
+	}
+}
+
+type Server struct {
+	URL string
+
+	httpServer    *httptest.Server
+	spec          *Spec
+	username      string
+	password      string
+	tokenLifetime time.Duration
+
+	mu         sync.Mutex
+	users      map[string]*core.MarzbanUserData
+	order      []string
+	usage      map[string]map[string]int64
+	subTokens  map[string]string
+	inbounds   map[string][]core.MarzbanInbound
+	templates  map[int]*core.MarzbanUserTemplate
+	tokens     map[string]time.Time
+	tokenSeq   int
+	latency    time.Duration
+	faults     []*fault
+	requests   []Request
+	unexpected []string
+}
+
+type fault struct {
+	pattern string
+	status  int
+	times   int
+}
+
+type handlerFunc func(w http.ResponseWriter, r *http.Request)
+
+func NewServer(opts ...Option) (*Server, error) {
+	s := &Server{
+		username:      DefaultUsername,
+		password:      DefaultPassword,
+		tokenLifetime: defaultTokenLifetime,
+		users:         make(map[string]*core.MarzbanUserData),
+		usage:         make(map[string]map[string]int64),
+		subTokens:     make(map[string]string),
+		tokens:        make(map[string]time.Time),
+		inbounds:      DefaultInbounds(),
+		templates:     make(map[int]*core.MarzbanUserTemplate),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.spec == nil {
+		path, err := FindSpec()
+		if err != nil {
+
+			return nil, err
+		}
+
+		spec, err := LoadSpec(path)
+		if err != nil {
+
+			return nil, err
+		}
+		s.spec = spec
+	}
+
+	mux, err := s.routes()
+	if err != nil {
+
+		return nil, err
+	}
+
+	s.httpServer = httptest.NewServer(mux)
+	s.URL = s.httpServer.URL
+
+	return s, nil
+}
+
+func DefaultInbounds() map[string][]core.MarzbanInbound {
+
+	return map[string][]core.MarzbanInbound{
+		"vless": {
+			{Tag: "VLESS TCP REALITY", Protocol: "vless", Network: "tcp", TLS: "reality", Port: "443"},
+		},
+		"vmess": {
+			{Tag: "VMess WS", Protocol: "vmess", Network: "ws", TLS: "none", Port: "8080"},
+		},
+	}
+}
+
+func (s *Server) Close() {
+	s.httpServer.Close()
+}
+
+func (s *Server) SetLatency(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latency = latency
+}
+
+func (s *Server) InjectFault(pattern string, status, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = append(s.faults, &fault{pattern: pattern, status: status, times: times})
+}
+
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = nil
+	s.latency = 0
+}
+
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens = make(map[string]time.Time)
+}
+
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests := make([]Request, len(s.requests))
+	copy(requests, s.requests)
+
+	return requests
+}
+
+func (s *Server) RequestCount(pattern string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, request := range s.requests {
+		if request.Pattern == pattern {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (s *Server) UnexpectedRequests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unexpected := make([]string, len(s.unexpected))
+	copy(unexpected, s.unexpected)
+
+	return unexpected
+}
+
+func (s *Server) User(username string) (*core.MarzbanUserData, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[username]
+	if !ok {
+
+		return nil, false
+	}
+
+	return s.view(user), true
+}
+
+func (s *Server) Users() []*core.MarzbanUserData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]*core.MarzbanUserData, 0, len(s.order))
+	for _, username := range s.order {
+		users = append(users, s.view(s.users[username]))
+	}
+
+	return users
+}
+
+func (s *Server) PutUser(user *core.MarzbanUserData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.storeUser(cloneUser(user))
+}
+
+func (s *Server) RemoveUser(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteUser(username)
+}
+
+func (s *Server) PutUserTemplate(template core.MarzbanUserTemplate) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if template.ID == 0 {
+		template.ID = len(s.templates) + 1
+	}
+	s.templates[template.ID] = &template
+
+	return template.ID
+}
+
+func (s *Server) AddUsage(username, nodeName string, traffic int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[username]
+	if !ok {
+
+		return fmt.Errorf("user %s not found", username)
+	}
+
+	if nodeName == "" {
+		nodeName = masterNodeName
+	}
+
+	used := traffic
+	if user.DataUsed != nil {
+		used += *user.DataUsed
+	}
+	user.DataUsed = &used
+
+	lifetime := traffic
+	if user.LifetimeUsedTraffic != nil {
+		lifetime += *user.LifetimeUsedTraffic
+	}
+	user.LifetimeUsedTraffic = &lifetime
+
+	if s.usage[username] == nil {
+		s.usage[username] = make(map[string]int64)
+	}
+	s.usage[username][nodeName] += traffic
+
+	return nil
+}
+
+func (s *Server) routes() (*http.ServeMux, error) {
+	handlers := map[string]handlerFunc{
+		"POST /api/admin/token":                s.handleToken,
+		"GET /api/system":                      s.handleSystem,
+		"GET /api/inbounds":                    s.handleInbounds,
+		"POST /api/user":                       s.handleCreateUser,
+		"GET /api/user/{username}":             s.handleGetUser,
+		"PUT /api/user/{username}":             s.handleModifyUser,
+		"DELETE /api/user/{username}":          s.handleDeleteUser,
+		"POST /api/user/{username}/reset":      s.handleResetUser,
+		"POST /api/user/{username}/revoke_sub": s.handleRevokeSub,
+		"GET /api/user/{username}/usage":       s.handleUserUsage,
+		"GET /api/users":                       s.handleUsers,
+		"GET /api/users/usage":                 s.handleUsersUsage,
+		"GET /api/users/expired":               s.handleExpiredUsers,
+		"DELETE /api/users/expired":            s.handleDeleteExpiredUsers,
+		"GET /api/user_template/{template_id}": s.handleGetUserTemplate,
+	}
+
+	mux := http.NewServeMux()
+	for _, op := range s.spec.Operations() {
+		handler, ok := handlers[op.Pattern()]
+		if !ok {
+			handler = s.handleNotImplemented
+		}
+		delete(handlers, op.Pattern())
+
+		mux.Handle(op.Pattern(), s.wrap(op, handler))
+	}
+
+	if len(handlers) > 0 {
+		missing := make([]string, 0, len(handlers))
+		for pattern := range handlers {
+			missing = append(missing, pattern)
+		}
+		sort.Strings(missing)
+
+		return nil, fmt.Errorf("routes missing from spec: %s", strings.Join(missing, ", "))
+	}
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.unexpected = append(s.unexpected, r.Method+" "+r.URL.Path)
+		s.mu.Unlock()
+
+		writeDetail(w, http.StatusNotFound, "Not Found")
+	})
+
+	return mux, nil
+}
+
+func (s *Server) wrap(op Operation, handler handlerFunc) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			s.mu.Lock()
+			s.requests = append(s.requests, Request{
+				Method:  r.Method,
+				Path:    r.URL.Path,
+				Pattern: op.Pattern(),
+				Status:  recorder.status,
+			})
+			s.mu.Unlock()
+		}()
+
+		if err := s.delay(r); err != nil {
+			recorder.status = 0
+
+			return
+		}
+
+		if status, ok := s.takeFault(op.Pattern()); ok {
+			if status == http.StatusUnauthorized {
+				writeDetail(recorder, status, "Could not validate credentials")
+
+				return
+			}
+			writeDetail(recorder, status, "Injected fault")
+
+			return
+		}
+
+		if op.Secured && !s.authorized(r) {
+			recorder.Header().Set("WWW-Authenticate", "Bearer")
+			writeDetail(recorder, http.StatusUnauthorized, "Could not validate credentials")
+
+			return
+		}
+
+		if op.allowsBody() && len(op.RequiredFields) > 0 {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeDetail(recorder, http.StatusBadRequest, "Failed to read body")
+
+				return
+			}
+
+			if missing := missingFields(body, op.RequiredFields); len(missing) > 0 {
+				writeValidationError(recorder, missing)
+
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		handler(recorder, r)
+	})
+}
+
+func (s *Server) delay(r *http.Request) error {
+	s.mu.Lock()
+	latency := s.latency
+	s.mu.Unlock()
+
+	if latency <= 0 {
+
+		return nil
+	}
+
+	timer := time.NewTimer(latency)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+
+		return nil
+	case <-r.Context().Done():
+
+		return r.Context().Err()
+	}
+}
+
+func (s *Server) takeFault(pattern string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, f := range s.faults {
+		if f.pattern != "" && f.pattern != pattern {
+			continue
+		}
+
+		f.times--
+		if f.times <= 0 {
+			s.faults = append(s.faults[:i], s.faults[i+1:]...)
+		}
+
+		return f.status, true
+	}
+
+	return 0, false
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expiresAt, ok := s.tokens[token]
+
+	return ok && time.Now().Before(expiresAt)
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeDetail(w, http.StatusBadRequest, "Invalid form")
+
+		return
+	}
+
+	if r.PostForm.Get("username") != s.username || r.PostForm.Get("password") != s.password {
+		writeDetail(w, http.StatusUnauthorized, "Incorrect username or password")
+
+		return
+	}
+
+	s.mu.Lock()
+	s.tokenSeq++
+	expiresAt := time.Now().Add(s.tokenLifetime)
+	token := issueToken(s.username, s.tokenSeq, expiresAt)
+	s.tokens[token] = expiresAt
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"access_token": token,
+		"token_type":   "bearer",
+	})
+}
+
+func (s *Server) handleSystem(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := core.MarzbanSystemStats{
+		Version:   "fake",
+		CPUCores:  1,
+		TotalUser: len(s.users),
+	}
+
+	for _, username := range s.order {
+		switch core.MarzbanUserStatus(s.view(s.users[username]).Status) {
+		case core.MarzbanUserStatusActive:
+			stats.UsersActive++
+		case core.MarzbanUserStatusOnHold:
+			stats.UsersOnHold++
+		case core.MarzbanUserStatusDisabled:
+			stats.UsersDisabled++
+		case core.MarzbanUserStatusExpired:
+			stats.UsersExpired++
+		case core.MarzbanUserStatusLimited:
+			stats.UsersLimited++
+		}
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (s *Server) handleInbounds(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, s.inbounds)
+}
+
+func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
+	var user core.MarzbanUserData
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		writeDetail(w, http.StatusBadRequest, "Invalid body")
+
+		return
+	}
+
+	if user.Username == "" {
+		writeValidationError(w, []string{"username"})
+
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.users[user.Username]; exists {
+		writeDetail(w, http.StatusConflict, "User already exists")
+
+		return
+	}
+
+	for protocol := range user.Inbounds {
+		if _, ok := s.inbounds[protocol]; !ok {
+			writeDetail(w, http.StatusBadRequest, fmt.Sprintf("Protocol %s is disabled on your server", protocol))
+
+			return
+		}
+	}
+
+	if user.Status == "" {
+		user.Status = string(core.MarzbanUserStatusActive)
+	}
+	createdAt := time.Now().UTC().Format("2006-01-02T15:04:05")
+	user.CreatedAt = &createdAt
+
+	stored := cloneUser(&user)
+	s.storeUser(stored)
+
+	writeJSON(w, http.StatusOK, s.view(stored))
+}
+
+func (s *Server) handleGetUser(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[r.PathValue("username")]
+	if !ok {
+		writeDetail(w, http.StatusNotFound, "User not found")
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.view(user))
+}
+
+func (s *Server) handleModifyUser(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeDetail(w, http.StatusBadRequest, "Failed to read body")
+
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	username := r.PathValue("username")
+	user, ok := s.users[username]
+	if !ok {
+		writeDetail(w, http.StatusNotFound, "User not found")
+
+		return
+	}
+
+	modified := cloneUser(user)
+	if err := json.Unmarshal(body, modified); err != nil {
+		writeDetail(w, http.StatusBadRequest, "Invalid body")
+
+		return
+	}
+	modified.Username = username
+	modified.DataUsed = user.DataUsed
+	modified.LifetimeUsedTraffic = user.LifetimeUsedTraffic
+	modified.CreatedAt = user.CreatedAt
+
+	s.users[username] = modified
+
+	writeJSON(w, http.StatusOK, s.view(modified))
+}
+
+func (s *Server) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	username := r.PathValue("username")
+	if _, ok := s.users[username]; !ok {
+		writeDetail(w, http.StatusNotFound, "User not found")
+
+		return
+	}
+
+	s.deleteUser(username)
+
+	writeJSON(w, http.StatusOK, map[string]string{})
+}
+
+func (s *Server) handleResetUser(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	username := r.PathValue("username")
+	user, ok := s.users[username]
+	if !ok {
+		writeDetail(w, http.StatusNotFound, "User not found")
+
+		return
+	}
+
+	used := int64(0)
+	user.DataUsed = &used
+	delete(s.usage, username)
+
+	writeJSON(w, http.StatusOK, s.view(user))
+}
+
+func (s *Server) handleRevokeSub(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	username := r.PathValue("username")
+	user, ok := s.users[username]
+	if !ok {
+		writeDetail(w, http.StatusNotFound, "User not found")
+
+		return
+	}
+
+	s.tokenSeq++
+	s.subTokens[username] = subscriptionToken(username, s.tokenSeq)
+
+	writeJSON(w, http.StatusOK, s.view(user))
+}
+
+func (s *Server) handleUserUsage(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	username := r.PathValue("username")
+	if _, ok := s.users[username]; !ok {
+		writeDetail(w, http.StatusNotFound, "User not found")
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"username": username,
+		"usages":   usages(s.usage[username]),
+	})
+}
+
+func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	usernames := query["username"]
+	search := strings.ToLower(query.Get("search"))
+	status := query.Get("status")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matched := make([]*core.MarzbanUserData, 0, len(s.order))
+	for _, username := range s.order {
+		user := s.view(s.users[username])
+		if len(usernames) > 0 && !contains(usernames, username) {
+			continue
+		}
+		if search != "" && !strings.Contains(strings.ToLower(username), search) && !strings.Contains(strings.ToLower(user.Note), search) {
+			continue
+		}
+		if status != "" && user.Status != status {
+			continue
+		}
+		matched = append(matched, user)
+	}
+
+	total := len(matched)
+	if offset > len(matched) {
+		offset = len(matched)
+	}
+	matched = matched[offset:]
+	if limit > 0 && limit < len(matched) {
+		matched = matched[:limit]
+	}
+
+	writeJSON(w, http.StatusOK, core.MarzbanUsersPage{Users: matched, Total: total})
+}
+
+func (s *Server) handleUsersUsage(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := make(map[string]int64)
+	for _, nodes := range s.usage {
+		for node, traffic := range nodes {
+			total[node] += traffic
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"usages": usages(total),
+	})
+}
+
+func (s *Server) handleExpiredUsers(w http.ResponseWriter, r *http.Request) {
+	after, before, ok := expiredRange(w, r)
+	if !ok {
+
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, s.expiredUsernames(after, before))
+}
+
+func (s *Server) handleDeleteExpiredUsers(w http.ResponseWriter, r *http.Request) {
+	after, before, ok := expiredRange(w, r)
+	if !ok {
+
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usernames := s.expiredUsernames(after, before)
+	for _, username := range usernames {
+		s.deleteUser(username)
+	}
+
+	writeJSON(w, http.StatusOK, usernames)
+}
+
+func (s *Server) handleGetUserTemplate(w http.ResponseWriter, r *http.Request) {
+	templateID, err := strconv.Atoi(r.PathValue("template_id"))
+	if err != nil {
+		writeValidationError(w, []string{"template_id"})
+
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	template, ok := s.templates[templateID]
+	if !ok {
+		writeDetail(w, http.StatusNotFound, "User Template not found")
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, template)
+}
+
+func (s *Server) handleNotImplemented(w http.ResponseWriter, r *http.Request) {
+	writeDetail(w, http.StatusNotImplemented, "Not implemented by fake Marzban server")
+}
+
+func (s *Server) storeUser(user *core.MarzbanUserData) {
+	if _, exists := s.users[user.Username]; !exists {
+		s.order = append(s.order, user.Username)
+	}
+	s.users[user.Username] = user
+
+	s.tokenSeq++
+	s.subTokens[user.Username] = subscriptionToken(user.Username, s.tokenSeq)
+}
+
+func (s *Server) deleteUser(username string) {
+	delete(s.users, username)
+	delete(s.usage, username)
+	delete(s.subTokens, username)
+
+	for i, name := range s.order {
+		if name == username {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (s *Server) expiredUsernames(after, before *time.Time) []string {
+	now := time.Now()
+	usernames := make([]string, 0)
+	for _, username := range s.order {
+		expireAt := s.users[username].ExpireAt()
+		if expireAt == nil || expireAt.After(now) {
+			continue
+		}
+		if after != nil && expireAt.Before(*after) {
+			continue
+		}
+		if before != nil && expireAt.After(*before) {
+			continue
+		}
+		usernames = append(usernames, username)
+	}
+
+	return usernames
+}
+
+func (s *Server) view(user *core.MarzbanUserData) *core.MarzbanUserData {
+	view := cloneUser(user)
+
+	if view.DataUsed == nil {
+		used := int64(0)
+		view.DataUsed = &used
+	}
+
+	if view.Status == string(core.MarzbanUserStatusActive) {
+		if view.IsExpired() {
+			view.Status = string(core.MarzbanUserStatusExpired)
+		} else if view.DataLimit != nil && *view.DataLimit > 0 && *view.DataUsed >= *view.DataLimit {
+			view.Status = string(core.MarzbanUserStatusLimited)
+		}
+	}
+
+	view.SubscriptionURL = "/sub/" + s.subTokens[user.Username] + "/"
+	view.Links = s.links(view)
+
+	return view
+}
+
+func (s *Server) links(user *core.MarzbanUserData) []string {
+	protocols := make([]string, 0, len(user.Inbounds))
+	for protocol := range user.Inbounds {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+
+	links := make([]string, 0)
+	for _, protocol := range protocols {
+		for _, tag := range user.Inbounds[protocol] {
+			for _, inbound := range s.inbounds[protocol] {
+				if inbound.Tag != tag {
+					continue
+				}
+				links = append(links, fmt.Sprintf("%s://%s@marzban.test:%s#%s", protocol, user.Username, inbound.Port, tag))
+			}
+		}
+	}
+
+	return links
+}
+
+func expiredRange(w http.ResponseWriter, r *http.Request) (*time.Time, *time.Time, bool) {
+	after, err := parseTimeParam(r.URL.Query().Get("expired_after"))
+	if err != nil {
+		writeValidationError(w, []string{"expired_after"})
+
+		return nil, nil, false
+	}
+
+	before, err := parseTimeParam(r.URL.Query().Get("expired_before"))
+	if err != nil {
+		writeValidationError(w, []string{"expired_before"})
+
+		return nil, nil, false
+	}
+
+	return after, before, true
+}
+
+func parseTimeParam(value string) (*time.Time, error) {
+	if value == "" {
+
+		return nil, nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05"} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+
+			return &parsed, nil
+		}
+	}
+
+	return nil, fmt.Errorf("invalid time %q", value)
+}
+
+func usages(nodes map[string]int64) []core.MarzbanUsage {
+	names := make([]string, 0, len(nodes))
+	for name := range nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]core.MarzbanUsage, 0, len(names))
+	for _, name := range names {
+		result = append(result, core.MarzbanUsage{NodeName: name, UsedTraffic: nodes[name]})
+	}
+
+	return result
+}
+
+func missingFields(body []byte, required []string) []string {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+
+		return required
+	}
+
+	missing := make([]string, 0)
+	for _, field := range required {
+		if _, ok := payload[field]; !ok {
+			missing = append(missing, field)
+		}
+	}
+
+	return missing
+}
+
+func issueToken(username string, seq int, expiresAt time.Time) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	header := encode([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	claims := encode([]byte(fmt.Sprintf(`{"sub":%q,"access":"sudo","seq":%d,"exp":%d}`, username, seq, expiresAt.Unix())))
+
+	return header + "." + claims + "." + encode([]byte("fake-signature"))
+}
+
+func subscriptionToken(username string, seq int) string {
+
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s,%d", username, seq)))
+}
+
+func cloneUser(user *core.MarzbanUserData) *core.MarzbanUserData {
+	data, _ := json.Marshal(user)
+
+	var clone core.MarzbanUserData
+	_ = json.Unmarshal(data, &clone)
+
+	return &clone
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(payload)
+}
+
+func writeDetail(w http.ResponseWriter, status int, detail string) {
+	writeJSON(w, status, map[string]string{"detail": detail})
+}
+
+func writeValidationError(w http.ResponseWriter, fields []string) {
+	detail := make([]map[string]interface{}, 0, len(fields))
+	for _, field := range fields {
+		detail = append(detail, map[string]interface{}{
+			"loc":  []string{"body", field},
+			"msg":  "field required",
+			"type": "value_error.missing",
+		})
+	}
+
+	writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{"detail": detail})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,144 @@
+package marzbantest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const specFileName = "marzban.json"
+
+var ErrSpecNotFound = errors.New("marzban.json not found")
+
+type Spec struct {
+	Paths      map[string]map[string]specOperation `json:"paths"`
+	Components struct {
+		Schemas map[string]specSchema `json:"schemas"`
+	} `json:"components"`
+}
+
+type Operation struct {
+	Method         string
+	Path           string
+	Secured        bool
+	RequiredFields []string
+}
+
+type specOperation struct {
+	OperationID string                `json:"operationId"`
+	Security    []map[string][]string `json:"security"`
+	RequestBody *struct {
+		Content map[string]struct {
+			Schema specSchema `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+}
+
+type specSchema struct {
+	Ref      string   `json:"$ref"`
+	Required []string `json:"required"`
+}
+
+func LoadSpec(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to read spec: %w", err)
+	}
+
+	var spec Spec
+	if err := json.Unmarshal(data, &spec); err != nil {
+
+		return nil, fmt.Errorf("failed to decode spec: %w", err)
+	}
+
+	if len(spec.Paths) == 0 {
+
+		return nil, fmt.Errorf("spec %s has no paths", path)
+	}
+
+	return &spec, nil
+}
+
+func FindSpec() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+
+		return "", fmt.Errorf("failed to get working directory: %w", err)
+	}
+
+	for {
+		path := filepath.Join(dir, specFileName)
+		if _, err := os.Stat(path); err == nil {
+
+			return path, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+
+			return "", ErrSpecNotFound
+		}
+		dir = parent
+	}
+}
+
+func (s *Spec) Operations() []Operation {
+	operations := make([]Operation, 0, len(s.Paths))
+	for path, methods := range s.Paths {
+		for method, op := range methods {
+			operations = append(operations, Operation{
+				Method:         strings.ToUpper(method),
+				Path:           path,
+				Secured:        len(op.Security) > 0,
+				RequiredFields: s.requiredFields(op),
+			})
+		}
+	}
+
+	sort.Slice(operations, func(i, j int) bool {
+		if operations[i].Path != operations[j].Path {
+
+			return operations[i].Path < operations[j].Path
+		}
+
+		return operations[i].Method < operations[j].Method
+	})
+
+	return operations
+}
+
+func (s *Spec) requiredFields(op specOperation) []string {
+	if op.RequestBody == nil {
+
+		return nil
+	}
+
+	content, ok := op.RequestBody.Content["application/json"]
+	if !ok {
+
+		return nil
+	}
+
+	schema := content.Schema
+	if schema.Ref != "" {
+		schema = s.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+
+	return schema.Required
+}
+
+func (o Operation) Pattern() string {
+
+	return o.Method + " " + o.Path
+}
+
+func (o Operation) allowsBody() bool {
+
+	return o.Method == http.MethodPost || o.Method == http.MethodPut || o.Method == http.MethodPatch
+}
@@ -0,0 +1,201 @@
+package marzban
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/ports"
+)
+
+type Panel struct {
+	client ports.Marzban
+	subURL string
+}
+
+func NewPanel(client ports.Marzban, subURL string) *Panel {
+
+	return &Panel{
+		client: client,
+		subURL: strings.TrimRight(subURL, "/"),
+	}
+}
+
+func (p *Panel) Type() core.PanelType {
+
+	return core.PanelTypeMarzban
+}
+
+func (p *Panel) GetInbounds(ctx context.Context) ([]core.PanelInbound, error) {
+	inbounds, err := p.client.GetInbounds(ctx)
+	if err != nil {
+
+		return nil, err
+	}
+
+	protocols := make([]string, 0, len(inbounds))
+	for protocol := range inbounds {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+
+	result := make([]core.PanelInbound, 0, len(inbounds))
+	for _, protocol := range protocols {
+		for _, inbound := range inbounds[protocol] {
+			if inbound.Tag == "" {
+				continue
+			}
+
+			inboundProtocol := inbound.Protocol
+			if inboundProtocol == "" {
+				inboundProtocol = protocol
+			}
+
+			result = append(result, core.PanelInbound{
+				Tag:      inbound.Tag,
+				Protocol: inboundProtocol,
+				Port:     inbound.Port.Int(),
+			})
+		}
+	}
+
+	return result, nil
+}
+
+func (p *Panel) CreateUser(ctx context.Context, user *core.PanelUser) (*core.PanelUser, error) {
+	marzbanUser := toMarzbanUser(user)
+	if user.TemplateID != 0 {
+		template, err := p.client.GetUserTemplate(ctx, user.TemplateID)
+		if err != nil {
+
+			return nil, fmt.Errorf("failed to get Marzban user template %d: %w", user.TemplateID, err)
+		}
+		applyUserTemplate(marzbanUser, template)
+	}
+
+	created, err := p.client.CreateUser(ctx, marzbanUser)
+	if err != nil {
+
+		return nil, err
+	}
+
+	return p.toPanelUser(created), nil
+}
+
+func (p *Panel) GetUser(ctx context.Context, username string) (*core.PanelUser, error) {
+	user, err := p.client.GetUser(ctx, username)
+	if err != nil {
+
+		return nil, err
+	}
+
+	return p.toPanelUser(user), nil
+}
+
+func (p *Panel) ListUsers(ctx context.Context, offset, limit int) (*core.PanelUsersPage, error) {
+	page, err := p.client.GetUsers(ctx, core.MarzbanUsersQuery{Offset: offset, Limit: limit, Sort: "created_at"})
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to list Marzban users: %w", err)
+	}
+
+	users := make([]*core.PanelUser, 0, len(page.Users))
+	for _, user := range page.Users {
+		users = append(users, p.toPanelUser(user))
+	}
+
+	return &core.PanelUsersPage{Users: users, Total: page.Total}, nil
+}
+
+func (p *Panel) UpdateUser(ctx context.Context, username string, user *core.PanelUser) (*core.PanelUser, error) {
+	updated, err := p.client.UpdateUser(ctx, username, toMarzbanUser(user))
+	if err != nil {
+
+		return nil, err
+	}
+
+	return p.toPanelUser(updated), nil
+}
+
+func (p *Panel) DeleteUser(ctx context.Context, username string) error {
+
+	return p.client.DeleteUser(ctx, username)
+}
+
+func (p *Panel) ResetUserTraffic(ctx context.Context, username string) error {
+	if err := p.client.ResetUserTraffic(ctx, username); err != nil {
+
+		return fmt.Errorf("failed to reset Marzban user traffic: %w", err)
+	}
+
+	return nil
+}
+
+func (p *Panel) toPanelUser(user *core.MarzbanUserData) *core.PanelUser {
+
+	return &core.PanelUser{
+		Username:               user.Username,
+		Status:                 core.PanelUserStatus(user.Status),
+		ExpireAt:               user.ExpireAt(),
+		DataLimit:              user.DataLimit,
+		DataUsed:               user.DataUsed,
+		Proxies:                user.Proxies,
+		Inbounds:               user.Inbounds,
+		Note:                   user.Note,
+		SubscriptionURL:        p.subscriptionURL(user.SubscriptionURL),
+		Links:                  user.Links,
+		DataLimitResetStrategy: core.DataLimitResetStrategy(user.DataLimitResetStrategy),
+		CreatedAt:              user.CreatedTime(),
+	}
+}
+
+func (p *Panel) subscriptionURL(url string) string {
+	if url == "" || !strings.HasPrefix(url, "/") || p.subURL == "" {
+
+		return url
+	}
+
+	return p.subURL + url
+}
+
+func toMarzbanUser(user *core.PanelUser) *core.MarzbanUserData {
+	expire := int64(0)
+	if user.ExpireAt != nil {
+		expire = user.ExpireAt.Unix()
+	}
+
+	return &core.MarzbanUserData{
+		Username:               user.Username,
+		Expire:                 &expire,
+		DataLimit:              user.DataLimit,
+		DataUsed:               user.DataUsed,
+		Status:                 string(user.Status),
+		Proxies:                user.Proxies,
+		Inbounds:               user.Inbounds,
+		Note:                   user.Note,
+		SubscriptionURL:        user.SubscriptionURL,
+		Links:                  user.Links,
+		DataLimitResetStrategy: string(user.DataLimitResetStrategy),
+	}
+}
+
+func applyUserTemplate(user *core.MarzbanUserData, template *core.MarzbanUserTemplate) {
+	if template.DataLimit != nil && *template.DataLimit > 0 {
+		dataLimit := *template.DataLimit
+		user.DataLimit = &dataLimit
+	}
+
+	if len(template.Inbounds) == 0 {
+
+		return
+	}
+
+	user.Inbounds = make(map[string][]string, len(template.Inbounds))
+	user.Proxies = make(map[string]interface{}, len(template.Inbounds))
+	for protocol, tags := range template.Inbounds {
+		user.Inbounds[protocol] = append([]string(nil), tags...)
+		user.Proxies[protocol] = map[string]interface{}{}
+	}
+}
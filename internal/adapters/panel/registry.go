@@ -0,0 +1,68 @@
+package panel
+
+import (
+	"fmt"
+
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/ports"
+	"3xui-bot/internal/usecase"
+)
+
+type Registry struct {
+	servers       []core.PanelServer
+	panels        map[string]ports.VPNPanel
+	defaultServer string
+}
+
+func NewRegistry() *Registry {
+
+	return &Registry{
+		panels: make(map[string]ports.VPNPanel),
+	}
+}
+
+func (r *Registry) Register(server core.PanelServer, panel ports.VPNPanel) error {
+	if server.Name == "" {
+
+		return fmt.Errorf("panel server name is required")
+	}
+
+	if _, exists := r.panels[server.Name]; exists {
+
+		return fmt.Errorf("panel server %q is already registered", server.Name)
+	}
+
+	r.servers = append(r.servers, server)
+	r.panels[server.Name] = panel
+	if r.defaultServer == "" {
+		r.defaultServer = server.Name
+	}
+
+	return nil
+}
+
+func (r *Registry) Servers() []core.PanelServer {
+	servers := make([]core.PanelServer, len(r.servers))
+	copy(servers, r.servers)
+
+	return servers
+}
+
+func (r *Registry) DefaultServer() string {
+
+	return r.defaultServer
+}
+
+func (r *Registry) Panel(serverName string) (ports.VPNPanel, error) {
+	if serverName == "" {
+		serverName = r.defaultServer
+	}
+
+	panel, ok := r.panels[serverName]
+	if !ok {
+
+		return nil, fmt.Errorf("%w: %s", usecase.ErrServerNotFound, serverName)
+	}
+
+	return panel, nil
+}
@@ -6,6 +6,7 @@ import (
 
 	"3xui-bot/internal/core"
 	"3xui-bot/internal/pkg/id"
+	"3xui-bot/internal/usecase"
 )
 
 type MockProvider struct{}
@@ -15,7 +16,7 @@ func NewMockProvider() *MockProvider {
 	return &MockProvider{}
 }
 
-func (m *MockProvider) CreatePayment(ctx context.Context, amount float64, currency, description string) (string, string, error) {
+func (m *MockProvider) CreatePayment(ctx context.Context, dto usecase.CreateProviderPaymentDTO) (string, string, error) {
 	mockPaymentID := id.GenerateWithPrefix("mock_payment")
 	mockURL := fmt.Sprintf("https://mock-payment.example.com/pay/%s", mockPaymentID)
 
@@ -26,3 +27,43 @@ func (m *MockProvider) CheckPaymentStatus(ctx context.Context, paymentID string)
 
 	return string(core.PaymentStatusCompleted), nil
 }
+
+func (m *MockProvider) CapturePayment(ctx context.Context, paymentID string) (string, error) {
+
+	return string(core.PaymentStatusCompleted), nil
+}
+
+func (m *MockProvider) CancelPayment(ctx context.Context, paymentID string) (string, error) {
+
+	return string(core.PaymentStatusCancelled), nil
+}
+
+func (m *MockProvider) ChargeSavedMethod(ctx context.Context, dto usecase.CreateProviderPaymentDTO) (string, string, error) {
+
+	return id.GenerateWithPrefix("mock_payment"), string(core.PaymentStatusCompleted), nil
+}
+
+func (m *MockProvider) GetPaymentMethod(ctx context.Context, paymentID string) (*usecase.ProviderPaymentMethodDTO, error) {
+
+	return &usecase.ProviderPaymentMethodDTO{
+		ID:    id.GenerateWithPrefix("mock_method"),
+		Type:  "bank_card",
+		Title: "Bank card *4444",
+		Saved: true,
+	}, nil
+}
+
+func (m *MockProvider) SendReceipt(ctx context.Context, externalID string, receipt usecase.ProviderReceiptDTO) (string, error) {
+
+	return string(core.ReceiptStatusSucceeded), nil
+}
+
+func (m *MockProvider) GetReceiptStatus(ctx context.Context, paymentID string) (string, error) {
+
+	return string(core.ReceiptStatusSucceeded), nil
+}
+
+func (m *MockProvider) Refund(ctx context.Context, externalID string, amount float64, idempotencyKey string) (string, error) {
+
+	return id.GenerateWithPrefix("mock_refund"), nil
+}
@@ -0,0 +1,45 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type TelegramStars struct {
+	bot *tgbotapi.BotAPI
+}
+
+func NewTelegramStars(bot *tgbotapi.BotAPI) *TelegramStars {
+
+	return &TelegramStars{
+		bot: bot,
+	}
+}
+
+func (t *TelegramStars) RefundStarPayment(ctx context.Context, userID int64, chargeID string) error {
+	params := tgbotapi.Params{
+		"user_id":                    strconv.FormatInt(userID, 10),
+		"telegram_payment_charge_id": chargeID,
+	}
+
+	resp, err := t.bot.MakeRequest("refundStarPayment", params)
+	if err != nil && strings.Contains(err.Error(), "CHARGE_ALREADY_REFUNDED") {
+
+		return nil
+	}
+	if err != nil {
+
+		return fmt.Errorf("failed to refund star payment: %w", err)
+	}
+
+	if !resp.Ok {
+
+		return fmt.Errorf("refundStarPayment failed: %s", resp.Description)
+	}
+
+	return nil
+}
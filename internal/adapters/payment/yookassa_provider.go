@@ -0,0 +1,475 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/pkg/id"
+	"3xui-bot/internal/usecase"
+)
+
+const yooKassaMaxDescriptionLength = 128
+
+type yooKassaAmount struct {
+	Value    string `json:"value"`
+	Currency string `json:"currency"`
+}
+
+type yooKassaConfirmation struct {
+	Type            string `json:"type"`
+	ReturnURL       string `json:"return_url,omitempty"`
+	ConfirmationURL string `json:"confirmation_url,omitempty"`
+}
+
+type yooKassaPaymentMethodData struct {
+	Type string `json:"type"`
+}
+
+type yooKassaPaymentMethod struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Saved bool   `json:"saved"`
+	Title string `json:"title,omitempty"`
+}
+
+type yooKassaCustomer struct {
+	Email string `json:"email,omitempty"`
+}
+
+type yooKassaReceiptItem struct {
+	Description    string         `json:"description"`
+	Quantity       string         `json:"quantity"`
+	Amount         yooKassaAmount `json:"amount"`
+	VATCode        int            `json:"vat_code"`
+	PaymentSubject string         `json:"payment_subject,omitempty"`
+	PaymentMode    string         `json:"payment_mode,omitempty"`
+}
+
+type yooKassaReceipt struct {
+	Customer      yooKassaCustomer      `json:"customer"`
+	Items         []yooKassaReceiptItem `json:"items"`
+	TaxSystemCode int                   `json:"tax_system_code,omitempty"`
+}
+
+type yooKassaSettlement struct {
+	Type   string         `json:"type"`
+	Amount yooKassaAmount `json:"amount"`
+}
+
+type yooKassaReceiptRequest struct {
+	Type          string                `json:"type"`
+	PaymentID     string                `json:"payment_id"`
+	Customer      yooKassaCustomer      `json:"customer"`
+	Items         []yooKassaReceiptItem `json:"items"`
+	Send          bool                  `json:"send"`
+	TaxSystemCode int                   `json:"tax_system_code,omitempty"`
+	Settlements   []yooKassaSettlement  `json:"settlements"`
+}
+
+type yooKassaReceiptResponse struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	PaymentID string `json:"payment_id"`
+	Status    string `json:"status"`
+}
+
+type yooKassaPaymentRequest struct {
+	Amount            yooKassaAmount             `json:"amount"`
+	Capture           bool                       `json:"capture"`
+	Confirmation      *yooKassaConfirmation      `json:"confirmation,omitempty"`
+	PaymentMethodData *yooKassaPaymentMethodData `json:"payment_method_data,omitempty"`
+	PaymentMethodID   string                     `json:"payment_method_id,omitempty"`
+	SavePaymentMethod bool                       `json:"save_payment_method,omitempty"`
+	Description       string                     `json:"description,omitempty"`
+	Metadata          map[string]string          `json:"metadata,omitempty"`
+	Receipt           *yooKassaReceipt           `json:"receipt,omitempty"`
+}
+
+type yooKassaPayment struct {
+	ID            string                 `json:"id"`
+	Status        string                 `json:"status"`
+	Paid          bool                   `json:"paid"`
+	Amount        yooKassaAmount         `json:"amount"`
+	Confirmation  *yooKassaConfirmation  `json:"confirmation,omitempty"`
+	PaymentMethod *yooKassaPaymentMethod `json:"payment_method,omitempty"`
+	Description   string                 `json:"description,omitempty"`
+	Metadata      map[string]string      `json:"metadata,omitempty"`
+	ReceiptStatus string                 `json:"receipt_registration,omitempty"`
+	CreatedAt     string                 `json:"created_at"`
+}
+
+type yooKassaRefundRequest struct {
+	PaymentID   string         `json:"payment_id"`
+	Amount      yooKassaAmount `json:"amount"`
+	Description string         `json:"description,omitempty"`
+}
+
+type yooKassaRefund struct {
+	ID        string         `json:"id"`
+	PaymentID string         `json:"payment_id"`
+	Status    string         `json:"status"`
+	Amount    yooKassaAmount `json:"amount"`
+	CreatedAt string         `json:"created_at"`
+}
+
+type yooKassaError struct {
+	Type        string `json:"type"`
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+type YooKassaProvider struct {
+	apiURL     string
+	shopID     string
+	secretKey  string
+	returnURL  string
+	httpClient *http.Client
+}
+
+func NewYooKassaProvider(apiURL, shopID, secretKey, returnURL string) *YooKassaProvider {
+
+	return &YooKassaProvider{
+		apiURL:    apiURL,
+		shopID:    shopID,
+		secretKey: secretKey,
+		returnURL: returnURL,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+func (p *YooKassaProvider) CreatePayment(ctx context.Context, dto usecase.CreateProviderPaymentDTO) (string, string, error) {
+	request := yooKassaPaymentRequest{
+		Amount: yooKassaAmount{
+			Value:    formatYooKassaAmount(dto.Amount),
+			Currency: dto.Currency,
+		},
+		Capture: !yooKassaSupportsTwoStage(dto.PaymentMethod),
+		Confirmation: &yooKassaConfirmation{
+			Type:      "redirect",
+			ReturnURL: p.returnURL,
+		},
+		SavePaymentMethod: dto.SavePaymentMethod,
+		Description:       truncateYooKassaDescription(dto.Description),
+		Metadata:          dto.Metadata,
+		Receipt:           newYooKassaReceipt(dto.Receipt),
+	}
+
+	if methodType := yooKassaMethodType(dto.PaymentMethod); methodType != "" {
+		request.PaymentMethodData = &yooKassaPaymentMethodData{Type: methodType}
+	}
+
+	idempotencyKey := dto.IdempotencyKey
+	if idempotencyKey == "" {
+		idempotencyKey = id.Generate()
+	}
+
+	var payment yooKassaPayment
+	if err := p.doRequest(ctx, http.MethodPost, "/payments", idempotencyKey, request, &payment); err != nil {
+
+		return "", "", fmt.Errorf("failed to create yookassa payment: %w", err)
+	}
+
+	if payment.Confirmation == nil || payment.Confirmation.ConfirmationURL == "" {
+
+		return "", "", fmt.Errorf("yookassa payment %s has no confirmation URL", payment.ID)
+	}
+
+	return payment.Confirmation.ConfirmationURL, payment.ID, nil
+}
+
+func (p *YooKassaProvider) ChargeSavedMethod(ctx context.Context, dto usecase.CreateProviderPaymentDTO) (string, string, error) {
+	if dto.SavedMethodID == "" {
+
+		return "", "", fmt.Errorf("saved payment method ID is required")
+	}
+
+	request := yooKassaPaymentRequest{
+		Amount: yooKassaAmount{
+			Value:    formatYooKassaAmount(dto.Amount),
+			Currency: dto.Currency,
+		},
+		Capture:         true,
+		PaymentMethodID: dto.SavedMethodID,
+		Description:     truncateYooKassaDescription(dto.Description),
+		Metadata:        dto.Metadata,
+		Receipt:         newYooKassaReceipt(dto.Receipt),
+	}
+
+	idempotencyKey := dto.IdempotencyKey
+	if idempotencyKey == "" {
+		idempotencyKey = id.Generate()
+	}
+
+	var payment yooKassaPayment
+	if err := p.doRequest(ctx, http.MethodPost, "/payments", idempotencyKey, request, &payment); err != nil {
+
+		return "", "", fmt.Errorf("failed to charge saved yookassa payment method: %w", err)
+	}
+
+	return payment.ID, mapYooKassaStatus(payment.Status), nil
+}
+
+func (p *YooKassaProvider) GetPaymentMethod(ctx context.Context, paymentID string) (*usecase.ProviderPaymentMethodDTO, error) {
+	var payment yooKassaPayment
+	if err := p.doRequest(ctx, http.MethodGet, "/payments/"+paymentID, "", nil, &payment); err != nil {
+
+		return nil, fmt.Errorf("failed to get yookassa payment: %w", err)
+	}
+
+	if payment.PaymentMethod == nil {
+
+		return nil, nil
+	}
+
+	return &usecase.ProviderPaymentMethodDTO{
+		ID:    payment.PaymentMethod.ID,
+		Type:  payment.PaymentMethod.Type,
+		Title: payment.PaymentMethod.Title,
+		Saved: payment.PaymentMethod.Saved,
+	}, nil
+}
+
+func (p *YooKassaProvider) CheckPaymentStatus(ctx context.Context, paymentID string) (string, error) {
+	var payment yooKassaPayment
+	if err := p.doRequest(ctx, http.MethodGet, "/payments/"+paymentID, "", nil, &payment); err != nil {
+
+		return "", fmt.Errorf("failed to get yookassa payment: %w", err)
+	}
+
+	return mapYooKassaStatus(payment.Status), nil
+}
+
+func (p *YooKassaProvider) CapturePayment(ctx context.Context, paymentID string) (string, error) {
+	var payment yooKassaPayment
+	if err := p.doRequest(ctx, http.MethodPost, "/payments/"+paymentID+"/capture", "capture-"+paymentID, struct{}{}, &payment); err != nil {
+
+		return "", fmt.Errorf("failed to capture yookassa payment: %w", err)
+	}
+
+	return mapYooKassaStatus(payment.Status), nil
+}
+
+func (p *YooKassaProvider) CancelPayment(ctx context.Context, paymentID string) (string, error) {
+	var payment yooKassaPayment
+	if err := p.doRequest(ctx, http.MethodPost, "/payments/"+paymentID+"/cancel", "cancel-"+paymentID, struct{}{}, &payment); err != nil {
+
+		return "", fmt.Errorf("failed to cancel yookassa payment: %w", err)
+	}
+
+	return mapYooKassaStatus(payment.Status), nil
+}
+
+func (p *YooKassaProvider) Refund(ctx context.Context, externalID string, amount float64, idempotencyKey string) (string, error) {
+	request := yooKassaRefundRequest{
+		PaymentID: externalID,
+		Amount: yooKassaAmount{
+			Value:    formatYooKassaAmount(amount),
+			Currency: core.CurrencyRUB,
+		},
+	}
+
+	if idempotencyKey == "" {
+		idempotencyKey = id.Generate()
+	}
+
+	var refund yooKassaRefund
+	if err := p.doRequest(ctx, http.MethodPost, "/refunds", idempotencyKey, request, &refund); err != nil {
+
+		return "", fmt.Errorf("failed to create yookassa refund: %w", err)
+	}
+
+	if refund.Status == "canceled" {
+
+		return "", fmt.Errorf("yookassa refund %s was canceled", refund.ID)
+	}
+
+	return refund.ID, nil
+}
+
+func (p *YooKassaProvider) SendReceipt(ctx context.Context, externalID string, receipt usecase.ProviderReceiptDTO) (string, error) {
+	converted := newYooKassaReceipt(&receipt)
+
+	var total float64
+	for _, item := range receipt.Items {
+		total += item.Amount * item.Quantity
+	}
+
+	request := yooKassaReceiptRequest{
+		Type:          "payment",
+		PaymentID:     externalID,
+		Customer:      converted.Customer,
+		Items:         converted.Items,
+		Send:          true,
+		TaxSystemCode: converted.TaxSystemCode,
+		Settlements: []yooKassaSettlement{
+			{
+				Type: "cashless",
+				Amount: yooKassaAmount{
+					Value:    formatYooKassaAmount(total),
+					Currency: core.CurrencyRUB,
+				},
+			},
+		},
+	}
+
+	var response yooKassaReceiptResponse
+	if err := p.doRequest(ctx, http.MethodPost, "/receipts", id.Generate(), request, &response); err != nil {
+
+		return "", fmt.Errorf("failed to create yookassa receipt: %w", err)
+	}
+
+	return response.Status, nil
+}
+
+func (p *YooKassaProvider) GetReceiptStatus(ctx context.Context, paymentID string) (string, error) {
+	var payment yooKassaPayment
+	if err := p.doRequest(ctx, http.MethodGet, "/payments/"+paymentID, "", nil, &payment); err != nil {
+
+		return "", fmt.Errorf("failed to get yookassa payment: %w", err)
+	}
+
+	return payment.ReceiptStatus, nil
+}
+
+func (p *YooKassaProvider) doRequest(ctx context.Context, method, endpoint, idempotencyKey string, body interface{}, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+
+			return fmt.Errorf("failed to marshal request body: %w", err)
+		}
+		reqBody = bytes.NewBuffer(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.apiURL+endpoint, reqBody)
+	if err != nil {
+
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.SetBasicAuth(p.shopID, p.secretKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotence-Key", idempotencyKey)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr yooKassaError
+		if err := json.Unmarshal(respBody, &apiErr); err == nil && apiErr.Description != "" {
+
+			return fmt.Errorf("yookassa error %s (status %d): %s", apiErr.Code, resp.StatusCode, apiErr.Description)
+		}
+
+		return fmt.Errorf("yookassa request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	if err := json.Unmarshal(respBody, result); err != nil {
+
+		return fmt.Errorf("failed to decode response: %w, body: %s", err, string(respBody))
+	}
+
+	return nil
+}
+
+func mapYooKassaStatus(status string) string {
+	switch status {
+	case "pending":
+
+		return string(core.PaymentStatusPending)
+	case "waiting_for_capture":
+
+		return string(core.PaymentStatusWaitingForCapture)
+	case "succeeded":
+
+		return string(core.PaymentStatusCompleted)
+	case "canceled":
+
+		return string(core.PaymentStatusCancelled)
+	default:
+
+		return status
+	}
+}
+
+func yooKassaMethodType(method core.PaymentMethod) string {
+	switch method {
+	case core.PaymentMethodCard:
+
+		return "bank_card"
+	case core.PaymentMethodSBP:
+
+		return "sbp"
+	default:
+
+		return ""
+	}
+}
+
+func yooKassaSupportsTwoStage(method core.PaymentMethod) bool {
+
+	return method != core.PaymentMethodSBP
+}
+
+func newYooKassaReceipt(receipt *usecase.ProviderReceiptDTO) *yooKassaReceipt {
+	if receipt == nil {
+
+		return nil
+	}
+
+	items := make([]yooKassaReceiptItem, 0, len(receipt.Items))
+	for _, item := range receipt.Items {
+		items = append(items, yooKassaReceiptItem{
+			Description: truncateYooKassaDescription(item.Description),
+			Quantity:    strconv.FormatFloat(item.Quantity, 'f', 2, 64),
+			Amount: yooKassaAmount{
+				Value:    formatYooKassaAmount(item.Amount),
+				Currency: item.Currency,
+			},
+			VATCode:        item.VATCode,
+			PaymentSubject: item.PaymentSubject,
+			PaymentMode:    item.PaymentMode,
+		})
+	}
+
+	return &yooKassaReceipt{
+		Customer:      yooKassaCustomer{Email: receipt.CustomerEmail},
+		Items:         items,
+		TaxSystemCode: receipt.TaxSystemCode,
+	}
+}
+
+func formatYooKassaAmount(amount float64) string {
+
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
+
+func truncateYooKassaDescription(description string) string {
+	runes := []rune(description)
+	if len(runes) <= yooKassaMaxDescriptionLength {
+
+		return description
+	}
+
+	return string(runes[:yooKassaMaxDescriptionLength])
+}
@@ -0,0 +1,384 @@
+package payment_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"3xui-bot/internal/adapters/payment"
+	"3xui-bot/internal/adapters/webhook"
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/usecase"
+)
+
+const (
+	testShopID        = "shop-1"
+	testSecretKey     = "secret-key"
+	testReturnURL     = "https://t.me/test_bot"
+	testWebhookSecret = "webhook-secret"
+)
+
+type recordedRequest struct {
+	Method         string
+	Path           string
+	IdempotencyKey string
+	Body           map[string]interface{}
+}
+
+type yooKassaFixture struct {
+	server   *httptest.Server
+	provider *payment.YooKassaProvider
+
+	mu       sync.Mutex
+	requests []recordedRequest
+}
+
+func newYooKassaFixture(t *testing.T, webhookURL string) *yooKassaFixture {
+	t.Helper()
+
+	f := &yooKassaFixture{}
+	f.server = httptest.NewUnstartedServer(nil)
+	stub := payment.NewYooKassaStub("http://"+f.server.Listener.Addr().String(), testShopID, testSecretKey, webhookURL, testWebhookSecret)
+	f.server.Config.Handler = f.record(stub.Handler())
+	f.server.Start()
+	t.Cleanup(f.server.Close)
+
+	f.provider = payment.NewYooKassaProvider(f.server.URL+"/v3", testShopID, testSecretKey, testReturnURL)
+
+	return f
+}
+
+func (f *yooKassaFixture) record(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		request := recordedRequest{Method: r.Method, Path: r.URL.Path, IdempotencyKey: r.Header.Get("Idempotence-Key")}
+		_ = json.Unmarshal(body, &request.Body)
+
+		f.mu.Lock()
+		f.requests = append(f.requests, request)
+		f.mu.Unlock()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (f *yooKassaFixture) lastRequest(t *testing.T, method, path string) recordedRequest {
+	t.Helper()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := len(f.requests) - 1; i >= 0; i-- {
+		if f.requests[i].Method == method && f.requests[i].Path == path {
+
+			return f.requests[i]
+		}
+	}
+	t.Fatalf("no %s %s request recorded", method, path)
+
+	return recordedRequest{}
+}
+
+func (f *yooKassaFixture) checkout(t *testing.T, confirmationURL, action string) {
+	t.Helper()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Post(confirmationURL+"/"+action, "", nil)
+	if err != nil {
+		t.Fatalf("failed to %s checkout: %v", action, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != testReturnURL {
+		t.Fatalf("expected redirect to %s, got %d %s", testReturnURL, resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
+
+func (f *yooKassaFixture) createPayment(t *testing.T, method core.PaymentMethod, key string) (string, string) {
+	t.Helper()
+
+	confirmationURL, externalID, err := f.provider.CreatePayment(context.Background(), usecase.CreateProviderPaymentDTO{
+		Amount:            199,
+		Currency:          core.CurrencyRUB,
+		Description:       "Подписка на месяц",
+		PaymentMethod:     method,
+		IdempotencyKey:    key,
+		Metadata:          map[string]string{"payment_id": "pay-" + key},
+		SavePaymentMethod: method == core.PaymentMethodCard,
+	})
+	if err != nil {
+		t.Fatalf("CreatePayment returned error: %v", err)
+	}
+
+	return confirmationURL, externalID
+}
+
+func (f *yooKassaFixture) status(t *testing.T, externalID string) string {
+	t.Helper()
+
+	status, err := f.provider.CheckPaymentStatus(context.Background(), externalID)
+	if err != nil {
+		t.Fatalf("CheckPaymentStatus returned error: %v", err)
+	}
+
+	return status
+}
+
+func TestYooKassaCardPaymentIsCapturedAfterConfirmation(t *testing.T) {
+	f := newYooKassaFixture(t, "")
+	ctx := context.Background()
+
+	confirmationURL, externalID := f.createPayment(t, core.PaymentMethodCard, "key-1")
+	if !strings.HasPrefix(confirmationURL, f.server.URL+"/checkout/") {
+		t.Errorf("unexpected confirmation URL %s", confirmationURL)
+	}
+
+	request := f.lastRequest(t, http.MethodPost, "/v3/payments")
+	if request.Body["capture"] != false || request.IdempotencyKey != "key-1" {
+		t.Errorf("expected two-stage card payment with idempotency key, got capture=%v key=%q", request.Body["capture"], request.IdempotencyKey)
+	}
+	if method, _ := request.Body["payment_method_data"].(map[string]interface{}); method["type"] != "bank_card" {
+		t.Errorf("expected bank_card payment method, got %v", request.Body["payment_method_data"])
+	}
+	if status := f.status(t, externalID); status != string(core.PaymentStatusPending) {
+		t.Fatalf("expected pending payment, got %s", status)
+	}
+
+	f.checkout(t, confirmationURL, "pay")
+	if status := f.status(t, externalID); status != string(core.PaymentStatusWaitingForCapture) {
+		t.Fatalf("expected payment waiting for capture, got %s", status)
+	}
+
+	status, err := f.provider.CapturePayment(ctx, externalID)
+	if err != nil {
+		t.Fatalf("CapturePayment returned error: %v", err)
+	}
+	if status != string(core.PaymentStatusCompleted) {
+		t.Errorf("expected completed payment after capture, got %s", status)
+	}
+	if key := f.lastRequest(t, http.MethodPost, "/v3/payments/"+externalID+"/capture").IdempotencyKey; key != "capture-"+externalID {
+		t.Errorf("expected capture idempotency key, got %q", key)
+	}
+
+	if status, err := f.provider.CapturePayment(ctx, externalID); err != nil || status != string(core.PaymentStatusCompleted) {
+		t.Errorf("expected repeated capture to be a no-op, got %s, %v", status, err)
+	}
+
+	method, err := f.provider.GetPaymentMethod(ctx, externalID)
+	if err != nil {
+		t.Fatalf("GetPaymentMethod returned error: %v", err)
+	}
+	if method == nil || method.ID == "" || !method.Saved || method.Type != "bank_card" {
+		t.Errorf("expected saved bank card, got %+v", method)
+	}
+}
+
+func TestYooKassaSBPPaymentIsSingleStage(t *testing.T) {
+	f := newYooKassaFixture(t, "")
+
+	confirmationURL, externalID := f.createPayment(t, core.PaymentMethodSBP, "key-sbp")
+
+	request := f.lastRequest(t, http.MethodPost, "/v3/payments")
+	if request.Body["capture"] != true {
+		t.Errorf("expected SBP payment to be captured immediately, got capture=%v", request.Body["capture"])
+	}
+	if method, _ := request.Body["payment_method_data"].(map[string]interface{}); method["type"] != "sbp" {
+		t.Errorf("expected sbp payment method, got %v", request.Body["payment_method_data"])
+	}
+
+	f.checkout(t, confirmationURL, "pay")
+	if status := f.status(t, externalID); status != string(core.PaymentStatusCompleted) {
+		t.Errorf("expected SBP payment to complete without capture, got %s", status)
+	}
+}
+
+func TestYooKassaCancelPayment(t *testing.T) {
+	f := newYooKassaFixture(t, "")
+	ctx := context.Background()
+
+	confirmationURL, externalID := f.createPayment(t, core.PaymentMethodCard, "key-1")
+	if _, err := f.provider.CancelPayment(ctx, externalID); err == nil {
+		t.Error("expected pending payment to be impossible to cancel")
+	}
+
+	f.checkout(t, confirmationURL, "pay")
+	status, err := f.provider.CancelPayment(ctx, externalID)
+	if err != nil {
+		t.Fatalf("CancelPayment returned error: %v", err)
+	}
+	if status != string(core.PaymentStatusCancelled) {
+		t.Errorf("expected cancelled payment, got %s", status)
+	}
+	if key := f.lastRequest(t, http.MethodPost, "/v3/payments/"+externalID+"/cancel").IdempotencyKey; key != "cancel-"+externalID {
+		t.Errorf("expected cancel idempotency key, got %q", key)
+	}
+
+	if _, err := f.provider.CapturePayment(ctx, externalID); err == nil {
+		t.Error("expected cancelled payment to be impossible to capture")
+	}
+}
+
+func TestYooKassaDeclinedCheckoutCancelsPayment(t *testing.T) {
+	f := newYooKassaFixture(t, "")
+
+	confirmationURL, externalID := f.createPayment(t, core.PaymentMethodSBP, "key-1")
+	f.checkout(t, confirmationURL, "decline")
+
+	if status := f.status(t, externalID); status != string(core.PaymentStatusCancelled) {
+		t.Errorf("expected declined payment to be cancelled, got %s", status)
+	}
+}
+
+func TestYooKassaCreatePaymentIsIdempotent(t *testing.T) {
+	f := newYooKassaFixture(t, "")
+
+	firstURL, firstID := f.createPayment(t, core.PaymentMethodCard, "key-1")
+	retryURL, retryID := f.createPayment(t, core.PaymentMethodCard, "key-1")
+	if retryID != firstID || retryURL != firstURL {
+		t.Errorf("expected retry with the same key to return payment %s, got %s", firstID, retryID)
+	}
+
+	_, otherID := f.createPayment(t, core.PaymentMethodCard, "key-2")
+	if otherID == firstID {
+		t.Error("expected a new key to create a new payment")
+	}
+
+	_, _, err := f.provider.CreatePayment(context.Background(), usecase.CreateProviderPaymentDTO{Amount: 199, Currency: core.CurrencyRUB})
+	if err != nil {
+		t.Fatalf("CreatePayment without key returned error: %v", err)
+	}
+	if key := f.lastRequest(t, http.MethodPost, "/v3/payments").IdempotencyKey; key == "" {
+		t.Error("expected a generated idempotency key")
+	}
+}
+
+func TestYooKassaRefund(t *testing.T) {
+	f := newYooKassaFixture(t, "")
+	ctx := context.Background()
+
+	confirmationURL, externalID := f.createPayment(t, core.PaymentMethodSBP, "key-1")
+	if _, err := f.provider.Refund(ctx, externalID, 50, "refund-1"); err == nil {
+		t.Error("expected unpaid payment to be impossible to refund")
+	}
+	f.checkout(t, confirmationURL, "pay")
+
+	refundID, err := f.provider.Refund(ctx, externalID, 150, "refund-1")
+	if err != nil {
+		t.Fatalf("Refund returned error: %v", err)
+	}
+	request := f.lastRequest(t, http.MethodPost, "/v3/refunds")
+	amount, _ := request.Body["amount"].(map[string]interface{})
+	if request.IdempotencyKey != "refund-1" || request.Body["payment_id"] != externalID || amount["value"] != "150.00" || amount["currency"] != core.CurrencyRUB {
+		t.Errorf("unexpected refund request: %+v", request)
+	}
+
+	retryID, err := f.provider.Refund(ctx, externalID, 150, "refund-1")
+	if err != nil || retryID != refundID {
+		t.Errorf("expected retried refund to return %s, got %s, %v", refundID, retryID, err)
+	}
+
+	if _, err := f.provider.Refund(ctx, externalID, 100, "refund-2"); err == nil {
+		t.Error("expected refund above the remaining amount to fail")
+	}
+	if _, err := f.provider.Refund(ctx, externalID, 49, "refund-3"); err != nil {
+		t.Errorf("expected refund of the remaining amount to succeed, got %v", err)
+	}
+}
+
+func TestYooKassaChargeSavedMethod(t *testing.T) {
+	f := newYooKassaFixture(t, "")
+	ctx := context.Background()
+
+	confirmationURL, externalID := f.createPayment(t, core.PaymentMethodCard, "key-1")
+	f.checkout(t, confirmationURL, "pay")
+	method, err := f.provider.GetPaymentMethod(ctx, externalID)
+	if err != nil || method == nil {
+		t.Fatalf("failed to get saved method: %v", err)
+	}
+
+	dto := usecase.CreateProviderPaymentDTO{Amount: 199, Currency: core.CurrencyRUB, IdempotencyKey: "renew-1", SavedMethodID: method.ID}
+	chargeID, status, err := f.provider.ChargeSavedMethod(ctx, dto)
+	if err != nil {
+		t.Fatalf("ChargeSavedMethod returned error: %v", err)
+	}
+	if status != string(core.PaymentStatusCompleted) {
+		t.Errorf("expected recurring charge to complete, got %s", status)
+	}
+	if request := f.lastRequest(t, http.MethodPost, "/v3/payments"); request.Body["payment_method_id"] != method.ID || request.Body["capture"] != true {
+		t.Errorf("unexpected recurring charge request: %+v", request.Body)
+	}
+
+	retryID, _, err := f.provider.ChargeSavedMethod(ctx, dto)
+	if err != nil || retryID != chargeID {
+		t.Errorf("expected retried charge to return %s, got %s, %v", chargeID, retryID, err)
+	}
+
+	dto.SavedMethodID = "unknown"
+	dto.IdempotencyKey = "renew-2"
+	if _, _, err := f.provider.ChargeSavedMethod(ctx, dto); err == nil {
+		t.Error("expected charge with unknown method to fail")
+	}
+}
+
+func TestYooKassaRejectsWrongCredentials(t *testing.T) {
+	f := newYooKassaFixture(t, "")
+	provider := payment.NewYooKassaProvider(f.server.URL+"/v3", testShopID, "wrong", testReturnURL)
+
+	_, _, err := provider.CreatePayment(context.Background(), usecase.CreateProviderPaymentDTO{Amount: 199, Currency: core.CurrencyRUB})
+	if err == nil || !strings.Contains(err.Error(), "invalid_credentials") {
+		t.Errorf("expected invalid_credentials error, got %v", err)
+	}
+}
+
+func TestYooKassaStubSendsSignedNotification(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(webhook.SignatureHeader) != webhook.Sign(testWebhookSecret, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+		bodies <- body
+	}))
+	defer receiver.Close()
+
+	f := newYooKassaFixture(t, receiver.URL)
+	confirmationURL, externalID := f.createPayment(t, core.PaymentMethodSBP, "key-1")
+	f.checkout(t, confirmationURL, "pay")
+
+	select {
+	case body := <-bodies:
+		var notification struct {
+			Event  string `json:"event"`
+			Object struct {
+				ID       string            `json:"id"`
+				Status   string            `json:"status"`
+				Metadata map[string]string `json:"metadata"`
+			} `json:"object"`
+		}
+		if err := json.Unmarshal(body, &notification); err != nil {
+			t.Fatalf("failed to decode notification: %v", err)
+		}
+		if notification.Event != "payment.succeeded" || notification.Object.ID != externalID || notification.Object.Metadata["payment_id"] != "pay-key-1" {
+			t.Errorf("unexpected notification: %s", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification was not delivered")
+	}
+}
@@ -0,0 +1,562 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"3xui-bot/internal/adapters/webhook"
+	"3xui-bot/internal/pkg/id"
+)
+
+var yooKassaStubCheckoutPage = template.Must(template.New("checkout").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Оплата {{.ID}}</title></head>
+<body>
+<h2>Тестовая оплата</h2>
+<p>{{.Description}}</p>
+<p>Сумма: {{.Amount.Value}} {{.Amount.Currency}}</p>
+<p>Статус: {{.Status}}</p>
+{{if eq .Status "pending"}}
+<form method="post" action="/checkout/{{.ID}}/pay"><button type="submit">Оплатить</button></form>
+<form method="post" action="/checkout/{{.ID}}/decline"><button type="submit">Отказаться</button></form>
+{{end}}
+</body>
+</html>`))
+
+type yooKassaStubPayment struct {
+	yooKassaPayment
+	capture           bool
+	savePaymentMethod bool
+	refunded          float64
+}
+
+func (p *yooKassaStubPayment) succeed() {
+	p.Status = "succeeded"
+	p.Paid = true
+	if p.ReceiptStatus == "pending" {
+		p.ReceiptStatus = "succeeded"
+	}
+}
+
+type yooKassaNotification struct {
+	Type   string          `json:"type"`
+	Event  string          `json:"event"`
+	Object yooKassaPayment `json:"object"`
+}
+
+type YooKassaStub struct {
+	publicURL     string
+	shopID        string
+	secretKey     string
+	webhookURL    string
+	webhookSecret string
+	httpClient    *http.Client
+
+	mu              sync.Mutex
+	payments        map[string]*yooKassaStubPayment
+	idempotencyKeys map[string]string
+	refundKeys      map[string]yooKassaRefund
+	savedMethods    map[string]*yooKassaPaymentMethod
+}
+
+func NewYooKassaStub(publicURL, shopID, secretKey, webhookURL, webhookSecret string) *YooKassaStub {
+
+	return &YooKassaStub{
+		publicURL:     publicURL,
+		shopID:        shopID,
+		secretKey:     secretKey,
+		webhookURL:    webhookURL,
+		webhookSecret: webhookSecret,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		payments:        make(map[string]*yooKassaStubPayment),
+		idempotencyKeys: make(map[string]string),
+		refundKeys:      make(map[string]yooKassaRefund),
+		savedMethods:    make(map[string]*yooKassaPaymentMethod),
+	}
+}
+
+func (s *YooKassaStub) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /v3/payments", s.withAuth(s.handleCreatePayment))
+	mux.HandleFunc("GET /v3/payments/{id}", s.withAuth(s.handleGetPayment))
+	mux.HandleFunc("POST /v3/payments/{id}/capture", s.withAuth(s.handleCapturePayment))
+	mux.HandleFunc("POST /v3/payments/{id}/cancel", s.withAuth(s.handleCancelPayment))
+	mux.HandleFunc("POST /v3/refunds", s.withAuth(s.handleCreateRefund))
+	mux.HandleFunc("POST /v3/receipts", s.withAuth(s.handleCreateReceipt))
+
+	mux.HandleFunc("GET /checkout/{id}", s.handleCheckoutPage)
+	mux.HandleFunc("POST /checkout/{id}/pay", s.handleCheckoutPay)
+	mux.HandleFunc("POST /checkout/{id}/decline", s.handleCheckoutDecline)
+
+	return mux
+}
+
+func (s *YooKassaStub) withAuth(next http.HandlerFunc) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.shopID != "" || s.secretKey != "" {
+			shopID, secretKey, ok := r.BasicAuth()
+			if !ok || shopID != s.shopID || secretKey != s.secretKey {
+				writeYooKassaError(w, http.StatusUnauthorized, "invalid_credentials", "Authentication by given credentials failed")
+
+				return
+			}
+		}
+
+		next(w, r)
+	}
+}
+
+func (s *YooKassaStub) handleCreatePayment(w http.ResponseWriter, r *http.Request) {
+	idempotencyKey := r.Header.Get("Idempotence-Key")
+	if idempotencyKey == "" {
+		writeYooKassaError(w, http.StatusBadRequest, "invalid_request", "Idempotence-Key header is required")
+
+		return
+	}
+
+	var request yooKassaPaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeYooKassaError(w, http.StatusBadRequest, "invalid_request", err.Error())
+
+		return
+	}
+
+	if request.Amount.Value == "" || request.Amount.Currency == "" {
+		writeYooKassaError(w, http.StatusBadRequest, "invalid_request", "amount is required")
+
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existingID, ok := s.idempotencyKeys[idempotencyKey]; ok {
+		writeYooKassaJSON(w, s.payments[existingID].yooKassaPayment)
+
+		return
+	}
+
+	paymentID := id.Generate()
+
+	if request.PaymentMethodID != "" {
+		method, ok := s.savedMethods[request.PaymentMethodID]
+		if !ok {
+			writeYooKassaError(w, http.StatusBadRequest, "invalid_request", "Saved payment method not found")
+
+			return
+		}
+
+		payment := &yooKassaStubPayment{
+			yooKassaPayment: yooKassaPayment{
+				ID:            paymentID,
+				Status:        "waiting_for_capture",
+				Paid:          true,
+				Amount:        request.Amount,
+				PaymentMethod: method,
+				Description:   request.Description,
+				Metadata:      request.Metadata,
+				ReceiptStatus: receiptStatusOf(request.Receipt),
+				CreatedAt:     time.Now().UTC().Format(time.RFC3339),
+			},
+			capture: request.Capture,
+		}
+		if payment.capture {
+			payment.succeed()
+		}
+
+		s.payments[paymentID] = payment
+		s.idempotencyKeys[idempotencyKey] = paymentID
+
+		slog.Info("Stub recurring payment created", "payment_id", paymentID, "payment_method_id", method.ID, "amount", request.Amount.Value)
+
+		go s.notify(payment.yooKassaPayment)
+		writeYooKassaJSON(w, payment.yooKassaPayment)
+
+		return
+	}
+
+	payment := &yooKassaStubPayment{
+		yooKassaPayment: yooKassaPayment{
+			ID:     paymentID,
+			Status: "pending",
+			Amount: request.Amount,
+			Confirmation: &yooKassaConfirmation{
+				Type:            "redirect",
+				ReturnURL:       returnURLOf(request.Confirmation),
+				ConfirmationURL: fmt.Sprintf("%s/checkout/%s", s.publicURL, paymentID),
+			},
+			Description:   request.Description,
+			Metadata:      request.Metadata,
+			ReceiptStatus: receiptStatusOf(request.Receipt),
+			CreatedAt:     time.Now().UTC().Format(time.RFC3339),
+		},
+		capture:           request.Capture,
+		savePaymentMethod: request.SavePaymentMethod,
+	}
+
+	s.payments[paymentID] = payment
+	s.idempotencyKeys[idempotencyKey] = paymentID
+
+	slog.Info("Stub payment created", "payment_id", paymentID, "amount", request.Amount.Value, "currency", request.Amount.Currency)
+
+	writeYooKassaJSON(w, payment.yooKassaPayment)
+}
+
+func (s *YooKassaStub) handleGetPayment(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	payment, ok := s.payments[r.PathValue("id")]
+	if !ok {
+		writeYooKassaError(w, http.StatusNotFound, "not_found", "Payment not found")
+
+		return
+	}
+
+	writeYooKassaJSON(w, payment.yooKassaPayment)
+}
+
+func (s *YooKassaStub) handleCapturePayment(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	payment, ok := s.payments[r.PathValue("id")]
+	if !ok {
+		writeYooKassaError(w, http.StatusNotFound, "not_found", "Payment not found")
+
+		return
+	}
+
+	switch payment.Status {
+	case "waiting_for_capture":
+		payment.succeed()
+		go s.notify(payment.yooKassaPayment)
+	case "succeeded":
+	default:
+		writeYooKassaError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Payment in status %s cannot be captured", payment.Status))
+
+		return
+	}
+
+	writeYooKassaJSON(w, payment.yooKassaPayment)
+}
+
+func (s *YooKassaStub) handleCancelPayment(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	payment, ok := s.payments[r.PathValue("id")]
+	if !ok {
+		writeYooKassaError(w, http.StatusNotFound, "not_found", "Payment not found")
+
+		return
+	}
+
+	switch payment.Status {
+	case "waiting_for_capture":
+		payment.Status = "canceled"
+		go s.notify(payment.yooKassaPayment)
+	case "canceled":
+	default:
+		writeYooKassaError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Payment in status %s cannot be canceled", payment.Status))
+
+		return
+	}
+
+	writeYooKassaJSON(w, payment.yooKassaPayment)
+}
+
+func (s *YooKassaStub) handleCreateRefund(w http.ResponseWriter, r *http.Request) {
+	var request yooKassaRefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeYooKassaError(w, http.StatusBadRequest, "invalid_request", err.Error())
+
+		return
+	}
+
+	amount, err := strconv.ParseFloat(request.Amount.Value, 64)
+	if err != nil || amount <= 0 {
+		writeYooKassaError(w, http.StatusBadRequest, "invalid_request", "amount is invalid")
+
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idempotencyKey := r.Header.Get("Idempotence-Key")
+	if existing, ok := s.refundKeys[idempotencyKey]; ok {
+		writeYooKassaJSON(w, existing)
+
+		return
+	}
+
+	payment, ok := s.payments[request.PaymentID]
+	if !ok {
+		writeYooKassaError(w, http.StatusNotFound, "not_found", "Payment not found")
+
+		return
+	}
+
+	if payment.Status != "succeeded" {
+		writeYooKassaError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Payment in status %s cannot be refunded", payment.Status))
+
+		return
+	}
+
+	paid, _ := strconv.ParseFloat(payment.Amount.Value, 64)
+	if payment.refunded+amount > paid+0.001 {
+		writeYooKassaError(w, http.StatusBadRequest, "invalid_request", "Refund amount exceeds payment amount")
+
+		return
+	}
+
+	payment.refunded += amount
+
+	refund := yooKassaRefund{
+		ID:        id.Generate(),
+		PaymentID: payment.ID,
+		Status:    "succeeded",
+		Amount:    request.Amount,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if idempotencyKey != "" {
+		s.refundKeys[idempotencyKey] = refund
+	}
+
+	slog.Info("Stub refund created", "payment_id", payment.ID, "refund_id", refund.ID, "amount", request.Amount.Value)
+
+	writeYooKassaJSON(w, refund)
+}
+
+func (s *YooKassaStub) handleCreateReceipt(w http.ResponseWriter, r *http.Request) {
+	var request yooKassaReceiptRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeYooKassaError(w, http.StatusBadRequest, "invalid_request", err.Error())
+
+		return
+	}
+
+	if request.Customer.Email == "" || len(request.Items) == 0 {
+		writeYooKassaError(w, http.StatusBadRequest, "invalid_request", "customer and items are required")
+
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idempotencyKey := r.Header.Get("Idempotence-Key")
+	if existing, ok := s.refundKeys[idempotencyKey]; ok {
+		writeYooKassaJSON(w, existing)
+
+		return
+	}
+
+	payment, ok := s.payments[request.PaymentID]
+	if !ok {
+		writeYooKassaError(w, http.StatusNotFound, "not_found", "Payment not found")
+
+		return
+	}
+
+	if payment.Status != "succeeded" {
+		writeYooKassaError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Receipt cannot be created for payment in status %s", payment.Status))
+
+		return
+	}
+
+	payment.ReceiptStatus = "succeeded"
+
+	receipt := yooKassaReceiptResponse{
+		ID:        id.Generate(),
+		Type:      request.Type,
+		PaymentID: payment.ID,
+		Status:    "succeeded",
+	}
+
+	slog.Info("Stub receipt created", "payment_id", payment.ID, "receipt_id", receipt.ID, "email", request.Customer.Email)
+
+	writeYooKassaJSON(w, receipt)
+}
+
+func (s *YooKassaStub) handleCheckoutPage(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	payment, ok := s.payments[r.PathValue("id")]
+	var snapshot yooKassaPayment
+	if ok {
+		snapshot = payment.yooKassaPayment
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := yooKassaStubCheckoutPage.Execute(w, snapshot); err != nil {
+		slog.Error("Failed to render stub checkout page", "error", err)
+	}
+}
+
+func (s *YooKassaStub) handleCheckoutPay(w http.ResponseWriter, r *http.Request) {
+	s.finishCheckout(w, r, func(payment *yooKassaStubPayment) {
+		payment.Paid = true
+		payment.PaymentMethod = &yooKassaPaymentMethod{
+			ID:    id.Generate(),
+			Type:  "bank_card",
+			Saved: payment.savePaymentMethod,
+			Title: "Bank card *4444",
+		}
+		if payment.savePaymentMethod {
+			s.savedMethods[payment.PaymentMethod.ID] = payment.PaymentMethod
+		}
+		if payment.capture {
+			payment.succeed()
+		} else {
+			payment.Status = "waiting_for_capture"
+		}
+	})
+}
+
+func (s *YooKassaStub) handleCheckoutDecline(w http.ResponseWriter, r *http.Request) {
+	s.finishCheckout(w, r, func(payment *yooKassaStubPayment) {
+		payment.Status = "canceled"
+	})
+}
+
+func (s *YooKassaStub) finishCheckout(w http.ResponseWriter, r *http.Request, apply func(payment *yooKassaStubPayment)) {
+	paymentID := r.PathValue("id")
+
+	s.mu.Lock()
+	payment, ok := s.payments[paymentID]
+	if ok && payment.Status == "pending" {
+		apply(payment)
+		slog.Info("Stub payment checkout finished", "payment_id", paymentID, "status", payment.Status)
+		go s.notify(payment.yooKassaPayment)
+	}
+	var returnURL string
+	if ok {
+		returnURL = returnURLOf(payment.Confirmation)
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	if returnURL == "" {
+		returnURL = "/checkout/" + paymentID
+	}
+
+	http.Redirect(w, r, returnURL, http.StatusSeeOther)
+}
+
+func (s *YooKassaStub) notify(payment yooKassaPayment) {
+	if s.webhookURL == "" {
+
+		return
+	}
+
+	body, err := json.Marshal(yooKassaNotification{
+		Type:   "notification",
+		Event:  "payment." + payment.Status,
+		Object: payment,
+	})
+	if err != nil {
+		slog.Error("Failed to marshal stub notification", "payment_id", payment.ID, "error", err)
+
+		return
+	}
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		if err := s.deliver(body); err != nil {
+			slog.Warn("Stub webhook delivery failed", "payment_id", payment.ID, "attempt", attempt, "error", err)
+			time.Sleep(time.Duration(attempt) * time.Second)
+
+			continue
+		}
+		slog.Info("Stub webhook delivered", "payment_id", payment.ID, "status", payment.Status)
+
+		return
+	}
+}
+
+func (s *YooKassaStub) deliver(body []byte) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, s.webhookURL, bytes.NewReader(body))
+	if err != nil {
+
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if s.webhookSecret != "" {
+		req.Header.Set(webhook.SignatureHeader, webhook.Sign(s.webhookSecret, body))
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func returnURLOf(confirmation *yooKassaConfirmation) string {
+	if confirmation == nil {
+
+		return ""
+	}
+
+	return confirmation.ReturnURL
+}
+
+func writeYooKassaJSON(w http.ResponseWriter, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		slog.Error("Failed to encode stub response", "error", err)
+	}
+}
+
+func writeYooKassaError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(yooKassaError{
+		Type:        "error",
+		Code:        code,
+		Description: description,
+	})
+}
+
+func receiptStatusOf(receipt *yooKassaReceipt) string {
+	if receipt == nil {
+
+		return ""
+	}
+
+	return "pending"
+}
@@ -0,0 +1,187 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"3xui-bot/internal/usecase"
+)
+
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	SecretParam     = "token"
+
+	maxBodySize     = 1 << 20
+	shutdownTimeout = 5 * time.Second
+)
+
+type PaymentWebhookHandler interface {
+	HandlePaymentWebhook(ctx context.Context, paymentID string, externalID string, status string, signed bool) error
+}
+
+type paymentNotification struct {
+	Type   string `json:"type"`
+	Event  string `json:"event"`
+	Object struct {
+		ID       string            `json:"id"`
+		Status   string            `json:"status"`
+		Metadata map[string]string `json:"metadata"`
+	} `json:"object"`
+}
+
+type Server struct {
+	server  *http.Server
+	path    string
+	secret  string
+	handler PaymentWebhookHandler
+}
+
+func NewServer(port, path, secret string, handler PaymentWebhookHandler) *Server {
+	s := &Server{
+		path:    path,
+		secret:  secret,
+		handler: handler,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, s.handlePaymentWebhook)
+
+	s.server = &http.Server{
+		Addr:              ":" + port,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+
+	return s
+}
+
+func (s *Server) Handler() http.Handler {
+
+	return s.server.Handler
+}
+
+func (s *Server) Start(ctx context.Context) error {
+	errCh := make(chan error, 1)
+
+	go func() {
+		slog.Info("Webhook server started", "addr", s.server.Addr, "path", s.path)
+		errCh <- s.server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+
+			return nil
+		}
+
+		return fmt.Errorf("webhook server failed: %w", err)
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := s.server.Shutdown(shutdownCtx); err != nil {
+
+			return fmt.Errorf("failed to shutdown webhook server: %w", err)
+		}
+		slog.Info("Webhook server stopped")
+
+		return nil
+	}
+}
+
+func (s *Server) handlePaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+
+		return
+	}
+
+	valid, signed := s.verify(r, body)
+	if !valid {
+		slog.Warn("Rejected payment webhook with invalid signature", "remote_addr", r.RemoteAddr)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+
+		return
+	}
+
+	var notification paymentNotification
+	if err := json.Unmarshal(body, &notification); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+
+		return
+	}
+
+	paymentID := notification.Object.Metadata["payment_id"]
+	externalID := notification.Object.ID
+	status := notification.Object.Status
+	if (paymentID == "" && externalID == "") || status == "" {
+		slog.Warn("Payment webhook without payment reference or status", "event", notification.Event)
+		http.Error(w, "payment reference and status are required", http.StatusBadRequest)
+
+		return
+	}
+
+	slog.Info("Payment webhook received", "event", notification.Event, "payment_id", paymentID, "external_id", externalID, "status", status, "signed", signed)
+
+	err = s.handler.HandlePaymentWebhook(r.Context(), paymentID, externalID, status, signed)
+	switch {
+	case err == nil,
+		errors.Is(err, usecase.ErrPaymentAlreadyPaid),
+		errors.Is(err, usecase.ErrPaymentCancelled),
+		errors.Is(err, usecase.ErrPaymentFailed):
+		w.WriteHeader(http.StatusOK)
+	case errors.Is(err, usecase.ErrInvalidInput):
+		slog.Warn("Rejected invalid payment webhook", "payment_id", paymentID, "external_id", externalID, "error", err)
+		http.Error(w, "invalid payment reference", http.StatusBadRequest)
+	case errors.Is(err, usecase.ErrNotFound):
+		slog.Warn("Payment webhook for unknown payment", "payment_id", paymentID)
+		http.Error(w, "payment not found", http.StatusNotFound)
+	default:
+		slog.Error("Failed to process payment webhook", "payment_id", paymentID, "status", status, "error", err)
+		http.Error(w, "failed to process webhook", http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) verify(r *http.Request, body []byte) (bool, bool) {
+	if signature := r.Header.Get(SignatureHeader); signature != "" {
+		expected := Sign(s.secret, body)
+
+		return hmac.Equal([]byte(strings.TrimPrefix(signature, "sha256=")), []byte(strings.TrimPrefix(expected, "sha256="))), true
+	}
+
+	if token := r.URL.Query().Get(SecretParam); token != "" {
+
+		return subtle.ConstantTimeCompare([]byte(token), []byte(s.secret)) == 1, false
+	}
+
+	return false, false
+}
+
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
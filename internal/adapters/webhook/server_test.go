@@ -0,0 +1,159 @@
+package webhook_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"3xui-bot/internal/adapters/webhook"
+	"3xui-bot/internal/usecase"
+)
+
+const (
+	testPath   = "/webhook/payment"
+	testSecret = "webhook-secret"
+)
+
+type webhookCall struct {
+	PaymentID  string
+	ExternalID string
+	Status     string
+	Signed     bool
+}
+
+type recordingHandler struct {
+	calls []webhookCall
+	err   error
+}
+
+func (h *recordingHandler) HandlePaymentWebhook(ctx context.Context, paymentID string, externalID string, status string, signed bool) error {
+	h.calls = append(h.calls, webhookCall{PaymentID: paymentID, ExternalID: externalID, Status: status, Signed: signed})
+
+	return h.err
+}
+
+func notificationBody(status string) string {
+
+	return fmt.Sprintf(`{"type":"notification","event":"payment.%s","object":{"id":"ext-1","status":"%s","metadata":{"payment_id":"pay-1"}}}`, status, status)
+}
+
+func post(t *testing.T, handler http.Handler, target, body string, headers map[string]string) int {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	return recorder.Code
+}
+
+func TestPaymentWebhookAuthentication(t *testing.T) {
+	body := notificationBody("succeeded")
+	tests := []struct {
+		name       string
+		target     string
+		headers    map[string]string
+		wantStatus int
+		wantSigned bool
+	}{
+		{name: "valid signature", target: testPath, headers: map[string]string{webhook.SignatureHeader: webhook.Sign(testSecret, []byte(body))}, wantStatus: http.StatusOK, wantSigned: true},
+		{name: "signature without prefix", target: testPath, headers: map[string]string{webhook.SignatureHeader: strings.TrimPrefix(webhook.Sign(testSecret, []byte(body)), "sha256=")}, wantStatus: http.StatusOK, wantSigned: true},
+		{name: "signature with other secret", target: testPath, headers: map[string]string{webhook.SignatureHeader: webhook.Sign("other", []byte(body))}, wantStatus: http.StatusUnauthorized},
+		{name: "signature of other body", target: testPath, headers: map[string]string{webhook.SignatureHeader: webhook.Sign(testSecret, []byte(notificationBody("canceled")))}, wantStatus: http.StatusUnauthorized},
+		{name: "bad signature does not fall back to token", target: testPath + "?token=" + testSecret, headers: map[string]string{webhook.SignatureHeader: "sha256=deadbeef"}, wantStatus: http.StatusUnauthorized},
+		{name: "valid token", target: testPath + "?token=" + testSecret, wantStatus: http.StatusOK},
+		{name: "wrong token", target: testPath + "?token=wrong", wantStatus: http.StatusUnauthorized},
+		{name: "no credentials", target: testPath, wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &recordingHandler{}
+			server := webhook.NewServer("0", testPath, testSecret, handler)
+
+			if status := post(t, server.Handler(), tt.target, body, tt.headers); status != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, status)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				if len(handler.calls) != 0 {
+					t.Errorf("expected rejected webhook not to reach the handler, got %+v", handler.calls)
+				}
+
+				return
+			}
+
+			want := webhookCall{PaymentID: "pay-1", ExternalID: "ext-1", Status: "succeeded", Signed: tt.wantSigned}
+			if len(handler.calls) != 1 || handler.calls[0] != want {
+				t.Errorf("expected handler call %+v, got %+v", want, handler.calls)
+			}
+		})
+	}
+}
+
+func TestPaymentWebhookResponseStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+	}{
+		{name: "processed", wantStatus: http.StatusOK},
+		{name: "already paid", err: usecase.ErrPaymentAlreadyPaid, wantStatus: http.StatusOK},
+		{name: "cancelled", err: fmt.Errorf("checked: %w", usecase.ErrPaymentCancelled), wantStatus: http.StatusOK},
+		{name: "failed", err: usecase.ErrPaymentFailed, wantStatus: http.StatusOK},
+		{name: "invalid reference", err: fmt.Errorf("external ID mismatch: %w", usecase.ErrInvalidInput), wantStatus: http.StatusBadRequest},
+		{name: "unknown payment", err: fmt.Errorf("failed to get payment: %w", usecase.ErrNotFound), wantStatus: http.StatusNotFound},
+		{name: "retryable", err: errors.New("provider unavailable"), wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := webhook.NewServer("0", testPath, testSecret, &recordingHandler{err: tt.err})
+
+			if status := post(t, server.Handler(), testPath+"?token="+testSecret, notificationBody("succeeded"), nil); status != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, status)
+			}
+		})
+	}
+}
+
+func TestPaymentWebhookRejectsMalformedRequests(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{name: "invalid json", body: "{", wantStatus: http.StatusBadRequest},
+		{name: "missing status", body: `{"object":{"id":"ext-1","metadata":{"payment_id":"pay-1"}}}`, wantStatus: http.StatusBadRequest},
+		{name: "missing reference", body: `{"object":{"status":"succeeded"}}`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &recordingHandler{}
+			server := webhook.NewServer("0", testPath, testSecret, handler)
+
+			if status := post(t, server.Handler(), testPath+"?token="+testSecret, tt.body, nil); status != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, status)
+			}
+			if len(handler.calls) != 0 {
+				t.Errorf("expected malformed webhook not to reach the handler, got %+v", handler.calls)
+			}
+		})
+	}
+
+	server := webhook.NewServer("0", testPath, testSecret, &recordingHandler{})
+	req := httptest.NewRequest(http.MethodGet, testPath+"?token="+testSecret, nil)
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected GET to be rejected with 405, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,815 @@
+package xui
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/usecase"
+
+	"github.com/google/uuid"
+)
+
+var (
+	errSessionExpired = errors.New("3x-ui session expired")
+	errClientNotFound = fmt.Errorf("3x-ui: %w", usecase.ErrPanelUserNotFound)
+)
+
+type apiResponse struct {
+	Success bool            `json:"success"`
+	Msg     string          `json:"msg"`
+	Obj     json.RawMessage `json:"obj"`
+}
+
+type Inbound struct {
+	ID             int             `json:"id"`
+	Remark         string          `json:"remark"`
+	Enable         bool            `json:"enable"`
+	Listen         string          `json:"listen"`
+	Protocol       string          `json:"protocol"`
+	Port           int             `json:"port"`
+	Tag            string          `json:"tag"`
+	Settings       string          `json:"settings"`
+	StreamSettings string          `json:"streamSettings"`
+	ClientStats    []ClientTraffic `json:"clientStats"`
+}
+
+type inboundSettings struct {
+	Clients  []Client `json:"clients"`
+	Method   string   `json:"method,omitempty"`
+	Password string   `json:"password,omitempty"`
+}
+
+type Client struct {
+	ID         string `json:"id,omitempty"`
+	Password   string `json:"password,omitempty"`
+	Email      string `json:"email"`
+	Enable     bool   `json:"enable"`
+	ExpiryTime int64  `json:"expiryTime"`
+	TotalGB    int64  `json:"totalGB"`
+	LimitIP    int    `json:"limitIp"`
+	SubID      string `json:"subId"`
+	Flow       string `json:"flow"`
+	Comment    string `json:"comment,omitempty"`
+	Reset      int    `json:"reset"`
+
+	raw map[string]any
+}
+
+type clientFields Client
+
+func (c *Client) UnmarshalJSON(data []byte) error {
+	var fields clientFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+
+		return err
+	}
+
+	raw, err := decodeObject(data)
+	if err != nil {
+
+		return err
+	}
+
+	*c = Client(fields)
+	c.raw = raw
+
+	return nil
+}
+
+func (c Client) MarshalJSON() ([]byte, error) {
+	managed, err := json.Marshal(clientFields(c))
+	if err != nil {
+
+		return nil, err
+	}
+
+	if len(c.raw) == 0 {
+
+		return managed, nil
+	}
+
+	fields, err := decodeObject(managed)
+	if err != nil {
+
+		return nil, err
+	}
+
+	merged := make(map[string]any, len(c.raw)+len(fields))
+	for key, value := range c.raw {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+
+	return json.Marshal(merged)
+}
+
+func decodeObject(data []byte) (map[string]any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var object map[string]any
+	if err := decoder.Decode(&object); err != nil {
+
+		return nil, err
+	}
+
+	return object, nil
+}
+
+type ClientTraffic struct {
+	ID         int    `json:"id"`
+	InboundID  int    `json:"inboundId"`
+	Enable     bool   `json:"enable"`
+	Email      string `json:"email"`
+	Up         int64  `json:"up"`
+	Down       int64  `json:"down"`
+	ExpiryTime int64  `json:"expiryTime"`
+	Total      int64  `json:"total"`
+}
+
+type clientRequest struct {
+	ID       int    `json:"id"`
+	Settings string `json:"settings"`
+}
+
+type XUIRepository struct {
+	baseURL    string
+	username   string
+	password   string
+	inboundID  int
+	subURL     string
+	publicHost string
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	loggedIn bool
+}
+
+func NewXUIRepository(baseURL, username, password string, inboundID int, subURL, publicHost string) *XUIRepository {
+	jar, _ := cookiejar.New(nil)
+
+	return &XUIRepository{
+		baseURL:    baseURL,
+		username:   username,
+		password:   password,
+		inboundID:  inboundID,
+		subURL:     subURL,
+		publicHost: publicHost,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+			Jar:     jar,
+		},
+	}
+}
+
+func (x *XUIRepository) Type() core.PanelType {
+
+	return core.PanelType3XUI
+}
+
+func (x *XUIRepository) Login(ctx context.Context) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	return x.login(ctx)
+}
+
+func (x *XUIRepository) login(ctx context.Context) error {
+	formData := url.Values{}
+	formData.Set("username", x.username)
+	formData.Set("password", x.password)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, x.baseURL+"/login", strings.NewReader(formData.Encode()))
+	if err != nil {
+
+		return fmt.Errorf("failed to create login request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := x.httpClient.Do(req)
+	if err != nil {
+
+		return fmt.Errorf("%w: failed to execute login request: %w", usecase.ErrPanelUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+
+		return fmt.Errorf("login failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var loginResp apiResponse
+	if err := json.Unmarshal(body, &loginResp); err != nil {
+
+		return fmt.Errorf("failed to decode login response: %w", err)
+	}
+
+	if !loginResp.Success {
+
+		return fmt.Errorf("login failed: %s", loginResp.Msg)
+	}
+
+	x.loggedIn = true
+
+	return nil
+}
+
+func (x *XUIRepository) ensureSession(ctx context.Context) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.loggedIn && x.hasSessionCookie() {
+
+		return nil
+	}
+
+	return x.login(ctx)
+}
+
+func (x *XUIRepository) hasSessionCookie() bool {
+	parsed, err := url.Parse(x.baseURL)
+	if err != nil {
+
+		return false
+	}
+
+	return len(x.httpClient.Jar.Cookies(parsed)) > 0
+}
+
+func (x *XUIRepository) invalidateSession() {
+	x.mu.Lock()
+	x.loggedIn = false
+	x.mu.Unlock()
+}
+
+func (x *XUIRepository) makeRequest(ctx context.Context, method, endpoint string, body interface{}, result interface{}) error {
+	err := x.doRequest(ctx, method, endpoint, body, result)
+	if errors.Is(err, errSessionExpired) {
+		x.invalidateSession()
+
+		return x.doRequest(ctx, method, endpoint, body, result)
+	}
+
+	return err
+}
+
+func (x *XUIRepository) doRequest(ctx context.Context, method, endpoint string, body interface{}, result interface{}) error {
+	if err := x.ensureSession(ctx); err != nil {
+
+		return err
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+
+			return fmt.Errorf("failed to marshal request body: %w", err)
+		}
+		reqBody = bytes.NewBuffer(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, x.baseURL+endpoint, reqBody)
+	if err != nil {
+
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := x.httpClient.Do(req)
+	if err != nil {
+
+		return fmt.Errorf("%w: failed to execute request: %w", usecase.ErrPanelUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.Request.URL.Path != req.URL.Path {
+
+		return errSessionExpired
+	}
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+
+		return fmt.Errorf("%w: 3x-ui request failed with status %d: %s", usecase.ErrPanelUnavailable, resp.StatusCode, string(respBody))
+	}
+
+	if resp.StatusCode != http.StatusOK {
+
+		return fmt.Errorf("3x-ui request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var apiResp apiResponse
+	if err := json.Unmarshal(respBody, &apiResp); err != nil {
+
+		return fmt.Errorf("failed to decode response: %w, body: %s", err, string(respBody))
+	}
+
+	if !apiResp.Success {
+
+		return fmt.Errorf("3x-ui request %s failed: %s", endpoint, apiResp.Msg)
+	}
+
+	if result == nil || len(apiResp.Obj) == 0 || string(apiResp.Obj) == "null" {
+
+		return nil
+	}
+
+	if err := json.Unmarshal(apiResp.Obj, result); err != nil {
+
+		return fmt.Errorf("failed to decode response object: %w", err)
+	}
+
+	return nil
+}
+
+func (x *XUIRepository) ListInbounds(ctx context.Context) ([]*Inbound, error) {
+	var inbounds []*Inbound
+	if err := x.makeRequest(ctx, http.MethodGet, "/panel/api/inbounds/list", nil, &inbounds); err != nil {
+
+		return nil, fmt.Errorf("failed to list inbounds: %w", err)
+	}
+
+	return inbounds, nil
+}
+
+func (x *XUIRepository) GetInbound(ctx context.Context, inboundID int) (*Inbound, error) {
+	var inbound Inbound
+	if err := x.makeRequest(ctx, http.MethodGet, fmt.Sprintf("/panel/api/inbounds/get/%d", inboundID), nil, &inbound); err != nil {
+
+		return nil, fmt.Errorf("failed to get inbound %d: %w", inboundID, err)
+	}
+
+	return &inbound, nil
+}
+
+func (x *XUIRepository) AddClient(ctx context.Context, inboundID int, client Client) error {
+	request, err := newClientRequest(inboundID, client)
+	if err != nil {
+
+		return err
+	}
+
+	if err := x.makeRequest(ctx, http.MethodPost, "/panel/api/inbounds/addClient", request, nil); err != nil {
+
+		return fmt.Errorf("failed to add client: %w", err)
+	}
+
+	return nil
+}
+
+func (x *XUIRepository) UpdateClient(ctx context.Context, inboundID int, clientKey string, client Client) error {
+	request, err := newClientRequest(inboundID, client)
+	if err != nil {
+
+		return err
+	}
+
+	if err := x.makeRequest(ctx, http.MethodPost, "/panel/api/inbounds/updateClient/"+url.PathEscape(clientKey), request, nil); err != nil {
+
+		return fmt.Errorf("failed to update client: %w", err)
+	}
+
+	return nil
+}
+
+func (x *XUIRepository) DeleteClient(ctx context.Context, inboundID int, clientKey string) error {
+	endpoint := fmt.Sprintf("/panel/api/inbounds/%d/delClient/%s", inboundID, url.PathEscape(clientKey))
+	if err := x.makeRequest(ctx, http.MethodPost, endpoint, nil, nil); err != nil {
+
+		return fmt.Errorf("failed to delete client: %w", err)
+	}
+
+	return nil
+}
+
+func (x *XUIRepository) GetClientTraffics(ctx context.Context, email string) (*ClientTraffic, error) {
+	var traffic *ClientTraffic
+	if err := x.makeRequest(ctx, http.MethodGet, "/panel/api/inbounds/getClientTraffics/"+url.PathEscape(email), nil, &traffic); err != nil {
+
+		return nil, fmt.Errorf("failed to get client traffics: %w", err)
+	}
+
+	if traffic == nil || traffic.Email == "" {
+
+		return nil, errClientNotFound
+	}
+
+	return traffic, nil
+}
+
+func (x *XUIRepository) ResetClientTraffic(ctx context.Context, inboundID int, email string) error {
+	endpoint := fmt.Sprintf("/panel/api/inbounds/%d/resetClientTraffic/%s", inboundID, url.PathEscape(email))
+	if err := x.makeRequest(ctx, http.MethodPost, endpoint, nil, nil); err != nil {
+
+		return fmt.Errorf("failed to reset client traffic: %w", err)
+	}
+
+	return nil
+}
+
+func (x *XUIRepository) GetInbounds(ctx context.Context) ([]core.PanelInbound, error) {
+	inbounds, err := x.ListInbounds(ctx)
+	if err != nil {
+
+		return nil, err
+	}
+
+	result := make([]core.PanelInbound, 0, len(inbounds))
+	for _, inbound := range inbounds {
+		if !inbound.Enable {
+			continue
+		}
+
+		result = append(result, core.PanelInbound{
+			ID:       inbound.ID,
+			Tag:      inbound.Tag,
+			Protocol: inbound.Protocol,
+			Port:     inbound.Port,
+		})
+	}
+
+	return result, nil
+}
+
+func (x *XUIRepository) CreateUser(ctx context.Context, user *core.PanelUser) (*core.PanelUser, error) {
+	inbound, err := x.selectInbound(ctx, user)
+	if err != nil {
+
+		return nil, err
+	}
+
+	client := Client{
+		Email:  user.Username,
+		Enable: user.Status != core.PanelUserStatusDisabled,
+		SubID:  strings.ReplaceAll(uuid.NewString(), "-", "")[:16],
+	}
+	setClientCredentials(&client, inbound.Protocol, clientSecret(inbound))
+	client.Flow = clientFlow(inbound)
+	applyPanelUser(&client, user)
+
+	if err := x.AddClient(ctx, inbound.ID, client); err != nil {
+
+		return nil, err
+	}
+
+	return x.toPanelUser(inbound, client, nil), nil
+}
+
+func (x *XUIRepository) GetUser(ctx context.Context, username string) (*core.PanelUser, error) {
+	inbound, client, traffic, err := x.findClient(ctx, username)
+	if err != nil {
+
+		return nil, err
+	}
+
+	return x.toPanelUser(inbound, *client, traffic), nil
+}
+
+func (x *XUIRepository) ListUsers(ctx context.Context, offset, limit int) (*core.PanelUsersPage, error) {
+	inbounds, err := x.ListInbounds(ctx)
+	if err != nil {
+
+		return nil, err
+	}
+
+	var users []*core.PanelUser
+	for _, inbound := range inbounds {
+		var settings inboundSettings
+		if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+
+			return nil, fmt.Errorf("failed to decode inbound settings: %w", err)
+		}
+
+		traffics := make(map[string]*ClientTraffic, len(inbound.ClientStats))
+		for i := range inbound.ClientStats {
+			traffics[inbound.ClientStats[i].Email] = &inbound.ClientStats[i]
+		}
+
+		for _, client := range settings.Clients {
+			users = append(users, x.toPanelUser(inbound, client, traffics[client.Email]))
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+
+		return users[i].Username < users[j].Username
+	})
+
+	total := len(users)
+	if offset > total {
+		offset = total
+	}
+	users = users[offset:]
+	if limit > 0 && limit < len(users) {
+		users = users[:limit]
+	}
+
+	return &core.PanelUsersPage{Users: users, Total: total}, nil
+}
+
+func (x *XUIRepository) UpdateUser(ctx context.Context, username string, user *core.PanelUser) (*core.PanelUser, error) {
+	inbound, client, traffic, err := x.findClient(ctx, username)
+	if err != nil {
+
+		return nil, err
+	}
+
+	applyPanelUser(client, user)
+	client.Enable = user.Status != core.PanelUserStatusDisabled
+
+	if err := x.UpdateClient(ctx, inbound.ID, clientKey(inbound.Protocol, *client), *client); err != nil {
+
+		return nil, err
+	}
+
+	return x.toPanelUser(inbound, *client, traffic), nil
+}
+
+func (x *XUIRepository) DeleteUser(ctx context.Context, username string) error {
+	inbound, client, _, err := x.findClient(ctx, username)
+	if errors.Is(err, errClientNotFound) {
+
+		return nil
+	}
+	if err != nil {
+
+		return err
+	}
+
+	return x.DeleteClient(ctx, inbound.ID, clientKey(inbound.Protocol, *client))
+}
+
+func (x *XUIRepository) ResetUserTraffic(ctx context.Context, username string) error {
+	traffic, err := x.GetClientTraffics(ctx, username)
+	if err != nil {
+
+		return err
+	}
+
+	return x.ResetClientTraffic(ctx, traffic.InboundID, username)
+}
+
+func (x *XUIRepository) selectInbound(ctx context.Context, user *core.PanelUser) (*Inbound, error) {
+	if x.inboundID != 0 {
+
+		return x.GetInbound(ctx, x.inboundID)
+	}
+
+	inbounds, err := x.ListInbounds(ctx)
+	if err != nil {
+
+		return nil, err
+	}
+
+	var byProtocol, fallback *Inbound
+	for _, inbound := range inbounds {
+		if !inbound.Enable {
+			continue
+		}
+		for _, tag := range user.Inbounds[inbound.Protocol] {
+			if tag == inbound.Tag {
+
+				return inbound, nil
+			}
+		}
+		if _, ok := user.Proxies[inbound.Protocol]; ok && byProtocol == nil {
+			byProtocol = inbound
+		}
+		if fallback == nil {
+			fallback = inbound
+		}
+	}
+
+	if byProtocol != nil {
+
+		return byProtocol, nil
+	}
+
+	if fallback == nil {
+
+		return nil, fmt.Errorf("no enabled inbounds on 3x-ui panel")
+	}
+
+	return fallback, nil
+}
+
+func (x *XUIRepository) findClient(ctx context.Context, email string) (*Inbound, *Client, *ClientTraffic, error) {
+	traffic, err := x.GetClientTraffics(ctx, email)
+	if err != nil {
+
+		return nil, nil, nil, err
+	}
+
+	inbound, err := x.GetInbound(ctx, traffic.InboundID)
+	if err != nil {
+
+		return nil, nil, nil, err
+	}
+
+	var settings inboundSettings
+	if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+
+		return nil, nil, nil, fmt.Errorf("failed to decode inbound settings: %w", err)
+	}
+
+	for i := range settings.Clients {
+		if settings.Clients[i].Email == email {
+
+			return inbound, &settings.Clients[i], traffic, nil
+		}
+	}
+
+	return nil, nil, nil, errClientNotFound
+}
+
+func (x *XUIRepository) toPanelUser(inbound *Inbound, client Client, traffic *ClientTraffic) *core.PanelUser {
+	user := &core.PanelUser{
+		Username: client.Email,
+		Status:   core.PanelUserStatusActive,
+		Note:     client.Comment,
+		Proxies: map[string]interface{}{
+			inbound.Protocol: map[string]interface{}{"id": clientKey(inbound.Protocol, client)},
+		},
+		Inbounds: map[string][]string{
+			inbound.Protocol: {inbound.Tag},
+		},
+		DataLimitResetStrategy: resetStrategy(client.Reset),
+		DeviceLimit:            client.LimitIP,
+	}
+
+	if client.ExpiryTime > 0 {
+		expireAt := time.UnixMilli(client.ExpiryTime)
+		user.ExpireAt = &expireAt
+	}
+
+	if client.TotalGB > 0 {
+		dataLimit := client.TotalGB
+		user.DataLimit = &dataLimit
+	}
+
+	if traffic != nil {
+		dataUsed := traffic.Up + traffic.Down
+		user.DataUsed = &dataUsed
+	}
+
+	if x.subURL != "" && client.SubID != "" {
+		user.SubscriptionURL = strings.TrimRight(x.subURL, "/") + "/" + client.SubID
+	}
+
+	user.Links = x.clientLinks(inbound, client)
+
+	switch {
+	case user.ExpireAt != nil && user.ExpireAt.Before(time.Now()):
+		user.Status = core.PanelUserStatusExpired
+	case user.DataLimit != nil && user.DataUsed != nil && *user.DataUsed >= *user.DataLimit:
+		user.Status = core.PanelUserStatusLimited
+	case !client.Enable || (traffic != nil && !traffic.Enable):
+		user.Status = core.PanelUserStatusDisabled
+	}
+
+	return user
+}
+
+func applyPanelUser(client *Client, user *core.PanelUser) {
+	client.ExpiryTime = 0
+	if user.ExpireAt != nil {
+		client.ExpiryTime = user.ExpireAt.UnixMilli()
+	}
+
+	client.TotalGB = 0
+	if user.DataLimit != nil {
+		client.TotalGB = *user.DataLimit
+	}
+
+	client.LimitIP = user.DeviceLimit
+	client.Reset = resetDays(user.DataLimitResetStrategy)
+
+	if user.Note != "" {
+		client.Comment = user.Note
+	}
+}
+
+func resetDays(strategy core.DataLimitResetStrategy) int {
+	switch strategy {
+	case core.DataLimitResetDay:
+
+		return 1
+	case core.DataLimitResetWeek:
+
+		return 7
+	case core.DataLimitResetMonth:
+
+		return 30
+	case core.DataLimitResetYear:
+
+		return 365
+	default:
+
+		return 0
+	}
+}
+
+func resetStrategy(days int) core.DataLimitResetStrategy {
+	switch days {
+	case 1:
+
+		return core.DataLimitResetDay
+	case 7:
+
+		return core.DataLimitResetWeek
+	case 30:
+
+		return core.DataLimitResetMonth
+	case 365:
+
+		return core.DataLimitResetYear
+	default:
+
+		return core.DataLimitResetNone
+	}
+}
+
+func setClientCredentials(client *Client, protocol, secret string) {
+	switch protocol {
+	case "trojan", "shadowsocks":
+		client.Password = secret
+	default:
+		client.ID = secret
+	}
+}
+
+func clientSecret(inbound *Inbound) string {
+	if inbound.Protocol != "shadowsocks" {
+
+		return uuid.NewString()
+	}
+
+	var settings inboundSettings
+	if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+
+		return uuid.NewString()
+	}
+
+	size := shadowsocksKeySize(settings.Method)
+	if size == 0 {
+
+		return uuid.NewString()
+	}
+
+	key := make([]byte, size)
+	_, _ = rand.Read(key)
+
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func clientKey(protocol string, client Client) string {
+	switch protocol {
+	case "trojan":
+
+		return client.Password
+	case "shadowsocks":
+
+		return client.Email
+	default:
+
+		return client.ID
+	}
+}
+
+func newClientRequest(inboundID int, client Client) (*clientRequest, error) {
+	settings, err := json.Marshal(inboundSettings{Clients: []Client{client}})
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to marshal client settings: %w", err)
+	}
+
+	return &clientRequest{
+		ID:       inboundID,
+		Settings: string(settings),
+	}, nil
+}
@@ -0,0 +1,463 @@
+package xui_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"3xui-bot/internal/adapters/xui"
+	"3xui-bot/internal/adapters/xui/xuitest"
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/usecase"
+)
+
+const (
+	testPublicHost = "vpn.example.com"
+	testSubURL     = "https://sub.example.com/sub"
+	loginPattern   = "POST /login"
+)
+
+func newTestRepository(t *testing.T, inboundID int, opts ...xuitest.Option) (*xui.XUIRepository, *xuitest.Server) {
+	t.Helper()
+
+	server := xuitest.NewServer(opts...)
+	t.Cleanup(server.Close)
+
+	repo := xui.NewXUIRepository(server.URL, xuitest.DefaultUsername, xuitest.DefaultPassword, inboundID, testSubURL, testPublicHost)
+
+	return repo, server
+}
+
+func newTestUser(username string) *core.PanelUser {
+	expireAt := time.Now().Add(30 * 24 * time.Hour).Truncate(time.Millisecond)
+	dataLimit := int64(100 * 1024 * 1024 * 1024)
+
+	return &core.PanelUser{
+		Username:               username,
+		Status:                 core.PanelUserStatusActive,
+		ExpireAt:               &expireAt,
+		DataLimit:              &dataLimit,
+		DataLimitResetStrategy: core.DataLimitResetMonth,
+		DeviceLimit:            3,
+		Note:                   "User 42 - Месяц",
+	}
+}
+
+func TestCreateUserBuildsRealityLink(t *testing.T) {
+	repo, server := newTestRepository(t, 0)
+	ctx := context.Background()
+
+	user := newTestUser("user_42_reality")
+	created, err := repo.CreateUser(ctx, user)
+	if err != nil {
+		t.Fatalf("CreateUser returned error: %v", err)
+	}
+
+	stored, ok := server.Client(user.Username)
+	if !ok {
+		t.Fatalf("expected client to be created on the panel")
+	}
+	if stored["flow"] != "xtls-rprx-vision" {
+		t.Errorf("expected vision flow for VLESS REALITY, got %v", stored["flow"])
+	}
+	if fmt.Sprint(stored["expiryTime"]) != fmt.Sprint(user.ExpireAt.UnixMilli()) {
+		t.Errorf("expected expiry %d, got %v", user.ExpireAt.UnixMilli(), stored["expiryTime"])
+	}
+	if fmt.Sprint(stored["reset"]) != "30" {
+		t.Errorf("expected monthly reset, got %v", stored["reset"])
+	}
+
+	if len(created.Links) != 1 {
+		t.Fatalf("expected one link, got %v", created.Links)
+	}
+	link, err := url.Parse(created.Links[0])
+	if err != nil {
+		t.Fatalf("failed to parse link: %v", err)
+	}
+	if link.Scheme != "vless" || link.User.Username() != stored["id"] || link.Host != testPublicHost+":443" {
+		t.Errorf("unexpected link target %s", created.Links[0])
+	}
+	query := link.Query()
+	expected := map[string]string{
+		"type":       "tcp",
+		"security":   "reality",
+		"flow":       "xtls-rprx-vision",
+		"encryption": "none",
+		"pbk":        "pubkey",
+		"fp":         "chrome",
+		"sni":        "www.example.com",
+		"sid":        "6ba85179e30d4fc2",
+	}
+	for key, value := range expected {
+		if query.Get(key) != value {
+			t.Errorf("expected %s=%s, got %q", key, value, query.Get(key))
+		}
+	}
+	if link.Fragment != "reality-"+user.Username {
+		t.Errorf("unexpected link remark %q", link.Fragment)
+	}
+	if !strings.HasPrefix(created.SubscriptionURL, testSubURL+"/") {
+		t.Errorf("unexpected subscription URL %q", created.SubscriptionURL)
+	}
+
+	fetched, err := repo.GetUser(ctx, user.Username)
+	if err != nil {
+		t.Fatalf("GetUser returned error: %v", err)
+	}
+	if len(fetched.Links) != 1 || fetched.Links[0] != created.Links[0] {
+		t.Errorf("expected GetUser to return the same link, got %v", fetched.Links)
+	}
+	if fetched.Status != core.PanelUserStatusActive || fetched.DeviceLimit != 3 || fetched.DataLimitResetStrategy != core.DataLimitResetMonth {
+		t.Errorf("unexpected panel user %+v", fetched)
+	}
+
+	if logins := server.RequestCount(loginPattern); logins != 1 {
+		t.Errorf("expected one login for the session, got %d", logins)
+	}
+}
+
+func TestUpdateUserPreservesUnmanagedClientFields(t *testing.T) {
+	repo, server := newTestRepository(t, 0)
+	ctx := context.Background()
+
+	err := server.PutClient(1, map[string]any{
+		"id":         "2b0f3f2e-9b3c-4d1e-8f3a-1c2d3e4f5a6b",
+		"email":      "user_42_manual",
+		"enable":     true,
+		"expiryTime": 0,
+		"totalGB":    0,
+		"limitIp":    0,
+		"flow":       "xtls-rprx-vision",
+		"subId":      "keepme",
+		"tgId":       123456789,
+		"reset":      0,
+		"custom":     map[string]any{"source": "admin"},
+	})
+	if err != nil {
+		t.Fatalf("failed to seed client: %v", err)
+	}
+
+	update := newTestUser("user_42_manual")
+	update.Status = core.PanelUserStatusDisabled
+	if _, err := repo.UpdateUser(ctx, update.Username, update); err != nil {
+		t.Fatalf("UpdateUser returned error: %v", err)
+	}
+
+	stored, _ := server.Client(update.Username)
+	if fmt.Sprint(stored["tgId"]) != "123456789" || stored["subId"] != "keepme" || stored["flow"] != "xtls-rprx-vision" {
+		t.Errorf("expected unmanaged fields to survive the update, got %v", stored)
+	}
+	if custom, ok := stored["custom"].(map[string]any); !ok || custom["source"] != "admin" {
+		t.Errorf("expected custom field to survive the update, got %v", stored["custom"])
+	}
+	if stored["enable"] != false || fmt.Sprint(stored["limitIp"]) != "3" || fmt.Sprint(stored["totalGB"]) != fmt.Sprint(*update.DataLimit) {
+		t.Errorf("expected managed fields to be updated, got %v", stored)
+	}
+
+	fetched, err := repo.GetUser(ctx, update.Username)
+	if err != nil {
+		t.Fatalf("GetUser returned error: %v", err)
+	}
+	if fetched.Status != core.PanelUserStatusDisabled {
+		t.Errorf("expected disabled user, got %s", fetched.Status)
+	}
+}
+
+func TestClientJSONKeepsUnknownFields(t *testing.T) {
+	var client xui.Client
+	if err := json.Unmarshal([]byte(`{"id":"abc","email":"user_1","enable":true,"totalGB":10,"tgId":9007199254740993,"reset":0}`), &client); err != nil {
+		t.Fatalf("failed to decode client: %v", err)
+	}
+	if client.Email != "user_1" || client.TotalGB != 10 {
+		t.Fatalf("unexpected typed fields %+v", client)
+	}
+
+	client.TotalGB = 20
+	client.Enable = false
+	data, err := json.Marshal(client)
+	if err != nil {
+		t.Fatalf("failed to encode client: %v", err)
+	}
+
+	encoded := string(data)
+	for _, fragment := range []string{`"tgId":9007199254740993`, `"totalGB":20`, `"enable":false`, `"id":"abc"`} {
+		if !strings.Contains(encoded, fragment) {
+			t.Errorf("expected %s in %s", fragment, encoded)
+		}
+	}
+}
+
+func TestExpiredSessionLogsInAgain(t *testing.T) {
+	repo, server := newTestRepository(t, 0)
+	ctx := context.Background()
+
+	user := newTestUser("user_42_session")
+	if _, err := repo.CreateUser(ctx, user); err != nil {
+		t.Fatalf("CreateUser returned error: %v", err)
+	}
+
+	server.ExpireSessions()
+
+	if _, err := repo.GetUser(ctx, user.Username); err != nil {
+		t.Fatalf("GetUser after session expiry returned error: %v", err)
+	}
+	if logins := server.RequestCount(loginPattern); logins != 2 {
+		t.Errorf("expected a second login after the session expired, got %d", logins)
+	}
+}
+
+func TestWrongCredentialsFailLogin(t *testing.T) {
+	server := xuitest.NewServer(xuitest.WithCredentials("root", "secret"))
+	t.Cleanup(server.Close)
+
+	repo := xui.NewXUIRepository(server.URL, "root", "wrong", 0, "", "")
+	if _, err := repo.GetInbounds(context.Background()); err == nil {
+		t.Fatalf("expected login with wrong credentials to fail")
+	}
+	if requests := server.RequestCount("GET /panel/api/inbounds/list"); requests != 0 {
+		t.Errorf("expected no API calls without a session, got %d", requests)
+	}
+}
+
+func TestNotFoundIsNotTreatedAsExpiredSession(t *testing.T) {
+	repo, server := newTestRepository(t, 0)
+	ctx := context.Background()
+
+	if err := repo.Login(ctx); err != nil {
+		t.Fatalf("Login returned error: %v", err)
+	}
+
+	server.InjectFault("GET /panel/api/inbounds/list", 404, 1)
+	_, err := repo.ListInbounds(ctx)
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("expected a 404 error, got %v", err)
+	}
+	if errors.Is(err, usecase.ErrPanelUnavailable) {
+		t.Errorf("404 must not be reported as an unavailable panel")
+	}
+	if logins := server.RequestCount(loginPattern); logins != 1 {
+		t.Errorf("expected 404 not to trigger a new login, got %d logins", logins)
+	}
+	if requests := server.RequestCount("GET /panel/api/inbounds/list"); requests != 1 {
+		t.Errorf("expected 404 not to be retried, got %d requests", requests)
+	}
+}
+
+func TestServerErrorIsReportedAsUnavailable(t *testing.T) {
+	repo, server := newTestRepository(t, 0)
+
+	server.InjectFault("GET /panel/api/inbounds/list", 502, 1)
+	if _, err := repo.ListInbounds(context.Background()); !errors.Is(err, usecase.ErrPanelUnavailable) {
+		t.Fatalf("expected ErrPanelUnavailable, got %v", err)
+	}
+}
+
+func TestDeleteAndResetUser(t *testing.T) {
+	repo, server := newTestRepository(t, 0)
+	ctx := context.Background()
+
+	user := newTestUser("user_42_delete")
+	if _, err := repo.CreateUser(ctx, user); err != nil {
+		t.Fatalf("CreateUser returned error: %v", err)
+	}
+
+	if err := server.SetTraffic(user.Username, 1024, 2048); err != nil {
+		t.Fatalf("failed to set traffic: %v", err)
+	}
+	fetched, _ := repo.GetUser(ctx, user.Username)
+	if fetched.DataUsed == nil || *fetched.DataUsed != 3072 {
+		t.Fatalf("expected 3072 bytes used, got %v", fetched.DataUsed)
+	}
+
+	if err := repo.ResetUserTraffic(ctx, user.Username); err != nil {
+		t.Fatalf("ResetUserTraffic returned error: %v", err)
+	}
+	fetched, _ = repo.GetUser(ctx, user.Username)
+	if fetched.DataUsed == nil || *fetched.DataUsed != 0 {
+		t.Errorf("expected traffic to be reset, got %v", fetched.DataUsed)
+	}
+
+	if err := repo.DeleteUser(ctx, user.Username); err != nil {
+		t.Fatalf("DeleteUser returned error: %v", err)
+	}
+	if _, ok := server.Client(user.Username); ok {
+		t.Errorf("expected client to be removed from the panel")
+	}
+	if _, err := repo.GetUser(ctx, user.Username); !errors.Is(err, usecase.ErrPanelUserNotFound) {
+		t.Errorf("expected ErrPanelUserNotFound, got %v", err)
+	}
+	if err := repo.DeleteUser(ctx, user.Username); err != nil {
+		t.Errorf("expected deleting a missing user to succeed, got %v", err)
+	}
+}
+
+func TestListUsersPagesAcrossInbounds(t *testing.T) {
+	repo, _ := newTestRepository(t, 0)
+	ctx := context.Background()
+
+	for _, username := range []string{"user_3", "user_1", "user_2"} {
+		if _, err := repo.CreateUser(ctx, newTestUser(username)); err != nil {
+			t.Fatalf("CreateUser returned error: %v", err)
+		}
+	}
+
+	page, err := repo.ListUsers(ctx, 1, 1)
+	if err != nil {
+		t.Fatalf("ListUsers returned error: %v", err)
+	}
+	if page.Total != 3 || len(page.Users) != 1 || page.Users[0].Username != "user_2" {
+		t.Errorf("unexpected page %+v", page)
+	}
+}
+
+func TestClientLinksPerProtocol(t *testing.T) {
+	inbounds := []xuitest.Inbound{
+		{
+			ID: 2, Remark: "ws", Enable: true, Protocol: "vmess", Port: 8443, Tag: "inbound-8443",
+			Settings: map[string]any{"clients": []any{}},
+			StreamSettings: map[string]any{
+				"network":     "ws",
+				"security":    "tls",
+				"wsSettings":  map[string]any{"path": "/ws", "headers": map[string]any{"Host": "cdn.example.com"}},
+				"tlsSettings": map[string]any{"serverName": "cdn.example.com", "alpn": []any{"h2", "http/1.1"}},
+			},
+		},
+		{
+			ID: 3, Remark: "grpc", Enable: true, Protocol: "trojan", Port: 2083, Tag: "inbound-2083",
+			Settings: map[string]any{"clients": []any{}},
+			StreamSettings: map[string]any{
+				"network":      "grpc",
+				"security":     "tls",
+				"grpcSettings": map[string]any{"serviceName": "svc"},
+				"tlsSettings":  map[string]any{"serverName": "trojan.example.com"},
+			},
+		},
+		{
+			ID: 4, Remark: "ss", Enable: true, Protocol: "shadowsocks", Port: 8388, Tag: "inbound-8388",
+			Settings:       map[string]any{"clients": []any{}, "method": "aes-256-gcm"},
+			StreamSettings: map[string]any{"network": "tcp", "security": "none"},
+		},
+		{
+			ID: 5, Remark: "ss2022", Enable: true, Protocol: "shadowsocks", Port: 8389, Tag: "inbound-8389",
+			Settings:       map[string]any{"clients": []any{}, "method": "2022-blake3-aes-256-gcm", "password": "c2VydmVyLWtleS1vZi0zMi1ieXRlcy1mb3ItdGVzdHM="},
+			StreamSettings: map[string]any{"network": "tcp", "security": "none"},
+		},
+		{
+			ID: 6, Remark: "plain", Enable: true, Protocol: "vless", Port: 80, Tag: "inbound-80",
+			Settings:       map[string]any{"clients": []any{}, "decryption": "none"},
+			StreamSettings: map[string]any{"network": "ws", "security": "none", "wsSettings": map[string]any{"path": "/"}},
+		},
+	}
+
+	t.Run("vmess", func(t *testing.T) {
+		link := createLink(t, 2, inbounds, "vmess://")
+		data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(link, "vmess://"))
+		if err != nil {
+			t.Fatalf("vmess link is not base64: %v", err)
+		}
+		var config map[string]string
+		if err := json.Unmarshal(data, &config); err != nil {
+			t.Fatalf("vmess link is not JSON: %v", err)
+		}
+		expected := map[string]string{"add": testPublicHost, "port": "8443", "net": "ws", "path": "/ws", "host": "cdn.example.com", "tls": "tls", "sni": "cdn.example.com", "alpn": "h2,http/1.1"}
+		for key, value := range expected {
+			if config[key] != value {
+				t.Errorf("expected %s=%s, got %q", key, value, config[key])
+			}
+		}
+	})
+
+	t.Run("trojan", func(t *testing.T) {
+		link, err := url.Parse(createLink(t, 3, inbounds, "trojan://"))
+		if err != nil {
+			t.Fatalf("failed to parse link: %v", err)
+		}
+		query := link.Query()
+		if link.User.Username() == "" || link.Host != testPublicHost+":2083" || query.Get("type") != "grpc" || query.Get("serviceName") != "svc" || query.Get("sni") != "trojan.example.com" {
+			t.Errorf("unexpected trojan link %s", link)
+		}
+	})
+
+	t.Run("shadowsocks", func(t *testing.T) {
+		repo, server := newTestRepository(t, 4, xuitest.WithInbounds(inbounds...))
+		created, err := repo.CreateUser(context.Background(), newTestUser("user_42_ss"))
+		if err != nil {
+			t.Fatalf("CreateUser returned error: %v", err)
+		}
+		stored, _ := server.Client("user_42_ss")
+
+		link := created.Links[0]
+		userInfo, rest, _ := strings.Cut(strings.TrimPrefix(link, "ss://"), "@")
+		if strings.ContainsAny(userInfo, "+/=") {
+			t.Errorf("expected URL-safe base64 without padding, got %q", userInfo)
+		}
+		decoded, err := base64.RawURLEncoding.DecodeString(userInfo)
+		if err != nil {
+			t.Fatalf("userinfo is not URL-safe base64: %v", err)
+		}
+		if string(decoded) != "aes-256-gcm:"+stored["password"].(string) {
+			t.Errorf("unexpected userinfo %q", decoded)
+		}
+		if !strings.HasPrefix(rest, testPublicHost+":8388#") {
+			t.Errorf("unexpected link address %q", rest)
+		}
+	})
+
+	t.Run("shadowsocks 2022", func(t *testing.T) {
+		repo, server := newTestRepository(t, 5, xuitest.WithInbounds(inbounds...))
+		created, err := repo.CreateUser(context.Background(), newTestUser("user_42_ss2022"))
+		if err != nil {
+			t.Fatalf("CreateUser returned error: %v", err)
+		}
+		stored, _ := server.Client("user_42_ss2022")
+
+		password := stored["password"].(string)
+		key, err := base64.StdEncoding.DecodeString(password)
+		if err != nil || len(key) != 32 {
+			t.Fatalf("expected a 32-byte base64 key for 2022-blake3-aes-256-gcm, got %q", password)
+		}
+
+		link, err := url.Parse(created.Links[0])
+		if err != nil {
+			t.Fatalf("failed to parse link: %v", err)
+		}
+		userPassword, _ := link.User.Password()
+		if link.User.Username() != "2022-blake3-aes-256-gcm" || userPassword != "c2VydmVyLWtleS1vZi0zMi1ieXRlcy1mb3ItdGVzdHM=:"+password {
+			t.Errorf("unexpected 2022 userinfo %q", link.User)
+		}
+		if strings.Contains(strings.SplitN(created.Links[0], "@", 2)[0], "+") {
+			t.Errorf("expected userinfo to be percent-encoded, got %s", created.Links[0])
+		}
+	})
+
+	t.Run("vless without tls has no flow", func(t *testing.T) {
+		link, err := url.Parse(createLink(t, 6, inbounds, "vless://"))
+		if err != nil {
+			t.Fatalf("failed to parse link: %v", err)
+		}
+		if link.Query().Has("flow") || link.Query().Get("type") != "ws" || link.Query().Get("security") != "none" {
+			t.Errorf("unexpected vless link %s", link)
+		}
+	})
+}
+
+func createLink(t *testing.T, inboundID int, inbounds []xuitest.Inbound, scheme string) string {
+	t.Helper()
+
+	repo, _ := newTestRepository(t, inboundID, xuitest.WithInbounds(inbounds...))
+	created, err := repo.CreateUser(context.Background(), newTestUser(fmt.Sprintf("user_42_%d", inboundID)))
+	if err != nil {
+		t.Fatalf("CreateUser returned error: %v", err)
+	}
+	if len(created.Links) != 1 || !strings.HasPrefix(created.Links[0], scheme) {
+		t.Fatalf("expected one %s link, got %v", scheme, created.Links)
+	}
+
+	return created.Links[0]
+}
@@ -0,0 +1,322 @@
+package xui
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const visionFlow = "xtls-rprx-vision"
+
+type streamSettings struct {
+	Network             string           `json:"network"`
+	Security            string           `json:"security"`
+	TLSSettings         *tlsSettings     `json:"tlsSettings"`
+	RealitySettings     *realitySettings `json:"realitySettings"`
+	TCPSettings         *tcpSettings     `json:"tcpSettings"`
+	WSSettings          *pathSettings    `json:"wsSettings"`
+	HTTPUpgradeSettings *pathSettings    `json:"httpupgradeSettings"`
+	XHTTPSettings       *pathSettings    `json:"xhttpSettings"`
+	GRPCSettings        *grpcSettings    `json:"grpcSettings"`
+}
+
+type tlsSettings struct {
+	ServerName string   `json:"serverName"`
+	ALPN       []string `json:"alpn"`
+	Settings   struct {
+		Fingerprint string `json:"fingerprint"`
+	} `json:"settings"`
+}
+
+type realitySettings struct {
+	ServerNames []string `json:"serverNames"`
+	ShortIDs    []string `json:"shortIds"`
+	Settings    struct {
+		PublicKey   string `json:"publicKey"`
+		Fingerprint string `json:"fingerprint"`
+		SpiderX     string `json:"spiderX"`
+	} `json:"settings"`
+}
+
+type tcpSettings struct {
+	Header struct {
+		Type string `json:"type"`
+	} `json:"header"`
+}
+
+type pathSettings struct {
+	Path    string            `json:"path"`
+	Host    string            `json:"host"`
+	Mode    string            `json:"mode"`
+	Headers map[string]string `json:"headers"`
+}
+
+type grpcSettings struct {
+	ServiceName string `json:"serviceName"`
+}
+
+func parseStreamSettings(inbound *Inbound) (*streamSettings, error) {
+	stream := &streamSettings{}
+	if inbound.StreamSettings == "" {
+
+		return stream, nil
+	}
+
+	if err := json.Unmarshal([]byte(inbound.StreamSettings), stream); err != nil {
+
+		return nil, fmt.Errorf("failed to decode stream settings: %w", err)
+	}
+
+	return stream, nil
+}
+
+func (s *streamSettings) network() string {
+	if s.Network == "" {
+
+		return "tcp"
+	}
+
+	return s.Network
+}
+
+func (s *streamSettings) security() string {
+	if s.Security == "" {
+
+		return "none"
+	}
+
+	return s.Security
+}
+
+func (s *streamSettings) query() url.Values {
+	params := url.Values{}
+	params.Set("type", s.network())
+
+	var transport *pathSettings
+	switch s.network() {
+	case "tcp":
+		if s.TCPSettings != nil && s.TCPSettings.Header.Type == "http" {
+			params.Set("headerType", "http")
+		}
+	case "ws":
+		transport = s.WSSettings
+	case "httpupgrade":
+		transport = s.HTTPUpgradeSettings
+	case "xhttp":
+		transport = s.XHTTPSettings
+		if transport != nil && transport.Mode != "" {
+			params.Set("mode", transport.Mode)
+		}
+	case "grpc":
+		if s.GRPCSettings != nil && s.GRPCSettings.ServiceName != "" {
+			params.Set("serviceName", s.GRPCSettings.ServiceName)
+		}
+	}
+
+	if transport != nil {
+		if transport.Path != "" {
+			params.Set("path", transport.Path)
+		}
+		host := transport.Host
+		if host == "" {
+			host = transport.Headers["Host"]
+		}
+		if host != "" {
+			params.Set("host", host)
+		}
+	}
+
+	params.Set("security", s.security())
+	switch s.security() {
+	case "tls":
+		if s.TLSSettings != nil {
+			setIfNotEmpty(params, "sni", s.TLSSettings.ServerName)
+			setIfNotEmpty(params, "fp", s.TLSSettings.Settings.Fingerprint)
+			setIfNotEmpty(params, "alpn", strings.Join(s.TLSSettings.ALPN, ","))
+		}
+	case "reality":
+		if s.RealitySettings != nil {
+			setIfNotEmpty(params, "pbk", s.RealitySettings.Settings.PublicKey)
+			setIfNotEmpty(params, "fp", s.RealitySettings.Settings.Fingerprint)
+			setIfNotEmpty(params, "spx", s.RealitySettings.Settings.SpiderX)
+			if len(s.RealitySettings.ServerNames) > 0 {
+				params.Set("sni", s.RealitySettings.ServerNames[0])
+			}
+			if len(s.RealitySettings.ShortIDs) > 0 {
+				params.Set("sid", s.RealitySettings.ShortIDs[0])
+			}
+		}
+	}
+
+	return params
+}
+
+func setIfNotEmpty(params url.Values, key, value string) {
+	if value != "" {
+		params.Set(key, value)
+	}
+}
+
+func clientFlow(inbound *Inbound) string {
+	if inbound.Protocol != "vless" {
+
+		return ""
+	}
+
+	stream, err := parseStreamSettings(inbound)
+	if err != nil {
+
+		return ""
+	}
+
+	if stream.network() == "tcp" && (stream.security() == "reality" || stream.security() == "tls") {
+
+		return visionFlow
+	}
+
+	return ""
+}
+
+func (x *XUIRepository) linkHost(inbound *Inbound) string {
+	if x.publicHost != "" {
+
+		return x.publicHost
+	}
+
+	if inbound.Listen != "" && inbound.Listen != "0.0.0.0" && inbound.Listen != "::" {
+
+		return inbound.Listen
+	}
+
+	parsed, err := url.Parse(x.baseURL)
+	if err != nil {
+
+		return ""
+	}
+
+	return parsed.Hostname()
+}
+
+func (x *XUIRepository) clientLinks(inbound *Inbound, client Client) []string {
+	host := x.linkHost(inbound)
+	if host == "" {
+
+		return nil
+	}
+
+	stream, err := parseStreamSettings(inbound)
+	if err != nil {
+		slog.Warn("Failed to build 3x-ui client links", "inbound_id", inbound.ID, "error", err)
+
+		return nil
+	}
+
+	address := net.JoinHostPort(host, strconv.Itoa(inbound.Port))
+	remark := client.Email
+	if inbound.Remark != "" {
+		remark = inbound.Remark + "-" + client.Email
+	}
+
+	switch inbound.Protocol {
+	case "vless":
+		params := stream.query()
+		params.Set("encryption", "none")
+		setIfNotEmpty(params, "flow", client.Flow)
+
+		return []string{fmt.Sprintf("vless://%s@%s?%s#%s", client.ID, address, params.Encode(), url.PathEscape(remark))}
+	case "trojan":
+		params := stream.query()
+
+		return []string{fmt.Sprintf("trojan://%s@%s?%s#%s", url.PathEscape(client.Password), address, params.Encode(), url.PathEscape(remark))}
+	case "vmess":
+
+		return vmessLinks(stream, host, inbound.Port, client.ID, remark)
+	case "shadowsocks":
+
+		return shadowsocksLinks(inbound, client, address, remark)
+	default:
+
+		return nil
+	}
+}
+
+func vmessLinks(stream *streamSettings, host string, port int, clientID, remark string) []string {
+	params := stream.query()
+	headerType := params.Get("headerType")
+	if headerType == "" {
+		headerType = "none"
+	}
+	security := ""
+	if stream.security() == "tls" {
+		security = "tls"
+	}
+
+	config := map[string]string{
+		"v":    "2",
+		"ps":   remark,
+		"add":  host,
+		"port": strconv.Itoa(port),
+		"id":   clientID,
+		"aid":  "0",
+		"scy":  "auto",
+		"net":  stream.network(),
+		"type": headerType,
+		"host": params.Get("host"),
+		"path": params.Get("path") + params.Get("serviceName"),
+		"tls":  security,
+		"sni":  params.Get("sni"),
+		"fp":   params.Get("fp"),
+		"alpn": params.Get("alpn"),
+	}
+
+	encoded, err := json.Marshal(config)
+	if err != nil {
+
+		return nil
+	}
+
+	return []string{"vmess://" + base64.StdEncoding.EncodeToString(encoded)}
+}
+
+func shadowsocksLinks(inbound *Inbound, client Client, address, remark string) []string {
+	var settings inboundSettings
+	if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil || settings.Method == "" {
+
+		return nil
+	}
+
+	userInfo := base64.RawURLEncoding.EncodeToString([]byte(settings.Method + ":" + client.Password))
+	if isShadowsocks2022(settings.Method) {
+		password := client.Password
+		if settings.Password != "" {
+			password = settings.Password + ":" + client.Password
+		}
+		userInfo = url.QueryEscape(settings.Method) + ":" + url.QueryEscape(password)
+	}
+
+	return []string{fmt.Sprintf("ss://%s@%s#%s", userInfo, address, url.PathEscape(remark))}
+}
+
+func isShadowsocks2022(method string) bool {
+
+	return strings.HasPrefix(method, "2022-")
+}
+
+func shadowsocksKeySize(method string) int {
+	switch method {
+	case "2022-blake3-aes-128-gcm":
+
+		return 16
+	case "2022-blake3-aes-256-gcm", "2022-blake3-chacha20-poly1305":
+
+		return 32
+	default:
+
+		return 0
+	}
+}
@@ -0,0 +1,719 @@
+package xuitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	DefaultUsername = "admin"
+	DefaultPassword = "admin"
+
+	sessionCookie = "3x-ui"
+)
+
+type Request struct {
+	Method  string
+	Path    string
+	Pattern string
+	Status  int
+}
+
+type Inbound struct {
+	ID             int
+	Remark         string
+	Enable         bool
+	Listen         string
+	Protocol       string
+	Port           int
+	Tag            string
+	Settings       map[string]any
+	StreamSettings map[string]any
+}
+
+type Traffic struct {
+	ID         int    `json:"id"`
+	InboundID  int    `json:"inboundId"`
+	Enable     bool   `json:"enable"`
+	Email      string `json:"email"`
+	Up         int64  `json:"up"`
+	Down       int64  `json:"down"`
+	ExpiryTime int64  `json:"expiryTime"`
+	Total      int64  `json:"total"`
+}
+
+type Option func(*Server)
+
+func WithCredentials(username, password string) Option {
+
+	return func(s *Server) {
+		s.username = username
+		s.password = password
+	}
+}
+
+func WithInbounds(inbounds ...Inbound) Option {
+
+	return func(s *Server) {
+		s.inbounds = make(map[int]*Inbound, len(inbounds))
+		for i := range inbounds {
+			inbound := inbounds[i]
+			s.inbounds[inbound.ID] = &inbound
+		}
+	}
+}
+
+type Server struct {
+	URL string
+
+	httpServer *httptest.Server
+	username   string
+	password   string
+
+	mu         sync.Mutex
+	inbounds   map[int]*Inbound
+	traffics   map[string]*Traffic
+	sessions   map[string]bool
+	sessionSeq int
+	trafficSeq int
+	faults     []*fault
+	requests   []Request
+}
+
+type fault struct {
+	pattern string
+	status  int
+	times   int
+}
+
+type handlerFunc func(w http.ResponseWriter, r *http.Request)
+
+type apiResponse struct {
+	Success bool   `json:"success"`
+	Msg     string `json:"msg"`
+	Obj     any    `json:"obj"`
+}
+
+type inboundView struct {
+	ID             int        `json:"id"`
+	Remark         string     `json:"remark"`
+	Enable         bool       `json:"enable"`
+	Listen         string     `json:"listen"`
+	Protocol       string     `json:"protocol"`
+	Port           int        `json:"port"`
+	Tag            string     `json:"tag"`
+	Settings       string     `json:"settings"`
+	StreamSettings string     `json:"streamSettings"`
+	ClientStats    []*Traffic `json:"clientStats"`
+}
+
+type clientRequest struct {
+	ID       int    `json:"id"`
+	Settings string `json:"settings"`
+}
+
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		username: DefaultUsername,
+		password: DefaultPassword,
+		traffics: make(map[string]*Traffic),
+		sessions: make(map[string]bool),
+	}
+	WithInbounds(DefaultInbounds()...)(s)
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.httpServer = httptest.NewServer(s.routes())
+	s.URL = s.httpServer.URL
+
+	return s
+}
+
+func DefaultInbounds() []Inbound {
+
+	return []Inbound{
+		{
+			ID:       1,
+			Remark:   "reality",
+			Enable:   true,
+			Protocol: "vless",
+			Port:     443,
+			Tag:      "inbound-443",
+			Settings: map[string]any{"clients": []any{}, "decryption": "none"},
+			StreamSettings: map[string]any{
+				"network":  "tcp",
+				"security": "reality",
+				"realitySettings": map[string]any{
+					"serverNames": []any{"www.example.com"},
+					"shortIds":    []any{"6ba85179e30d4fc2"},
+					"settings":    map[string]any{"publicKey": "pubkey", "fingerprint": "chrome", "spiderX": "/"},
+				},
+			},
+		},
+	}
+}
+
+func (s *Server) Close() {
+	s.httpServer.Close()
+}
+
+func (s *Server) InjectFault(pattern string, status, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = append(s.faults, &fault{pattern: pattern, status: status, times: times})
+}
+
+func (s *Server) ExpireSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions = make(map[string]bool)
+}
+
+func (s *Server) RequestCount(pattern string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, request := range s.requests {
+		if request.Pattern == pattern {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests := make([]Request, len(s.requests))
+	copy(requests, s.requests)
+
+	return requests
+}
+
+func (s *Server) Client(email string) (map[string]any, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, client, ok := s.findClient(email)
+	if !ok {
+
+		return nil, false
+	}
+
+	return cloneObject(client), true
+}
+
+func (s *Server) PutClient(inboundID int, client map[string]any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inbound, ok := s.inbounds[inboundID]
+	if !ok {
+
+		return fmt.Errorf("inbound %d not found", inboundID)
+	}
+
+	return s.addClient(inbound, cloneObject(client))
+}
+
+func (s *Server) SetTraffic(email string, up, down int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	traffic, ok := s.traffics[email]
+	if !ok {
+
+		return fmt.Errorf("client %s not found", email)
+	}
+	traffic.Up = up
+	traffic.Down = down
+
+	return nil
+}
+
+func (s *Server) routes() *http.ServeMux {
+	handlers := map[string]handlerFunc{
+		"POST /login":                                              s.handleLogin,
+		"GET /panel/api/inbounds/list":                             s.handleListInbounds,
+		"GET /panel/api/inbounds/get/{id}":                         s.handleGetInbound,
+		"POST /panel/api/inbounds/addClient":                       s.handleAddClient,
+		"POST /panel/api/inbounds/updateClient/{key}":              s.handleUpdateClient,
+		"POST /panel/api/inbounds/{id}/delClient/{key}":            s.handleDeleteClient,
+		"GET /panel/api/inbounds/getClientTraffics/{email}":        s.handleClientTraffics,
+		"POST /panel/api/inbounds/{id}/resetClientTraffic/{email}": s.handleResetTraffic,
+	}
+
+	mux := http.NewServeMux()
+	for pattern, handler := range handlers {
+		mux.Handle(pattern, s.wrap(pattern, handler))
+	}
+
+	return mux
+}
+
+func (s *Server) wrap(pattern string, handler handlerFunc) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			s.mu.Lock()
+			s.requests = append(s.requests, Request{
+				Method:  r.Method,
+				Path:    r.URL.Path,
+				Pattern: pattern,
+				Status:  recorder.status,
+			})
+			s.mu.Unlock()
+		}()
+
+		if status, ok := s.takeFault(pattern); ok {
+			recorder.WriteHeader(status)
+
+			return
+		}
+
+		if strings.HasPrefix(r.URL.Path, "/panel/") && !s.authorized(r) {
+			recorder.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		handler(recorder, r)
+	})
+}
+
+func (s *Server) takeFault(pattern string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, f := range s.faults {
+		if f.pattern != "" && f.pattern != pattern {
+			continue
+		}
+
+		f.times--
+		if f.times <= 0 {
+			s.faults = append(s.faults[:i], s.faults[i+1:]...)
+		}
+
+		return f.status, true
+	}
+
+	return 0, false
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sessions[cookie.Value]
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeResult(w, nil, fmt.Errorf("invalid form"))
+
+		return
+	}
+
+	if r.PostForm.Get("username") != s.username || r.PostForm.Get("password") != s.password {
+		writeResult(w, nil, fmt.Errorf("Wrong username or password"))
+
+		return
+	}
+
+	s.mu.Lock()
+	s.sessionSeq++
+	session := "session-" + strconv.Itoa(s.sessionSeq)
+	s.sessions[session] = true
+	s.mu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: session, Path: "/", HttpOnly: true})
+	writeResult(w, nil, nil)
+}
+
+func (s *Server) handleListInbounds(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]int, 0, len(s.inbounds))
+	for id := range s.inbounds {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	views := make([]inboundView, 0, len(ids))
+	for _, id := range ids {
+		views = append(views, s.view(s.inbounds[id]))
+	}
+
+	writeResult(w, views, nil)
+}
+
+func (s *Server) handleGetInbound(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inbound, err := s.inbound(r.PathValue("id"))
+	if err != nil {
+		writeResult(w, nil, err)
+
+		return
+	}
+
+	writeResult(w, s.view(inbound), nil)
+}
+
+func (s *Server) handleAddClient(w http.ResponseWriter, r *http.Request) {
+	request, clients, err := decodeClientRequest(r)
+	if err != nil {
+		writeResult(w, nil, err)
+
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inbound, ok := s.inbounds[request.ID]
+	if !ok {
+		writeResult(w, nil, fmt.Errorf("inbound %d not found", request.ID))
+
+		return
+	}
+
+	for _, client := range clients {
+		if err := s.addClient(inbound, client); err != nil {
+			writeResult(w, nil, err)
+
+			return
+		}
+	}
+
+	writeResult(w, nil, nil)
+}
+
+func (s *Server) handleUpdateClient(w http.ResponseWriter, r *http.Request) {
+	request, clients, err := decodeClientRequest(r)
+	if err != nil {
+		writeResult(w, nil, err)
+
+		return
+	}
+	if len(clients) != 1 {
+		writeResult(w, nil, fmt.Errorf("expected exactly one client"))
+
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inbound, ok := s.inbounds[request.ID]
+	if !ok {
+		writeResult(w, nil, fmt.Errorf("inbound %d not found", request.ID))
+
+		return
+	}
+
+	existing := inboundClients(inbound)
+	index := clientIndex(inbound.Protocol, existing, r.PathValue("key"))
+	if index < 0 {
+		writeResult(w, nil, fmt.Errorf("client not found"))
+
+		return
+	}
+
+	oldEmail, _ := existing[index]["email"].(string)
+	updated := clients[0]
+	existing[index] = updated
+	setInboundClients(inbound, existing)
+
+	traffic := s.traffics[oldEmail]
+	delete(s.traffics, oldEmail)
+	email, _ := updated["email"].(string)
+	if traffic == nil {
+		s.trafficSeq++
+		traffic = &Traffic{ID: s.trafficSeq, InboundID: inbound.ID}
+	}
+	applyClientTraffic(traffic, email, updated)
+	s.traffics[email] = traffic
+
+	writeResult(w, nil, nil)
+}
+
+func (s *Server) handleDeleteClient(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inbound, err := s.inbound(r.PathValue("id"))
+	if err != nil {
+		writeResult(w, nil, err)
+
+		return
+	}
+
+	clients := inboundClients(inbound)
+	index := clientIndex(inbound.Protocol, clients, r.PathValue("key"))
+	if index < 0 {
+		writeResult(w, nil, fmt.Errorf("client not found"))
+
+		return
+	}
+
+	email, _ := clients[index]["email"].(string)
+	setInboundClients(inbound, append(clients[:index], clients[index+1:]...))
+	delete(s.traffics, email)
+
+	writeResult(w, nil, nil)
+}
+
+func (s *Server) handleClientTraffics(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	traffic, ok := s.traffics[r.PathValue("email")]
+	if !ok {
+		writeResult(w, nil, nil)
+
+		return
+	}
+
+	writeResult(w, traffic, nil)
+}
+
+func (s *Server) handleResetTraffic(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	traffic, ok := s.traffics[r.PathValue("email")]
+	if !ok {
+		writeResult(w, nil, fmt.Errorf("client not found"))
+
+		return
+	}
+	traffic.Up = 0
+	traffic.Down = 0
+
+	writeResult(w, nil, nil)
+}
+
+func (s *Server) addClient(inbound *Inbound, client map[string]any) error {
+	email, _ := client["email"].(string)
+	if email == "" {
+
+		return fmt.Errorf("empty client email")
+	}
+	if _, exists := s.traffics[email]; exists {
+
+		return fmt.Errorf("Duplicate email: %s", email)
+	}
+
+	setInboundClients(inbound, append(inboundClients(inbound), client))
+
+	s.trafficSeq++
+	traffic := &Traffic{ID: s.trafficSeq, InboundID: inbound.ID}
+	applyClientTraffic(traffic, email, client)
+	s.traffics[email] = traffic
+
+	return nil
+}
+
+func (s *Server) findClient(email string) (*Inbound, map[string]any, bool) {
+	for _, inbound := range s.inbounds {
+		for _, client := range inboundClients(inbound) {
+			if client["email"] == email {
+
+				return inbound, client, true
+			}
+		}
+	}
+
+	return nil, nil, false
+}
+
+func (s *Server) inbound(value string) (*Inbound, error) {
+	id, err := strconv.Atoi(value)
+	if err != nil {
+
+		return nil, fmt.Errorf("invalid inbound id %q", value)
+	}
+
+	inbound, ok := s.inbounds[id]
+	if !ok {
+
+		return nil, fmt.Errorf("inbound %d not found", id)
+	}
+
+	return inbound, nil
+}
+
+func (s *Server) view(inbound *Inbound) inboundView {
+	settings, _ := json.Marshal(inbound.Settings)
+	stream, _ := json.Marshal(inbound.StreamSettings)
+
+	view := inboundView{
+		ID:             inbound.ID,
+		Remark:         inbound.Remark,
+		Enable:         inbound.Enable,
+		Listen:         inbound.Listen,
+		Protocol:       inbound.Protocol,
+		Port:           inbound.Port,
+		Tag:            inbound.Tag,
+		Settings:       string(settings),
+		StreamSettings: string(stream),
+	}
+	for _, client := range inboundClients(inbound) {
+		email, _ := client["email"].(string)
+		if traffic, ok := s.traffics[email]; ok {
+			stats := *traffic
+			view.ClientStats = append(view.ClientStats, &stats)
+		}
+	}
+
+	return view
+}
+
+func decodeClientRequest(r *http.Request) (*clientRequest, []map[string]any, error) {
+	var request clientRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+
+		return nil, nil, fmt.Errorf("invalid request body: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(request.Settings)))
+	decoder.UseNumber()
+
+	var settings struct {
+		Clients []map[string]any `json:"clients"`
+	}
+	if err := decoder.Decode(&settings); err != nil {
+
+		return nil, nil, fmt.Errorf("invalid client settings: %w", err)
+	}
+
+	return &request, settings.Clients, nil
+}
+
+func inboundClients(inbound *Inbound) []map[string]any {
+	raw, _ := inbound.Settings["clients"].([]any)
+	clients := make([]map[string]any, 0, len(raw))
+	for _, item := range raw {
+		if client, ok := item.(map[string]any); ok {
+			clients = append(clients, client)
+		}
+	}
+
+	return clients
+}
+
+func setInboundClients(inbound *Inbound, clients []map[string]any) {
+	raw := make([]any, 0, len(clients))
+	for _, client := range clients {
+		raw = append(raw, client)
+	}
+	if inbound.Settings == nil {
+		inbound.Settings = make(map[string]any)
+	}
+	inbound.Settings["clients"] = raw
+}
+
+func clientIndex(protocol string, clients []map[string]any, key string) int {
+	field := "id"
+	switch protocol {
+	case "trojan":
+		field = "password"
+	case "shadowsocks":
+		field = "email"
+	}
+
+	for i, client := range clients {
+		if value, _ := client[field].(string); value == key {
+
+			return i
+		}
+	}
+
+	return -1
+}
+
+func applyClientTraffic(traffic *Traffic, email string, client map[string]any) {
+	traffic.Email = email
+	traffic.Enable, _ = client["enable"].(bool)
+	traffic.ExpiryTime = int64Value(client["expiryTime"])
+	traffic.Total = int64Value(client["totalGB"])
+}
+
+func int64Value(value any) int64 {
+	switch v := value.(type) {
+	case json.Number:
+		n, _ := v.Int64()
+
+		return n
+	case float64:
+
+		return int64(v)
+	case int64:
+
+		return v
+	case int:
+
+		return int64(v)
+	default:
+
+		return 0
+	}
+}
+
+func cloneObject(object map[string]any) map[string]any {
+	data, _ := json.Marshal(object)
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var clone map[string]any
+	_ = decoder.Decode(&clone)
+
+	return clone
+}
+
+func writeResult(w http.ResponseWriter, obj any, err error) {
+	response := apiResponse{Success: err == nil, Obj: obj}
+	if err != nil {
+		response.Msg = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
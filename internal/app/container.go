@@ -4,17 +4,25 @@ import (
 	"3xui-bot/internal/adapters/db/postgres"
 	"context"
 	"fmt"
+	"time"
 
 	"3xui-bot/internal/adapters/bot/telegram"
+	"3xui-bot/internal/adapters/db/postgres/balance"
+	"3xui-bot/internal/adapters/db/postgres/lock"
 	"3xui-bot/internal/adapters/db/postgres/notification"
 	paymentAdapter "3xui-bot/internal/adapters/db/postgres/payment"
+	"3xui-bot/internal/adapters/db/postgres/promo"
 	"3xui-bot/internal/adapters/db/postgres/referral"
 	"3xui-bot/internal/adapters/db/postgres/subscription"
 	"3xui-bot/internal/adapters/db/postgres/user"
 	"3xui-bot/internal/adapters/db/postgres/vpn"
 	"3xui-bot/internal/adapters/marzban"
 	"3xui-bot/internal/adapters/notify"
+	panelAdapter "3xui-bot/internal/adapters/panel"
 	"3xui-bot/internal/adapters/payment"
+	"3xui-bot/internal/adapters/webhook"
+	"3xui-bot/internal/adapters/xui"
+	"3xui-bot/internal/core"
 	"3xui-bot/internal/pkg/config"
 	"3xui-bot/internal/pkg/logger"
 	"3xui-bot/internal/ports"
@@ -35,7 +43,7 @@ type Container struct {
 	DBGetter   transactorPgx.DBGetter
 	UnitOfWork ports.UnitOfWork
 	Clock      ports.Clock
-	Marzban    ports.Marzban
+	Panels     ports.PanelRegistry
 	Notifier   ports.Notifier
 
 	UserUC     *usecase.UserUseCase
@@ -44,9 +52,16 @@ type Container struct {
 	VPNUC      *usecase.VPNUseCase
 	ReferralUC *usecase.ReferralUseCase
 	NotifUC    *usecase.NotificationUseCase
-
-	Router    *telegram.Router
-	Scheduler *scheduler.Scheduler
+	PromoUC    *usecase.PromoCodeUseCase
+	BalanceUC  *usecase.BalanceUseCase
+	RenewalUC  *usecase.AutoRenewalUseCase
+	ReconUC    *usecase.ReconciliationUseCase
+	ExpiryUC   *usecase.ExpiryUseCase
+	TrafficUC  *usecase.TrafficUseCase
+
+	Router        *telegram.Router
+	Scheduler     *scheduler.Scheduler
+	WebhookServer *webhook.Server
 }
 
 func NewContainer(ctx context.Context, configPath string) (*Container, error) {
@@ -97,39 +112,130 @@ func NewContainer(ctx context.Context, configPath string) (*Container, error) {
 
 	c.Clock = &ports.SystemClock{}
 
-	c.Marzban = marzban.NewMarzbanRepository(
-		cfg.Marzban.BaseURL,
-		cfg.Marzban.Username,
-		cfg.Marzban.Password,
-	)
+	panels := panelAdapter.NewRegistry()
+	for _, server := range cfg.Panel.Servers {
+		panelServer := core.PanelServer{
+			Name:     server.Name,
+			Title:    server.Title,
+			Flag:     server.Flag,
+			Region:   server.Region,
+			Type:     core.PanelType(server.Type),
+			Weight:   server.Weight,
+			Capacity: server.Capacity,
+		}
+		if err := panels.Register(panelServer, newPanelClient(server)); err != nil {
+
+			return nil, fmt.Errorf("failed to register panel server: %w", err)
+		}
+		c.Logger.Info("VPN panel server: %s (%s, region %q, weight %d)", server.Name, server.Type, server.Region, server.Weight)
+	}
+	c.Panels = panels
 
 	c.Notifier = notify.NewTelegramNotifier(bot)
 
 	userRepo := user.NewUser(c.DBGetter)
 	subRepo := subscription.NewSubscription(c.DBGetter)
 	planRepo := subscription.NewPlan(c.DBGetter)
+	reminderRepo := subscription.NewReminder(c.DBGetter)
 	paymentRepo := paymentAdapter.NewPayment(c.DBGetter)
+	refundRepo := paymentAdapter.NewPaymentRefund(c.DBGetter)
+	savedMethodRepo := paymentAdapter.NewSavedPaymentMethod(c.DBGetter)
+	promoRepo := promo.NewPromoCode(c.DBGetter)
+	balanceRepo := balance.NewBalance(c.DBGetter)
 	vpnRepo := vpn.NewVPNConnection(c.DBGetter)
 	referralRepo := referral.NewReferral(c.DBGetter)
 	referralLinkRepo := referral.NewReferralLink(c.DBGetter)
 	notifRepo := notification.NewNotification(c.DBGetter)
+	schedulerLockRepo := lock.NewSchedulerLock(c.DBGetter)
 
 	c.UserUC = usecase.NewUserUseCase(userRepo, c.Clock)
 	c.SubUC = usecase.NewSubscriptionUseCase(subRepo, planRepo)
 	c.ReferralUC = usecase.NewReferralUseCase(referralRepo, referralLinkRepo)
+	c.PromoUC = usecase.NewPromoCodeUseCase(promoRepo)
+	c.BalanceUC = usecase.NewBalanceUseCase(balanceRepo, referralRepo, cfg.Referral.RewardPercent)
 
-	c.VPNUC = usecase.NewVPNUseCase(vpnRepo, c.Marzban, subRepo, planRepo)
+	panelSelector := usecase.NewPanelSelector(c.Panels, vpnRepo, usecase.PanelSelectionPolicy(cfg.Panel.SelectionPolicy), cfg.Panel.PreferredRegion)
+	c.VPNUC = usecase.NewVPNUseCase(vpnRepo, c.Panels, panelSelector, subRepo, planRepo)
 
 	c.NotifUC = usecase.NewNotificationUseCase(notifRepo, userRepo, c.Notifier)
 
-	paymentProvider := payment.NewMockProvider()
+	var paymentProvider usecase.PaymentProvider
+	switch cfg.Payment.Provider {
+	case config.PaymentProviderYooKassa:
+		paymentProvider = payment.NewYooKassaProvider(
+			cfg.Payment.APIURL,
+			cfg.Payment.ShopID,
+			cfg.Payment.SecretKey,
+			cfg.Payment.ReturnURL,
+		)
+	default:
+		paymentProvider = payment.NewMockProvider()
+	}
+	c.Logger.Info("Payment provider: %s", cfg.Payment.Provider)
 
 	c.PaymentUC = usecase.NewPaymentUseCase(
+		c.UnitOfWork,
 		paymentRepo,
+		refundRepo,
+		savedMethodRepo,
+		userRepo,
 		c.SubUC,
+		c.PromoUC,
+		c.BalanceUC,
 		c.VPNUC,
 		c.NotifUC,
 		paymentProvider,
+		payment.NewTelegramStars(bot),
+		usecase.ReceiptSettings{
+			Enabled:        cfg.Payment.Receipt.Enabled,
+			VATCode:        cfg.Payment.Receipt.VATCode,
+			TaxSystemCode:  cfg.Payment.Receipt.TaxSystemCode,
+			PaymentSubject: cfg.Payment.Receipt.PaymentSubject,
+			PaymentMode:    cfg.Payment.Receipt.PaymentMode,
+		},
+	)
+
+	c.RenewalUC = usecase.NewAutoRenewalUseCase(
+		c.SubUC,
+		c.PaymentUC,
+		c.NotifUC,
+		time.Duration(cfg.Renewal.ChargeBeforeHours)*time.Hour,
+		cfg.Renewal.MaxAttempts,
+		time.Duration(cfg.Renewal.RetryBackoffMinutes)*time.Minute,
+	)
+
+	c.ReconUC = usecase.NewReconciliationUseCase(vpnRepo, subRepo, c.Panels, c.VPNUC, c.Notifier, cfg.Bot.AdminIDs)
+
+	reminderOffsets := make([]time.Duration, 0, len(cfg.Scheduler.ExpirationReminderHours))
+	for _, hours := range cfg.Scheduler.ExpirationReminderHours {
+		reminderOffsets = append(reminderOffsets, time.Duration(hours)*time.Hour)
+	}
+	expiryPolicy := core.ExpiryPolicy{
+		GracePeriod:   time.Duration(*cfg.Scheduler.GracePeriodHours) * time.Hour,
+		SuspendPeriod: time.Duration(cfg.Scheduler.SuspendedDeleteDays) * 24 * time.Hour,
+	}
+	c.ExpiryUC = usecase.NewExpiryUseCase(
+		subRepo,
+		reminderRepo,
+		c.VPNUC,
+		c.NotifUC,
+		reminderOffsets,
+		expiryPolicy,
+		time.Duration(cfg.Scheduler.GraceReminderIntervalHours)*time.Hour,
+		cfg.Scheduler.ExpiryBatchSize,
+	)
+
+	c.TrafficUC = usecase.NewTrafficUseCase(
+		vpnRepo,
+		subRepo,
+		planRepo,
+		c.Panels,
+		c.VPNUC,
+		c.BalanceUC,
+		c.NotifUC,
+		c.UnitOfWork,
+		cfg.Traffic.WarningThresholds,
+		usecase.TrafficAddon{GB: cfg.Traffic.AddonGB, Price: cfg.Traffic.AddonPrice},
 	)
 
 	c.Router = telegram.NewRouter(
@@ -141,9 +247,23 @@ func NewContainer(ctx context.Context, configPath string) (*Container, error) {
 		c.VPNUC,
 		c.ReferralUC,
 		c.NotifUC,
+		c.PromoUC,
+		c.BalanceUC,
+		c.ReconUC,
+		c.TrafficUC,
+		cfg.Bot.AdminIDs,
 	)
 
-	c.Scheduler = scheduler.NewScheduler(subRepo, c.VPNUC, c.NotifUC, userRepo)
+	if cfg.Payment.Webhook.Enabled {
+		c.WebhookServer = webhook.NewServer(
+			cfg.Payment.Webhook.Port,
+			cfg.Payment.Webhook.Path,
+			cfg.Payment.Webhook.Secret,
+			c.Router.PaymentHandler(),
+		)
+	}
+
+	c.Scheduler = scheduler.NewScheduler(subRepo, c.VPNUC, c.NotifUC, c.PaymentUC, c.RenewalUC, c.ReconUC, c.ExpiryUC, c.TrafficUC, userRepo, schedulerLockRepo, cfg.Scheduler)
 
 	c.Logger.Info("All components initialized successfully")
 
@@ -156,3 +276,17 @@ func (c *Container) Close() {
 		c.Logger.Info("Database connection closed")
 	}
 }
+
+func newPanelClient(server config.PanelServerConfig) ports.VPNPanel {
+	if server.Type == config.PanelType3XUI {
+
+		return xui.NewXUIRepository(server.BaseURL, server.Username, server.Password, server.InboundID, server.SubURL, server.PublicHost)
+	}
+
+	subURL := server.SubURL
+	if subURL == "" {
+		subURL = server.BaseURL
+	}
+
+	return marzban.NewPanel(marzban.NewMarzbanRepository(server.BaseURL, server.Username, server.Password), subURL)
+}
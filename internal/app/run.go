@@ -26,6 +26,15 @@ func Run(ctx context.Context, container *Container) error {
 	go container.Scheduler.Start(appCtx)
 	container.Logger.Info("Scheduler started")
 
+	if container.WebhookServer != nil {
+		go func() {
+			if err := container.WebhookServer.Start(appCtx); err != nil {
+				slog.Error("Webhook server error", "error", err)
+				cancel()
+			}
+		}()
+	}
+
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 
@@ -0,0 +1,30 @@
+package core
+
+import (
+	"time"
+)
+
+type BalanceTransaction struct {
+	ID          string    `json:"id"`
+	UserID      int64     `json:"user_id"`
+	Amount      float64   `json:"amount"`
+	Type        string    `json:"type"`
+	PaymentID   string    `json:"payment_id"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type BalanceTransactionType string
+
+const (
+	BalanceTransactionTopUp          BalanceTransactionType = "topup"
+	BalanceTransactionPayment        BalanceTransactionType = "payment"
+	BalanceTransactionReferralReward BalanceTransactionType = "referral_reward"
+	BalanceTransactionRefund         BalanceTransactionType = "refund"
+	BalanceTransactionTrafficAddon   BalanceTransactionType = "traffic_addon"
+)
+
+func (t *BalanceTransaction) IsCredit() bool {
+
+	return t.Amount > 0
+}
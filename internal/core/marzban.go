@@ -0,0 +1,155 @@
+package core
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+type MarzbanUserStatus string
+
+const (
+	MarzbanUserStatusActive   MarzbanUserStatus = "active"
+	MarzbanUserStatusDisabled MarzbanUserStatus = "disabled"
+	MarzbanUserStatusLimited  MarzbanUserStatus = "limited"
+	MarzbanUserStatusExpired  MarzbanUserStatus = "expired"
+	MarzbanUserStatusOnHold   MarzbanUserStatus = "on_hold"
+)
+
+type MarzbanNodeStatus string
+
+const (
+	MarzbanNodeStatusConnected  MarzbanNodeStatus = "connected"
+	MarzbanNodeStatusConnecting MarzbanNodeStatus = "connecting"
+	MarzbanNodeStatusError      MarzbanNodeStatus = "error"
+	MarzbanNodeStatusDisabled   MarzbanNodeStatus = "disabled"
+)
+
+type MarzbanUsersQuery struct {
+	Offset    int
+	Limit     int
+	Usernames []string
+	Search    string
+	Status    MarzbanUserStatus
+	Sort      string
+}
+
+type MarzbanUsersPage struct {
+	Users []*MarzbanUserData `json:"users"`
+	Total int                `json:"total"`
+}
+
+type MarzbanSystemStats struct {
+	Version                string  `json:"version"`
+	MemTotal               int64   `json:"mem_total"`
+	MemUsed                int64   `json:"mem_used"`
+	CPUCores               int     `json:"cpu_cores"`
+	CPUUsage               float64 `json:"cpu_usage"`
+	TotalUser              int     `json:"total_user"`
+	OnlineUsers            int     `json:"online_users"`
+	UsersActive            int     `json:"users_active"`
+	UsersOnHold            int     `json:"users_on_hold"`
+	UsersDisabled          int     `json:"users_disabled"`
+	UsersExpired           int     `json:"users_expired"`
+	UsersLimited           int     `json:"users_limited"`
+	IncomingBandwidth      int64   `json:"incoming_bandwidth"`
+	OutgoingBandwidth      int64   `json:"outgoing_bandwidth"`
+	IncomingBandwidthSpeed int64   `json:"incoming_bandwidth_speed"`
+	OutgoingBandwidthSpeed int64   `json:"outgoing_bandwidth_speed"`
+}
+
+type MarzbanPort string
+
+func (p *MarzbanPort) UnmarshalJSON(data []byte) error {
+	var number int
+	if err := json.Unmarshal(data, &number); err == nil {
+		*p = MarzbanPort(strconv.Itoa(number))
+
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+
+		return err
+	}
+	*p = MarzbanPort(text)
+
+	return nil
+}
+
+func (p MarzbanPort) Int() int {
+	port, err := strconv.Atoi(string(p))
+	if err != nil {
+
+		return 0
+	}
+
+	return port
+}
+
+type MarzbanInbound struct {
+	Tag      string      `json:"tag"`
+	Protocol string      `json:"protocol"`
+	Network  string      `json:"network"`
+	TLS      string      `json:"tls"`
+	Port     MarzbanPort `json:"port"`
+}
+
+type MarzbanHost struct {
+	Remark          string  `json:"remark"`
+	Address         string  `json:"address"`
+	Port            *int    `json:"port"`
+	SNI             *string `json:"sni"`
+	Host            *string `json:"host"`
+	Path            *string `json:"path"`
+	Security        string  `json:"security,omitempty"`
+	ALPN            string  `json:"alpn"`
+	Fingerprint     string  `json:"fingerprint"`
+	AllowInsecure   *bool   `json:"allowinsecure"`
+	IsDisabled      *bool   `json:"is_disabled"`
+	MuxEnable       *bool   `json:"mux_enable"`
+	FragmentSetting *string `json:"fragment_setting"`
+	NoiseSetting    *string `json:"noise_setting"`
+	RandomUserAgent *bool   `json:"random_user_agent"`
+	UseSNIAsHost    *bool   `json:"use_sni_as_host"`
+}
+
+type MarzbanUserTemplate struct {
+	ID             int                 `json:"id,omitempty"`
+	Name           *string             `json:"name"`
+	DataLimit      *int64              `json:"data_limit"`
+	ExpireDuration *int64              `json:"expire_duration"`
+	UsernamePrefix *string             `json:"username_prefix"`
+	UsernameSuffix *string             `json:"username_suffix"`
+	Inbounds       map[string][]string `json:"inbounds"`
+}
+
+type MarzbanUsage struct {
+	NodeID      *int   `json:"node_id"`
+	NodeName    string `json:"node_name"`
+	UsedTraffic int64  `json:"used_traffic"`
+}
+
+type MarzbanNode struct {
+	ID               int               `json:"id"`
+	Name             string            `json:"name"`
+	Address          string            `json:"address"`
+	Port             int               `json:"port"`
+	APIPort          int               `json:"api_port"`
+	UsageCoefficient float64           `json:"usage_coefficient"`
+	XrayVersion      *string           `json:"xray_version"`
+	Status           MarzbanNodeStatus `json:"status"`
+	Message          *string           `json:"message"`
+}
+
+func (n *MarzbanNode) IsConnected() bool {
+
+	return n.Status == MarzbanNodeStatusConnected
+}
+
+type MarzbanNodeUsage struct {
+	NodeID   *int   `json:"node_id"`
+	NodeName string `json:"node_name"`
+	Uplink   int64  `json:"uplink"`
+	Downlink int64  `json:"downlink"`
+}
@@ -0,0 +1,104 @@
+package core
+
+import "time"
+
+type PanelType string
+
+const (
+	PanelTypeMarzban PanelType = "marzban"
+	PanelType3XUI    PanelType = "3xui"
+)
+
+type PanelServer struct {
+	Name     string
+	Title    string
+	Flag     string
+	Region   string
+	Type     PanelType
+	Weight   int
+	Capacity int
+}
+
+func (s PanelServer) DisplayName() string {
+	title := s.Title
+	if title == "" {
+		title = s.Name
+	}
+	if s.Flag == "" {
+
+		return title
+	}
+
+	return s.Flag + " " + title
+}
+
+type ServerLocation struct {
+	Server PanelServer
+	Users  int
+}
+
+func (l ServerLocation) LoadPercent() int {
+	if l.Server.Capacity <= 0 {
+
+		return 0
+	}
+
+	return l.Users * 100 / l.Server.Capacity
+}
+
+func (l ServerLocation) IsFull() bool {
+
+	return l.Server.Capacity > 0 && l.Users >= l.Server.Capacity
+}
+
+type PanelUserStatus string
+
+const (
+	PanelUserStatusActive   PanelUserStatus = "active"
+	PanelUserStatusDisabled PanelUserStatus = "disabled"
+	PanelUserStatusLimited  PanelUserStatus = "limited"
+	PanelUserStatusExpired  PanelUserStatus = "expired"
+)
+
+type PanelUser struct {
+	Username               string
+	Status                 PanelUserStatus
+	ExpireAt               *time.Time
+	DataLimit              *int64
+	DataLimitResetStrategy DataLimitResetStrategy
+	DataUsed               *int64
+	DeviceLimit            int
+	TemplateID             int
+	Proxies                map[string]interface{}
+	Inbounds               map[string][]string
+	Note                   string
+	SubscriptionURL        string
+	Links                  []string
+	CreatedAt              *time.Time
+}
+
+func (u *PanelUser) UsagePercent() int {
+	if u.DataLimit == nil || *u.DataLimit <= 0 || u.DataUsed == nil {
+
+		return 0
+	}
+
+	return int(*u.DataUsed * 100 / *u.DataLimit)
+}
+
+type PanelUsersPage struct {
+	Users []*PanelUser
+	Total int
+}
+
+func (u *PanelUser) IsActive() bool {
+
+	return u.Status == PanelUserStatusActive
+}
+
+type PanelInbound struct {
+	ID       int
+	Tag      string
+	Protocol string
+	Port     int
+}
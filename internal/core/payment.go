@@ -5,24 +5,100 @@ import (
 )
 
 type Payment struct {
-	ID            string    `json:"id"`
-	UserID        int64     `json:"user_id"`
-	Amount        float64   `json:"amount"`
-	Currency      string    `json:"currency"`
-	PaymentMethod string    `json:"payment_method"`
-	Description   string    `json:"description"`
-	Status        string    `json:"status"`
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
+	ID             string    `json:"id"`
+	UserID         int64     `json:"user_id"`
+	PlanID         string    `json:"plan_id"`
+	SubscriptionID string    `json:"subscription_id"`
+	Amount         float64   `json:"amount"`
+	Currency       string    `json:"currency"`
+	PaymentMethod  string    `json:"payment_method"`
+	Purpose        string    `json:"purpose"`
+	ExternalID     string    `json:"external_id"`
+	IdempotencyKey string    `json:"idempotency_key"`
+	PromoCodeID    string    `json:"promo_code_id"`
+	DiscountAmount float64   `json:"discount_amount"`
+	BonusDays      int       `json:"bonus_days"`
+	Description    string    `json:"description"`
+	Status         string    `json:"status"`
+	ReceiptEmail   string    `json:"receipt_email"`
+	ReceiptStatus  string    `json:"receipt_status"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
 }
 
+type PaymentRefund struct {
+	ID               string    `json:"id"`
+	PaymentID        string    `json:"payment_id"`
+	AdminID          int64     `json:"admin_id"`
+	Amount           float64   `json:"amount"`
+	Reason           string    `json:"reason"`
+	ExternalRefundID string    `json:"external_refund_id"`
+	Status           string    `json:"status"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+type RefundStatus string
+
+const (
+	RefundStatusPending   RefundStatus = "pending"
+	RefundStatusSucceeded RefundStatus = "succeeded"
+)
+
+func (r *PaymentRefund) IsPending() bool {
+
+	return r.Status == string(RefundStatusPending)
+}
+
+type SavedPaymentMethod struct {
+	UserID           int64     `json:"user_id"`
+	ProviderMethodID string    `json:"provider_method_id"`
+	Method           string    `json:"method"`
+	Title            string    `json:"title"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+type ReceiptStatus string
+
+const (
+	ReceiptStatusPending   ReceiptStatus = "pending"
+	ReceiptStatusSucceeded ReceiptStatus = "succeeded"
+	ReceiptStatusCanceled  ReceiptStatus = "canceled"
+)
+
 type PaymentStatus string
 
 const (
-	PaymentStatusPending   PaymentStatus = "pending"
-	PaymentStatusCompleted PaymentStatus = "completed"
-	PaymentStatusFailed    PaymentStatus = "failed"
-	PaymentStatusCancelled PaymentStatus = "cancelled"
+	PaymentStatusPending           PaymentStatus = "pending"
+	PaymentStatusWaitingForCapture PaymentStatus = "waiting_for_capture"
+	PaymentStatusCompleted         PaymentStatus = "completed"
+	PaymentStatusFailed            PaymentStatus = "failed"
+	PaymentStatusCancelled         PaymentStatus = "cancelled"
+	PaymentStatusRefunded          PaymentStatus = "refunded"
+	PaymentStatusPartiallyRefunded PaymentStatus = "partially_refunded"
+	PaymentStatusExpired           PaymentStatus = "expired"
+)
+
+const (
+	CurrencyRUB   = "RUB"
+	CurrencyStars = "XTR"
+)
+
+type PaymentMethod string
+
+const (
+	PaymentMethodCard    PaymentMethod = "card"
+	PaymentMethodSBP     PaymentMethod = "sbp"
+	PaymentMethodStars   PaymentMethod = "stars"
+	PaymentMethodBalance PaymentMethod = "balance"
+)
+
+type PaymentPurpose string
+
+const (
+	PaymentPurposeSubscription PaymentPurpose = "subscription"
+	PaymentPurposeExtension    PaymentPurpose = "extension"
+	PaymentPurposeTopUp        PaymentPurpose = "topup"
 )
 
 func (p *Payment) IsPending() bool {
@@ -44,3 +120,33 @@ func (p *Payment) IsCancelled() bool {
 
 	return p.Status == string(PaymentStatusCancelled)
 }
+
+func (p *Payment) IsRefunded() bool {
+
+	return p.Status == string(PaymentStatusRefunded)
+}
+
+func (p *Payment) IsExpired() bool {
+
+	return p.Status == string(PaymentStatusExpired)
+}
+
+func (p *Payment) IsPartiallyRefunded() bool {
+
+	return p.Status == string(PaymentStatusPartiallyRefunded)
+}
+
+func (p *Payment) IsTopUp() bool {
+
+	return p.Purpose == string(PaymentPurposeTopUp)
+}
+
+func (p *Payment) IsExtension() bool {
+
+	return p.Purpose == string(PaymentPurposeExtension)
+}
+
+func (p *Payment) IsRefundable() bool {
+
+	return p.IsCompleted() || p.IsPartiallyRefunded()
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"math"
+	"time"
+)
+
+type PromoCode struct {
+	ID             string     `json:"id"`
+	Code           string     `json:"code"`
+	DiscountType   string     `json:"discount_type"`
+	DiscountValue  float64    `json:"discount_value"`
+	BonusDays      int        `json:"bonus_days"`
+	MaxUses        int        `json:"max_uses"`
+	MaxUsesPerUser int        `json:"max_uses_per_user"`
+	UsedCount      int        `json:"used_count"`
+	PlanIDs        []string   `json:"plan_ids"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	IsActive       bool       `json:"is_active"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+type PromoCodeUsage struct {
+	ID          string    `json:"id"`
+	PromoCodeID string    `json:"promo_code_id"`
+	UserID      int64     `json:"user_id"`
+	PaymentID   string    `json:"payment_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type PromoDiscountType string
+
+const (
+	PromoDiscountPercent PromoDiscountType = "percent"
+	PromoDiscountFixed   PromoDiscountType = "fixed"
+)
+
+func (p *PromoCode) IsExpired() bool {
+
+	return p.ExpiresAt != nil && time.Now().After(*p.ExpiresAt)
+}
+
+func (p *PromoCode) IsExhausted() bool {
+
+	return p.MaxUses > 0 && p.UsedCount >= p.MaxUses
+}
+
+func (p *PromoCode) AppliesToPlan(planID string) bool {
+	if len(p.PlanIDs) == 0 {
+
+		return true
+	}
+
+	for _, id := range p.PlanIDs {
+		if id == planID {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *PromoCode) Discount(price float64) float64 {
+	var discount float64
+	switch PromoDiscountType(p.DiscountType) {
+	case PromoDiscountPercent:
+		discount = price * p.DiscountValue / 100
+	case PromoDiscountFixed:
+		discount = p.DiscountValue
+	}
+
+	discount = math.Round(discount*100) / 100
+	if discount > price {
+
+		return price
+	}
+	if discount < 0 {
+
+		return 0
+	}
+
+	return discount
+}
@@ -0,0 +1,86 @@
+package core
+
+import "time"
+
+type ReconciliationIssueType string
+
+const (
+	IssueOrphanPanelUser    ReconciliationIssueType = "orphan_panel_user"
+	IssueDanglingConnection ReconciliationIssueType = "dangling_connection"
+	IssueExpireDrift        ReconciliationIssueType = "expire_drift"
+	IssueStatusDrift        ReconciliationIssueType = "status_drift"
+)
+
+type ReconciliationAction string
+
+const (
+	ActionNone            ReconciliationAction = "none"
+	ActionDeletePanelUser ReconciliationAction = "delete_panel_user"
+	ActionRecreateUser    ReconciliationAction = "recreate_panel_user"
+	ActionDeleteRow       ReconciliationAction = "delete_connection"
+	ActionUpdateExpire    ReconciliationAction = "update_expire"
+	ActionEnableUser      ReconciliationAction = "enable_panel_user"
+	ActionDisableUser     ReconciliationAction = "disable_panel_user"
+	ActionUpdateRowStatus ReconciliationAction = "update_connection_status"
+)
+
+type ReconciliationIssue struct {
+	Type           ReconciliationIssueType
+	Action         ReconciliationAction
+	ServerName     string
+	Username       string
+	VPNID          string
+	SubscriptionID string
+	Detail         string
+	Fixed          bool
+	Error          string
+}
+
+type ReconciliationReport struct {
+	DryRun       bool
+	StartedAt    time.Time
+	FinishedAt   time.Time
+	Servers      int
+	PanelUsers   int
+	Connections  int
+	Issues       []ReconciliationIssue
+	ServerErrors map[string]string
+}
+
+func (r *ReconciliationReport) Count(issueType ReconciliationIssueType) int {
+	count := 0
+	for _, issue := range r.Issues {
+		if issue.Type == issueType {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (r *ReconciliationReport) FixedCount() int {
+	count := 0
+	for _, issue := range r.Issues {
+		if issue.Fixed {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (r *ReconciliationReport) FailedCount() int {
+	count := 0
+	for _, issue := range r.Issues {
+		if issue.Error != "" {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (r *ReconciliationReport) IsClean() bool {
+
+	return len(r.Issues) == 0 && len(r.ServerErrors) == 0
+}
@@ -5,15 +5,21 @@ import (
 )
 
 type Subscription struct {
-	ID        string    `json:"id"`
-	UserID    int64     `json:"user_id"`
-	Name      string    `json:"name"`
-	PlanID    string    `json:"plan_id"`
-	StartDate time.Time `json:"start_date"`
-	EndDate   time.Time `json:"end_date"`
-	IsActive  bool      `json:"is_active"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID              string             `json:"id"`
+	UserID          int64              `json:"user_id"`
+	Name            string             `json:"name"`
+	PlanID          string             `json:"plan_id"`
+	StartDate       time.Time          `json:"start_date"`
+	EndDate         time.Time          `json:"end_date"`
+	IsActive        bool               `json:"is_active"`
+	AutoRenew       bool               `json:"auto_renew"`
+	RenewalAttempts int                `json:"renewal_attempts"`
+	NextRenewalAt   *time.Time         `json:"next_renewal_at"`
+	Status          SubscriptionStatus `json:"status"`
+	GraceEndsAt     *time.Time         `json:"grace_ends_at"`
+	SuspendedAt     *time.Time         `json:"suspended_at"`
+	CreatedAt       time.Time          `json:"created_at"`
+	UpdatedAt       time.Time          `json:"updated_at"`
 }
 
 func (s *Subscription) IsExpired() bool {
@@ -48,9 +54,19 @@ func (s *Subscription) GetDisplayName() string {
 }
 
 func (s *Subscription) GetStatusText() string {
-	if !s.IsActive {
+	switch s.GetStatus() {
+	case StatusInactive:
 
 		return "Неактивна"
+	case StatusGrace:
+
+		return "Льготный период"
+	case StatusSuspended:
+
+		return "Приостановлена"
+	case StatusExpired:
+
+		return "Истекла"
 	}
 	if s.IsExpired() {
 
@@ -65,27 +81,168 @@ func (s *Subscription) Extend(days int) {
 	s.UpdatedAt = time.Now()
 }
 
+func (s *Subscription) ResetRenewalState() {
+	s.RenewalAttempts = 0
+	s.NextRenewalAt = nil
+}
+
 func (s *Subscription) GetStatus() SubscriptionStatus {
+	if s.Status != "" {
+
+		return s.Status
+	}
 	if !s.IsActive {
 
 		return StatusInactive
 	}
-	if s.IsExpired() {
 
-		return StatusExpired
+	return StatusActive
+}
+
+func (s *Subscription) HasAccess() bool {
+	switch s.GetStatus() {
+	case StatusActive:
+
+		return !s.IsExpired()
+	case StatusGrace:
+
+		return s.GraceEndsAt != nil && time.Now().Before(*s.GraceEndsAt)
+	default:
+
+		return false
 	}
+}
 
-	return StatusActive
+func (s *Subscription) AccessEndsAt() time.Time {
+	if s.GetStatus() == StatusGrace && s.GraceEndsAt != nil {
+
+		return *s.GraceEndsAt
+	}
+
+	return s.EndDate
+}
+
+func (s *Subscription) DeletionAt(policy ExpiryPolicy) (time.Time, bool) {
+	if s.GetStatus() != StatusSuspended || s.SuspendedAt == nil || policy.SuspendPeriod <= 0 {
+
+		return time.Time{}, false
+	}
+
+	return s.SuspendedAt.Add(policy.SuspendPeriod), true
+}
+
+func (s *Subscription) NextStatus(now time.Time, policy ExpiryPolicy) SubscriptionStatus {
+	status := s.GetStatus()
+	switch status {
+	case StatusActive:
+		if now.Before(s.EndDate) {
+
+			return StatusActive
+		}
+		if policy.GracePeriod > 0 && now.Before(s.EndDate.Add(policy.GracePeriod)) {
+
+			return StatusGrace
+		}
+
+		return StatusSuspended
+	case StatusGrace:
+		if s.GraceEndsAt != nil && now.Before(*s.GraceEndsAt) {
+
+			return StatusGrace
+		}
+
+		return StatusSuspended
+	case StatusSuspended:
+		if deleteAt, ok := s.DeletionAt(policy); ok && !now.Before(deleteAt) {
+
+			return StatusExpired
+		}
+
+		return StatusSuspended
+	default:
+
+		return status
+	}
+}
+
+func (s *Subscription) CanTransition(to SubscriptionStatus) bool {
+	from := s.GetStatus()
+	if from == to {
+
+		return true
+	}
+
+	for _, allowed := range subscriptionTransitions[from] {
+		if allowed == to {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Subscription) Transition(to SubscriptionStatus, now time.Time, policy ExpiryPolicy) bool {
+	if !s.CanTransition(to) {
+
+		return false
+	}
+
+	switch to {
+	case StatusActive:
+		s.IsActive = true
+		s.GraceEndsAt = nil
+		s.SuspendedAt = nil
+	case StatusGrace:
+		graceEndsAt := s.EndDate.Add(policy.GracePeriod)
+		s.IsActive = true
+		s.GraceEndsAt = &graceEndsAt
+		s.SuspendedAt = nil
+	case StatusSuspended:
+		s.IsActive = false
+		s.GraceEndsAt = nil
+		s.SuspendedAt = &now
+	case StatusExpired, StatusInactive:
+		s.IsActive = false
+		s.GraceEndsAt = nil
+	}
+	s.Status = to
+	s.UpdatedAt = now
+
+	return true
+}
+
+func (s *Subscription) Activate(now time.Time) {
+	s.Transition(StatusActive, now, ExpiryPolicy{})
+}
+
+func (s *Subscription) Deactivate(now time.Time) {
+	s.Transition(StatusInactive, now, ExpiryPolicy{})
 }
 
 type SubscriptionStatus string
 
 const (
-	StatusActive   SubscriptionStatus = "active"
-	StatusExpired  SubscriptionStatus = "expired"
-	StatusInactive SubscriptionStatus = "inactive"
+	StatusActive    SubscriptionStatus = "active"
+	StatusGrace     SubscriptionStatus = "grace"
+	StatusSuspended SubscriptionStatus = "suspended"
+	StatusExpired   SubscriptionStatus = "expired"
+	StatusInactive  SubscriptionStatus = "inactive"
 )
 
+var subscriptionTransitions = map[SubscriptionStatus][]SubscriptionStatus{
+	StatusActive:    {StatusGrace, StatusSuspended, StatusInactive},
+	StatusGrace:     {StatusActive, StatusSuspended, StatusInactive},
+	StatusSuspended: {StatusActive, StatusExpired, StatusInactive},
+	StatusExpired:   {StatusActive, StatusInactive},
+	StatusInactive:  {StatusActive},
+}
+
+type ExpiryPolicy struct {
+	GracePeriod   time.Duration
+	SuspendPeriod time.Duration
+}
+
 type SubscriptionPeriod struct {
 	StartDate time.Time `json:"start_date"`
 	EndDate   time.Time `json:"end_date"`
@@ -101,13 +258,118 @@ func (p *SubscriptionPeriod) Duration() int {
 	return int(p.EndDate.Sub(p.StartDate).Hours() / 24)
 }
 
+type DataLimitResetStrategy string
+
+const (
+	DataLimitResetNone  DataLimitResetStrategy = "no_reset"
+	DataLimitResetDay   DataLimitResetStrategy = "day"
+	DataLimitResetWeek  DataLimitResetStrategy = "week"
+	DataLimitResetMonth DataLimitResetStrategy = "month"
+	DataLimitResetYear  DataLimitResetStrategy = "year"
+)
+
+func (s DataLimitResetStrategy) Period() time.Duration {
+	switch s {
+	case DataLimitResetDay:
+
+		return 24 * time.Hour
+	case DataLimitResetWeek:
+
+		return 7 * 24 * time.Hour
+	case DataLimitResetMonth:
+
+		return 30 * 24 * time.Hour
+	case DataLimitResetYear:
+
+		return 365 * 24 * time.Hour
+	default:
+
+		return 0
+	}
+}
+
+func (s DataLimitResetStrategy) NextReset(anchor, now time.Time) (time.Time, bool) {
+	period := s.Period()
+	if period == 0 || anchor.IsZero() {
+
+		return time.Time{}, false
+	}
+	if anchor.After(now) {
+
+		return anchor.Add(period), true
+	}
+
+	periods := now.Sub(anchor)/period + 1
+
+	return anchor.Add(periods * period), true
+}
+
 type Plan struct {
-	ID          string  `json:"id"`
-	Name        string  `json:"name"`
-	Description string  `json:"description"`
-	Price       float64 `json:"price"`
-	Days        int     `json:"days"`
-	IsActive    bool    `json:"is_active"`
+	ID                     string                 `json:"id"`
+	Name                   string                 `json:"name"`
+	Description            string                 `json:"description"`
+	Price                  float64                `json:"price"`
+	StarsPrice             int                    `json:"stars_price"`
+	Days                   int                    `json:"days"`
+	IsActive               bool                   `json:"is_active"`
+	TrafficLimitGB         int                    `json:"traffic_limit_gb"`
+	DataLimitResetStrategy DataLimitResetStrategy `json:"data_limit_reset_strategy"`
+	Protocols              []string               `json:"protocols"`
+	InboundTags            []string               `json:"inbound_tags"`
+	DeviceLimit            int                    `json:"device_limit"`
+	MarzbanTemplateID      int                    `json:"marzban_template_id"`
+}
+
+func (p *Plan) DataLimitBytes() *int64 {
+	if p.TrafficLimitGB <= 0 {
+
+		return nil
+	}
+
+	limit := int64(p.TrafficLimitGB) * 1024 * 1024 * 1024
+
+	return &limit
+}
+
+func (p *Plan) ResetStrategy() DataLimitResetStrategy {
+	if p.DataLimitResetStrategy == "" {
+
+		return DataLimitResetNone
+	}
+
+	return p.DataLimitResetStrategy
+}
+
+func (p *Plan) AllowsProtocol(protocol string) bool {
+	if len(p.Protocols) == 0 {
+
+		return true
+	}
+
+	for _, allowed := range p.Protocols {
+		if allowed == protocol {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *Plan) AllowsInbound(tag string) bool {
+	if len(p.InboundTags) == 0 {
+
+		return true
+	}
+
+	for _, allowed := range p.InboundTags {
+		if allowed == tag {
+
+			return true
+		}
+	}
+
+	return false
 }
 
 func (p *Plan) GetPricePerDay() float64 {
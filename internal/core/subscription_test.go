@@ -0,0 +1,83 @@
+package core_test
+
+import (
+	"testing"
+	"time"
+
+	"3xui-bot/internal/core"
+)
+
+var testGracePolicy = core.ExpiryPolicy{GracePeriod: 72 * time.Hour, SuspendPeriod: 14 * 24 * time.Hour}
+
+func TestSubscriptionStatusTransitions(t *testing.T) {
+	now := time.Now()
+	suspendedAt := now.Add(-15 * 24 * time.Hour)
+	graceEndsAt := now.Add(time.Hour)
+	graceEnded := now.Add(-time.Minute)
+
+	cases := []struct {
+		name   string
+		sub    core.Subscription
+		policy core.ExpiryPolicy
+		want   core.SubscriptionStatus
+	}{
+		{"active before end", core.Subscription{IsActive: true, EndDate: now.Add(time.Hour)}, testGracePolicy, core.StatusActive},
+		{"active after end enters grace", core.Subscription{IsActive: true, EndDate: now.Add(-time.Hour)}, testGracePolicy, core.StatusGrace},
+		{"active without grace suspends", core.Subscription{IsActive: true, EndDate: now.Add(-time.Hour)}, core.ExpiryPolicy{}, core.StatusSuspended},
+		{"active long after end skips grace", core.Subscription{IsActive: true, EndDate: now.Add(-100 * time.Hour)}, testGracePolicy, core.StatusSuspended},
+		{"grace in progress", core.Subscription{Status: core.StatusGrace, IsActive: true, GraceEndsAt: &graceEndsAt}, testGracePolicy, core.StatusGrace},
+		{"grace over", core.Subscription{Status: core.StatusGrace, IsActive: true, GraceEndsAt: &graceEnded}, testGracePolicy, core.StatusSuspended},
+		{"suspended waiting", core.Subscription{Status: core.StatusSuspended, SuspendedAt: &now}, testGracePolicy, core.StatusSuspended},
+		{"suspended long enough", core.Subscription{Status: core.StatusSuspended, SuspendedAt: &suspendedAt}, testGracePolicy, core.StatusExpired},
+		{"suspended kept without deletion", core.Subscription{Status: core.StatusSuspended, SuspendedAt: &suspendedAt}, core.ExpiryPolicy{}, core.StatusSuspended},
+		{"inactive stays", core.Subscription{IsActive: false, EndDate: now.Add(-time.Hour)}, testGracePolicy, core.StatusInactive},
+		{"expired stays", core.Subscription{Status: core.StatusExpired}, testGracePolicy, core.StatusExpired},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.sub.NextStatus(now, tc.policy); got != tc.want {
+				t.Errorf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+
+	forbidden := []struct {
+		from core.SubscriptionStatus
+		to   core.SubscriptionStatus
+	}{
+		{core.StatusActive, core.StatusExpired},
+		{core.StatusGrace, core.StatusExpired},
+		{core.StatusExpired, core.StatusGrace},
+		{core.StatusExpired, core.StatusSuspended},
+		{core.StatusInactive, core.StatusGrace},
+		{core.StatusInactive, core.StatusSuspended},
+	}
+	for _, tc := range forbidden {
+		sub := core.Subscription{Status: tc.from}
+		if sub.Transition(tc.to, now, testGracePolicy) {
+			t.Errorf("expected transition %s -> %s to be rejected", tc.from, tc.to)
+		}
+		if sub.GetStatus() != tc.from {
+			t.Errorf("rejected transition changed status to %s", sub.GetStatus())
+		}
+	}
+
+	sub := core.Subscription{IsActive: true, EndDate: now.Add(-time.Hour)}
+	if !sub.Transition(core.StatusGrace, now, testGracePolicy) || !sub.IsActive || sub.GraceEndsAt == nil || !sub.GraceEndsAt.Equal(sub.EndDate.Add(72*time.Hour)) {
+		t.Fatalf("unexpected grace state: %+v", sub)
+	}
+	if !sub.HasAccess() || !sub.AccessEndsAt().Equal(*sub.GraceEndsAt) {
+		t.Errorf("expected access until the end of grace, got %v", sub.AccessEndsAt())
+	}
+	if !sub.Transition(core.StatusSuspended, now, testGracePolicy) || sub.IsActive || sub.HasAccess() || sub.SuspendedAt == nil || sub.GraceEndsAt != nil {
+		t.Fatalf("unexpected suspended state: %+v", sub)
+	}
+	if deleteAt, ok := sub.DeletionAt(testGracePolicy); !ok || !deleteAt.Equal(now.Add(14*24*time.Hour)) {
+		t.Errorf("unexpected deletion time %v", deleteAt)
+	}
+	sub.Activate(now)
+	if sub.GetStatus() != core.StatusActive || !sub.IsActive || sub.SuspendedAt != nil {
+		t.Errorf("expected renewal to reactivate subscription, got %+v", sub)
+	}
+}
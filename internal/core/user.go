@@ -12,6 +12,7 @@ type User struct {
 	LanguageCode string    `json:"language_code" db:"language_code"`
 	IsBlocked    bool      `json:"is_blocked" db:"is_blocked"`
 	HasTrial     bool      `json:"has_trial" db:"has_trial"`
+	Email        string    `json:"email" db:"email"`
 	CreatedAt    time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
 }
@@ -8,17 +8,25 @@ import (
 type VPNConnection struct {
 	ID              string    `json:"id" db:"id"`
 	TelegramUserID  int64     `json:"telegram_user_id" db:"telegram_user_id"`
+	SubscriptionID  string    `json:"subscription_id" db:"subscription_id"`
 	MarzbanUsername string    `json:"marzban_username" db:"marzban_username"`
+	ServerName      string    `json:"server_name" db:"server_name"`
 	Name            string    `json:"name" db:"name"`
 	IsActive        bool      `json:"is_active" db:"is_active"`
 	CreatedAt       time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt       time.Time `json:"updated_at" db:"updated_at"`
 
-	ExpireAt       *time.Time             `json:"expire_at,omitempty"`
-	DataLimitBytes *int64                 `json:"data_limit_bytes,omitempty"`
-	DataUsedBytes  *int64                 `json:"data_used_bytes,omitempty"`
-	Status         string                 `json:"status,omitempty"`
-	ProtocolConfig map[string]interface{} `json:"protocol_config,omitempty"`
+	TrafficWarningPercent int        `json:"traffic_warning_percent" db:"traffic_warning_percent"`
+	TrafficAddonBytes     int64      `json:"traffic_addon_bytes" db:"traffic_addon_bytes"`
+	TrafficAddonExpiresAt *time.Time `json:"traffic_addon_expires_at,omitempty" db:"traffic_addon_expires_at"`
+
+	ExpireAt        *time.Time             `json:"expire_at,omitempty"`
+	DataLimitBytes  *int64                 `json:"data_limit_bytes,omitempty"`
+	DataUsedBytes   *int64                 `json:"data_used_bytes,omitempty"`
+	Status          string                 `json:"status,omitempty"`
+	ProtocolConfig  map[string]interface{} `json:"protocol_config,omitempty"`
+	SubscriptionURL string                 `json:"subscription_url,omitempty"`
+	Links           []string               `json:"links,omitempty"`
 }
 
 func (v *VPNConnection) GetDisplayName() string {
@@ -123,21 +131,25 @@ func formatBytes(bytes int64) string {
 }
 
 type MarzbanUserData struct {
-	Username         string                 `json:"username"`
-	Expire           *int64                 `json:"expire"`
-	DataLimit        *int64                 `json:"data_limit"`
-	DataUsed         *int64                 `json:"data_used"`
-	Status           string                 `json:"status"`
-	Proxies          map[string]interface{} `json:"proxies"`
-	Inbounds         map[string][]string    `json:"inbounds"`
-	Note             string                 `json:"note"`
-	SubUpdatedAt     *string                `json:"sub_updated_at"`
-	SubLastUserAgent *string                `json:"sub_last_user_agent"`
-	OnlineAt         *string                `json:"online_at"`
-	OnHoldTimeout    *string                `json:"on_hold_timeout"`
-	CreatedAt        *string                `json:"created_at"`
-	Links            []string               `json:"links"`
-	SubscriptionURL  string                 `json:"subscription_url"`
+	Username               string                 `json:"username"`
+	Expire                 *int64                 `json:"expire"`
+	DataLimit              *int64                 `json:"data_limit"`
+	DataLimitResetStrategy string                 `json:"data_limit_reset_strategy,omitempty"`
+	DataUsed               *int64                 `json:"used_traffic,omitempty"`
+	LifetimeUsedTraffic    *int64                 `json:"lifetime_used_traffic,omitempty"`
+	Status                 string                 `json:"status"`
+	Proxies                map[string]interface{} `json:"proxies"`
+	Inbounds               map[string][]string    `json:"inbounds"`
+	Note                   string                 `json:"note"`
+	OnHoldExpireDuration   *int64                 `json:"on_hold_expire_duration,omitempty"`
+	AutoDeleteInDays       *int                   `json:"auto_delete_in_days,omitempty"`
+	SubUpdatedAt           *string                `json:"sub_updated_at"`
+	SubLastUserAgent       *string                `json:"sub_last_user_agent"`
+	OnlineAt               *string                `json:"online_at"`
+	OnHoldTimeout          *string                `json:"on_hold_timeout"`
+	CreatedAt              *string                `json:"created_at"`
+	Links                  []string               `json:"links"`
+	SubscriptionURL        string                 `json:"subscription_url"`
 }
 
 func (m *MarzbanUserData) IsExpired() bool {
@@ -221,6 +233,22 @@ func (m *MarzbanUserData) GetExpireText() string {
 	return time.Unix(*m.Expire, 0).Format("02.01.2006 15:04")
 }
 
+func (m *MarzbanUserData) CreatedTime() *time.Time {
+	if m.CreatedAt == nil || *m.CreatedAt == "" {
+
+		return nil
+	}
+
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999", "2006-01-02T15:04:05"} {
+		if parsed, err := time.Parse(layout, *m.CreatedAt); err == nil {
+
+			return &parsed
+		}
+	}
+
+	return nil
+}
+
 func (m *MarzbanUserData) ExpireAt() *time.Time {
 	if m.Expire == nil || *m.Expire == 0 {
 
@@ -15,8 +15,13 @@ import (
 type Config struct {
 	Bot       BotConfig       `json:"bot"`
 	DB        DBConfig        `json:"db"`
+	Panel     PanelConfig     `json:"panel"`
 	Marzban   MarzbanConfig   `json:"marzban"`
+	XUI       XUIConfig       `json:"xui"`
 	Payment   PaymentConfig   `json:"payment"`
+	Referral  ReferralConfig  `json:"referral"`
+	Renewal   RenewalConfig   `json:"renewal"`
+	Traffic   TrafficConfig   `json:"traffic"`
 	Scheduler SchedulerConfig `json:"scheduler"`
 	Logging   LoggingConfig   `json:"logging"`
 }
@@ -40,17 +45,126 @@ type DBConfig struct {
 	SSLMode  string `json:"sslmode"`
 }
 
+type PanelConfig struct {
+	Type            string              `json:"type"`
+	SelectionPolicy string              `json:"selection_policy"`
+	PreferredRegion string              `json:"preferred_region"`
+	Servers         []PanelServerConfig `json:"servers"`
+}
+
+type PanelServerConfig struct {
+	Name           string `json:"name"`
+	Title          string `json:"title"`
+	Flag           string `json:"flag"`
+	Region         string `json:"region"`
+	Type           string `json:"type"`
+	BaseURL        string `json:"base_url"`
+	CredentialsEnv string `json:"credentials_env"`
+	Username       string `json:"-"`
+	Password       string `json:"-"`
+	InboundID      int    `json:"inbound_id"`
+	SubURL         string `json:"sub_url"`
+	PublicHost     string `json:"public_host"`
+	Weight         int    `json:"weight"`
+	Capacity       int    `json:"capacity"`
+}
+
+const (
+	PanelTypeMarzban = "marzban"
+	PanelType3XUI    = "3xui"
+)
+
+const (
+	PanelSelectionLeastUsers = "least_users"
+	PanelSelectionRegion     = "region"
+	PanelSelectionRoundRobin = "round_robin"
+)
+
+const (
+	defaultPanelServerName   = "default"
+	maxPanelServerNameLength = 16
+)
+
 type MarzbanConfig struct {
 	BaseURL  string `json:"base_url"`
-	Username string `env:"MARZBAN_USERNAME,required"`
-	Password string `env:"MARZBAN_PASSWORD,required"`
+	SubURL   string `json:"sub_url"`
+	Username string `env:"MARZBAN_USERNAME"`
+	Password string `env:"MARZBAN_PASSWORD"`
+}
+
+type XUIConfig struct {
+	BaseURL    string `json:"base_url"`
+	Username   string `env:"XUI_USERNAME"`
+	Password   string `env:"XUI_PASSWORD"`
+	InboundID  int    `json:"inbound_id"`
+	SubURL     string `json:"sub_url"`
+	PublicHost string `json:"public_host"`
 }
 
 type PaymentConfig struct {
+	Provider  string `json:"provider"`
+	APIURL    string `json:"api_url"`
+	ReturnURL string `json:"return_url"`
+	ShopID    string `env:"PAYMENT_SHOP_ID"`
+	SecretKey string `env:"PAYMENT_SECRET_KEY"`
+
+	Webhook WebhookConfig `json:"webhook"`
+	Receipt ReceiptConfig `json:"receipt"`
+}
+
+type ReceiptConfig struct {
+	Enabled        bool   `json:"enabled"`
+	VATCode        int    `json:"vat_code"`
+	TaxSystemCode  int    `json:"tax_system_code"`
+	PaymentSubject string `json:"payment_subject"`
+	PaymentMode    string `json:"payment_mode"`
+}
+
+type WebhookConfig struct {
+	Enabled bool   `json:"enabled"`
+	Port    string `json:"port"`
+	Path    string `json:"path"`
+	Secret  string `env:"PAYMENT_WEBHOOK_SECRET"`
+}
+
+const (
+	PaymentProviderMock     = "mock"
+	PaymentProviderYooKassa = "yookassa"
+)
+
+type ReferralConfig struct {
+	RewardPercent float64 `json:"reward_percent"`
+}
+
+type RenewalConfig struct {
+	ChargeBeforeHours   int `json:"charge_before_hours"`
+	MaxAttempts         int `json:"max_attempts"`
+	RetryBackoffMinutes int `json:"retry_backoff_minutes"`
+}
+
+type TrafficConfig struct {
+	WarningThresholds []int   `json:"warning_thresholds"`
+	AddonGB           int     `json:"addon_gb"`
+	AddonPrice        float64 `json:"addon_price"`
 }
 
 type SchedulerConfig struct {
-	Enabled bool `json:"enabled"`
+	Enabled                     bool   `json:"enabled"`
+	InstanceID                  string `json:"instance_id"`
+	PaymentCheckIntervalMinutes int    `json:"payment_check_interval_minutes"`
+	PendingPaymentMinAgeMinutes int    `json:"pending_payment_min_age_minutes"`
+	PendingPaymentTTLMinutes    int    `json:"pending_payment_ttl_minutes"`
+	RenewalCheckIntervalMinutes int    `json:"renewal_check_interval_minutes"`
+	ReconcileIntervalMinutes    int    `json:"reconcile_interval_minutes"`
+	ReconcileApplyFixes         bool   `json:"reconcile_apply_fixes"`
+	ExpiryCheckIntervalMinutes  int    `json:"expiry_check_interval_minutes"`
+	ExpiryBatchSize             int    `json:"expiry_batch_size"`
+	ExpirationReminderHours     []int  `json:"expiration_reminder_hours"`
+	GracePeriodHours            *int   `json:"grace_period_hours"`
+	GraceReminderIntervalHours  int    `json:"grace_reminder_interval_hours"`
+	SuspendedDeleteDays         int    `json:"suspended_delete_days"`
+	NotificationRetentionDays   int    `json:"notification_retention_days"`
+	TrafficCheckIntervalMinutes int    `json:"traffic_check_interval_minutes"`
 }
 
 type LoggingConfig struct {
@@ -83,6 +197,7 @@ func Load(configPath string) (*Config, error) {
 	}
 
 	normalize(cfg)
+	resolvePanelServers(cfg)
 
 	if err := validateRequired(cfg); err != nil {
 
@@ -97,23 +212,172 @@ func Load(configPath string) (*Config, error) {
 func normalize(cfg *Config) {
 	cfg.Marzban.BaseURL = strings.TrimSpace(cfg.Marzban.BaseURL)
 	cfg.Marzban.BaseURL = strings.TrimRight(cfg.Marzban.BaseURL, "/")
+	cfg.Marzban.SubURL = strings.TrimRight(strings.TrimSpace(cfg.Marzban.SubURL), "/")
+
+	cfg.Panel.Type = strings.TrimSpace(strings.ToLower(cfg.Panel.Type))
+	cfg.XUI.BaseURL = strings.TrimRight(strings.TrimSpace(cfg.XUI.BaseURL), "/")
+	cfg.XUI.SubURL = strings.TrimRight(strings.TrimSpace(cfg.XUI.SubURL), "/")
+	cfg.XUI.PublicHost = strings.TrimSpace(cfg.XUI.PublicHost)
+	cfg.Panel.SelectionPolicy = strings.TrimSpace(strings.ToLower(cfg.Panel.SelectionPolicy))
+	cfg.Panel.PreferredRegion = strings.TrimSpace(cfg.Panel.PreferredRegion)
+	for i := range cfg.Panel.Servers {
+		server := &cfg.Panel.Servers[i]
+		server.Name = strings.TrimSpace(strings.ToLower(server.Name))
+		server.Title = strings.TrimSpace(server.Title)
+		server.Flag = strings.TrimSpace(server.Flag)
+		server.Region = strings.TrimSpace(server.Region)
+		server.Type = strings.TrimSpace(strings.ToLower(server.Type))
+		server.BaseURL = strings.TrimRight(strings.TrimSpace(server.BaseURL), "/")
+		server.CredentialsEnv = strings.TrimSpace(strings.ToUpper(server.CredentialsEnv))
+		server.SubURL = strings.TrimRight(strings.TrimSpace(server.SubURL), "/")
+		server.PublicHost = strings.TrimSpace(server.PublicHost)
+	}
 
 	cfg.DB.Host = strings.TrimSpace(cfg.DB.Host)
 	cfg.DB.Port = strings.TrimSpace(cfg.DB.Port)
 	cfg.DB.Database = strings.TrimSpace(cfg.DB.Database)
 	cfg.DB.SSLMode = strings.TrimSpace(strings.ToLower(cfg.DB.SSLMode))
 
+	cfg.Payment.Provider = strings.TrimSpace(strings.ToLower(cfg.Payment.Provider))
+	cfg.Payment.APIURL = strings.TrimRight(strings.TrimSpace(cfg.Payment.APIURL), "/")
+	cfg.Payment.ReturnURL = strings.TrimSpace(cfg.Payment.ReturnURL)
+	cfg.Payment.Webhook.Port = strings.TrimSpace(cfg.Payment.Webhook.Port)
+	cfg.Payment.Webhook.Path = strings.TrimSpace(cfg.Payment.Webhook.Path)
+	cfg.Payment.Receipt.PaymentSubject = strings.TrimSpace(strings.ToLower(cfg.Payment.Receipt.PaymentSubject))
+	cfg.Payment.Receipt.PaymentMode = strings.TrimSpace(strings.ToLower(cfg.Payment.Receipt.PaymentMode))
+
 	cfg.Logging.Level = strings.TrimSpace(strings.ToLower(cfg.Logging.Level))
 	cfg.Bot.SupportUsername = strings.TrimSpace(cfg.Bot.SupportUsername)
 }
 
-func validateRequired(cfg *Config) error {
+func resolvePanelServers(cfg *Config) {
+	if len(cfg.Panel.Servers) == 0 {
+		switch cfg.Panel.Type {
+		case PanelType3XUI:
+			cfg.Panel.Servers = []PanelServerConfig{{
+				Name:           defaultPanelServerName,
+				Type:           PanelType3XUI,
+				BaseURL:        cfg.XUI.BaseURL,
+				CredentialsEnv: "XUI",
+				Username:       cfg.XUI.Username,
+				Password:       cfg.XUI.Password,
+				InboundID:      cfg.XUI.InboundID,
+				SubURL:         cfg.XUI.SubURL,
+				PublicHost:     cfg.XUI.PublicHost,
+			}}
+		case "", PanelTypeMarzban:
+			cfg.Panel.Servers = []PanelServerConfig{{
+				Name:           defaultPanelServerName,
+				Type:           PanelTypeMarzban,
+				BaseURL:        cfg.Marzban.BaseURL,
+				SubURL:         cfg.Marzban.SubURL,
+				CredentialsEnv: "MARZBAN",
+				Username:       cfg.Marzban.Username,
+				Password:       cfg.Marzban.Password,
+			}}
+		}
+
+		return
+	}
+
+	for i := range cfg.Panel.Servers {
+		server := &cfg.Panel.Servers[i]
+		if server.Type == "" {
+			server.Type = cfg.Panel.Type
+		}
+		if server.CredentialsEnv != "" {
+			server.Username = os.Getenv(server.CredentialsEnv + "_USERNAME")
+			server.Password = os.Getenv(server.CredentialsEnv + "_PASSWORD")
+		}
+	}
+}
+
+func validatePanelServers(cfg *Config) []string {
 	var errs []string
 
-	if cfg.Marzban.BaseURL == "" {
-		errs = append(errs, "marzban.base_url is required (set in JSON)")
+	switch cfg.Panel.Type {
+	case "", PanelTypeMarzban, PanelType3XUI:
+	default:
+		errs = append(errs, fmt.Sprintf("panel.type %q is not supported", cfg.Panel.Type))
+	}
+
+	switch cfg.Panel.SelectionPolicy {
+	case "", PanelSelectionLeastUsers, PanelSelectionRoundRobin:
+	case PanelSelectionRegion:
+		if cfg.Panel.PreferredRegion == "" {
+			errs = append(errs, "panel.preferred_region is required for region selection policy")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("panel.selection_policy %q is not supported", cfg.Panel.SelectionPolicy))
+	}
+
+	seen := make(map[string]bool)
+	for i, server := range cfg.Panel.Servers {
+		name := server.Name
+		if name == "" {
+			errs = append(errs, fmt.Sprintf("panel.servers[%d].name is required", i))
+			name = fmt.Sprintf("#%d", i)
+		} else if seen[name] {
+			errs = append(errs, fmt.Sprintf("panel server %q is defined more than once", name))
+		} else if !isValidPanelServerName(name) {
+			errs = append(errs, fmt.Sprintf("panel server name %q must be up to %d characters of a-z, 0-9 and -", name, maxPanelServerNameLength))
+		}
+		seen[name] = true
+
+		if server.BaseURL == "" {
+			errs = append(errs, fmt.Sprintf("base_url is required for panel server %q (set in JSON)", name))
+		}
+		if server.Username == "" || server.Password == "" {
+			errs = append(errs, fmt.Sprintf("%s is required for panel server %q (set in env)", credentialsHint(server), name))
+		}
+		if server.Weight < 0 || server.Capacity < 0 {
+			errs = append(errs, fmt.Sprintf("weight and capacity must not be negative for panel server %q", name))
+		}
+
+		switch server.Type {
+		case PanelTypeMarzban:
+		case PanelType3XUI:
+			if server.InboundID < 0 {
+				errs = append(errs, fmt.Sprintf("inbound_id must not be negative for panel server %q", name))
+			}
+		default:
+			errs = append(errs, fmt.Sprintf("type %q is not supported for panel server %q", server.Type, name))
+		}
 	}
 
+	return errs
+}
+
+func isValidPanelServerName(name string) bool {
+	if len(name) > maxPanelServerNameLength {
+
+		return false
+	}
+
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+
+			return false
+		}
+	}
+
+	return true
+}
+
+func credentialsHint(server PanelServerConfig) string {
+	if server.CredentialsEnv == "" {
+
+		return "credentials_env"
+	}
+
+	return server.CredentialsEnv + "_USERNAME and " + server.CredentialsEnv + "_PASSWORD"
+}
+
+func validateRequired(cfg *Config) error {
+	var errs []string
+
+	errs = append(errs, validatePanelServers(cfg)...)
+
 	if cfg.DB.Host == "" {
 		errs = append(errs, "db.host is required (set in JSON)")
 	}
@@ -121,6 +385,49 @@ func validateRequired(cfg *Config) error {
 		errs = append(errs, "db.database is required (set in JSON)")
 	}
 
+	switch cfg.Payment.Provider {
+	case "", PaymentProviderMock:
+	case PaymentProviderYooKassa:
+		if cfg.Payment.ShopID == "" || cfg.Payment.SecretKey == "" {
+			errs = append(errs, "PAYMENT_SHOP_ID and PAYMENT_SECRET_KEY are required for yookassa provider (set in env)")
+		}
+		if cfg.Payment.ReturnURL == "" {
+			errs = append(errs, "payment.return_url is required for yookassa provider (set in JSON)")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("payment.provider %q is not supported", cfg.Payment.Provider))
+	}
+
+	if cfg.Payment.Webhook.Enabled && cfg.Payment.Webhook.Secret == "" {
+		errs = append(errs, "PAYMENT_WEBHOOK_SECRET is required when payment.webhook is enabled (set in env)")
+	}
+	if cfg.Payment.Webhook.Path != "" && !strings.HasPrefix(cfg.Payment.Webhook.Path, "/") {
+		errs = append(errs, "payment.webhook.path must start with /")
+	}
+
+	if cfg.Payment.Receipt.Enabled {
+		if cfg.Payment.Receipt.VATCode < 0 || cfg.Payment.Receipt.VATCode > 12 {
+			errs = append(errs, "payment.receipt.vat_code must be between 1 and 12")
+		}
+		if cfg.Payment.Receipt.TaxSystemCode < 0 || cfg.Payment.Receipt.TaxSystemCode > 6 {
+			errs = append(errs, "payment.receipt.tax_system_code must be between 1 and 6 (0 to omit)")
+		}
+	}
+
+	if cfg.Referral.RewardPercent < 0 || cfg.Referral.RewardPercent > 100 {
+		errs = append(errs, "referral.reward_percent must be between 0 and 100")
+	}
+
+	if cfg.Renewal.ChargeBeforeHours < 0 || cfg.Renewal.MaxAttempts < 0 || cfg.Renewal.RetryBackoffMinutes < 0 {
+		errs = append(errs, "renewal settings must not be negative")
+	}
+
+	errs = append(errs, validateSchedulerIntervals(cfg.Scheduler)...)
+
+	if (cfg.Scheduler.GracePeriodHours != nil && *cfg.Scheduler.GracePeriodHours < 0) || cfg.Scheduler.GraceReminderIntervalHours < 0 || cfg.Scheduler.SuspendedDeleteDays < 0 {
+		errs = append(errs, "scheduler grace settings must not be negative (grace_period_hours 0 disables the grace period)")
+	}
+
 	if len(errs) > 0 {
 
 		return errors.New("invalid config: " + strings.Join(errs, "; "))
@@ -129,7 +436,41 @@ func validateRequired(cfg *Config) error {
 	return nil
 }
 
+func validateSchedulerIntervals(cfg SchedulerConfig) []string {
+	var errs []string
+
+	intervals := []struct {
+		name    string
+		minutes int
+	}{
+		{"payment_check_interval_minutes", cfg.PaymentCheckIntervalMinutes},
+		{"renewal_check_interval_minutes", cfg.RenewalCheckIntervalMinutes},
+		{"reconcile_interval_minutes", cfg.ReconcileIntervalMinutes},
+		{"expiry_check_interval_minutes", cfg.ExpiryCheckIntervalMinutes},
+		{"traffic_check_interval_minutes", cfg.TrafficCheckIntervalMinutes},
+	}
+	for _, interval := range intervals {
+		if interval.minutes < 0 {
+			errs = append(errs, fmt.Sprintf("scheduler.%s must not be negative (0 uses the default)", interval.name))
+		}
+	}
+
+	return errs
+}
+
 func applyDefaults(cfg *Config) {
+	if cfg.Panel.Type == "" {
+		cfg.Panel.Type = PanelTypeMarzban
+	}
+	if cfg.Panel.SelectionPolicy == "" {
+		cfg.Panel.SelectionPolicy = PanelSelectionLeastUsers
+	}
+	for i := range cfg.Panel.Servers {
+		if cfg.Panel.Servers[i].Weight == 0 {
+			cfg.Panel.Servers[i].Weight = 1
+		}
+	}
+
 	if cfg.Bot.Timeout == 0 {
 		cfg.Bot.Timeout = 30
 	}
@@ -153,6 +494,85 @@ func applyDefaults(cfg *Config) {
 		cfg.DB.SSLMode = "disable"
 	}
 
+	if cfg.Payment.Provider == "" {
+		cfg.Payment.Provider = PaymentProviderMock
+	}
+	if cfg.Payment.APIURL == "" {
+		cfg.Payment.APIURL = "https://api.yookassa.ru/v3"
+	}
+	if cfg.Payment.Webhook.Port == "" {
+		cfg.Payment.Webhook.Port = "8080"
+	}
+	if cfg.Payment.Webhook.Path == "" {
+		cfg.Payment.Webhook.Path = "/webhooks/payment"
+	}
+	if cfg.Payment.Receipt.VATCode == 0 {
+		cfg.Payment.Receipt.VATCode = 1
+	}
+	if cfg.Payment.Receipt.PaymentSubject == "" {
+		cfg.Payment.Receipt.PaymentSubject = "service"
+	}
+	if cfg.Payment.Receipt.PaymentMode == "" {
+		cfg.Payment.Receipt.PaymentMode = "full_payment"
+	}
+
+	if cfg.Scheduler.PaymentCheckIntervalMinutes == 0 {
+		cfg.Scheduler.PaymentCheckIntervalMinutes = 5
+	}
+	if cfg.Scheduler.PendingPaymentMinAgeMinutes == 0 {
+		cfg.Scheduler.PendingPaymentMinAgeMinutes = 2
+	}
+	if cfg.Scheduler.PendingPaymentTTLMinutes == 0 {
+		cfg.Scheduler.PendingPaymentTTLMinutes = 60
+	}
+
+	if cfg.Scheduler.RenewalCheckIntervalMinutes == 0 {
+		cfg.Scheduler.RenewalCheckIntervalMinutes = 15
+	}
+	if cfg.Scheduler.ReconcileIntervalMinutes == 0 {
+		cfg.Scheduler.ReconcileIntervalMinutes = 360
+	}
+
+	if cfg.Scheduler.ExpiryCheckIntervalMinutes == 0 {
+		cfg.Scheduler.ExpiryCheckIntervalMinutes = 10
+	}
+	if cfg.Scheduler.ExpiryBatchSize == 0 {
+		cfg.Scheduler.ExpiryBatchSize = 100
+	}
+	if len(cfg.Scheduler.ExpirationReminderHours) == 0 {
+		cfg.Scheduler.ExpirationReminderHours = []int{72, 24, 3}
+	}
+	if cfg.Scheduler.GracePeriodHours == nil {
+		gracePeriodHours := 72
+		cfg.Scheduler.GracePeriodHours = &gracePeriodHours
+	}
+	if cfg.Scheduler.GraceReminderIntervalHours == 0 {
+		cfg.Scheduler.GraceReminderIntervalHours = 24
+	}
+	if cfg.Scheduler.SuspendedDeleteDays == 0 {
+		cfg.Scheduler.SuspendedDeleteDays = 14
+	}
+	if cfg.Scheduler.NotificationRetentionDays == 0 {
+		cfg.Scheduler.NotificationRetentionDays = 90
+	}
+	if cfg.Scheduler.TrafficCheckIntervalMinutes == 0 {
+		cfg.Scheduler.TrafficCheckIntervalMinutes = 30
+	}
+
+	if len(cfg.Traffic.WarningThresholds) == 0 {
+		cfg.Traffic.WarningThresholds = []int{80, 95}
+	}
+
+	if cfg.Renewal.ChargeBeforeHours == 0 {
+		cfg.Renewal.ChargeBeforeHours = 24
+	}
+	if cfg.Renewal.MaxAttempts == 0 {
+		cfg.Renewal.MaxAttempts = 4
+	}
+	if cfg.Renewal.RetryBackoffMinutes == 0 {
+		cfg.Renewal.RetryBackoffMinutes = 60
+	}
+
 	if cfg.Logging.Level == "" {
 		cfg.Logging.Level = "info"
 	}
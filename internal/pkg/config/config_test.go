@@ -0,0 +1,99 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"3xui-bot/internal/pkg/config"
+)
+
+const testEnv = "BOT_TOKEN=token\nDB_USER=bot\nDB_PASSWORD=secret\nMARZBAN_USERNAME=admin\nMARZBAN_PASSWORD=admin\n"
+
+func loadConfig(t *testing.T, scheduler string) (*config.Config, error) {
+	t.Helper()
+
+	dir := t.TempDir()
+	body := `{"db":{"host":"localhost","database":"bot"},"marzban":{"base_url":"https://panel.example.com"},"scheduler":{` + scheduler + `}}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(body), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".env"), []byte(testEnv), 0o600); err != nil {
+		t.Fatalf("failed to write env: %v", err)
+	}
+	t.Chdir(dir)
+
+	return config.Load(filepath.Join(dir, "config.json"))
+}
+
+func TestLoadRejectsNegativeSchedulerIntervals(t *testing.T) {
+	for _, name := range []string{
+		"payment_check_interval_minutes",
+		"renewal_check_interval_minutes",
+		"reconcile_interval_minutes",
+		"expiry_check_interval_minutes",
+		"traffic_check_interval_minutes",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := loadConfig(t, `"`+name+`":-5`)
+			if err == nil || !strings.Contains(err.Error(), "scheduler."+name) {
+				t.Errorf("expected %s to be rejected, got %v", name, err)
+			}
+		})
+	}
+}
+
+func TestLoadDefaultsSchedulerIntervals(t *testing.T) {
+	cfg, err := loadConfig(t, `"traffic_check_interval_minutes":0`)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+
+	intervals := []int{
+		cfg.Scheduler.PaymentCheckIntervalMinutes,
+		cfg.Scheduler.RenewalCheckIntervalMinutes,
+		cfg.Scheduler.ReconcileIntervalMinutes,
+		cfg.Scheduler.ExpiryCheckIntervalMinutes,
+		cfg.Scheduler.TrafficCheckIntervalMinutes,
+	}
+	for i, minutes := range intervals {
+		if minutes <= 0 {
+			t.Errorf("expected interval %d to get a positive default, got %d", i, minutes)
+		}
+	}
+}
+
+func TestLoadGracePeriodHours(t *testing.T) {
+	tests := []struct {
+		name      string
+		scheduler string
+		want      int
+	}{
+		{name: "unset uses default", scheduler: `"enabled":true`, want: 72},
+		{name: "zero disables grace", scheduler: `"grace_period_hours":0`, want: 0},
+		{name: "explicit value", scheduler: `"grace_period_hours":12`, want: 12},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadConfig(t, tt.scheduler)
+			if err != nil {
+				t.Fatalf("Load returned error: %v", err)
+			}
+			if cfg.Scheduler.GracePeriodHours == nil || *cfg.Scheduler.GracePeriodHours != tt.want {
+				t.Errorf("expected grace_period_hours %d, got %v", tt.want, cfg.Scheduler.GracePeriodHours)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsNegativeGraceSettings(t *testing.T) {
+	for _, name := range []string{"grace_period_hours", "grace_reminder_interval_hours", "suspended_delete_days"} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := loadConfig(t, `"`+name+`":-1`); err == nil || !strings.Contains(err.Error(), "grace settings") {
+				t.Errorf("expected negative %s to be rejected, got %v", name, err)
+			}
+		})
+	}
+}
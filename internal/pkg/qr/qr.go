@@ -0,0 +1,23 @@
+package qr
+
+import (
+	"fmt"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const DefaultSize = 512
+
+func PNG(content string, size int) ([]byte, error) {
+	if size <= 0 {
+		size = DefaultSize
+	}
+
+	image, err := qrcode.Encode(content, qrcode.Medium, size)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to encode QR code: %w", err)
+	}
+
+	return image, nil
+}
@@ -2,6 +2,7 @@ package ports
 
 import (
 	"context"
+	"time"
 
 	"3xui-bot/internal/core"
 )
@@ -9,17 +10,51 @@ import (
 type Marzban interface {
 	Authenticate(ctx context.Context) error
 
-	GetInbounds(ctx context.Context) ([]map[string]interface{}, error)
+	GetInbounds(ctx context.Context) (map[string][]core.MarzbanInbound, error)
 
 	CreateUser(ctx context.Context, user *core.MarzbanUserData) (*core.MarzbanUserData, error)
 
 	GetUser(ctx context.Context, username string) (*core.MarzbanUserData, error)
 
+	GetUsers(ctx context.Context, query core.MarzbanUsersQuery) (*core.MarzbanUsersPage, error)
+
 	UpdateUser(ctx context.Context, username string, user *core.MarzbanUserData) (*core.MarzbanUserData, error)
 
 	DeleteUser(ctx context.Context, username string) error
 
 	ResetUserTraffic(ctx context.Context, username string) error
 
-	GetStats(ctx context.Context) (map[string]interface{}, error)
+	RevokeSubscription(ctx context.Context, username string) (*core.MarzbanUserData, error)
+
+	ActivateNextPlan(ctx context.Context, username string) (*core.MarzbanUserData, error)
+
+	SetOwner(ctx context.Context, username, adminUsername string) (*core.MarzbanUserData, error)
+
+	GetUserUsage(ctx context.Context, username string, start, end time.Time) ([]core.MarzbanUsage, error)
+
+	GetUsersUsage(ctx context.Context, start, end time.Time) ([]core.MarzbanUsage, error)
+
+	GetExpiredUsers(ctx context.Context, expiredAfter, expiredBefore *time.Time) ([]string, error)
+
+	DeleteExpiredUsers(ctx context.Context, expiredAfter, expiredBefore *time.Time) ([]string, error)
+
+	GetUserTemplates(ctx context.Context, offset, limit int) ([]core.MarzbanUserTemplate, error)
+
+	GetUserTemplate(ctx context.Context, templateID int) (*core.MarzbanUserTemplate, error)
+
+	CreateUserTemplate(ctx context.Context, template *core.MarzbanUserTemplate) (*core.MarzbanUserTemplate, error)
+
+	UpdateUserTemplate(ctx context.Context, templateID int, template *core.MarzbanUserTemplate) (*core.MarzbanUserTemplate, error)
+
+	DeleteUserTemplate(ctx context.Context, templateID int) error
+
+	GetNodes(ctx context.Context) ([]core.MarzbanNode, error)
+
+	GetNodesUsage(ctx context.Context, start, end time.Time) ([]core.MarzbanNodeUsage, error)
+
+	GetHosts(ctx context.Context) (map[string][]core.MarzbanHost, error)
+
+	ModifyHosts(ctx context.Context, hosts map[string][]core.MarzbanHost) (map[string][]core.MarzbanHost, error)
+
+	GetStats(ctx context.Context) (*core.MarzbanSystemStats, error)
 }
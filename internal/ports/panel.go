@@ -0,0 +1,33 @@
+package ports
+
+import (
+	"context"
+
+	"3xui-bot/internal/core"
+)
+
+type VPNPanel interface {
+	Type() core.PanelType
+
+	GetInbounds(ctx context.Context) ([]core.PanelInbound, error)
+
+	CreateUser(ctx context.Context, user *core.PanelUser) (*core.PanelUser, error)
+
+	GetUser(ctx context.Context, username string) (*core.PanelUser, error)
+
+	ListUsers(ctx context.Context, offset, limit int) (*core.PanelUsersPage, error)
+
+	UpdateUser(ctx context.Context, username string, user *core.PanelUser) (*core.PanelUser, error)
+
+	DeleteUser(ctx context.Context, username string) error
+
+	ResetUserTraffic(ctx context.Context, username string) error
+}
+
+type PanelRegistry interface {
+	Servers() []core.PanelServer
+
+	DefaultServer() string
+
+	Panel(serverName string) (VPNPanel, error)
+}
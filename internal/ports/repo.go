@@ -2,6 +2,7 @@ package ports
 
 import (
 	"context"
+	"time"
 
 	"3xui-bot/internal/core"
 )
@@ -20,9 +21,19 @@ type SubscriptionRepo interface {
 	GetSubscriptionsByUserID(ctx context.Context, userID int64) ([]*core.Subscription, error)
 	GetActiveSubscriptionByUserID(ctx context.Context, userID int64) (*core.Subscription, error)
 	UpdateSubscription(ctx context.Context, subscription *core.Subscription) error
+	GetSubscriptionsDueForRenewal(ctx context.Context, chargeBefore, now time.Time) ([]*core.Subscription, error)
+	GetExpiredActiveSubscriptions(ctx context.Context, now time.Time, afterID string, limit int) ([]*core.Subscription, error)
+	GetSubscriptionsExpiringBetween(ctx context.Context, from, to time.Time) ([]*core.Subscription, error)
+	GetSuspendedSubscriptions(ctx context.Context, suspendedBefore time.Time, afterID string, limit int) ([]*core.Subscription, error)
+	UpdateSubscriptionStatus(ctx context.Context, subscription *core.Subscription, from core.SubscriptionStatus) (bool, error)
 	DeleteSubscription(ctx context.Context, id string) error
 }
 
+type SubscriptionReminderRepo interface {
+	MarkReminderSent(ctx context.Context, subscriptionID string, offsetHours int, endDate time.Time) (bool, error)
+	DeleteRemindersBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
 type PlanRepo interface {
 	GetPlanByID(ctx context.Context, id string) (*core.Plan, error)
 	GetAll(ctx context.Context) ([]*core.Plan, error)
@@ -31,11 +42,50 @@ type PlanRepo interface {
 type PaymentRepo interface {
 	CreatePayment(ctx context.Context, payment *core.Payment) error
 	GetPaymentByID(ctx context.Context, id string) (*core.Payment, error)
+	GetPaymentByIDForUpdate(ctx context.Context, id string) (*core.Payment, error)
+	GetPaymentByExternalID(ctx context.Context, externalID string) (*core.Payment, error)
 	GetPaymentsByUserID(ctx context.Context, userID int64) ([]*core.Payment, error)
+	GetPendingPaymentsOlderThan(ctx context.Context, cutoff time.Time) ([]*core.Payment, error)
+	CountPendingPaymentsBySubscriptionID(ctx context.Context, subscriptionID string) (int, error)
+	UpdatePayment(ctx context.Context, payment *core.Payment) error
 	UpdatePaymentStatus(ctx context.Context, id, status string) error
+	UpdateReceipt(ctx context.Context, id, email, status string) error
 	DeletePayment(ctx context.Context, id string) error
 }
 
+type PaymentRefundRepo interface {
+	CreateRefund(ctx context.Context, refund *core.PaymentRefund) error
+	UpdateRefund(ctx context.Context, refund *core.PaymentRefund) error
+	GetRefundByID(ctx context.Context, id string) (*core.PaymentRefund, error)
+	GetPendingRefundByPaymentID(ctx context.Context, paymentID string) (*core.PaymentRefund, error)
+	GetRefundsByPaymentID(ctx context.Context, paymentID string) ([]*core.PaymentRefund, error)
+	GetTotalRefundedAmount(ctx context.Context, paymentID string) (float64, error)
+}
+
+type SavedPaymentMethodRepo interface {
+	SaveMethod(ctx context.Context, method *core.SavedPaymentMethod) error
+	GetMethodByUserID(ctx context.Context, userID int64) (*core.SavedPaymentMethod, error)
+	DeleteMethod(ctx context.Context, userID int64) error
+}
+
+type BalanceRepo interface {
+	CreateTransaction(ctx context.Context, transaction *core.BalanceTransaction) error
+	GetBalance(ctx context.Context, userID int64) (float64, error)
+	GetBalanceForUpdate(ctx context.Context, userID int64) (float64, error)
+	GetTransactionsByUserID(ctx context.Context, userID int64, limit int) ([]*core.BalanceTransaction, error)
+}
+
+type PromoCodeRepo interface {
+	CreatePromoCode(ctx context.Context, promo *core.PromoCode) error
+	GetPromoCodeByCode(ctx context.Context, code string) (*core.PromoCode, error)
+	GetPromoCodeByIDForUpdate(ctx context.Context, id string) (*core.PromoCode, error)
+	GetPromoCodes(ctx context.Context) ([]*core.PromoCode, error)
+	UpdatePromoCode(ctx context.Context, promo *core.PromoCode) error
+	CreatePromoCodeUsage(ctx context.Context, usage *core.PromoCodeUsage) error
+	CountUserPromoCodeUsages(ctx context.Context, promoCodeID string, userID int64) (int, error)
+	CountPendingPromoCodePayments(ctx context.Context, promoCodeID string, userID int64) (int, int, error)
+}
+
 type ReferralRepo interface {
 	CreateReferral(ctx context.Context, referral *core.Referral) error
 	GetReferralByID(ctx context.Context, id int64) (*core.Referral, error)
@@ -56,12 +106,20 @@ type VPNRepo interface {
 	CreateVPNConnection(ctx context.Context, conn *core.VPNConnection) error
 	GetVPNConnectionsByTelegramUserID(ctx context.Context, telegramUserID int64) ([]*core.VPNConnection, error)
 	GetVPNConnectionsBySubscriptionID(ctx context.Context, subscriptionID string) ([]*core.VPNConnection, error)
+	GetAllVPNConnections(ctx context.Context) ([]*core.VPNConnection, error)
 	GetVPNConnectionByID(ctx context.Context, id string) (*core.VPNConnection, error)
 	GetVPNConnectionByMarzbanUsername(ctx context.Context, marzbanUsername string) (*core.VPNConnection, error)
 	UpdateVPNConnectionName(ctx context.Context, id, name string) error
 	DeleteVPNConnection(ctx context.Context, id string) error
 	DeleteVPNConnectionByMarzbanUsername(ctx context.Context, marzbanUsername string) error
 	GetActiveVPNConnections(ctx context.Context, telegramUserID int64) ([]*core.VPNConnection, error)
+	UpdateVPNConnectionStatus(ctx context.Context, id string, isActive bool) error
+	UpdateVPNConnectionServer(ctx context.Context, id, serverName string) error
+	UpdateVPNConnectionTrafficWarning(ctx context.Context, id string, percent int) error
+	AddVPNConnectionTrafficAddon(ctx context.Context, id string, bytes int64, expiresAt *time.Time) error
+	ClearVPNConnectionTrafficAddon(ctx context.Context, id string) error
+	CountVPNConnectionsByServer(ctx context.Context) (map[string]int, error)
+	GetExpiredActiveVPNConnections(ctx context.Context, now time.Time, afterID string, limit int) ([]*core.VPNConnection, error)
 }
 
 type NotificationRepo interface {
@@ -72,4 +130,11 @@ type NotificationRepo interface {
 	UpdateNotification(ctx context.Context, notification *core.Notification) error
 	MarkAsRead(ctx context.Context, id string) error
 	DeleteNotification(ctx context.Context, id string) error
+	DeleteReadNotificationsOlderThan(ctx context.Context, before time.Time) (int64, error)
+}
+
+type SchedulerLockRepo interface {
+	AcquireLock(ctx context.Context, jobName, holder string, ttl, minInterval time.Duration) (bool, error)
+	RenewLock(ctx context.Context, jobName, holder string, ttl time.Duration) (bool, error)
+	ReleaseLock(ctx context.Context, jobName, holder string) error
 }
@@ -2,73 +2,206 @@ package scheduler
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"os"
 	"time"
 
+	"3xui-bot/internal/pkg/config"
+	"3xui-bot/internal/pkg/id"
 	"3xui-bot/internal/ports"
 	"3xui-bot/internal/usecase"
 )
 
+const (
+	lockTTL               = 2 * time.Minute
+	lockHeartbeatInterval = 30 * time.Second
+)
+
 type Scheduler struct {
-	subRepo  ports.SubscriptionRepo
-	vpnUC    *usecase.VPNUseCase
-	notifUC  *usecase.NotificationUseCase
-	userRepo ports.UserRepo
+	subRepo   ports.SubscriptionRepo
+	vpnUC     *usecase.VPNUseCase
+	notifUC   *usecase.NotificationUseCase
+	paymentUC *usecase.PaymentUseCase
+	renewalUC *usecase.AutoRenewalUseCase
+	reconUC   *usecase.ReconciliationUseCase
+	expiryUC  *usecase.ExpiryUseCase
+	trafficUC *usecase.TrafficUseCase
+	userRepo  ports.UserRepo
+	locks     ports.SchedulerLockRepo
+	holder    string
+	cfg       config.SchedulerConfig
 }
 
 func NewScheduler(
 	subRepo ports.SubscriptionRepo,
 	vpnUC *usecase.VPNUseCase,
 	notifUC *usecase.NotificationUseCase,
+	paymentUC *usecase.PaymentUseCase,
+	renewalUC *usecase.AutoRenewalUseCase,
+	reconUC *usecase.ReconciliationUseCase,
+	expiryUC *usecase.ExpiryUseCase,
+	trafficUC *usecase.TrafficUseCase,
 	userRepo ports.UserRepo,
+	locks ports.SchedulerLockRepo,
+	cfg config.SchedulerConfig,
 ) *Scheduler {
+	holder := cfg.InstanceID
+	if holder == "" {
+		hostname, _ := os.Hostname()
+		holder = fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), id.GenerateShort())
+	}
 
 	return &Scheduler{
-		subRepo:  subRepo,
-		vpnUC:    vpnUC,
-		notifUC:  notifUC,
-		userRepo: userRepo,
+		subRepo:   subRepo,
+		vpnUC:     vpnUC,
+		notifUC:   notifUC,
+		paymentUC: paymentUC,
+		renewalUC: renewalUC,
+		reconUC:   reconUC,
+		expiryUC:  expiryUC,
+		trafficUC: trafficUC,
+		userRepo:  userRepo,
+		locks:     locks,
+		holder:    holder,
+		cfg:       cfg,
 	}
 }
 
 func (s *Scheduler) Start(ctx context.Context) {
-	slog.Info("Starting scheduler...")
+	slog.Info("Starting scheduler...", "holder", s.holder)
+
+	go s.runPeriodically(ctx, "check_expired_subscriptions", s.expiryInterval(), s.CheckExpiredSubscriptions)
+
+	go s.runPeriodically(ctx, "send_expiration_notifications", s.expiryInterval(), s.SendExpirationNotifications)
+
+	go s.runPeriodically(ctx, "deactivate_expired_vpns", 6*time.Hour, s.DeactivateExpiredVPNs)
+
+	go s.runPeriodically(ctx, "clean_old_data", 24*time.Hour, s.CleanOldData)
 
-	go s.runPeriodically(ctx, time.Hour, s.CheckExpiredSubscriptions)
+	go s.runPeriodically(ctx, "reconcile_pending_payments", time.Duration(s.cfg.PaymentCheckIntervalMinutes)*time.Minute, s.ReconcilePendingPayments)
 
-	go s.runPeriodically(ctx, 6*time.Hour, s.SendExpirationNotifications)
+	go s.runPeriodically(ctx, "process_auto_renewals", time.Duration(s.cfg.RenewalCheckIntervalMinutes)*time.Minute, s.ProcessAutoRenewals)
 
-	go s.runPeriodically(ctx, 6*time.Hour, s.DeactivateExpiredVPNs)
+	go s.runPeriodically(ctx, "reconcile_vpn_connections", time.Duration(s.cfg.ReconcileIntervalMinutes)*time.Minute, s.ReconcileVPNConnections)
+
+	go s.runPeriodically(ctx, "check_traffic_usage", time.Duration(s.cfg.TrafficCheckIntervalMinutes)*time.Minute, s.CheckTrafficUsage)
 
 	slog.Info("Scheduler started successfully")
 }
 
-func (s *Scheduler) runPeriodically(ctx context.Context, interval time.Duration, fn func(context.Context) error) {
+func (s *Scheduler) runPeriodically(ctx context.Context, name string, interval time.Duration, fn func(context.Context) error) {
+	if interval <= 0 {
+		slog.Error("Scheduled job has no positive interval, not starting", "job", name, "interval", interval)
+
+		return
+	}
+
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	if err := fn(ctx); err != nil {
-		slog.Error("Error in scheduled job", "error", err)
+	s.runExclusive(ctx, name, interval, fn)
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Stopping scheduled job...", "job", name)
+
+			return
+		case <-ticker.C:
+			s.runExclusive(ctx, name, interval, fn)
+		}
+	}
+}
+
+func (s *Scheduler) runExclusive(ctx context.Context, name string, interval time.Duration, fn func(context.Context) error) {
+	if s.locks == nil {
+		if err := fn(ctx); err != nil {
+			slog.Error("Error in scheduled job", "job", name, "error", err)
+		}
+
+		return
+	}
+
+	acquired, err := s.locks.AcquireLock(ctx, name, s.holder, lockTTL, interval/2)
+	if err != nil {
+		slog.Error("Failed to acquire scheduler lock, skipping tick", "job", name, "error", err)
+
+		return
+	}
+	if !acquired {
+		slog.Debug("Scheduled job is held or was just run by another replica", "job", name)
+
+		return
 	}
+	defer s.release(name)
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.heartbeat(jobCtx, cancel, name, done)
+
+	if err := fn(jobCtx); err != nil {
+		slog.Error("Error in scheduled job", "job", name, "error", err)
+	}
+}
+
+func (s *Scheduler) heartbeat(ctx context.Context, cancel context.CancelFunc, name string, done <-chan struct{}) {
+	ticker := time.NewTicker(lockHeartbeatInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
+		case <-done:
+
+			return
 		case <-ctx.Done():
-			slog.Info("Stopping scheduled job...")
 
 			return
 		case <-ticker.C:
-			if err := fn(ctx); err != nil {
-				slog.Error("Error in scheduled job", "error", err)
+			renewed, err := s.locks.RenewLock(ctx, name, s.holder, lockTTL)
+			if err != nil || !renewed {
+				slog.Error("Lost scheduler lock, cancelling job", "job", name, "error", err)
+				cancel()
+
+				return
 			}
 		}
 	}
 }
 
+func (s *Scheduler) release(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.locks.ReleaseLock(ctx, name, s.holder); err != nil {
+		slog.Error("Failed to release scheduler lock", "job", name, "error", err)
+	}
+}
+
+func (s *Scheduler) expiryInterval() time.Duration {
+
+	return time.Duration(s.cfg.ExpiryCheckIntervalMinutes) * time.Minute
+}
+
 func (s *Scheduler) CheckExpiredSubscriptions(ctx context.Context) error {
 	slog.Info("Checking expired subscriptions...")
 
-	slog.Info("Expired subscriptions check completed")
+	expired, err := s.expiryUC.ExpireSubscriptions(ctx)
+	if err != nil {
+
+		return err
+	}
+
+	deleted, err := s.expiryUC.DeleteSuspendedSubscriptions(ctx)
+	if err != nil {
+
+		return err
+	}
+
+	slog.Info("Expired subscriptions check completed", "transitions", expired, "deleted", deleted)
 
 	return nil
 }
@@ -76,7 +209,13 @@ func (s *Scheduler) CheckExpiredSubscriptions(ctx context.Context) error {
 func (s *Scheduler) SendExpirationNotifications(ctx context.Context) error {
 	slog.Info("Sending expiration notifications...")
 
-	slog.Info("Expiration notifications sent")
+	sent, err := s.expiryUC.SendExpirationReminders(ctx)
+	if err != nil {
+
+		return err
+	}
+
+	slog.Info("Expiration notifications sent", "sent", sent)
 
 	return nil
 }
@@ -84,12 +223,69 @@ func (s *Scheduler) SendExpirationNotifications(ctx context.Context) error {
 func (s *Scheduler) DeactivateExpiredVPNs(ctx context.Context) error {
 	slog.Info("Deactivating expired VPNs...")
 
-	if err := s.vpnUC.DeactivateExpiredVPNs(ctx); err != nil {
+	deactivated, err := s.vpnUC.DeactivateExpiredVPNs(ctx, s.expiryUC.Policy().GracePeriod, s.cfg.ExpiryBatchSize)
+	if err != nil {
+
+		return err
+	}
+
+	slog.Info("Expired VPNs deactivated", "deactivated", deactivated)
+
+	return nil
+}
+
+func (s *Scheduler) ReconcilePendingPayments(ctx context.Context) error {
+	slog.Info("Reconciling pending payments...")
+
+	minAge := time.Duration(s.cfg.PendingPaymentMinAgeMinutes) * time.Minute
+	ttl := time.Duration(s.cfg.PendingPaymentTTLMinutes) * time.Minute
+
+	if err := s.paymentUC.ReconcilePendingPayments(ctx, minAge, ttl); err != nil {
 
 		return err
 	}
 
-	slog.Info("Expired VPNs deactivated")
+	slog.Info("Pending payments reconciliation completed")
+
+	return nil
+}
+
+func (s *Scheduler) ProcessAutoRenewals(ctx context.Context) error {
+	slog.Info("Processing auto-renewals...")
+
+	if err := s.renewalUC.ProcessAutoRenewals(ctx); err != nil {
+
+		return err
+	}
+
+	slog.Info("Auto-renewals processed")
+
+	return nil
+}
+
+func (s *Scheduler) ReconcileVPNConnections(ctx context.Context) error {
+	slog.Info("Reconciling VPN connections with panels...", "apply_fixes", s.cfg.ReconcileApplyFixes)
+
+	if err := s.reconUC.ReconcileAndNotify(ctx, !s.cfg.ReconcileApplyFixes); err != nil {
+
+		return err
+	}
+
+	slog.Info("VPN connections reconciliation completed")
+
+	return nil
+}
+
+func (s *Scheduler) CheckTrafficUsage(ctx context.Context) error {
+	slog.Info("Checking traffic usage...")
+
+	notified, err := s.trafficUC.CheckTrafficUsage(ctx)
+	if err != nil {
+
+		return err
+	}
+
+	slog.Info("Traffic usage check completed", "notified", notified)
 
 	return nil
 }
@@ -97,7 +293,20 @@ func (s *Scheduler) DeactivateExpiredVPNs(ctx context.Context) error {
 func (s *Scheduler) CleanOldData(ctx context.Context) error {
 	slog.Info("Cleaning old data...")
 
-	slog.Info("Old data cleaned")
+	retention := time.Duration(s.cfg.NotificationRetentionDays) * 24 * time.Hour
+	deleted, err := s.notifUC.DeleteOldNotifications(ctx, retention)
+	if err != nil {
+
+		return err
+	}
+
+	reminders, err := s.expiryUC.CleanReminders(ctx, retention)
+	if err != nil {
+
+		return err
+	}
+
+	slog.Info("Old data cleaned", "notifications", deleted, "reminders", reminders)
 
 	return nil
 }
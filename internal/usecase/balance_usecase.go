@@ -0,0 +1,135 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/pkg/id"
+	"3xui-bot/internal/ports"
+)
+
+const balanceHistoryLimit = 10
+
+type BalanceUseCase struct {
+	balanceRepo           ports.BalanceRepo
+	referralRepo          ports.ReferralRepo
+	referralRewardPercent float64
+}
+
+func NewBalanceUseCase(balanceRepo ports.BalanceRepo, referralRepo ports.ReferralRepo, referralRewardPercent float64) *BalanceUseCase {
+
+	return &BalanceUseCase{
+		balanceRepo:           balanceRepo,
+		referralRepo:          referralRepo,
+		referralRewardPercent: referralRewardPercent,
+	}
+}
+
+func (uc *BalanceUseCase) GetBalance(ctx context.Context, userID int64) (float64, error) {
+
+	return uc.balanceRepo.GetBalance(ctx, userID)
+}
+
+func (uc *BalanceUseCase) GetRecentTransactions(ctx context.Context, userID int64) ([]*core.BalanceTransaction, error) {
+
+	return uc.balanceRepo.GetTransactionsByUserID(ctx, userID, balanceHistoryLimit)
+}
+
+func (uc *BalanceUseCase) Credit(ctx context.Context, userID int64, amount float64, txType core.BalanceTransactionType, paymentID, description string) error {
+	if amount <= 0 {
+
+		return ErrInvalidAmount
+	}
+
+	return uc.createTransaction(ctx, userID, amount, txType, paymentID, description)
+}
+
+func (uc *BalanceUseCase) Debit(ctx context.Context, userID int64, amount float64, txType core.BalanceTransactionType, paymentID, description string) error {
+	if amount <= 0 {
+
+		return ErrInvalidAmount
+	}
+
+	balance, err := uc.balanceRepo.GetBalanceForUpdate(ctx, userID)
+	if err != nil {
+
+		return fmt.Errorf("failed to get balance: %w", err)
+	}
+
+	if roundAmount(balance) < roundAmount(amount) {
+
+		return ErrInsufficientBalance
+	}
+
+	return uc.createTransaction(ctx, userID, -amount, txType, paymentID, description)
+}
+
+func (uc *BalanceUseCase) ForceDebit(ctx context.Context, userID int64, amount float64, txType core.BalanceTransactionType, paymentID, description string) error {
+	if amount <= 0 {
+
+		return ErrInvalidAmount
+	}
+
+	if _, err := uc.balanceRepo.GetBalanceForUpdate(ctx, userID); err != nil {
+
+		return fmt.Errorf("failed to get balance: %w", err)
+	}
+
+	return uc.createTransaction(ctx, userID, -amount, txType, paymentID, description)
+}
+
+func (uc *BalanceUseCase) CreditReferralReward(ctx context.Context, payment *core.Payment) error {
+	if uc.referralRewardPercent <= 0 || payment.Currency != core.CurrencyRUB || payment.Amount <= 0 {
+
+		return nil
+	}
+
+	referral, err := uc.referralRepo.GetReferralByRefereeID(ctx, payment.UserID)
+	if errors.Is(err, ErrNotFound) || referral == nil {
+
+		return nil
+	}
+	if err != nil {
+
+		return fmt.Errorf("failed to get referral: %w", err)
+	}
+
+	reward := roundAmount(payment.Amount * uc.referralRewardPercent / 100)
+	if reward <= 0 {
+
+		return nil
+	}
+
+	description := fmt.Sprintf("Реферальное вознаграждение за оплату пользователя %d", payment.UserID)
+	if err := uc.createTransaction(ctx, referral.ReferrerID, reward, core.BalanceTransactionReferralReward, payment.ID, description); err != nil {
+
+		return err
+	}
+
+	slog.Info("Referral reward credited", "referrer_id", referral.ReferrerID, "referee_id", payment.UserID, "payment_id", payment.ID, "amount", reward)
+
+	return nil
+}
+
+func (uc *BalanceUseCase) createTransaction(ctx context.Context, userID int64, amount float64, txType core.BalanceTransactionType, paymentID, description string) error {
+	transaction := &core.BalanceTransaction{
+		ID:          id.Generate(),
+		UserID:      userID,
+		Amount:      roundAmount(amount),
+		Type:        string(txType),
+		PaymentID:   paymentID,
+		Description: description,
+		CreatedAt:   time.Now(),
+	}
+
+	if err := uc.balanceRepo.CreateTransaction(ctx, transaction); err != nil {
+
+		return fmt.Errorf("failed to save balance transaction: %w", err)
+	}
+
+	return nil
+}
@@ -31,6 +31,91 @@ type CreatePaymentDTO struct {
 	Description   string
 }
 
+type CompletedPaymentDTO struct {
+	Payment       *core.Payment
+	Plan          *core.Plan
+	Subscription  *core.Subscription
+	VPNConnection *core.VPNConnection
+}
+
+type CreatePromoCodeDTO struct {
+	Code           string
+	DiscountType   core.PromoDiscountType
+	DiscountValue  float64
+	BonusDays      int
+	MaxUses        int
+	MaxUsesPerUser int
+	PlanIDs        []string
+	ExpiresAt      *time.Time
+}
+
+type PlanQuoteDTO struct {
+	Plan           *core.Plan
+	PromoCode      *core.PromoCode
+	Amount         float64
+	DiscountAmount float64
+	StarsAmount    int
+	BonusDays      int
+}
+
+type RefundPaymentDTO struct {
+	PaymentID string
+	AdminID   int64
+	Amount    float64
+	Reason    string
+}
+
+type RefundResultDTO struct {
+	Payment      *core.Payment
+	Refund       *core.PaymentRefund
+	Subscription *core.Subscription
+	FullRefund   bool
+	VPNUpdateErr error
+}
+
+type CreateProviderPaymentDTO struct {
+	Amount            float64
+	Currency          string
+	Description       string
+	PaymentMethod     core.PaymentMethod
+	IdempotencyKey    string
+	Metadata          map[string]string
+	SavePaymentMethod bool
+	SavedMethodID     string
+	Receipt           *ProviderReceiptDTO
+}
+
+type ProviderReceiptDTO struct {
+	CustomerEmail string
+	TaxSystemCode int
+	Items         []ProviderReceiptItemDTO
+}
+
+type ProviderReceiptItemDTO struct {
+	Description    string
+	Quantity       float64
+	Amount         float64
+	Currency       string
+	VATCode        int
+	PaymentSubject string
+	PaymentMode    string
+}
+
+type ReceiptSettings struct {
+	Enabled        bool
+	VATCode        int
+	TaxSystemCode  int
+	PaymentSubject string
+	PaymentMode    string
+}
+
+type ProviderPaymentMethodDTO struct {
+	ID    string
+	Type  string
+	Title string
+	Saved bool
+}
+
 type CreateConfigDTO struct {
 	UserID     int64
 	Name       string
@@ -49,4 +134,5 @@ type CreateNotificationDTO struct {
 	Type    string
 	Title   string
 	Message string
+	Markup  interface{}
 }
@@ -12,6 +12,7 @@ var (
 var (
 	ErrUserAlreadyExists    = errors.New("user already exists")
 	ErrUserTrialAlreadyUsed = errors.New("user trial already used")
+	ErrInvalidEmail         = errors.New("invalid email")
 )
 
 var (
@@ -22,16 +23,47 @@ var (
 )
 
 var (
-	ErrPaymentAlreadyPaid = errors.New("payment already paid")
-	ErrPaymentCancelled   = errors.New("payment cancelled")
-	ErrPaymentFailed      = errors.New("payment failed")
-	ErrInvalidAmount      = errors.New("invalid amount")
+	ErrPaymentAlreadyPaid   = errors.New("payment already paid")
+	ErrPaymentCancelled     = errors.New("payment cancelled")
+	ErrPaymentFailed        = errors.New("payment failed")
+	ErrInvalidAmount        = errors.New("invalid amount")
+	ErrPaymentNotRefundable = errors.New("payment not refundable")
+	ErrRefundPending        = errors.New("another refund is pending for payment")
+	ErrInsufficientBalance  = errors.New("insufficient balance")
+	ErrReceiptEmailRequired = errors.New("receipt email required")
+	ErrReceiptNotAvailable  = errors.New("receipt not available for payment")
 )
 
 var (
-	ErrVPNConfigNotActive    = errors.New("VPN config not active")
-	ErrVPNConfigLimitReached = errors.New("VPN config limit reached")
-	ErrInvalidVPNType        = errors.New("invalid VPN type")
+	ErrNoRenewalPaymentMethod = errors.New("no payment method available for renewal")
+	ErrRenewalPending         = errors.New("renewal payment already pending")
+)
+
+var (
+	ErrPromoCodeInvalid       = errors.New("promo code invalid")
+	ErrPromoCodeExpired       = errors.New("promo code expired")
+	ErrPromoCodeExhausted     = errors.New("promo code exhausted")
+	ErrPromoCodeNotApplicable = errors.New("promo code not applicable to plan")
+	ErrPromoCodeAlreadyUsed   = errors.New("promo code already used")
+	ErrPromoCodeAlreadyExists = errors.New("promo code already exists")
+)
+
+var (
+	ErrVPNConfigNotActive      = errors.New("VPN config not active")
+	ErrVPNConfigLimitReached   = errors.New("VPN config limit reached")
+	ErrInvalidVPNType          = errors.New("invalid VPN type")
+	ErrServerNotFound          = errors.New("VPN server not found")
+	ErrNoServersAvailable      = errors.New("no VPN servers available")
+	ErrServerFull              = errors.New("VPN server is full")
+	ErrSameServer              = errors.New("VPN connection is already on this server")
+	ErrTrafficAddonUnavailable = errors.New("traffic add-on unavailable")
+)
+
+var (
+	ErrPanelUserNotFound = errors.New("panel user not found")
+	ErrPanelConflict     = errors.New("panel user already exists")
+	ErrPanelValidation   = errors.New("panel rejected request data")
+	ErrPanelUnavailable  = errors.New("VPN panel temporarily unavailable")
 )
 
 var (
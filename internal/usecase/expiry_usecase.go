@@ -0,0 +1,377 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/ports"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type ExpiryUseCase struct {
+	subRepo               ports.SubscriptionRepo
+	reminderRepo          ports.SubscriptionReminderRepo
+	vpnUC                 *VPNUseCase
+	notifUC               *NotificationUseCase
+	reminderOffsets       []time.Duration
+	policy                core.ExpiryPolicy
+	graceReminderInterval time.Duration
+	batchSize             int
+}
+
+func NewExpiryUseCase(
+	subRepo ports.SubscriptionRepo,
+	reminderRepo ports.SubscriptionReminderRepo,
+	vpnUC *VPNUseCase,
+	notifUC *NotificationUseCase,
+	reminderOffsets []time.Duration,
+	policy core.ExpiryPolicy,
+	graceReminderInterval time.Duration,
+	batchSize int,
+) *ExpiryUseCase {
+	offsets := make([]time.Duration, 0, len(reminderOffsets))
+	for _, offset := range reminderOffsets {
+		if offset > 0 {
+			offsets = append(offsets, offset)
+		}
+	}
+	sort.Slice(offsets, func(i, j int) bool {
+
+		return offsets[i] > offsets[j]
+	})
+
+	return &ExpiryUseCase{
+		subRepo:               subRepo,
+		reminderRepo:          reminderRepo,
+		vpnUC:                 vpnUC,
+		notifUC:               notifUC,
+		reminderOffsets:       offsets,
+		policy:                policy,
+		graceReminderInterval: graceReminderInterval,
+		batchSize:             batchSize,
+	}
+}
+
+func (uc *ExpiryUseCase) Policy() core.ExpiryPolicy {
+
+	return uc.policy
+}
+
+func (uc *ExpiryUseCase) ExpireSubscriptions(ctx context.Context) (int, error) {
+	now := time.Now()
+	afterID := ""
+	expired := 0
+
+	for {
+		subscriptions, err := uc.subRepo.GetExpiredActiveSubscriptions(ctx, now, afterID, uc.batchSize)
+		if err != nil {
+
+			return expired, fmt.Errorf("failed to get expired subscriptions: %w", err)
+		}
+
+		for _, subscription := range subscriptions {
+			if ctx.Err() != nil {
+
+				return expired, ctx.Err()
+			}
+			afterID = subscription.ID
+
+			if uc.expire(ctx, subscription, now) {
+				expired++
+			}
+		}
+
+		if len(subscriptions) < uc.batchSize {
+
+			return expired, nil
+		}
+	}
+}
+
+func (uc *ExpiryUseCase) expire(ctx context.Context, subscription *core.Subscription, now time.Time) bool {
+	from := subscription.GetStatus()
+	to := subscription.NextStatus(now, uc.policy)
+	if to == from {
+		if to == core.StatusGrace {
+			uc.remindDuringGrace(ctx, subscription, now)
+		}
+
+		return false
+	}
+
+	if !uc.transition(ctx, subscription, from, to, now) {
+
+		return false
+	}
+
+	switch to {
+	case core.StatusGrace:
+		uc.enterGrace(ctx, subscription)
+	case core.StatusSuspended:
+		uc.suspend(ctx, subscription)
+	}
+
+	return true
+}
+
+func (uc *ExpiryUseCase) transition(ctx context.Context, subscription *core.Subscription, from, to core.SubscriptionStatus, now time.Time) bool {
+	if !subscription.Transition(to, now, uc.policy) {
+		slog.Error("Invalid subscription transition", "subscription_id", subscription.ID, "from", from, "to", to)
+
+		return false
+	}
+
+	updated, err := uc.subRepo.UpdateSubscriptionStatus(ctx, subscription, from)
+	if err != nil {
+		slog.Error("Failed to update subscription status", "subscription_id", subscription.ID, "from", from, "to", to, "error", err)
+
+		return false
+	}
+	if !updated {
+
+		return false
+	}
+
+	slog.Info("Subscription status changed", "subscription_id", subscription.ID, "user_id", subscription.UserID, "from", from, "to", to)
+
+	return true
+}
+
+func (uc *ExpiryUseCase) enterGrace(ctx context.Context, subscription *core.Subscription) {
+	if err := uc.vpnUC.ActivateSubscriptionVPNs(ctx, subscription.ID, subscription.AccessEndsAt()); err != nil {
+		slog.Error("Failed to extend VPN for grace period", "subscription_id", subscription.ID, "error", err)
+	}
+
+	if _, err := uc.reminderRepo.MarkReminderSent(ctx, subscription.ID, 0, subscription.EndDate); err != nil {
+		slog.Error("Failed to record grace reminder", "subscription_id", subscription.ID, "error", err)
+	}
+
+	uc.notify(ctx, subscription, "⌛ Подписка истекла",
+		fmt.Sprintf("Срок действия подписки \"%s\" закончился %s. VPN продолжит работать до %s — продлите подписку, чтобы не потерять доступ.",
+			subscription.GetDisplayName(), subscription.EndDate.Format("02.01.2006 15:04"), subscription.AccessEndsAt().Format("02.01.2006 15:04")))
+}
+
+func (uc *ExpiryUseCase) remindDuringGrace(ctx context.Context, subscription *core.Subscription, now time.Time) {
+	if uc.graceReminderInterval <= 0 {
+
+		return
+	}
+
+	stage := int(now.Sub(subscription.EndDate) / uc.graceReminderInterval)
+	if stage <= 0 {
+
+		return
+	}
+
+	marked, err := uc.reminderRepo.MarkReminderSent(ctx, subscription.ID, -stage*int(uc.graceReminderInterval/time.Hour), subscription.EndDate)
+	if err != nil {
+		slog.Error("Failed to record grace reminder", "subscription_id", subscription.ID, "error", err)
+
+		return
+	}
+	if !marked {
+
+		return
+	}
+
+	uc.notify(ctx, subscription, "⏳ Льготный период заканчивается",
+		fmt.Sprintf("Подписка \"%s\" истекла, VPN работает в льготном режиме ещё %s (до %s). Продлите подписку, иначе ключи будут отключены.",
+			subscription.GetDisplayName(), formatRemaining(subscription.AccessEndsAt().Sub(now)), subscription.AccessEndsAt().Format("02.01.2006 15:04")))
+}
+
+func (uc *ExpiryUseCase) suspend(ctx context.Context, subscription *core.Subscription) {
+	if err := uc.vpnUC.DisableSubscriptionVPNs(ctx, subscription.ID); err != nil {
+		slog.Error("Failed to disable VPN of suspended subscription", "subscription_id", subscription.ID, "error", err)
+	}
+
+	message := fmt.Sprintf("Подписка \"%s\" приостановлена: срок действия закончился %s. VPN-ключи отключены, но сохранены — продлите подписку, и они снова заработают.",
+		subscription.GetDisplayName(), subscription.EndDate.Format("02.01.2006 15:04"))
+	if deleteAt, ok := subscription.DeletionAt(uc.policy); ok {
+		message += fmt.Sprintf(" После %s ключи будут удалены.", deleteAt.Format("02.01.2006"))
+	}
+
+	uc.notify(ctx, subscription, "⛔ Подписка приостановлена", message)
+}
+
+func (uc *ExpiryUseCase) DeleteSuspendedSubscriptions(ctx context.Context) (int, error) {
+	if uc.policy.SuspendPeriod <= 0 {
+
+		return 0, nil
+	}
+
+	now := time.Now()
+	afterID := ""
+	deleted := 0
+
+	for {
+		subscriptions, err := uc.subRepo.GetSuspendedSubscriptions(ctx, now.Add(-uc.policy.SuspendPeriod), afterID, uc.batchSize)
+		if err != nil {
+
+			return deleted, fmt.Errorf("failed to get suspended subscriptions: %w", err)
+		}
+
+		for _, subscription := range subscriptions {
+			if ctx.Err() != nil {
+
+				return deleted, ctx.Err()
+			}
+			afterID = subscription.ID
+
+			if uc.terminate(ctx, subscription, now) {
+				deleted++
+			}
+		}
+
+		if len(subscriptions) < uc.batchSize {
+
+			return deleted, nil
+		}
+	}
+}
+
+func (uc *ExpiryUseCase) terminate(ctx context.Context, subscription *core.Subscription, now time.Time) bool {
+	if subscription.NextStatus(now, uc.policy) != core.StatusExpired {
+
+		return false
+	}
+
+	if !uc.transition(ctx, subscription, core.StatusSuspended, core.StatusExpired, now) {
+
+		return false
+	}
+
+	keys, err := uc.vpnUC.DeleteSubscriptionVPNs(ctx, subscription.ID)
+	if err != nil {
+		slog.Error("Failed to delete VPN of expired subscription", "subscription_id", subscription.ID, "error", err)
+	}
+
+	if keys > 0 {
+		uc.notify(ctx, subscription, "🗑 VPN-ключи удалены",
+			fmt.Sprintf("Подписка \"%s\" не была продлена, поэтому её VPN-ключи удалены. Продлите подписку, чтобы снова создать ключи.", subscription.GetDisplayName()))
+	}
+
+	return true
+}
+
+func (uc *ExpiryUseCase) SendExpirationReminders(ctx context.Context) (int, error) {
+	if len(uc.reminderOffsets) == 0 {
+
+		return 0, nil
+	}
+
+	now := time.Now()
+	subscriptions, err := uc.subRepo.GetSubscriptionsExpiringBetween(ctx, now, now.Add(uc.reminderOffsets[0]))
+	if err != nil {
+
+		return 0, fmt.Errorf("failed to get expiring subscriptions: %w", err)
+	}
+
+	sent := 0
+	for _, subscription := range subscriptions {
+		if ctx.Err() != nil {
+
+			return sent, ctx.Err()
+		}
+
+		remaining := subscription.EndDate.Sub(now)
+		offset := uc.reminderStage(remaining)
+
+		marked, err := uc.reminderRepo.MarkReminderSent(ctx, subscription.ID, int(offset/time.Hour), subscription.EndDate)
+		if err != nil {
+			slog.Error("Failed to record expiration reminder", "subscription_id", subscription.ID, "error", err)
+			continue
+		}
+		if !marked {
+			continue
+		}
+
+		uc.notify(ctx, subscription, "⏰ Подписка скоро закончится",
+			fmt.Sprintf("Подписка \"%s\" закончится через %s (%s). Продлите её заранее, чтобы VPN не отключился.",
+				subscription.GetDisplayName(), formatRemaining(remaining), subscription.EndDate.Format("02.01.2006 15:04")))
+		sent++
+	}
+
+	return sent, nil
+}
+
+func (uc *ExpiryUseCase) CleanReminders(ctx context.Context, retention time.Duration) (int64, error) {
+	deleted, err := uc.reminderRepo.DeleteRemindersBefore(ctx, time.Now().Add(-retention))
+	if err != nil {
+
+		return 0, fmt.Errorf("failed to delete old reminders: %w", err)
+	}
+
+	return deleted, nil
+}
+
+func (uc *ExpiryUseCase) reminderStage(remaining time.Duration) time.Duration {
+	for i := len(uc.reminderOffsets) - 1; i >= 0; i-- {
+		if uc.reminderOffsets[i] >= remaining {
+
+			return uc.reminderOffsets[i]
+		}
+	}
+
+	return uc.reminderOffsets[0]
+}
+
+func (uc *ExpiryUseCase) notify(ctx context.Context, subscription *core.Subscription, title, message string) {
+	notifDTO := CreateNotificationDTO{
+		UserID:  subscription.UserID,
+		Type:    "expiration",
+		Title:   title,
+		Message: message,
+		Markup:  renewalKeyboard(subscription.ID),
+	}
+
+	if err := uc.notifUC.CreateNotification(ctx, notifDTO); err != nil {
+		slog.Error("Failed to notify user about expiration", "user_id", subscription.UserID, "subscription_id", subscription.ID, "error", err)
+	}
+}
+
+func renewalKeyboard(subscriptionID string) tgbotapi.InlineKeyboardMarkup {
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔄 Продлить подписку", "extend_subscription_"+subscriptionID),
+		),
+	)
+}
+
+func formatRemaining(d time.Duration) string {
+	hours := int((d + 30*time.Minute) / time.Hour)
+	if hours >= 24 {
+		days := (hours + 12) / 24
+
+		return fmt.Sprintf("%d %s", days, pluralRu(days, "день", "дня", "дней"))
+	}
+	if hours < 1 {
+		hours = 1
+	}
+
+	return fmt.Sprintf("%d %s", hours, pluralRu(hours, "час", "часа", "часов"))
+}
+
+func pluralRu(n int, one, few, many string) string {
+	if n%100 >= 11 && n%100 <= 14 {
+
+		return many
+	}
+
+	switch n % 10 {
+	case 1:
+
+		return one
+	case 2, 3, 4:
+
+		return few
+	default:
+
+		return many
+	}
+}
@@ -0,0 +1,341 @@
+package usecase_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/usecase"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var testReminderOffsets = []time.Duration{3 * time.Hour, 72 * time.Hour, 24 * time.Hour}
+
+var testGracePolicy = core.ExpiryPolicy{GracePeriod: 72 * time.Hour, SuspendPeriod: 14 * 24 * time.Hour}
+
+type expiryFixture struct {
+	h         *vpnHarness
+	uc        *usecase.ExpiryUseCase
+	notifUC   *usecase.NotificationUseCase
+	reminders *memoryReminderRepo
+	notifier  *recordingNotifier
+}
+
+func newExpiryFixture(t *testing.T, batchSize int, policy core.ExpiryPolicy) *expiryFixture {
+	t.Helper()
+
+	h := newVPNHarness(t)
+	notifUC, notifier := newTestNotificationUseCase()
+	reminders := newMemoryReminderRepo()
+
+	return &expiryFixture{
+		h:         h,
+		uc:        usecase.NewExpiryUseCase(h.subRepo, reminders, h.uc, notifUC, testReminderOffsets, policy, 24*time.Hour, batchSize),
+		notifUC:   notifUC,
+		reminders: reminders,
+		notifier:  notifier,
+	}
+}
+
+func (f *expiryFixture) setEndDate(t *testing.T, subscriptionID string, endDate time.Time, autoRenew bool) {
+	t.Helper()
+
+	sub, err := f.h.subRepo.GetSubscriptionByID(context.Background(), subscriptionID)
+	if err != nil {
+		t.Fatalf("failed to get subscription: %v", err)
+	}
+	sub.EndDate = endDate
+	sub.AutoRenew = autoRenew
+	if err := f.h.subRepo.UpdateSubscription(context.Background(), sub); err != nil {
+		t.Fatalf("failed to update subscription: %v", err)
+	}
+}
+
+func renewalCallback(markup interface{}) string {
+	keyboard, ok := markup.(tgbotapi.InlineKeyboardMarkup)
+	if !ok || len(keyboard.InlineKeyboard) == 0 || len(keyboard.InlineKeyboard[0]) == 0 || keyboard.InlineKeyboard[0][0].CallbackData == nil {
+
+		return ""
+	}
+
+	return *keyboard.InlineKeyboard[0][0].CallbackData
+}
+
+func TestExpireSubscriptionsDisablesPanelUsers(t *testing.T) {
+	f := newExpiryFixture(t, 1, core.ExpiryPolicy{})
+	ctx := context.Background()
+	future := time.Now().Add(30 * 24 * time.Hour)
+
+	expired := make(map[string]*core.VPNConnection)
+	for _, id := range []string{"sub-a", "sub-b"} {
+		f.h.addSubscription(t, id, future)
+		expired[id] = f.h.createVPN(t, id)
+		f.setEndDate(t, id, time.Now().Add(-time.Hour), false)
+	}
+	f.h.addSubscription(t, "sub-active", future)
+	active := f.h.createVPN(t, "sub-active")
+
+	count, err := f.uc.ExpireSubscriptions(ctx)
+	if err != nil {
+		t.Fatalf("ExpireSubscriptions returned error: %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 expired subscriptions, got %d", count)
+	}
+
+	for id, conn := range expired {
+		sub, _ := f.h.subRepo.GetSubscriptionByID(ctx, id)
+		if sub.IsActive || sub.GetStatus() != core.StatusSuspended {
+			t.Errorf("expected subscription %s to be suspended, got %s", id, sub.GetStatus())
+		}
+		user, ok := f.h.server.User(conn.MarzbanUsername)
+		if !ok || user.Status != string(core.MarzbanUserStatusDisabled) {
+			t.Errorf("expected panel user of %s to be disabled, got %+v", id, user)
+		}
+		row, _ := f.h.vpnRepo.GetVPNConnectionByID(ctx, conn.ID)
+		if row.IsActive {
+			t.Errorf("expected connection of %s to be inactive", id)
+		}
+	}
+
+	user, _ := f.h.server.User(active.MarzbanUsername)
+	if user.Status != string(core.MarzbanUserStatusActive) {
+		t.Errorf("expected panel user of active subscription to stay active, got %q", user.Status)
+	}
+
+	messages := f.notifier.Messages(testUserID)
+	if len(messages) != 2 || !strings.Contains(messages[0], "Подписка приостановлена") {
+		t.Fatalf("expected 2 suspension notifications, got %v", messages)
+	}
+	callbacks := map[string]bool{}
+	for _, markup := range f.notifier.Markups(testUserID) {
+		callbacks[renewalCallback(markup)] = true
+	}
+	if !callbacks["extend_subscription_sub-a"] || !callbacks["extend_subscription_sub-b"] {
+		t.Errorf("expected renewal buttons for both subscriptions, got %v", callbacks)
+	}
+
+	count, err = f.uc.ExpireSubscriptions(ctx)
+	if err != nil || count != 0 {
+		t.Errorf("expected second run to expire nothing, got %d (%v)", count, err)
+	}
+	if got := len(f.notifier.Messages(testUserID)); got != 2 {
+		t.Errorf("expected no repeated notifications, got %d messages", got)
+	}
+}
+
+func TestSendExpirationRemindersPicksStage(t *testing.T) {
+	f := newExpiryFixture(t, 100, core.ExpiryPolicy{})
+	now := time.Now()
+
+	f.h.addSubscription(t, "sub-day", now.Add(23*time.Hour+10*time.Minute))
+	f.h.addSubscription(t, "sub-auto", now.Add(23*time.Hour+10*time.Minute))
+	f.setEndDate(t, "sub-auto", now.Add(23*time.Hour+10*time.Minute), true)
+	f.h.addSubscription(t, "sub-days", now.Add(60*time.Hour))
+	f.h.addSubscription(t, "sub-later", now.Add(80*time.Hour))
+
+	sent, err := f.uc.SendExpirationReminders(context.Background())
+	if err != nil {
+		t.Fatalf("SendExpirationReminders returned error: %v", err)
+	}
+	if sent != 2 {
+		t.Fatalf("expected 2 reminders, got %d", sent)
+	}
+
+	callbacks := map[string]bool{}
+	for _, markup := range f.notifier.Markups(testUserID) {
+		callbacks[renewalCallback(markup)] = true
+	}
+	if !callbacks["extend_subscription_sub-day"] || !callbacks["extend_subscription_sub-days"] {
+		t.Errorf("expected renewal buttons for sub-day and sub-days, got %v", callbacks)
+	}
+
+	messages := strings.Join(f.notifier.Messages(testUserID), "\n")
+	if !strings.Contains(messages, "через 23 часа") || !strings.Contains(messages, "через 3 дня") {
+		t.Errorf("unexpected reminder texts:\n%s", messages)
+	}
+}
+
+func TestSendExpirationRemindersDeduplicatesAcrossRestarts(t *testing.T) {
+	f := newExpiryFixture(t, 100, core.ExpiryPolicy{})
+	ctx := context.Background()
+	endDate := time.Now().Add(23 * time.Hour)
+	f.h.addSubscription(t, "sub-1", endDate)
+
+	if sent, _ := f.uc.SendExpirationReminders(ctx); sent != 1 {
+		t.Fatalf("expected first reminder to be sent, got %d", sent)
+	}
+	if sent, _ := f.uc.SendExpirationReminders(ctx); sent != 0 {
+		t.Errorf("expected repeated tick to send nothing, got %d", sent)
+	}
+
+	restarted := usecase.NewExpiryUseCase(f.h.subRepo, f.reminders, f.h.uc, f.notifUC, testReminderOffsets, core.ExpiryPolicy{}, 24*time.Hour, 100)
+	if sent, _ := restarted.SendExpirationReminders(ctx); sent != 0 {
+		t.Errorf("expected restarted scheduler to send nothing, got %d", sent)
+	}
+
+	f.setEndDate(t, "sub-1", time.Now().Add(2*time.Hour), false)
+	if sent, _ := restarted.SendExpirationReminders(ctx); sent != 1 {
+		t.Errorf("expected the 3 hour reminder to be sent, got %d", sent)
+	}
+
+	f.setEndDate(t, "sub-1", endDate.Add(-30*time.Minute), false)
+	if sent, _ := restarted.SendExpirationReminders(ctx); sent != 1 {
+		t.Errorf("expected reminders to restart after the end date changed, got %d", sent)
+	}
+
+	if got := len(f.notifier.Messages(testUserID)); got != 3 {
+		t.Errorf("expected 3 reminders in total, got %d", got)
+	}
+}
+
+func TestDeactivateExpiredVPNsRetriesPanelDisable(t *testing.T) {
+	h := newVPNHarness(t)
+	ctx := context.Background()
+
+	h.addSubscription(t, "sub-expired", time.Now().Add(24*time.Hour))
+	stale := h.createVPN(t, "sub-expired")
+	missing := h.createVPN(t, "sub-expired")
+	h.server.RemoveUser(missing.MarzbanUsername)
+	sub, _ := h.subRepo.GetSubscriptionByID(ctx, "sub-expired")
+	sub.IsActive = false
+	_ = h.subRepo.UpdateSubscription(ctx, sub)
+
+	h.addSubscription(t, "sub-active", time.Now().Add(24*time.Hour))
+	active := h.createVPN(t, "sub-active")
+
+	deactivated, err := h.uc.DeactivateExpiredVPNs(ctx, 0, 1)
+	if err != nil {
+		t.Fatalf("DeactivateExpiredVPNs returned error: %v", err)
+	}
+	if deactivated != 2 {
+		t.Errorf("expected 2 deactivated connections, got %d", deactivated)
+	}
+
+	user, _ := h.server.User(stale.MarzbanUsername)
+	if user.Status != string(core.MarzbanUserStatusDisabled) {
+		t.Errorf("expected stale panel user to be disabled, got %q", user.Status)
+	}
+	for _, conn := range []*core.VPNConnection{stale, missing} {
+		row, _ := h.vpnRepo.GetVPNConnectionByID(ctx, conn.ID)
+		if row.IsActive {
+			t.Errorf("expected connection %s to be inactive", conn.ID)
+		}
+	}
+	row, _ := h.vpnRepo.GetVPNConnectionByID(ctx, active.ID)
+	if !row.IsActive {
+		t.Errorf("expected connection of active subscription to stay active")
+	}
+}
+
+func TestExpireSubscriptionsGraceLifecycle(t *testing.T) {
+	f := newExpiryFixture(t, 100, testGracePolicy)
+	ctx := context.Background()
+
+	f.h.addSubscription(t, "sub-1", time.Now().Add(30*24*time.Hour))
+	conn := f.h.createVPN(t, "sub-1")
+	f.setEndDate(t, "sub-1", time.Now().Add(-time.Hour), false)
+
+	if count, err := f.uc.ExpireSubscriptions(ctx); err != nil || count != 1 {
+		t.Fatalf("expected subscription to enter grace, got %d (%v)", count, err)
+	}
+	sub, _ := f.h.subRepo.GetSubscriptionByID(ctx, "sub-1")
+	if sub.GetStatus() != core.StatusGrace || !sub.IsActive {
+		t.Fatalf("expected grace status, got %s", sub.GetStatus())
+	}
+	user, _ := f.h.server.User(conn.MarzbanUsername)
+	if user.Status != string(core.MarzbanUserStatusActive) || user.Expire == nil || *user.Expire != sub.GraceEndsAt.Unix() {
+		t.Errorf("expected panel user to stay active until the end of grace, got %+v", user)
+	}
+	if deactivated, _ := f.h.uc.DeactivateExpiredVPNs(ctx, testGracePolicy.GracePeriod, 100); deactivated != 0 {
+		t.Errorf("expected grace connections to stay active, got %d deactivated", deactivated)
+	}
+
+	if count, _ := f.uc.ExpireSubscriptions(ctx); count != 0 {
+		t.Errorf("expected repeated tick to keep grace, got %d transitions", count)
+	}
+	if got := len(f.notifier.Messages(testUserID)); got != 1 {
+		t.Fatalf("expected a single grace notification, got %d", got)
+	}
+
+	sub.EndDate = time.Now().Add(-25 * time.Hour)
+	graceEndsAt := sub.EndDate.Add(testGracePolicy.GracePeriod)
+	sub.GraceEndsAt = &graceEndsAt
+	_ = f.h.subRepo.UpdateSubscription(ctx, sub)
+	_, _ = f.reminders.MarkReminderSent(ctx, "sub-1", 0, sub.EndDate)
+	f.uc.ExpireSubscriptions(ctx)
+	f.uc.ExpireSubscriptions(ctx)
+	messages := f.notifier.Messages(testUserID)
+	if len(messages) != 2 || !strings.Contains(messages[1], "Льготный период заканчивается") {
+		t.Fatalf("expected one repeated renewal prompt, got %v", messages)
+	}
+	if callback := renewalCallback(f.notifier.Markups(testUserID)[1]); callback != "extend_subscription_sub-1" {
+		t.Errorf("expected renewal button, got %q", callback)
+	}
+
+	graceEndsAt = time.Now().Add(-time.Minute)
+	sub.GraceEndsAt = &graceEndsAt
+	_ = f.h.subRepo.UpdateSubscription(ctx, sub)
+	if count, _ := f.uc.ExpireSubscriptions(ctx); count != 1 {
+		t.Fatalf("expected subscription to be suspended, got %d transitions", count)
+	}
+	sub, _ = f.h.subRepo.GetSubscriptionByID(ctx, "sub-1")
+	if sub.GetStatus() != core.StatusSuspended || sub.IsActive || sub.SuspendedAt == nil {
+		t.Fatalf("expected suspended status, got %+v", sub)
+	}
+	user, _ = f.h.server.User(conn.MarzbanUsername)
+	if user.Status != string(core.MarzbanUserStatusDisabled) {
+		t.Errorf("expected panel user to be disabled, got %q", user.Status)
+	}
+
+	if deleted, _ := f.uc.DeleteSuspendedSubscriptions(ctx); deleted != 0 {
+		t.Errorf("expected freshly suspended subscription to be kept, got %d", deleted)
+	}
+
+	suspendedAt := time.Now().Add(-15 * 24 * time.Hour)
+	sub.SuspendedAt = &suspendedAt
+	_ = f.h.subRepo.UpdateSubscription(ctx, sub)
+	if deleted, err := f.uc.DeleteSuspendedSubscriptions(ctx); err != nil || deleted != 1 {
+		t.Fatalf("expected suspended subscription to be deleted, got %d (%v)", deleted, err)
+	}
+	sub, _ = f.h.subRepo.GetSubscriptionByID(ctx, "sub-1")
+	if sub.GetStatus() != core.StatusExpired {
+		t.Errorf("expected expired status, got %s", sub.GetStatus())
+	}
+	if _, ok := f.h.server.User(conn.MarzbanUsername); ok {
+		t.Errorf("expected panel user to be deleted")
+	}
+	if _, err := f.h.vpnRepo.GetVPNConnectionByID(ctx, conn.ID); err == nil {
+		t.Errorf("expected VPN connection row to be deleted")
+	}
+}
+
+func TestExpireSubscriptionsSkipsRenewedGrace(t *testing.T) {
+	f := newExpiryFixture(t, 100, testGracePolicy)
+	ctx := context.Background()
+
+	f.h.addSubscription(t, "sub-1", time.Now().Add(30*24*time.Hour))
+	conn := f.h.createVPN(t, "sub-1")
+	f.setEndDate(t, "sub-1", time.Now().Add(-time.Hour), false)
+	f.uc.ExpireSubscriptions(ctx)
+
+	subUC := usecase.NewSubscriptionUseCase(f.h.subRepo, newMemoryPlanRepo(f.h.plan))
+	if err := subUC.ExtendSubscription(ctx, testUserID, "sub-1", 30); err != nil {
+		t.Fatalf("ExtendSubscription returned error: %v", err)
+	}
+	sub, _ := f.h.subRepo.GetSubscriptionByID(ctx, "sub-1")
+	if sub.GetStatus() != core.StatusActive || sub.GraceEndsAt != nil || !sub.HasAccess() {
+		t.Fatalf("expected renewal to return subscription to active, got %+v", sub)
+	}
+
+	if count, _ := f.uc.ExpireSubscriptions(ctx); count != 0 {
+		t.Errorf("expected renewed subscription to be left alone, got %d", count)
+	}
+	user, _ := f.h.server.User(conn.MarzbanUsername)
+	if user.Status != string(core.MarzbanUserStatusActive) {
+		t.Errorf("expected panel user to stay active, got %q", user.Status)
+	}
+}
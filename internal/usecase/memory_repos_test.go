@@ -0,0 +1,1101 @@
+package usecase_test
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/usecase"
+)
+
+type memoryVPNRepo struct {
+	mu            sync.Mutex
+	connections   map[string]*core.VPNConnection
+	subscriptions *memorySubscriptionRepo
+}
+
+func newMemoryVPNRepo() *memoryVPNRepo {
+
+	return &memoryVPNRepo{connections: make(map[string]*core.VPNConnection)}
+}
+
+func (r *memoryVPNRepo) CreateVPNConnection(ctx context.Context, conn *core.VPNConnection) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.connections {
+		if existing.MarzbanUsername == conn.MarzbanUsername {
+
+			return usecase.ErrUserAlreadyExists
+		}
+	}
+
+	stored := *conn
+	r.connections[conn.ID] = &stored
+
+	return nil
+}
+
+func (r *memoryVPNRepo) GetVPNConnectionsByTelegramUserID(ctx context.Context, telegramUserID int64) ([]*core.VPNConnection, error) {
+
+	return r.filter(func(conn *core.VPNConnection) bool {
+
+		return conn.TelegramUserID == telegramUserID
+	}), nil
+}
+
+func (r *memoryVPNRepo) GetVPNConnectionsBySubscriptionID(ctx context.Context, subscriptionID string) ([]*core.VPNConnection, error) {
+
+	return r.filter(func(conn *core.VPNConnection) bool {
+
+		return conn.SubscriptionID == subscriptionID
+	}), nil
+}
+
+func (r *memoryVPNRepo) GetAllVPNConnections(ctx context.Context) ([]*core.VPNConnection, error) {
+
+	return r.filter(func(conn *core.VPNConnection) bool {
+
+		return true
+	}), nil
+}
+
+func (r *memoryVPNRepo) GetVPNConnectionByID(ctx context.Context, id string) (*core.VPNConnection, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	conn, ok := r.connections[id]
+	if !ok {
+
+		return nil, usecase.ErrNotFound
+	}
+	copied := *conn
+
+	return &copied, nil
+}
+
+func (r *memoryVPNRepo) GetVPNConnectionByMarzbanUsername(ctx context.Context, marzbanUsername string) (*core.VPNConnection, error) {
+	connections := r.filter(func(conn *core.VPNConnection) bool {
+
+		return conn.MarzbanUsername == marzbanUsername
+	})
+	if len(connections) == 0 {
+
+		return nil, usecase.ErrNotFound
+	}
+
+	return connections[0], nil
+}
+
+func (r *memoryVPNRepo) UpdateVPNConnectionName(ctx context.Context, id, name string) error {
+
+	return r.update(id, func(conn *core.VPNConnection) {
+		conn.Name = name
+	})
+}
+
+func (r *memoryVPNRepo) DeleteVPNConnection(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.connections[id]; !ok {
+
+		return usecase.ErrNotFound
+	}
+	delete(r.connections, id)
+
+	return nil
+}
+
+func (r *memoryVPNRepo) DeleteVPNConnectionByMarzbanUsername(ctx context.Context, marzbanUsername string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, conn := range r.connections {
+		if conn.MarzbanUsername == marzbanUsername {
+			delete(r.connections, id)
+
+			return nil
+		}
+	}
+
+	return usecase.ErrNotFound
+}
+
+func (r *memoryVPNRepo) GetActiveVPNConnections(ctx context.Context, telegramUserID int64) ([]*core.VPNConnection, error) {
+
+	return r.filter(func(conn *core.VPNConnection) bool {
+
+		return conn.TelegramUserID == telegramUserID && conn.IsActive
+	}), nil
+}
+
+func (r *memoryVPNRepo) UpdateVPNConnectionStatus(ctx context.Context, id string, isActive bool) error {
+
+	return r.update(id, func(conn *core.VPNConnection) {
+		conn.IsActive = isActive
+	})
+}
+
+func (r *memoryVPNRepo) UpdateVPNConnectionServer(ctx context.Context, id, serverName string) error {
+
+	return r.update(id, func(conn *core.VPNConnection) {
+		conn.ServerName = serverName
+	})
+}
+
+func (r *memoryVPNRepo) UpdateVPNConnectionTrafficWarning(ctx context.Context, id string, percent int) error {
+
+	return r.update(id, func(conn *core.VPNConnection) {
+		conn.TrafficWarningPercent = percent
+	})
+}
+
+func (r *memoryVPNRepo) AddVPNConnectionTrafficAddon(ctx context.Context, id string, bytes int64, expiresAt *time.Time) error {
+
+	return r.update(id, func(conn *core.VPNConnection) {
+		conn.TrafficAddonBytes += bytes
+		conn.TrafficAddonExpiresAt = expiresAt
+	})
+}
+
+func (r *memoryVPNRepo) ClearVPNConnectionTrafficAddon(ctx context.Context, id string) error {
+
+	return r.update(id, func(conn *core.VPNConnection) {
+		conn.TrafficAddonBytes = 0
+		conn.TrafficAddonExpiresAt = nil
+	})
+}
+
+func (r *memoryVPNRepo) CountVPNConnectionsByServer(ctx context.Context) (map[string]int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := make(map[string]int)
+	for _, conn := range r.connections {
+		if conn.IsActive {
+			counts[conn.ServerName]++
+		}
+	}
+
+	return counts, nil
+}
+
+func (r *memoryVPNRepo) GetExpiredActiveVPNConnections(ctx context.Context, now time.Time, afterID string, limit int) ([]*core.VPNConnection, error) {
+	connections := r.filter(func(conn *core.VPNConnection) bool {
+		if !conn.IsActive || conn.ID <= afterID || r.subscriptions == nil {
+
+			return false
+		}
+		sub, err := r.subscriptions.GetSubscriptionByID(ctx, conn.SubscriptionID)
+
+		return err == nil && (!sub.IsActive || !sub.EndDate.After(now))
+	})
+	sort.Slice(connections, func(i, j int) bool {
+
+		return connections[i].ID < connections[j].ID
+	})
+	if len(connections) > limit {
+		connections = connections[:limit]
+	}
+
+	return connections, nil
+}
+
+func (r *memoryVPNRepo) filter(match func(conn *core.VPNConnection) bool) []*core.VPNConnection {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]*core.VPNConnection, 0)
+	for _, conn := range r.connections {
+		if match(conn) {
+			copied := *conn
+			result = append(result, &copied)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+
+	return result
+}
+
+func (r *memoryVPNRepo) update(id string, modify func(conn *core.VPNConnection)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	conn, ok := r.connections[id]
+	if !ok {
+
+		return usecase.ErrNotFound
+	}
+	modify(conn)
+	conn.UpdatedAt = time.Now()
+
+	return nil
+}
+
+type memorySubscriptionRepo struct {
+	mu            sync.Mutex
+	subscriptions map[string]*core.Subscription
+}
+
+func newMemorySubscriptionRepo(subscriptions ...*core.Subscription) *memorySubscriptionRepo {
+	repo := &memorySubscriptionRepo{subscriptions: make(map[string]*core.Subscription)}
+	for _, sub := range subscriptions {
+		repo.subscriptions[sub.ID] = sub
+	}
+
+	return repo
+}
+
+func (r *memorySubscriptionRepo) CreateSubscription(ctx context.Context, subscription *core.Subscription) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *subscription
+	r.subscriptions[subscription.ID] = &stored
+
+	return nil
+}
+
+func (r *memorySubscriptionRepo) GetSubscriptionByID(ctx context.Context, id string) (*core.Subscription, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sub, ok := r.subscriptions[id]
+	if !ok {
+
+		return nil, usecase.ErrNotFound
+	}
+	copied := *sub
+
+	return &copied, nil
+}
+
+func (r *memorySubscriptionRepo) GetSubscriptionsByUserID(ctx context.Context, userID int64) ([]*core.Subscription, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]*core.Subscription, 0)
+	for _, sub := range r.subscriptions {
+		if sub.UserID == userID {
+			copied := *sub
+			result = append(result, &copied)
+		}
+	}
+
+	return result, nil
+}
+
+func (r *memorySubscriptionRepo) GetActiveSubscriptionByUserID(ctx context.Context, userID int64) (*core.Subscription, error) {
+	subscriptions, _ := r.GetSubscriptionsByUserID(ctx, userID)
+	for _, sub := range subscriptions {
+		if sub.IsActive {
+
+			return sub, nil
+		}
+	}
+
+	return nil, usecase.ErrNotFound
+}
+
+func (r *memorySubscriptionRepo) UpdateSubscription(ctx context.Context, subscription *core.Subscription) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.subscriptions[subscription.ID]; !ok {
+
+		return usecase.ErrNotFound
+	}
+	stored := *subscription
+	r.subscriptions[subscription.ID] = &stored
+
+	return nil
+}
+
+func (r *memorySubscriptionRepo) GetSubscriptionsDueForRenewal(ctx context.Context, chargeBefore, now time.Time) ([]*core.Subscription, error) {
+
+	return nil, nil
+}
+
+func (r *memorySubscriptionRepo) GetExpiredActiveSubscriptions(ctx context.Context, now time.Time, afterID string, limit int) ([]*core.Subscription, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]*core.Subscription, 0)
+	for _, sub := range r.subscriptions {
+		if sub.IsActive && !sub.EndDate.After(now) && sub.ID > afterID {
+			copied := *sub
+			result = append(result, &copied)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+
+		return result[i].ID < result[j].ID
+	})
+	if len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result, nil
+}
+
+func (r *memorySubscriptionRepo) GetSubscriptionsExpiringBetween(ctx context.Context, from, to time.Time) ([]*core.Subscription, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]*core.Subscription, 0)
+	for _, sub := range r.subscriptions {
+		if sub.IsActive && !sub.AutoRenew && sub.EndDate.After(from) && !sub.EndDate.After(to) {
+			copied := *sub
+			result = append(result, &copied)
+		}
+	}
+
+	return result, nil
+}
+
+func (r *memorySubscriptionRepo) GetSuspendedSubscriptions(ctx context.Context, suspendedBefore time.Time, afterID string, limit int) ([]*core.Subscription, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]*core.Subscription, 0)
+	for _, sub := range r.subscriptions {
+		if sub.GetStatus() == core.StatusSuspended && sub.SuspendedAt != nil && !sub.SuspendedAt.After(suspendedBefore) && sub.ID > afterID {
+			copied := *sub
+			result = append(result, &copied)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+
+		return result[i].ID < result[j].ID
+	})
+	if len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result, nil
+}
+
+func (r *memorySubscriptionRepo) UpdateSubscriptionStatus(ctx context.Context, subscription *core.Subscription, from core.SubscriptionStatus) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sub, ok := r.subscriptions[subscription.ID]
+	if !ok || sub.GetStatus() != from || !sub.EndDate.Equal(subscription.EndDate) {
+
+		return false, nil
+	}
+	sub.Status = subscription.GetStatus()
+	sub.IsActive = subscription.IsActive
+	sub.GraceEndsAt = subscription.GraceEndsAt
+	sub.SuspendedAt = subscription.SuspendedAt
+	sub.UpdatedAt = subscription.UpdatedAt
+
+	return true, nil
+}
+
+func (r *memorySubscriptionRepo) DeleteSubscription(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.subscriptions, id)
+
+	return nil
+}
+
+type reminderKey struct {
+	subscriptionID string
+	offsetHours    int
+	endDate        int64
+}
+
+type memoryReminderRepo struct {
+	mu   sync.Mutex
+	sent map[reminderKey]time.Time
+}
+
+func newMemoryReminderRepo() *memoryReminderRepo {
+
+	return &memoryReminderRepo{sent: make(map[reminderKey]time.Time)}
+}
+
+func (r *memoryReminderRepo) MarkReminderSent(ctx context.Context, subscriptionID string, offsetHours int, endDate time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := reminderKey{subscriptionID: subscriptionID, offsetHours: offsetHours, endDate: endDate.UnixNano()}
+	if _, ok := r.sent[key]; ok {
+
+		return false, nil
+	}
+	r.sent[key] = endDate
+
+	return true, nil
+}
+
+func (r *memoryReminderRepo) DeleteRemindersBefore(ctx context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deleted int64
+	for key, endDate := range r.sent {
+		if endDate.Before(before) {
+			delete(r.sent, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+type memoryBalanceRepo struct {
+	mu           sync.Mutex
+	transactions []*core.BalanceTransaction
+}
+
+func newMemoryBalanceRepo() *memoryBalanceRepo {
+
+	return &memoryBalanceRepo{}
+}
+
+func (r *memoryBalanceRepo) CreateTransaction(ctx context.Context, transaction *core.BalanceTransaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	copied := *transaction
+	r.transactions = append(r.transactions, &copied)
+
+	return nil
+}
+
+func (r *memoryBalanceRepo) GetBalance(ctx context.Context, userID int64) (float64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	balance := 0.0
+	for _, transaction := range r.transactions {
+		if transaction.UserID == userID {
+			balance += transaction.Amount
+		}
+	}
+
+	return balance, nil
+}
+
+func (r *memoryBalanceRepo) GetBalanceForUpdate(ctx context.Context, userID int64) (float64, error) {
+
+	return r.GetBalance(ctx, userID)
+}
+
+func (r *memoryBalanceRepo) GetTransactionsByUserID(ctx context.Context, userID int64, limit int) ([]*core.BalanceTransaction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]*core.BalanceTransaction, 0)
+	for i := len(r.transactions) - 1; i >= 0 && len(result) < limit; i-- {
+		if r.transactions[i].UserID == userID {
+			copied := *r.transactions[i]
+			result = append(result, &copied)
+		}
+	}
+
+	return result, nil
+}
+
+type passthroughUnitOfWork struct{}
+
+func (passthroughUnitOfWork) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+
+	return fn(ctx)
+}
+
+type memoryPlanRepo struct {
+	plans map[string]*core.Plan
+}
+
+func newMemoryPlanRepo(plans ...*core.Plan) *memoryPlanRepo {
+	repo := &memoryPlanRepo{plans: make(map[string]*core.Plan)}
+	for _, plan := range plans {
+		repo.plans[plan.ID] = plan
+	}
+
+	return repo
+}
+
+func (r *memoryPlanRepo) GetPlanByID(ctx context.Context, id string) (*core.Plan, error) {
+	plan, ok := r.plans[id]
+	if !ok {
+
+		return nil, usecase.ErrNotFound
+	}
+	copied := *plan
+
+	return &copied, nil
+}
+
+func (r *memoryPlanRepo) GetAll(ctx context.Context) ([]*core.Plan, error) {
+	plans := make([]*core.Plan, 0, len(r.plans))
+	for _, plan := range r.plans {
+		copied := *plan
+		plans = append(plans, &copied)
+	}
+
+	return plans, nil
+}
+
+type memoryUserRepo struct {
+	mu    sync.Mutex
+	users map[int64]*core.User
+}
+
+func newMemoryUserRepo(users ...*core.User) *memoryUserRepo {
+	repo := &memoryUserRepo{users: make(map[int64]*core.User)}
+	for _, user := range users {
+		repo.users[user.TelegramID] = user
+	}
+
+	return repo
+}
+
+func (r *memoryUserRepo) CreateUser(ctx context.Context, user *core.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *user
+	r.users[user.TelegramID] = &stored
+
+	return nil
+}
+
+func (r *memoryUserRepo) GetUserByID(ctx context.Context, id int64) (*core.User, error) {
+
+	return r.GetUserByTelegramID(ctx, id)
+}
+
+func (r *memoryUserRepo) GetUserByTelegramID(ctx context.Context, telegramID int64) (*core.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[telegramID]
+	if !ok {
+
+		return nil, usecase.ErrNotFound
+	}
+	copied := *user
+
+	return &copied, nil
+}
+
+func (r *memoryUserRepo) UpdateUser(ctx context.Context, user *core.User) error {
+
+	return r.CreateUser(ctx, user)
+}
+
+func (r *memoryUserRepo) MarkTrialAsUsed(ctx context.Context, userID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user, ok := r.users[userID]; ok {
+		user.HasTrial = true
+	}
+
+	return nil
+}
+
+type memoryNotificationRepo struct {
+	mu            sync.Mutex
+	notifications map[string]*core.Notification
+}
+
+func newMemoryNotificationRepo() *memoryNotificationRepo {
+
+	return &memoryNotificationRepo{notifications: make(map[string]*core.Notification)}
+}
+
+func (r *memoryNotificationRepo) CreateNotification(ctx context.Context, notification *core.Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *notification
+	r.notifications[notification.ID] = &stored
+
+	return nil
+}
+
+func (r *memoryNotificationRepo) GetNotificationByID(ctx context.Context, id string) (*core.Notification, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	notification, ok := r.notifications[id]
+	if !ok {
+
+		return nil, usecase.ErrNotFound
+	}
+	copied := *notification
+
+	return &copied, nil
+}
+
+func (r *memoryNotificationRepo) GetNotificationsByUserID(ctx context.Context, userID int64) ([]*core.Notification, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]*core.Notification, 0)
+	for _, notification := range r.notifications {
+		if notification.UserID == userID {
+			copied := *notification
+			result = append(result, &copied)
+		}
+	}
+
+	return result, nil
+}
+
+func (r *memoryNotificationRepo) GetUnreadNotificationsByUserID(ctx context.Context, userID int64) ([]*core.Notification, error) {
+	notifications, _ := r.GetNotificationsByUserID(ctx, userID)
+	result := make([]*core.Notification, 0)
+	for _, notification := range notifications {
+		if !notification.IsRead {
+			result = append(result, notification)
+		}
+	}
+
+	return result, nil
+}
+
+func (r *memoryNotificationRepo) UpdateNotification(ctx context.Context, notification *core.Notification) error {
+
+	return r.CreateNotification(ctx, notification)
+}
+
+func (r *memoryNotificationRepo) MarkAsRead(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	notification, ok := r.notifications[id]
+	if !ok {
+
+		return usecase.ErrNotFound
+	}
+	notification.IsRead = true
+
+	return nil
+}
+
+func (r *memoryNotificationRepo) DeleteNotification(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.notifications, id)
+
+	return nil
+}
+
+func (r *memoryNotificationRepo) DeleteReadNotificationsOlderThan(ctx context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deleted int64
+	for id, notification := range r.notifications {
+		if notification.IsRead && notification.CreatedAt.Before(before) {
+			delete(r.notifications, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+type memoryPromoRepo struct {
+	mu      sync.Mutex
+	promos  map[string]*core.PromoCode
+	usages  []*core.PromoCodeUsage
+	pending map[string][]int64
+}
+
+func newMemoryPromoRepo() *memoryPromoRepo {
+
+	return &memoryPromoRepo{
+		promos:  make(map[string]*core.PromoCode),
+		pending: make(map[string][]int64),
+	}
+}
+
+func (r *memoryPromoRepo) CreatePromoCode(ctx context.Context, promo *core.PromoCode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	copied := *promo
+	r.promos[promo.ID] = &copied
+
+	return nil
+}
+
+func (r *memoryPromoRepo) GetPromoCodeByCode(ctx context.Context, code string) (*core.PromoCode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, promo := range r.promos {
+		if promo.Code == code {
+			copied := *promo
+
+			return &copied, nil
+		}
+	}
+
+	return nil, usecase.ErrNotFound
+}
+
+func (r *memoryPromoRepo) GetPromoCodeByIDForUpdate(ctx context.Context, id string) (*core.PromoCode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	promo, ok := r.promos[id]
+	if !ok {
+
+		return nil, usecase.ErrNotFound
+	}
+	copied := *promo
+
+	return &copied, nil
+}
+
+func (r *memoryPromoRepo) GetPromoCodes(ctx context.Context) ([]*core.PromoCode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	promos := make([]*core.PromoCode, 0, len(r.promos))
+	for _, promo := range r.promos {
+		copied := *promo
+		promos = append(promos, &copied)
+	}
+
+	return promos, nil
+}
+
+func (r *memoryPromoRepo) UpdatePromoCode(ctx context.Context, promo *core.PromoCode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.promos[promo.ID]; !ok {
+
+		return usecase.ErrNotFound
+	}
+	copied := *promo
+	r.promos[promo.ID] = &copied
+
+	return nil
+}
+
+func (r *memoryPromoRepo) CreatePromoCodeUsage(ctx context.Context, usage *core.PromoCodeUsage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.usages = append(r.usages, usage)
+
+	return nil
+}
+
+func (r *memoryPromoRepo) CountUserPromoCodeUsages(ctx context.Context, promoCodeID string, userID int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := 0
+	for _, usage := range r.usages {
+		if usage.PromoCodeID == promoCodeID && usage.UserID == userID {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (r *memoryPromoRepo) CountPendingPromoCodePayments(ctx context.Context, promoCodeID string, userID int64) (int, int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	byUser := 0
+	for _, pendingUserID := range r.pending[promoCodeID] {
+		if pendingUserID == userID {
+			byUser++
+		}
+	}
+
+	return len(r.pending[promoCodeID]), byUser, nil
+}
+
+func (r *memoryPromoRepo) addPendingPayment(promoCodeID string, userID int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pending[promoCodeID] = append(r.pending[promoCodeID], userID)
+}
+
+func (r *memoryPromoRepo) completePendingPayment(promoCodeID string, userID int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pending := r.pending[promoCodeID]
+	for i, pendingUserID := range pending {
+		if pendingUserID == userID {
+			r.pending[promoCodeID] = append(pending[:i], pending[i+1:]...)
+
+			return
+		}
+	}
+}
+
+type memoryPaymentRepo struct {
+	mu       sync.Mutex
+	payments map[string]*core.Payment
+}
+
+func newMemoryPaymentRepo(payments ...*core.Payment) *memoryPaymentRepo {
+	repo := &memoryPaymentRepo{payments: make(map[string]*core.Payment)}
+	for _, payment := range payments {
+		repo.payments[payment.ID] = payment
+	}
+
+	return repo
+}
+
+func (r *memoryPaymentRepo) CreatePayment(ctx context.Context, payment *core.Payment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	copied := *payment
+	r.payments[payment.ID] = &copied
+
+	return nil
+}
+
+func (r *memoryPaymentRepo) GetPaymentByID(ctx context.Context, id string) (*core.Payment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	payment, ok := r.payments[id]
+	if !ok {
+
+		return nil, usecase.ErrNotFound
+	}
+	copied := *payment
+
+	return &copied, nil
+}
+
+func (r *memoryPaymentRepo) GetPaymentByIDForUpdate(ctx context.Context, id string) (*core.Payment, error) {
+
+	return r.GetPaymentByID(ctx, id)
+}
+
+func (r *memoryPaymentRepo) GetPaymentByExternalID(ctx context.Context, externalID string) (*core.Payment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, payment := range r.payments {
+		if payment.ExternalID == externalID {
+			copied := *payment
+
+			return &copied, nil
+		}
+	}
+
+	return nil, usecase.ErrNotFound
+}
+
+func (r *memoryPaymentRepo) GetPaymentsByUserID(ctx context.Context, userID int64) ([]*core.Payment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var payments []*core.Payment
+	for _, payment := range r.payments {
+		if payment.UserID == userID {
+			copied := *payment
+			payments = append(payments, &copied)
+		}
+	}
+
+	return payments, nil
+}
+
+func (r *memoryPaymentRepo) GetPendingPaymentsOlderThan(ctx context.Context, cutoff time.Time) ([]*core.Payment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var payments []*core.Payment
+	for _, payment := range r.payments {
+		if payment.IsPending() && payment.CreatedAt.Before(cutoff) {
+			copied := *payment
+			payments = append(payments, &copied)
+		}
+	}
+
+	return payments, nil
+}
+
+func (r *memoryPaymentRepo) CountPendingPaymentsBySubscriptionID(ctx context.Context, subscriptionID string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := 0
+	for _, payment := range r.payments {
+		if payment.SubscriptionID == subscriptionID && payment.IsPending() {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (r *memoryPaymentRepo) UpdatePayment(ctx context.Context, payment *core.Payment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.payments[payment.ID]; !ok {
+
+		return usecase.ErrNotFound
+	}
+	copied := *payment
+	r.payments[payment.ID] = &copied
+
+	return nil
+}
+
+func (r *memoryPaymentRepo) UpdatePaymentStatus(ctx context.Context, id, status string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	payment, ok := r.payments[id]
+	if !ok {
+
+		return usecase.ErrNotFound
+	}
+	payment.Status = status
+
+	return nil
+}
+
+func (r *memoryPaymentRepo) UpdateReceipt(ctx context.Context, id, email, status string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	payment, ok := r.payments[id]
+	if !ok {
+
+		return usecase.ErrNotFound
+	}
+	payment.ReceiptEmail = email
+	payment.ReceiptStatus = status
+
+	return nil
+}
+
+func (r *memoryPaymentRepo) DeletePayment(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.payments, id)
+
+	return nil
+}
+
+type memoryRefundRepo struct {
+	mu      sync.Mutex
+	refunds []*core.PaymentRefund
+}
+
+func newMemoryRefundRepo(refunds ...*core.PaymentRefund) *memoryRefundRepo {
+
+	return &memoryRefundRepo{refunds: refunds}
+}
+
+func (r *memoryRefundRepo) CreateRefund(ctx context.Context, refund *core.PaymentRefund) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	copied := *refund
+	r.refunds = append(r.refunds, &copied)
+
+	return nil
+}
+
+func (r *memoryRefundRepo) UpdateRefund(ctx context.Context, refund *core.PaymentRefund) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, existing := range r.refunds {
+		if existing.ID == refund.ID {
+			copied := *refund
+			r.refunds[i] = &copied
+
+			return nil
+		}
+	}
+
+	return usecase.ErrNotFound
+}
+
+func (r *memoryRefundRepo) GetRefundByID(ctx context.Context, id string) (*core.PaymentRefund, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, refund := range r.refunds {
+		if refund.ID == id {
+			copied := *refund
+
+			return &copied, nil
+		}
+	}
+
+	return nil, usecase.ErrNotFound
+}
+
+func (r *memoryRefundRepo) GetPendingRefundByPaymentID(ctx context.Context, paymentID string) (*core.PaymentRefund, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, refund := range r.refunds {
+		if refund.PaymentID == paymentID && refund.IsPending() {
+			copied := *refund
+
+			return &copied, nil
+		}
+	}
+
+	return nil, usecase.ErrNotFound
+}
+
+func (r *memoryRefundRepo) GetRefundsByPaymentID(ctx context.Context, paymentID string) ([]*core.PaymentRefund, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var refunds []*core.PaymentRefund
+	for _, refund := range r.refunds {
+		if refund.PaymentID == paymentID {
+			copied := *refund
+			refunds = append(refunds, &copied)
+		}
+	}
+
+	return refunds, nil
+}
+
+func (r *memoryRefundRepo) GetTotalRefundedAmount(ctx context.Context, paymentID string) (float64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var total float64
+	for _, refund := range r.refunds {
+		if refund.PaymentID == paymentID {
+			total += refund.Amount
+		}
+	}
+
+	return total, nil
+}
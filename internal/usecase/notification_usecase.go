@@ -48,7 +48,7 @@ func (uc *NotificationUseCase) CreateNotification(ctx context.Context, dto Creat
 		return fmt.Errorf("failed to create notification: %w", err)
 	}
 
-	return uc.sendToTelegram(ctx, notification)
+	return uc.sendToTelegram(ctx, notification, dto.Markup)
 }
 
 func (uc *NotificationUseCase) SendNotification(ctx context.Context, dto SendNotificationDTO) error {
@@ -67,10 +67,10 @@ func (uc *NotificationUseCase) SendNotification(ctx context.Context, dto SendNot
 		return err
 	}
 
-	return uc.sendToTelegram(ctx, newNotif)
+	return uc.sendToTelegram(ctx, newNotif, nil)
 }
 
-func (uc *NotificationUseCase) sendToTelegram(ctx context.Context, notification *core.Notification) error {
+func (uc *NotificationUseCase) sendToTelegram(ctx context.Context, notification *core.Notification, markup interface{}) error {
 	user, err := uc.userRepo.GetUserByID(ctx, notification.UserID)
 	if err != nil {
 
@@ -84,7 +84,7 @@ func (uc *NotificationUseCase) sendToTelegram(ctx context.Context, notification
 
 	message := fmt.Sprintf("📢 *%s*\n\n%s", notification.Title, notification.Message)
 
-	if err := uc.notifier.SendWithParseMode(ctx, user.TelegramID, message, "Markdown", nil); err != nil {
+	if err := uc.notifier.SendWithParseMode(ctx, user.TelegramID, message, "Markdown", markup); err != nil {
 		slog.Error("Failed to send notification to user", "user_id", user.TelegramID, "error", err)
 
 		return fmt.Errorf("failed to send message: %w", err)
@@ -176,6 +176,16 @@ func (uc *NotificationUseCase) DeleteNotification(ctx context.Context, userID in
 	return uc.notifRepo.DeleteNotification(ctx, notificationID)
 }
 
+func (uc *NotificationUseCase) DeleteOldNotifications(ctx context.Context, retention time.Duration) (int64, error) {
+	deleted, err := uc.notifRepo.DeleteReadNotificationsOlderThan(ctx, time.Now().Add(-retention))
+	if err != nil {
+
+		return 0, fmt.Errorf("failed to delete old notifications: %w", err)
+	}
+
+	return deleted, nil
+}
+
 func (uc *NotificationUseCase) SendNotificationWithPhoto(ctx context.Context, userID int64, photoPath, caption string, keyboard interface{}) error {
 	user, err := uc.userRepo.GetUserByTelegramID(ctx, userID)
 	if err != nil {
@@ -0,0 +1,166 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/ports"
+)
+
+type PanelSelectionPolicy string
+
+const (
+	PanelSelectionLeastUsers PanelSelectionPolicy = "least_users"
+	PanelSelectionRegion     PanelSelectionPolicy = "region"
+	PanelSelectionRoundRobin PanelSelectionPolicy = "round_robin"
+)
+
+type PanelSelector struct {
+	registry        ports.PanelRegistry
+	vpnRepo         ports.VPNRepo
+	policy          PanelSelectionPolicy
+	preferredRegion string
+
+	mu             sync.Mutex
+	currentWeights map[string]int
+}
+
+func NewPanelSelector(registry ports.PanelRegistry, vpnRepo ports.VPNRepo, policy PanelSelectionPolicy, preferredRegion string) *PanelSelector {
+
+	return &PanelSelector{
+		registry:        registry,
+		vpnRepo:         vpnRepo,
+		policy:          policy,
+		preferredRegion: preferredRegion,
+		currentWeights:  make(map[string]int),
+	}
+}
+
+func (s *PanelSelector) Select(ctx context.Context, region string) (core.PanelServer, error) {
+	candidates := s.registry.Servers()
+	if len(candidates) == 0 {
+
+		return core.PanelServer{}, ErrNoServersAvailable
+	}
+
+	counts, err := s.ConnectionsByServer(ctx)
+	if err != nil {
+
+		return core.PanelServer{}, err
+	}
+
+	candidates = filterAvailableServers(candidates, counts)
+	if len(candidates) == 0 {
+
+		return core.PanelServer{}, ErrNoServersAvailable
+	}
+
+	if region == "" && s.policy == PanelSelectionRegion {
+		region = s.preferredRegion
+	}
+
+	if region != "" {
+		if inRegion := filterServersByRegion(candidates, region); len(inRegion) > 0 {
+			candidates = inRegion
+		}
+	}
+
+	if len(candidates) == 1 {
+
+		return candidates[0], nil
+	}
+
+	if s.policy == PanelSelectionRoundRobin {
+
+		return s.nextRoundRobin(candidates), nil
+	}
+
+	return leastLoaded(candidates, counts), nil
+}
+
+func (s *PanelSelector) ConnectionsByServer(ctx context.Context) (map[string]int, error) {
+	counts, err := s.vpnRepo.CountVPNConnectionsByServer(ctx)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to count VPN connections: %w", err)
+	}
+
+	if unassigned, ok := counts[""]; ok {
+		counts[s.registry.DefaultServer()] += unassigned
+		delete(counts, "")
+	}
+
+	return counts, nil
+}
+
+func leastLoaded(candidates []core.PanelServer, counts map[string]int) core.PanelServer {
+	best := candidates[0]
+	bestLoad := serverLoad(counts[best.Name], best.Weight)
+	for _, server := range candidates[1:] {
+		if load := serverLoad(counts[server.Name], server.Weight); load < bestLoad {
+			best = server
+			bestLoad = load
+		}
+	}
+
+	return best
+}
+
+func (s *PanelSelector) nextRoundRobin(candidates []core.PanelServer) core.PanelServer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := 0
+	best := candidates[0]
+	for _, server := range candidates {
+		weight := normalizedWeight(server.Weight)
+		total += weight
+		s.currentWeights[server.Name] += weight
+		if s.currentWeights[server.Name] > s.currentWeights[best.Name] {
+			best = server
+		}
+	}
+
+	s.currentWeights[best.Name] -= total
+
+	return best
+}
+
+func filterAvailableServers(servers []core.PanelServer, counts map[string]int) []core.PanelServer {
+	var result []core.PanelServer
+	for _, server := range servers {
+		if server.Capacity <= 0 || counts[server.Name] < server.Capacity {
+			result = append(result, server)
+		}
+	}
+
+	return result
+}
+
+func filterServersByRegion(servers []core.PanelServer, region string) []core.PanelServer {
+	var result []core.PanelServer
+	for _, server := range servers {
+		if strings.EqualFold(server.Region, region) {
+			result = append(result, server)
+		}
+	}
+
+	return result
+}
+
+func serverLoad(users, weight int) float64 {
+
+	return float64(users) / float64(normalizedWeight(weight))
+}
+
+func normalizedWeight(weight int) int {
+	if weight <= 0 {
+
+		return 1
+	}
+
+	return weight
+}
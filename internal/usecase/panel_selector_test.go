@@ -0,0 +1,109 @@
+package usecase_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"3xui-bot/internal/adapters/panel"
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/usecase"
+)
+
+func newTestSelector(t *testing.T, vpnRepo *memoryVPNRepo, policy usecase.PanelSelectionPolicy, servers ...core.PanelServer) *usecase.PanelSelector {
+	t.Helper()
+
+	registry := panel.NewRegistry()
+	for _, server := range servers {
+		registerFakePanel(t, registry, server)
+	}
+
+	return usecase.NewPanelSelector(registry, vpnRepo, policy, "")
+}
+
+func addConnection(t *testing.T, vpnRepo *memoryVPNRepo, id, serverName string, isActive bool) {
+	t.Helper()
+
+	conn := &core.VPNConnection{ID: id, TelegramUserID: testUserID, MarzbanUsername: "user_" + id, ServerName: serverName, IsActive: isActive}
+	if err := vpnRepo.CreateVPNConnection(context.Background(), conn); err != nil {
+		t.Fatalf("failed to create VPN connection: %v", err)
+	}
+}
+
+func TestPanelSelectorRoundRobinSkipsFullServers(t *testing.T) {
+	ctx := context.Background()
+	vpnRepo := newMemoryVPNRepo()
+	addConnection(t, vpnRepo, "vpn-1", "full", true)
+	selector := newTestSelector(t, vpnRepo, usecase.PanelSelectionRoundRobin,
+		core.PanelServer{Name: "full", Weight: 1, Capacity: 1},
+		core.PanelServer{Name: "spare", Weight: 1, Capacity: 10},
+	)
+
+	for i := 0; i < 4; i++ {
+		server, err := selector.Select(ctx, "")
+		if err != nil {
+			t.Fatalf("Select returned error: %v", err)
+		}
+		if server.Name != "spare" {
+			t.Errorf("selection %d: expected spare server, got %s", i, server.Name)
+		}
+	}
+}
+
+func TestPanelSelectorReturnsNoServersWhenFull(t *testing.T) {
+	tests := []struct {
+		name    string
+		servers []core.PanelServer
+	}{
+		{name: "single server", servers: []core.PanelServer{{Name: "only", Weight: 1, Capacity: 1}}},
+		{name: "all servers", servers: []core.PanelServer{{Name: "only", Weight: 1, Capacity: 1}, {Name: "other", Weight: 1, Capacity: 1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vpnRepo := newMemoryVPNRepo()
+			for _, server := range tt.servers {
+				addConnection(t, vpnRepo, "vpn-"+server.Name, server.Name, true)
+			}
+
+			for _, policy := range []usecase.PanelSelectionPolicy{usecase.PanelSelectionLeastUsers, usecase.PanelSelectionRoundRobin} {
+				selector := newTestSelector(t, vpnRepo, policy, tt.servers...)
+				if _, err := selector.Select(context.Background(), ""); !errors.Is(err, usecase.ErrNoServersAvailable) {
+					t.Errorf("%s: expected ErrNoServersAvailable, got %v", policy, err)
+				}
+			}
+		})
+	}
+}
+
+func TestPanelSelectorIgnoresInactiveConnections(t *testing.T) {
+	vpnRepo := newMemoryVPNRepo()
+	addConnection(t, vpnRepo, "vpn-1", "only", false)
+	addConnection(t, vpnRepo, "vpn-2", "only", false)
+	selector := newTestSelector(t, vpnRepo, usecase.PanelSelectionLeastUsers, core.PanelServer{Name: "only", Weight: 1, Capacity: 1})
+
+	server, err := selector.Select(context.Background(), "")
+	if err != nil {
+		t.Fatalf("expected inactive connections not to use up capacity, got %v", err)
+	}
+	if server.Name != "only" {
+		t.Errorf("expected only server, got %s", server.Name)
+	}
+}
+
+func TestPanelSelectorLeavesFullRegion(t *testing.T) {
+	vpnRepo := newMemoryVPNRepo()
+	addConnection(t, vpnRepo, "vpn-1", "nl", true)
+	selector := newTestSelector(t, vpnRepo, usecase.PanelSelectionLeastUsers,
+		core.PanelServer{Name: "nl", Region: "NL", Weight: 1, Capacity: 1},
+		core.PanelServer{Name: "de", Region: "DE", Weight: 1, Capacity: 10},
+	)
+
+	server, err := selector.Select(context.Background(), "nl")
+	if err != nil {
+		t.Fatalf("Select returned error: %v", err)
+	}
+	if server.Name != "de" {
+		t.Errorf("expected full region to fall back to de, got %s", server.Name)
+	}
+}
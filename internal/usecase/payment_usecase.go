@@ -3,40 +3,84 @@ package usecase
 import (
 	"3xui-bot/internal/ports"
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math"
+	"strings"
 	"time"
 
 	"3xui-bot/internal/core"
 	"3xui-bot/internal/pkg/id"
 )
 
+const (
+	MinTopUpAmount = 50.0
+	MaxTopUpAmount = 50000.0
+)
+
 type PaymentProvider interface {
-	CreatePayment(ctx context.Context, amount float64, currency, description string) (paymentURL string, paymentID string, err error)
+	CreatePayment(ctx context.Context, dto CreateProviderPaymentDTO) (paymentURL string, paymentID string, err error)
 	CheckPaymentStatus(ctx context.Context, paymentID string) (status string, err error)
+	CapturePayment(ctx context.Context, paymentID string) (status string, err error)
+	CancelPayment(ctx context.Context, paymentID string) (status string, err error)
+	Refund(ctx context.Context, externalID string, amount float64, idempotencyKey string) (refundID string, err error)
+	ChargeSavedMethod(ctx context.Context, dto CreateProviderPaymentDTO) (paymentID string, status string, err error)
+	GetPaymentMethod(ctx context.Context, paymentID string) (*ProviderPaymentMethodDTO, error)
+	SendReceipt(ctx context.Context, externalID string, receipt ProviderReceiptDTO) (status string, err error)
+	GetReceiptStatus(ctx context.Context, paymentID string) (status string, err error)
+}
+
+type StarsRefunder interface {
+	RefundStarPayment(ctx context.Context, userID int64, chargeID string) error
 }
 
 type PaymentUseCase struct {
-	paymentRepo    ports.PaymentRepo
-	subscriptionUC *SubscriptionUseCase
-	vpnUC          *VPNUseCase
-	notifUC        *NotificationUseCase
-	provider       PaymentProvider
+	uow             ports.UnitOfWork
+	paymentRepo     ports.PaymentRepo
+	refundRepo      ports.PaymentRefundRepo
+	savedMethodRepo ports.SavedPaymentMethodRepo
+	userRepo        ports.UserRepo
+	subscriptionUC  *SubscriptionUseCase
+	promoUC         *PromoCodeUseCase
+	balanceUC       *BalanceUseCase
+	vpnUC           *VPNUseCase
+	notifUC         *NotificationUseCase
+	provider        PaymentProvider
+	starsRefunder   StarsRefunder
+	receipt         ReceiptSettings
 }
 
 func NewPaymentUseCase(
+	uow ports.UnitOfWork,
 	paymentRepo ports.PaymentRepo,
+	refundRepo ports.PaymentRefundRepo,
+	savedMethodRepo ports.SavedPaymentMethodRepo,
+	userRepo ports.UserRepo,
 	subscriptionUC *SubscriptionUseCase,
+	promoUC *PromoCodeUseCase,
+	balanceUC *BalanceUseCase,
 	vpnUC *VPNUseCase,
 	notifUC *NotificationUseCase,
 	provider PaymentProvider,
+	starsRefunder StarsRefunder,
+	receipt ReceiptSettings,
 ) *PaymentUseCase {
 
 	return &PaymentUseCase{
-		paymentRepo:    paymentRepo,
-		subscriptionUC: subscriptionUC,
-		vpnUC:          vpnUC,
-		notifUC:        notifUC,
-		provider:       provider,
+		uow:             uow,
+		paymentRepo:     paymentRepo,
+		refundRepo:      refundRepo,
+		savedMethodRepo: savedMethodRepo,
+		userRepo:        userRepo,
+		subscriptionUC:  subscriptionUC,
+		promoUC:         promoUC,
+		balanceUC:       balanceUC,
+		vpnUC:           vpnUC,
+		notifUC:         notifUC,
+		provider:        provider,
+		starsRefunder:   starsRefunder,
+		receipt:         receipt,
 	}
 }
 
@@ -46,6 +90,7 @@ func (uc *PaymentUseCase) CreatePayment(ctx context.Context, dto CreatePaymentDT
 		Amount:        dto.Amount,
 		Currency:      dto.Currency,
 		PaymentMethod: dto.PaymentMethod,
+		Purpose:       string(core.PaymentPurposeSubscription),
 		Description:   dto.Description,
 		Status:        string(core.PaymentStatusPending),
 		CreatedAt:     time.Now(),
@@ -66,6 +111,11 @@ func (uc *PaymentUseCase) GetPayment(ctx context.Context, paymentID string) (*co
 	return uc.paymentRepo.GetPaymentByID(ctx, paymentID)
 }
 
+func (uc *PaymentUseCase) GetPaymentByExternalID(ctx context.Context, externalID string) (*core.Payment, error) {
+
+	return uc.paymentRepo.GetPaymentByExternalID(ctx, externalID)
+}
+
 func (uc *PaymentUseCase) GetUserPayments(ctx context.Context, userID int64) ([]*core.Payment, error) {
 
 	return uc.paymentRepo.GetPaymentsByUserID(ctx, userID)
@@ -86,95 +136,366 @@ func (uc *PaymentUseCase) CancelPayment(ctx context.Context, paymentID string) e
 	return uc.paymentRepo.UpdatePaymentStatus(ctx, paymentID, string(core.PaymentStatusCancelled))
 }
 
-func (uc *PaymentUseCase) CreatePaymentForPlan(ctx context.Context, userID int64, planID string) (*core.Payment, string, error) {
+func (uc *PaymentUseCase) QuotePlan(ctx context.Context, userID int64, planID string, promoCode string) (*PlanQuoteDTO, error) {
 	plan, err := uc.subscriptionUC.GetPlan(ctx, planID)
 	if err != nil {
 
-		return nil, "", fmt.Errorf("failed to get plan: %w", err)
+		return nil, fmt.Errorf("failed to get plan: %w", err)
 	}
 
-	payment := &core.Payment{
-		ID:            id.Generate(),
-		UserID:        userID,
-		Amount:        plan.Price,
-		Currency:      "RUB",
-		PaymentMethod: "mock",
-		Description:   fmt.Sprintf("Подписка: %s", plan.Name),
-		Status:        string(core.PaymentStatusPending),
-		CreatedAt:     time.Now(),
-		UpdatedAt:     time.Now(),
+	if !plan.IsActive {
+
+		return nil, ErrPlanNotActive
 	}
 
-	if err := uc.paymentRepo.CreatePayment(ctx, payment); err != nil {
+	quote := &PlanQuoteDTO{
+		Plan:        plan,
+		Amount:      plan.Price,
+		StarsAmount: plan.StarsPrice,
+	}
+
+	if promoCode == "" {
+
+		return quote, nil
+	}
+
+	promo, err := uc.promoUC.ValidatePromoCode(ctx, userID, promoCode, plan.ID)
+	if err != nil {
+
+		return nil, err
+	}
+
+	quote.PromoCode = promo
+	quote.BonusDays = promo.BonusDays
+	quote.DiscountAmount = promo.Discount(plan.Price)
+	quote.Amount = roundAmount(plan.Price - quote.DiscountAmount)
+
+	if plan.StarsPrice > 0 && plan.Price > 0 {
+		quote.StarsAmount = int(math.Round(float64(plan.StarsPrice) * quote.Amount / plan.Price))
+		if quote.StarsAmount < 1 && quote.Amount > 0 {
+			quote.StarsAmount = 1
+		}
+	}
+
+	return quote, nil
+}
+
+func (uc *PaymentUseCase) CreatePaymentForPlan(ctx context.Context, userID int64, planID string, method core.PaymentMethod, promoCode string) (*core.Payment, string, error) {
+	quote, err := uc.QuotePlan(ctx, userID, planID, promoCode)
+	if err != nil {
+
+		return nil, "", err
+	}
+
+	if quote.Plan.Price <= 0 {
 
-		return nil, "", fmt.Errorf("failed to create payment: %w", err)
+		return nil, "", ErrInvalidAmount
 	}
 
-	paymentURL, externalID, err := uc.provider.CreatePayment(
-		ctx,
-		plan.Price,
-		"RUB",
-		payment.Description,
-	)
+	payment := newPlanPayment(userID, quote, quote.Amount, core.CurrencyRUB, method)
+
+	receipt, err := uc.prepareReceipt(ctx, payment)
+	if err != nil {
+
+		return nil, "", err
+	}
+
+	if err := uc.createPlanPayment(ctx, payment); err != nil {
+
+		return nil, "", err
+	}
+
+	if payment.Amount == 0 {
+
+		return uc.completeFreePayment(ctx, payment)
+	}
+
+	paymentURL, externalID, err := uc.provider.CreatePayment(ctx, CreateProviderPaymentDTO{
+		Amount:         payment.Amount,
+		Currency:       payment.Currency,
+		Description:    payment.Description,
+		PaymentMethod:  method,
+		IdempotencyKey: payment.IdempotencyKey,
+		Metadata: map[string]string{
+			"payment_id": payment.ID,
+			"plan_id":    quote.Plan.ID,
+			"user_id":    fmt.Sprintf("%d", userID),
+		},
+		SavePaymentMethod: method == core.PaymentMethodCard,
+		Receipt:           receipt,
+	})
 	if err != nil {
+		_ = uc.paymentRepo.UpdatePaymentStatus(ctx, payment.ID, string(core.PaymentStatusFailed))
 
 		return nil, "", fmt.Errorf("failed to create payment in provider: %w", err)
 	}
 
-	_ = externalID
+	payment.ExternalID = externalID
+	payment.UpdatedAt = time.Now()
+
+	if err := uc.paymentRepo.UpdatePayment(ctx, payment); err != nil {
+
+		return nil, "", fmt.Errorf("failed to save external payment ID: %w", err)
+	}
 
 	return payment, paymentURL, nil
 }
 
-func (uc *PaymentUseCase) ProcessPaymentSuccess(ctx context.Context, paymentID string, planID string) error {
+func (uc *PaymentUseCase) completeFreePayment(ctx context.Context, payment *core.Payment) (*core.Payment, string, error) {
+	if err := uc.ProcessPaymentSuccess(ctx, payment.ID); err != nil {
+
+		return nil, "", err
+	}
+
+	payment.Status = string(core.PaymentStatusCompleted)
+
+	return payment, "", nil
+}
+
+func (uc *PaymentUseCase) createPlanPayment(ctx context.Context, payment *core.Payment) error {
+	if payment.PromoCodeID == "" {
+		if err := uc.paymentRepo.CreatePayment(ctx, payment); err != nil {
+
+			return fmt.Errorf("failed to create payment: %w", err)
+		}
+
+		return nil
+	}
+
+	return uc.uow.Do(ctx, func(ctx context.Context) error {
+		if err := uc.promoUC.ReservePromoCode(ctx, payment.PromoCodeID, payment.UserID); err != nil {
+
+			return err
+		}
+
+		if err := uc.paymentRepo.CreatePayment(ctx, payment); err != nil {
+
+			return fmt.Errorf("failed to create payment: %w", err)
+		}
+
+		return nil
+	})
+}
+
+func newPlanPayment(userID int64, quote *PlanQuoteDTO, amount float64, currency string, method core.PaymentMethod) *core.Payment {
+	payment := &core.Payment{
+		ID:             id.Generate(),
+		UserID:         userID,
+		PlanID:         quote.Plan.ID,
+		Amount:         amount,
+		Currency:       currency,
+		PaymentMethod:  string(method),
+		Purpose:        string(core.PaymentPurposeSubscription),
+		IdempotencyKey: id.Generate(),
+		Description:    fmt.Sprintf("Подписка: %s", quote.Plan.Name),
+		Status:         string(core.PaymentStatusPending),
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+
+	if quote.PromoCode != nil {
+		payment.PromoCodeID = quote.PromoCode.ID
+		payment.DiscountAmount = quote.DiscountAmount
+		payment.BonusDays = quote.BonusDays
+		payment.Description = fmt.Sprintf("Подписка: %s (промокод %s)", quote.Plan.Name, quote.PromoCode.Code)
+	}
+
+	return payment
+}
+
+func (uc *PaymentUseCase) CheckPayment(ctx context.Context, paymentID string) (core.PaymentStatus, error) {
 	payment, err := uc.paymentRepo.GetPaymentByID(ctx, paymentID)
 	if err != nil {
 
-		return fmt.Errorf("failed to get payment: %w", err)
+		return "", fmt.Errorf("failed to get payment: %w", err)
 	}
 
-	if payment.IsCompleted() {
+	switch {
+	case payment.IsCompleted():
+
+		return core.PaymentStatusCompleted, ErrPaymentAlreadyPaid
+	case payment.IsCancelled(), payment.IsExpired():
 
-		return fmt.Errorf("payment already completed")
+		return uc.checkClosedPayment(ctx, payment)
+	case payment.IsRefunded():
+
+		return core.PaymentStatus(payment.Status), ErrPaymentCancelled
+	case payment.IsFailed():
+
+		return core.PaymentStatusFailed, ErrPaymentFailed
 	}
 
-	if err := uc.paymentRepo.UpdatePaymentStatus(ctx, paymentID, string(core.PaymentStatusCompleted)); err != nil {
+	if payment.ExternalID == "" {
 
-		return fmt.Errorf("failed to update payment status: %w", err)
+		return core.PaymentStatusPending, nil
 	}
 
-	plan, err := uc.subscriptionUC.GetPlan(ctx, planID)
+	status, err := uc.provider.CheckPaymentStatus(ctx, payment.ExternalID)
 	if err != nil {
 
-		return fmt.Errorf("failed to get plan: %w", err)
+		return "", fmt.Errorf("failed to check payment status: %w", err)
 	}
 
-	subscriptionDTO := CreateSubscriptionDTO{
-		UserID:    payment.UserID,
-		Name:      "Основная подписка",
-		PlanID:    planID,
-		StartDate: time.Now(),
-		EndDate:   time.Now().AddDate(0, 0, plan.Days),
-		IsActive:  true,
+	if status == string(core.PaymentStatusWaitingForCapture) {
+		status, err = uc.provider.CapturePayment(ctx, payment.ExternalID)
+		if err != nil {
+
+			return "", fmt.Errorf("failed to capture payment: %w", err)
+		}
+	}
+
+	switch core.PaymentStatus(status) {
+	case core.PaymentStatusCompleted:
+		if err := uc.ProcessPaymentSuccess(ctx, paymentID); err != nil {
+
+			return "", err
+		}
+
+		return core.PaymentStatusCompleted, nil
+	case core.PaymentStatusCancelled:
+		if err := uc.ProcessPaymentCancellation(ctx, paymentID); err != nil {
+
+			return "", err
+		}
+
+		return core.PaymentStatusCancelled, ErrPaymentCancelled
+	case core.PaymentStatusFailed:
+		if err := uc.ProcessPaymentFailure(ctx, paymentID); err != nil {
+
+			return "", err
+		}
+
+		return core.PaymentStatusFailed, ErrPaymentFailed
+	default:
+
+		return core.PaymentStatus(status), nil
+	}
+}
+
+func (uc *PaymentUseCase) checkClosedPayment(ctx context.Context, payment *core.Payment) (core.PaymentStatus, error) {
+	closedStatus := core.PaymentStatus(payment.Status)
+	if payment.ExternalID == "" || payment.PaymentMethod == string(core.PaymentMethodStars) {
+
+		return closedStatus, ErrPaymentCancelled
 	}
 
-	subscription, err := uc.subscriptionUC.CreateSubscription(ctx, subscriptionDTO)
+	status, err := uc.provider.CheckPaymentStatus(ctx, payment.ExternalID)
 	if err != nil {
 
-		return fmt.Errorf("failed to create subscription: %w", err)
+		return "", fmt.Errorf("failed to check payment status: %w", err)
+	}
+
+	switch core.PaymentStatus(status) {
+	case core.PaymentStatusWaitingForCapture:
+		if _, err := uc.provider.CancelPayment(ctx, payment.ExternalID); err != nil {
+
+			return "", fmt.Errorf("failed to cancel late payment: %w", err)
+		}
+
+		slog.Warn("Released hold for payment paid after it was closed", "payment_id", payment.ID, "external_id", payment.ExternalID, "status", payment.Status)
+		uc.notifyPaymentClosed(ctx, payment, "↩️ Платеж отменен",
+			fmt.Sprintf("Платеж на сумму %s был оплачен после отмены. Мы отменили списание, заблокированные средства вернутся на ваш счет.", formatPaymentAmount(payment)))
+
+		return closedStatus, ErrPaymentCancelled
+	case core.PaymentStatusCompleted:
+		slog.Warn("Provisioning payment completed after it was closed", "payment_id", payment.ID, "external_id", payment.ExternalID, "status", payment.Status)
+
+		if err := uc.reopenPayment(ctx, payment.ID); err != nil {
+
+			return "", err
+		}
+
+		if err := uc.ProcessPaymentSuccess(ctx, payment.ID); err != nil {
+
+			return "", err
+		}
+
+		return core.PaymentStatusCompleted, nil
+	default:
+
+		return closedStatus, ErrPaymentCancelled
+	}
+}
+
+func (uc *PaymentUseCase) reopenPayment(ctx context.Context, paymentID string) error {
+
+	return uc.uow.Do(ctx, func(ctx context.Context) error {
+		payment, err := uc.paymentRepo.GetPaymentByIDForUpdate(ctx, paymentID)
+		if err != nil {
+
+			return fmt.Errorf("failed to get payment: %w", err)
+		}
+
+		if !payment.IsCancelled() && !payment.IsExpired() {
+
+			return nil
+		}
+
+		return uc.paymentRepo.UpdatePaymentStatus(ctx, paymentID, string(core.PaymentStatusPending))
+	})
+}
+
+func (uc *PaymentUseCase) cancelAtProvider(ctx context.Context, payment *core.Payment) error {
+	if payment.ExternalID == "" || payment.PaymentMethod == string(core.PaymentMethodStars) {
+
+		return nil
 	}
 
-	vpnConn, err := uc.vpnUC.CreateVPNForSubscription(ctx, payment.UserID, subscription.ID)
+	status, err := uc.provider.CheckPaymentStatus(ctx, payment.ExternalID)
 	if err != nil {
 
-		return fmt.Errorf("failed to create VPN: %w", err)
+		return fmt.Errorf("failed to check payment status: %w", err)
+	}
+
+	switch core.PaymentStatus(status) {
+	case core.PaymentStatusCompleted:
+
+		return ErrPaymentAlreadyPaid
+	case core.PaymentStatusWaitingForCapture:
+		status, err = uc.provider.CancelPayment(ctx, payment.ExternalID)
+		if err != nil {
+
+			return fmt.Errorf("failed to cancel payment at provider: %w", err)
+		}
+
+		if core.PaymentStatus(status) == core.PaymentStatusCompleted {
+
+			return ErrPaymentAlreadyPaid
+		}
+	}
+
+	return nil
+}
+
+func (uc *PaymentUseCase) ProcessPaymentSuccess(ctx context.Context, paymentID string) error {
+	result, err := uc.completePayment(ctx, paymentID, "")
+	if err != nil {
+
+		return err
+	}
+
+	var message string
+	switch {
+	case result.Payment.IsTopUp():
+		message = fmt.Sprintf("Ваш баланс пополнен на %s.", formatPaymentAmount(result.Payment))
+	case result.Payment.IsExtension():
+		uc.activateExtendedSubscription(ctx, result.Subscription)
+		message = fmt.Sprintf("Ваш платеж на сумму %s успешно обработан. Подписка \"%s\" продлена до %s.", formatPaymentAmount(result.Payment), result.Subscription.GetDisplayName(), result.Subscription.EndDate.Format("02.01.2006"))
+		if result.VPNConnection != nil {
+			message += fmt.Sprintf(" Создан новый VPN-ключ \"%s\".", result.VPNConnection.Name)
+		}
+	default:
+		message = fmt.Sprintf("Ваш платеж на сумму %s успешно обработан. VPN подключение \"%s\" активировано!", formatPaymentAmount(result.Payment), result.VPNConnection.Name)
 	}
 
+	uc.storeSavedPaymentMethod(ctx, result.Payment)
+	uc.refreshReceiptStatus(ctx, result.Payment)
+
 	notifDTO := CreateNotificationDTO{
-		UserID:  payment.UserID,
+		UserID:  result.Payment.UserID,
 		Type:    "payment",
 		Title:   "✅ Платеж успешен",
-		Message: fmt.Sprintf("Ваш платеж на сумму %.2f ₽ успешно обработан. VPN подключение \"%s\" активировано!", payment.Amount, vpnConn.Name),
+		Message: message,
 	}
 
 	if err := uc.notifUC.CreateNotification(ctx, notifDTO); err != nil {
@@ -184,12 +505,1311 @@ func (uc *PaymentUseCase) ProcessPaymentSuccess(ctx context.Context, paymentID s
 	return nil
 }
 
-func (uc *PaymentUseCase) ProcessPaymentFailure(ctx context.Context, paymentID string) error {
+func (uc *PaymentUseCase) CreateTopUpPayment(ctx context.Context, userID int64, amount float64, method core.PaymentMethod) (*core.Payment, string, error) {
+	amount = roundAmount(amount)
+	if amount < MinTopUpAmount || amount > MaxTopUpAmount {
 
-	return uc.paymentRepo.UpdatePaymentStatus(ctx, paymentID, string(core.PaymentStatusFailed))
+		return nil, "", ErrInvalidAmount
+	}
+
+	payment := &core.Payment{
+		ID:             id.Generate(),
+		UserID:         userID,
+		Amount:         amount,
+		Currency:       core.CurrencyRUB,
+		PaymentMethod:  string(method),
+		Purpose:        string(core.PaymentPurposeTopUp),
+		IdempotencyKey: id.Generate(),
+		Description:    "Пополнение баланса",
+		Status:         string(core.PaymentStatusPending),
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+
+	receipt, err := uc.prepareReceipt(ctx, payment)
+	if err != nil {
+
+		return nil, "", err
+	}
+
+	if err := uc.createPlanPayment(ctx, payment); err != nil {
+
+		return nil, "", err
+	}
+
+	paymentURL, externalID, err := uc.provider.CreatePayment(ctx, CreateProviderPaymentDTO{
+		Amount:         payment.Amount,
+		Currency:       payment.Currency,
+		Description:    payment.Description,
+		PaymentMethod:  method,
+		IdempotencyKey: payment.IdempotencyKey,
+		Metadata: map[string]string{
+			"payment_id": payment.ID,
+			"purpose":    payment.Purpose,
+			"user_id":    fmt.Sprintf("%d", userID),
+		},
+		Receipt: receipt,
+	})
+	if err != nil {
+		_ = uc.paymentRepo.UpdatePaymentStatus(ctx, payment.ID, string(core.PaymentStatusFailed))
+
+		return nil, "", fmt.Errorf("failed to create payment in provider: %w", err)
+	}
+
+	payment.ExternalID = externalID
+	payment.UpdatedAt = time.Now()
+
+	if err := uc.paymentRepo.UpdatePayment(ctx, payment); err != nil {
+
+		return nil, "", fmt.Errorf("failed to save external payment ID: %w", err)
+	}
+
+	return payment, paymentURL, nil
 }
 
-func (uc *PaymentUseCase) ProcessPaymentCancellation(ctx context.Context, paymentID string) error {
+func (uc *PaymentUseCase) PayPlanFromBalance(ctx context.Context, userID int64, planID string, promoCode string) (*CompletedPaymentDTO, error) {
+	quote, err := uc.QuotePlan(ctx, userID, planID, promoCode)
+	if err != nil {
 
-	return uc.paymentRepo.UpdatePaymentStatus(ctx, paymentID, string(core.PaymentStatusCancelled))
+		return nil, err
+	}
+
+	if quote.Plan.Price <= 0 {
+
+		return nil, ErrInvalidAmount
+	}
+
+	result := &CompletedPaymentDTO{}
+
+	err = uc.uow.Do(ctx, func(ctx context.Context) error {
+		payment := newPlanPayment(userID, quote, quote.Amount, core.CurrencyRUB, core.PaymentMethodBalance)
+		result.Payment = payment
+
+		if payment.PromoCodeID != "" {
+			if err := uc.promoUC.ReservePromoCode(ctx, payment.PromoCodeID, userID); err != nil {
+
+				return err
+			}
+		}
+
+		if err := uc.paymentRepo.CreatePayment(ctx, payment); err != nil {
+
+			return fmt.Errorf("failed to create payment: %w", err)
+		}
+
+		if payment.Amount > 0 {
+			if err := uc.balanceUC.Debit(ctx, userID, payment.Amount, core.BalanceTransactionPayment, payment.ID, payment.Description); err != nil {
+
+				return err
+			}
+		}
+
+		return uc.provisionPayment(ctx, result, "")
+	})
+	if err != nil {
+		uc.revokeRolledBackVPN(ctx, result)
+
+		return nil, err
+	}
+
+	slog.Info("Plan paid from balance", "payment_id", result.Payment.ID, "user_id", userID, "plan_id", planID, "amount", result.Payment.Amount)
+
+	return result, nil
+}
+
+func (uc *PaymentUseCase) ExtendSubscriptionFromBalance(ctx context.Context, userID int64, subscriptionID string, planID string) (*core.Subscription, error) {
+	plan, err := uc.subscriptionUC.GetPlan(ctx, planID)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get plan: %w", err)
+	}
+
+	if !plan.IsActive {
+
+		return nil, ErrPlanNotActive
+	}
+
+	if plan.Price <= 0 {
+
+		return nil, ErrInvalidAmount
+	}
+
+	subscription, err := uc.subscriptionUC.GetSubscriptionByID(ctx, subscriptionID)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get subscription: %w", err)
+	}
+
+	if subscription.UserID != userID {
+
+		return nil, ErrUnauthorized
+	}
+
+	result, err := uc.extendFromBalance(ctx, subscription, plan, fmt.Sprintf("Продление подписки: %s", plan.Name))
+	if err != nil {
+
+		return nil, err
+	}
+
+	return result.Subscription, nil
+}
+
+func (uc *PaymentUseCase) ChargeSubscriptionRenewal(ctx context.Context, subscription *core.Subscription) (*CompletedPaymentDTO, error) {
+	plan, err := uc.subscriptionUC.GetPlan(ctx, subscription.PlanID)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get plan: %w", err)
+	}
+
+	if !plan.IsActive {
+
+		return nil, ErrPlanNotActive
+	}
+
+	if plan.Price <= 0 {
+
+		return nil, ErrInvalidAmount
+	}
+
+	pending, err := uc.paymentRepo.CountPendingPaymentsBySubscriptionID(ctx, subscription.ID)
+	if err != nil {
+
+		return nil, err
+	}
+
+	if pending > 0 {
+
+		return nil, ErrRenewalPending
+	}
+
+	description := fmt.Sprintf("Автопродление подписки: %s", plan.Name)
+
+	balance, err := uc.balanceUC.GetBalance(ctx, subscription.UserID)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get balance: %w", err)
+	}
+
+	if balance >= plan.Price {
+		result, err := uc.extendFromBalance(ctx, subscription, plan, description)
+		if !errors.Is(err, ErrInsufficientBalance) {
+
+			return result, err
+		}
+	}
+
+	method, err := uc.savedMethodRepo.GetMethodByUserID(ctx, subscription.UserID)
+	if errors.Is(err, ErrNotFound) {
+
+		return nil, ErrNoRenewalPaymentMethod
+	}
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get saved payment method: %w", err)
+	}
+
+	return uc.chargeSavedMethod(ctx, subscription, plan, method, description)
+}
+
+func (uc *PaymentUseCase) extendFromBalance(ctx context.Context, subscription *core.Subscription, plan *core.Plan, description string) (*CompletedPaymentDTO, error) {
+	payment := newExtensionPayment(subscription, plan, core.PaymentMethodBalance, description)
+	result := &CompletedPaymentDTO{Payment: payment}
+
+	err := uc.uow.Do(ctx, func(ctx context.Context) error {
+		if err := uc.paymentRepo.CreatePayment(ctx, payment); err != nil {
+
+			return fmt.Errorf("failed to create payment: %w", err)
+		}
+
+		if err := uc.balanceUC.Debit(ctx, payment.UserID, payment.Amount, core.BalanceTransactionPayment, payment.ID, payment.Description); err != nil {
+
+			return err
+		}
+
+		return uc.provisionPayment(ctx, result, "")
+	})
+	if err != nil {
+		uc.revokeRolledBackVPN(ctx, result)
+
+		return nil, err
+	}
+
+	uc.activateExtendedSubscription(ctx, result.Subscription)
+
+	slog.Info("Subscription extended from balance", "subscription_id", subscription.ID, "user_id", payment.UserID, "plan_id", plan.ID, "end_date", result.Subscription.EndDate)
+
+	return result, nil
+}
+
+func (uc *PaymentUseCase) chargeSavedMethod(ctx context.Context, subscription *core.Subscription, plan *core.Plan, method *core.SavedPaymentMethod, description string) (*CompletedPaymentDTO, error) {
+	payment := newExtensionPayment(subscription, plan, core.PaymentMethod(method.Method), description)
+
+	receipt, err := uc.prepareReceipt(ctx, payment)
+	if err != nil {
+
+		return nil, err
+	}
+
+	if err := uc.paymentRepo.CreatePayment(ctx, payment); err != nil {
+
+		return nil, fmt.Errorf("failed to create payment: %w", err)
+	}
+
+	externalID, status, err := uc.provider.ChargeSavedMethod(ctx, CreateProviderPaymentDTO{
+		Amount:         payment.Amount,
+		Currency:       payment.Currency,
+		Description:    payment.Description,
+		PaymentMethod:  core.PaymentMethod(method.Method),
+		IdempotencyKey: payment.IdempotencyKey,
+		Metadata: map[string]string{
+			"payment_id":      payment.ID,
+			"purpose":         payment.Purpose,
+			"subscription_id": subscription.ID,
+			"user_id":         fmt.Sprintf("%d", payment.UserID),
+		},
+		SavedMethodID: method.ProviderMethodID,
+		Receipt:       receipt,
+	})
+	if err != nil {
+		_ = uc.paymentRepo.UpdatePaymentStatus(ctx, payment.ID, string(core.PaymentStatusFailed))
+
+		return nil, fmt.Errorf("failed to charge saved payment method: %w", err)
+	}
+
+	payment.ExternalID = externalID
+	payment.UpdatedAt = time.Now()
+
+	if err := uc.paymentRepo.UpdatePayment(ctx, payment); err != nil {
+
+		return nil, fmt.Errorf("failed to save external payment ID: %w", err)
+	}
+
+	if status == string(core.PaymentStatusWaitingForCapture) {
+		status, err = uc.provider.CapturePayment(ctx, externalID)
+		if err != nil {
+
+			return nil, fmt.Errorf("failed to capture payment: %w", err)
+		}
+	}
+
+	switch core.PaymentStatus(status) {
+	case core.PaymentStatusCompleted:
+		result, err := uc.completePayment(ctx, payment.ID, "")
+		if err != nil {
+
+			return nil, err
+		}
+
+		uc.activateExtendedSubscription(ctx, result.Subscription)
+		uc.refreshReceiptStatus(ctx, result.Payment)
+
+		slog.Info("Subscription renewed with saved payment method", "subscription_id", subscription.ID, "payment_id", payment.ID, "end_date", result.Subscription.EndDate)
+
+		return result, nil
+	case core.PaymentStatusCancelled, core.PaymentStatusFailed:
+		if err := uc.ProcessPaymentFailure(ctx, payment.ID); err != nil {
+
+			return nil, err
+		}
+
+		return nil, ErrPaymentFailed
+	default:
+		slog.Info("Renewal payment is pending", "subscription_id", subscription.ID, "payment_id", payment.ID, "status", status)
+
+		return &CompletedPaymentDTO{Payment: payment, Plan: plan, Subscription: subscription}, nil
+	}
+}
+
+func newExtensionPayment(subscription *core.Subscription, plan *core.Plan, method core.PaymentMethod, description string) *core.Payment {
+
+	return &core.Payment{
+		ID:             id.Generate(),
+		UserID:         subscription.UserID,
+		PlanID:         plan.ID,
+		SubscriptionID: subscription.ID,
+		Amount:         plan.Price,
+		Currency:       core.CurrencyRUB,
+		PaymentMethod:  string(method),
+		Purpose:        string(core.PaymentPurposeExtension),
+		IdempotencyKey: id.Generate(),
+		Description:    description,
+		Status:         string(core.PaymentStatusPending),
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+}
+
+func (uc *PaymentUseCase) activateExtendedSubscription(ctx context.Context, subscription *core.Subscription) {
+	if subscription == nil {
+
+		return
+	}
+
+	if err := uc.vpnUC.ActivateSubscriptionVPNs(ctx, subscription.ID, subscription.EndDate); err != nil {
+		slog.Error("Failed to update VPN after extension", "subscription_id", subscription.ID, "error", err)
+	}
+}
+
+func (uc *PaymentUseCase) GetSavedPaymentMethod(ctx context.Context, userID int64) (*core.SavedPaymentMethod, error) {
+
+	return uc.savedMethodRepo.GetMethodByUserID(ctx, userID)
+}
+
+func (uc *PaymentUseCase) DeleteSavedPaymentMethod(ctx context.Context, userID int64) error {
+
+	return uc.savedMethodRepo.DeleteMethod(ctx, userID)
+}
+
+func (uc *PaymentUseCase) storeSavedPaymentMethod(ctx context.Context, payment *core.Payment) {
+	if payment.ExternalID == "" || payment.PaymentMethod != string(core.PaymentMethodCard) || payment.IsExtension() {
+
+		return
+	}
+
+	method, err := uc.provider.GetPaymentMethod(ctx, payment.ExternalID)
+	if err != nil {
+		slog.Error("Failed to get payment method from provider", "payment_id", payment.ID, "error", err)
+
+		return
+	}
+
+	if method == nil || !method.Saved || method.ID == "" {
+
+		return
+	}
+
+	savedMethod := &core.SavedPaymentMethod{
+		UserID:           payment.UserID,
+		ProviderMethodID: method.ID,
+		Method:           payment.PaymentMethod,
+		Title:            method.Title,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+	}
+
+	if err := uc.savedMethodRepo.SaveMethod(ctx, savedMethod); err != nil {
+		slog.Error("Failed to save payment method", "payment_id", payment.ID, "user_id", payment.UserID, "error", err)
+
+		return
+	}
+
+	slog.Info("Payment method saved for auto-renewal", "user_id", payment.UserID, "method", method.Type)
+}
+
+func (uc *PaymentUseCase) prepareReceipt(ctx context.Context, payment *core.Payment) (*ProviderReceiptDTO, error) {
+	if !uc.receipt.Enabled || payment.Currency != core.CurrencyRUB || payment.Amount <= 0 {
+
+		return nil, nil
+	}
+
+	user, err := uc.userRepo.GetUserByID(ctx, payment.UserID)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if user.Email == "" {
+
+		return nil, ErrReceiptEmailRequired
+	}
+
+	payment.ReceiptEmail = user.Email
+	payment.ReceiptStatus = string(core.ReceiptStatusPending)
+
+	receipt := uc.buildReceipt(payment)
+
+	return &receipt, nil
+}
+
+func (uc *PaymentUseCase) buildReceipt(payment *core.Payment) ProviderReceiptDTO {
+
+	return ProviderReceiptDTO{
+		CustomerEmail: payment.ReceiptEmail,
+		TaxSystemCode: uc.receipt.TaxSystemCode,
+		Items: []ProviderReceiptItemDTO{
+			{
+				Description:    payment.Description,
+				Quantity:       1,
+				Amount:         payment.Amount,
+				Currency:       payment.Currency,
+				VATCode:        uc.receipt.VATCode,
+				PaymentSubject: uc.receipt.PaymentSubject,
+				PaymentMode:    uc.receipt.PaymentMode,
+			},
+		},
+	}
+}
+
+func (uc *PaymentUseCase) refreshReceiptStatus(ctx context.Context, payment *core.Payment) {
+	if payment.ReceiptEmail == "" || payment.ExternalID == "" {
+
+		return
+	}
+
+	status, err := uc.provider.GetReceiptStatus(ctx, payment.ExternalID)
+	if err != nil {
+		slog.Error("Failed to get receipt status", "payment_id", payment.ID, "error", err)
+
+		return
+	}
+
+	if status == "" || status == payment.ReceiptStatus {
+
+		return
+	}
+
+	if err := uc.paymentRepo.UpdateReceipt(ctx, payment.ID, payment.ReceiptEmail, status); err != nil {
+		slog.Error("Failed to update receipt status", "payment_id", payment.ID, "error", err)
+
+		return
+	}
+
+	payment.ReceiptStatus = status
+}
+
+func (uc *PaymentUseCase) ResendReceipt(ctx context.Context, paymentID string) (*core.Payment, error) {
+	payment, err := uc.paymentRepo.GetPaymentByID(ctx, paymentID)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get payment: %w", err)
+	}
+
+	if !payment.IsCompleted() || payment.ExternalID == "" || payment.Currency != core.CurrencyRUB || payment.Amount <= 0 {
+
+		return nil, ErrReceiptNotAvailable
+	}
+
+	if payment.ReceiptEmail == "" {
+		user, err := uc.userRepo.GetUserByID(ctx, payment.UserID)
+		if err != nil {
+
+			return nil, fmt.Errorf("failed to get user: %w", err)
+		}
+
+		payment.ReceiptEmail = user.Email
+	}
+
+	if payment.ReceiptEmail == "" {
+
+		return nil, ErrReceiptEmailRequired
+	}
+
+	status, err := uc.provider.SendReceipt(ctx, payment.ExternalID, uc.buildReceipt(payment))
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to send receipt: %w", err)
+	}
+
+	if err := uc.paymentRepo.UpdateReceipt(ctx, payment.ID, payment.ReceiptEmail, status); err != nil {
+
+		return nil, fmt.Errorf("failed to update payment receipt: %w", err)
+	}
+
+	payment.ReceiptStatus = status
+
+	slog.Info("Receipt resent", "payment_id", payment.ID, "email", payment.ReceiptEmail, "status", status)
+
+	return payment, nil
+}
+
+func (uc *PaymentUseCase) CreateStarsPayment(ctx context.Context, userID int64, planID string, promoCode string) (*core.Payment, error) {
+	quote, err := uc.QuotePlan(ctx, userID, planID, promoCode)
+	if err != nil {
+
+		return nil, err
+	}
+
+	if quote.Plan.StarsPrice <= 0 {
+
+		return nil, ErrInvalidAmount
+	}
+
+	payment := newPlanPayment(userID, quote, float64(quote.StarsAmount), core.CurrencyStars, core.PaymentMethodStars)
+
+	if err := uc.createPlanPayment(ctx, payment); err != nil {
+
+		return nil, err
+	}
+
+	if payment.Amount == 0 {
+		payment, _, err = uc.completeFreePayment(ctx, payment)
+		if err != nil {
+
+			return nil, err
+		}
+	}
+
+	return payment, nil
+}
+
+func (uc *PaymentUseCase) ValidateStarsCheckout(ctx context.Context, userID int64, paymentID string, currency string, totalAmount int) error {
+	payment, err := uc.paymentRepo.GetPaymentByID(ctx, paymentID)
+	if err != nil {
+
+		return fmt.Errorf("failed to get payment: %w", err)
+	}
+
+	if payment.UserID != userID {
+
+		return ErrUnauthorized
+	}
+
+	switch {
+	case payment.IsCompleted():
+
+		return ErrPaymentAlreadyPaid
+	case payment.IsCancelled(), payment.IsRefunded(), payment.IsExpired():
+
+		return ErrPaymentCancelled
+	case payment.IsFailed():
+
+		return ErrPaymentFailed
+	}
+
+	if currency != core.CurrencyStars || payment.Currency != core.CurrencyStars || float64(totalAmount) != payment.Amount {
+
+		return ErrInvalidAmount
+	}
+
+	plan, err := uc.subscriptionUC.GetPlan(ctx, payment.PlanID)
+	if err != nil {
+
+		return fmt.Errorf("failed to get plan: %w", err)
+	}
+
+	if !plan.IsActive {
+
+		return ErrPlanNotActive
+	}
+
+	return nil
+}
+
+func (uc *PaymentUseCase) ProcessStarsPayment(ctx context.Context, paymentID string, chargeID string) (*CompletedPaymentDTO, error) {
+	if chargeID == "" {
+
+		return nil, fmt.Errorf("telegram payment charge ID is empty: %w", ErrInvalidInput)
+	}
+
+	return uc.completePayment(ctx, paymentID, chargeID)
+}
+
+func (uc *PaymentUseCase) RefundStarsPayment(ctx context.Context, paymentID string, chargeID string) error {
+	payment, err := uc.paymentRepo.GetPaymentByID(ctx, paymentID)
+	if err != nil {
+
+		return fmt.Errorf("failed to get payment: %w", err)
+	}
+
+	if payment.IsRefunded() {
+
+		return nil
+	}
+
+	if payment.IsCompleted() {
+
+		return ErrPaymentAlreadyPaid
+	}
+
+	if err := uc.starsRefunder.RefundStarPayment(ctx, payment.UserID, chargeID); err != nil {
+
+		return fmt.Errorf("failed to refund stars payment: %w", err)
+	}
+
+	payment.ExternalID = chargeID
+	payment.Status = string(core.PaymentStatusRefunded)
+	payment.UpdatedAt = time.Now()
+
+	if err := uc.paymentRepo.UpdatePayment(ctx, payment); err != nil {
+
+		return fmt.Errorf("failed to save refunded payment: %w", err)
+	}
+
+	refund := &core.PaymentRefund{
+		ID:               id.Generate(),
+		PaymentID:        payment.ID,
+		Amount:           payment.Amount,
+		Reason:           "Автоматический возврат: не удалось активировать подписку",
+		ExternalRefundID: chargeID,
+		Status:           string(core.RefundStatusSucceeded),
+		CreatedAt:        time.Now(),
+	}
+
+	if err := uc.refundRepo.CreateRefund(ctx, refund); err != nil {
+
+		return fmt.Errorf("failed to save refund: %w", err)
+	}
+
+	return nil
+}
+
+func (uc *PaymentUseCase) RefundPayment(ctx context.Context, dto RefundPaymentDTO) (*RefundResultDTO, error) {
+	if strings.TrimSpace(dto.Reason) == "" {
+
+		return nil, fmt.Errorf("refund reason is required: %w", ErrInvalidInput)
+	}
+
+	refund, payment, err := uc.beginRefund(ctx, dto)
+	if err != nil {
+
+		return nil, err
+	}
+
+	externalRefundID, err := uc.issueRefund(ctx, payment, refund)
+	if err != nil {
+		slog.Error("Refund left pending after provider error", "payment_id", payment.ID, "refund_id", refund.ID, "amount", refund.Amount, "error", err)
+
+		return nil, err
+	}
+
+	result, err := uc.finishRefund(ctx, refund.ID, payment.ID, externalRefundID)
+	if err != nil {
+		slog.Error("Refund issued but not recorded", "payment_id", payment.ID, "refund_id", refund.ID, "external_refund_id", externalRefundID, "amount", refund.Amount, "error", err)
+
+		return nil, err
+	}
+
+	if result.Subscription != nil {
+		if result.Subscription.IsActive {
+			result.VPNUpdateErr = uc.vpnUC.UpdateSubscriptionVPNExpire(ctx, result.Subscription.ID, result.Subscription.EndDate)
+		} else {
+			result.VPNUpdateErr = uc.vpnUC.DisableSubscriptionVPNs(ctx, result.Subscription.ID)
+		}
+
+		if result.VPNUpdateErr != nil {
+			slog.Error("Failed to update VPN after refund", "payment_id", result.Payment.ID, "subscription_id", result.Subscription.ID, "error", result.VPNUpdateErr)
+		}
+	}
+
+	slog.Info("Payment refunded",
+		"payment_id", result.Payment.ID,
+		"admin_id", dto.AdminID,
+		"amount", result.Refund.Amount,
+		"status", result.Payment.Status,
+		"reason", result.Refund.Reason)
+
+	message := fmt.Sprintf("Вам возвращено %s по платежу \"%s\".", formatAmount(result.Payment.Currency, result.Refund.Amount), result.Payment.Description)
+	if result.Subscription != nil {
+		if result.Subscription.IsActive {
+			message += fmt.Sprintf(" Срок подписки сокращен до %s.", result.Subscription.EndDate.Format("02.01.2006"))
+		} else {
+			message += " Подписка деактивирована."
+		}
+	}
+	uc.notifyPaymentClosed(ctx, result.Payment, "💸 Возврат средств", message)
+
+	return result, nil
+}
+
+func (uc *PaymentUseCase) beginRefund(ctx context.Context, dto RefundPaymentDTO) (*core.PaymentRefund, *core.Payment, error) {
+	var refund *core.PaymentRefund
+	var payment *core.Payment
+
+	err := uc.uow.Do(ctx, func(ctx context.Context) error {
+		var err error
+		payment, err = uc.paymentRepo.GetPaymentByIDForUpdate(ctx, dto.PaymentID)
+		if err != nil {
+
+			return fmt.Errorf("failed to get payment: %w", err)
+		}
+
+		refund, err = uc.refundRepo.GetPendingRefundByPaymentID(ctx, payment.ID)
+		if err == nil {
+			matches, err := uc.matchesPendingRefund(ctx, payment, refund, dto)
+			if err != nil {
+
+				return err
+			}
+
+			if !matches {
+
+				return fmt.Errorf("refund %s for %.2f is pending: %w", refund.ID, refund.Amount, ErrRefundPending)
+			}
+
+			slog.Warn("Resuming pending refund", "payment_id", payment.ID, "refund_id", refund.ID, "amount", refund.Amount)
+
+			return nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+
+			return fmt.Errorf("failed to get pending refund: %w", err)
+		}
+
+		if !payment.IsRefundable() {
+
+			return ErrPaymentNotRefundable
+		}
+
+		if payment.ExternalID == "" && payment.PaymentMethod != string(core.PaymentMethodBalance) {
+
+			return fmt.Errorf("payment %s has no external ID: %w", payment.ID, ErrPaymentNotRefundable)
+		}
+
+		refunded, err := uc.refundRepo.GetTotalRefundedAmount(ctx, payment.ID)
+		if err != nil {
+
+			return fmt.Errorf("failed to get refunded amount: %w", err)
+		}
+
+		remaining := roundAmount(payment.Amount - refunded)
+		amount := roundAmount(dto.Amount)
+		if amount == 0 {
+			amount = remaining
+		}
+
+		if amount <= 0 || amount > remaining {
+
+			return ErrInvalidAmount
+		}
+
+		if payment.PaymentMethod == string(core.PaymentMethodStars) && amount != payment.Amount {
+
+			return fmt.Errorf("partial refunds are not supported for stars: %w", ErrInvalidAmount)
+		}
+
+		refund = &core.PaymentRefund{
+			ID:        id.Generate(),
+			PaymentID: payment.ID,
+			AdminID:   dto.AdminID,
+			Amount:    amount,
+			Reason:    strings.TrimSpace(dto.Reason),
+			Status:    string(core.RefundStatusPending),
+			CreatedAt: time.Now(),
+		}
+
+		if err := uc.refundRepo.CreateRefund(ctx, refund); err != nil {
+
+			return fmt.Errorf("failed to save refund: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+
+		return nil, nil, err
+	}
+
+	return refund, payment, nil
+}
+
+func (uc *PaymentUseCase) matchesPendingRefund(ctx context.Context, payment *core.Payment, refund *core.PaymentRefund, dto RefundPaymentDTO) (bool, error) {
+	if strings.TrimSpace(dto.Reason) != refund.Reason {
+
+		return false, nil
+	}
+
+	amount := roundAmount(dto.Amount)
+	if amount == 0 {
+		refunded, err := uc.refundRepo.GetTotalRefundedAmount(ctx, payment.ID)
+		if err != nil {
+
+			return false, fmt.Errorf("failed to get refunded amount: %w", err)
+		}
+
+		amount = roundAmount(payment.Amount - refunded + refund.Amount)
+	}
+
+	return amount == roundAmount(refund.Amount), nil
+}
+
+func (uc *PaymentUseCase) issueRefund(ctx context.Context, payment *core.Payment, refund *core.PaymentRefund) (string, error) {
+	switch core.PaymentMethod(payment.PaymentMethod) {
+	case core.PaymentMethodBalance:
+
+		return "", nil
+	case core.PaymentMethodStars:
+		if err := uc.starsRefunder.RefundStarPayment(ctx, payment.UserID, payment.ExternalID); err != nil {
+
+			return "", fmt.Errorf("failed to refund stars payment: %w", err)
+		}
+
+		return payment.ExternalID, nil
+	default:
+		externalRefundID, err := uc.provider.Refund(ctx, payment.ExternalID, refund.Amount, refund.ID)
+		if err != nil {
+
+			return "", fmt.Errorf("failed to refund payment in provider: %w", err)
+		}
+
+		return externalRefundID, nil
+	}
+}
+
+func (uc *PaymentUseCase) finishRefund(ctx context.Context, refundID, paymentID, externalRefundID string) (*RefundResultDTO, error) {
+	result := &RefundResultDTO{}
+
+	err := uc.uow.Do(ctx, func(ctx context.Context) error {
+		payment, err := uc.paymentRepo.GetPaymentByIDForUpdate(ctx, paymentID)
+		if err != nil {
+
+			return fmt.Errorf("failed to get payment: %w", err)
+		}
+		result.Payment = payment
+
+		refund, err := uc.refundRepo.GetRefundByID(ctx, refundID)
+		if err != nil {
+
+			return fmt.Errorf("failed to get refund: %w", err)
+		}
+		result.Refund = refund
+
+		if !refund.IsPending() {
+
+			return nil
+		}
+
+		refund.ExternalRefundID = externalRefundID
+		refund.Status = string(core.RefundStatusSucceeded)
+		if err := uc.refundRepo.UpdateRefund(ctx, refund); err != nil {
+
+			return fmt.Errorf("failed to save refund: %w", err)
+		}
+
+		if payment.IsTopUp() {
+			if err := uc.balanceUC.ForceDebit(ctx, payment.UserID, refund.Amount, core.BalanceTransactionRefund, payment.ID, "Возврат пополнения баланса"); err != nil {
+
+				return fmt.Errorf("failed to debit refunded top-up: %w", err)
+			}
+		}
+
+		if payment.PaymentMethod == string(core.PaymentMethodBalance) {
+			if err := uc.balanceUC.Credit(ctx, payment.UserID, refund.Amount, core.BalanceTransactionRefund, payment.ID, fmt.Sprintf("Возврат: %s", payment.Description)); err != nil {
+
+				return fmt.Errorf("failed to refund to balance: %w", err)
+			}
+		}
+
+		refunded, err := uc.refundRepo.GetTotalRefundedAmount(ctx, payment.ID)
+		if err != nil {
+
+			return fmt.Errorf("failed to get refunded amount: %w", err)
+		}
+
+		result.FullRefund = roundAmount(payment.Amount-refunded) <= 0
+		if result.FullRefund {
+			payment.Status = string(core.PaymentStatusRefunded)
+		} else {
+			payment.Status = string(core.PaymentStatusPartiallyRefunded)
+		}
+		payment.UpdatedAt = time.Now()
+
+		if err := uc.paymentRepo.UpdatePayment(ctx, payment); err != nil {
+
+			return fmt.Errorf("failed to update payment: %w", err)
+		}
+
+		if payment.SubscriptionID == "" {
+			if !payment.IsTopUp() {
+				slog.Warn("Refunded payment has no linked subscription", "payment_id", payment.ID)
+			}
+
+			return nil
+		}
+
+		if result.FullRefund && !payment.IsExtension() {
+			result.Subscription, err = uc.subscriptionUC.DeactivateSubscription(ctx, payment.SubscriptionID)
+			if err != nil {
+
+				return fmt.Errorf("failed to deactivate subscription: %w", err)
+			}
+
+			return nil
+		}
+
+		plan, err := uc.subscriptionUC.GetPlan(ctx, payment.PlanID)
+		if err != nil {
+
+			return fmt.Errorf("failed to get plan: %w", err)
+		}
+
+		shortenBy := time.Duration(float64(plan.Days+payment.BonusDays) * 24 * float64(time.Hour) * refund.Amount / payment.Amount)
+		result.Subscription, err = uc.subscriptionUC.ShortenSubscription(ctx, payment.SubscriptionID, shortenBy)
+		if err != nil {
+
+			return fmt.Errorf("failed to shorten subscription: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (uc *PaymentUseCase) GetPaymentRefunds(ctx context.Context, paymentID string) ([]*core.PaymentRefund, error) {
+
+	return uc.refundRepo.GetRefundsByPaymentID(ctx, paymentID)
+}
+
+func (uc *PaymentUseCase) completePayment(ctx context.Context, paymentID string, externalID string) (*CompletedPaymentDTO, error) {
+	result := &CompletedPaymentDTO{}
+
+	err := uc.uow.Do(ctx, func(ctx context.Context) error {
+		payment, err := uc.paymentRepo.GetPaymentByIDForUpdate(ctx, paymentID)
+		if err != nil {
+
+			return fmt.Errorf("failed to get payment: %w", err)
+		}
+		result.Payment = payment
+
+		if payment.IsCompleted() {
+
+			return ErrPaymentAlreadyPaid
+		}
+
+		if payment.IsRefunded() || payment.IsExpired() {
+
+			return ErrPaymentCancelled
+		}
+
+		return uc.provisionPayment(ctx, result, externalID)
+	})
+	if err != nil {
+		uc.revokeRolledBackVPN(ctx, result)
+
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (uc *PaymentUseCase) provisionPayment(ctx context.Context, result *CompletedPaymentDTO, externalID string) error {
+	payment := result.Payment
+
+	if payment.IsTopUp() {
+		if err := uc.balanceUC.Credit(ctx, payment.UserID, payment.Amount, core.BalanceTransactionTopUp, payment.ID, payment.Description); err != nil {
+
+			return fmt.Errorf("failed to credit balance: %w", err)
+		}
+
+		return uc.markPaymentCompleted(ctx, payment, externalID)
+	}
+
+	if payment.PlanID == "" {
+
+		return fmt.Errorf("payment %s has no plan: %w", payment.ID, ErrInvalidInput)
+	}
+
+	if payment.IsExtension() {
+
+		return uc.provisionExtension(ctx, result, externalID)
+	}
+
+	plan, err := uc.subscriptionUC.GetPlan(ctx, payment.PlanID)
+	if err != nil {
+
+		return fmt.Errorf("failed to get plan: %w", err)
+	}
+	result.Plan = plan
+
+	subscription, err := uc.subscriptionUC.CreateSubscription(ctx, CreateSubscriptionDTO{
+		UserID:    payment.UserID,
+		Name:      "Основная подписка",
+		PlanID:    plan.ID,
+		StartDate: time.Now(),
+		EndDate:   time.Now().AddDate(0, 0, plan.Days+payment.BonusDays),
+		IsActive:  true,
+	})
+	if err != nil {
+
+		return fmt.Errorf("failed to create subscription: %w", err)
+	}
+	result.Subscription = subscription
+
+	if payment.PromoCodeID != "" {
+		if err := uc.promoUC.RedeemPromoCode(ctx, payment.PromoCodeID, payment.UserID, payment.ID); err != nil {
+
+			return fmt.Errorf("failed to redeem promo code: %w", err)
+		}
+	}
+
+	if err := uc.balanceUC.CreditReferralReward(ctx, payment); err != nil {
+
+		return fmt.Errorf("failed to credit referral reward: %w", err)
+	}
+
+	result.VPNConnection, err = uc.vpnUC.CreateVPNForSubscription(ctx, payment.UserID, subscription.ID)
+	if err != nil {
+
+		return fmt.Errorf("failed to create VPN: %w", err)
+	}
+
+	payment.SubscriptionID = subscription.ID
+
+	return uc.markPaymentCompleted(ctx, payment, externalID)
+}
+
+func (uc *PaymentUseCase) provisionExtension(ctx context.Context, result *CompletedPaymentDTO, externalID string) error {
+	payment := result.Payment
+
+	plan, err := uc.subscriptionUC.GetPlan(ctx, payment.PlanID)
+	if err != nil {
+
+		return fmt.Errorf("failed to get plan: %w", err)
+	}
+	result.Plan = plan
+
+	previous, err := uc.subscriptionUC.GetSubscriptionByID(ctx, payment.SubscriptionID)
+	if err != nil {
+
+		return fmt.Errorf("failed to get subscription: %w", err)
+	}
+
+	if err := uc.subscriptionUC.ExtendSubscription(ctx, payment.UserID, payment.SubscriptionID, plan.Days+payment.BonusDays); err != nil {
+
+		return fmt.Errorf("failed to extend subscription: %w", err)
+	}
+
+	result.Subscription, err = uc.subscriptionUC.GetSubscriptionByID(ctx, payment.SubscriptionID)
+	if err != nil {
+
+		return fmt.Errorf("failed to get subscription: %w", err)
+	}
+
+	if err := uc.balanceUC.CreditReferralReward(ctx, payment); err != nil {
+
+		return fmt.Errorf("failed to credit referral reward: %w", err)
+	}
+
+	if previous.GetStatus() == core.StatusExpired {
+		if err := uc.restoreExpiredVPN(ctx, result); err != nil {
+
+			return err
+		}
+	}
+
+	return uc.markPaymentCompleted(ctx, payment, externalID)
+}
+
+func (uc *PaymentUseCase) restoreExpiredVPN(ctx context.Context, result *CompletedPaymentDTO) error {
+	connections, err := uc.vpnUC.GetVPNConnectionsBySubscription(ctx, result.Subscription.ID)
+	if err != nil {
+
+		return fmt.Errorf("failed to get VPN connections: %w", err)
+	}
+	if len(connections) > 0 {
+
+		return nil
+	}
+
+	result.VPNConnection, err = uc.vpnUC.CreateVPNForSubscription(ctx, result.Subscription.UserID, result.Subscription.ID)
+	if err != nil {
+
+		return fmt.Errorf("failed to recreate VPN: %w", err)
+	}
+
+	slog.Info("VPN recreated for renewed expired subscription", "subscription_id", result.Subscription.ID, "username", result.VPNConnection.MarzbanUsername)
+
+	return nil
+}
+
+func (uc *PaymentUseCase) markPaymentCompleted(ctx context.Context, payment *core.Payment, externalID string) error {
+	if externalID != "" {
+		payment.ExternalID = externalID
+	}
+	payment.Status = string(core.PaymentStatusCompleted)
+	payment.UpdatedAt = time.Now()
+
+	if err := uc.paymentRepo.UpdatePayment(ctx, payment); err != nil {
+
+		return fmt.Errorf("failed to update payment: %w", err)
+	}
+
+	return nil
+}
+
+func (uc *PaymentUseCase) revokeRolledBackVPN(ctx context.Context, result *CompletedPaymentDTO) {
+	if result.VPNConnection == nil {
+
+		return
+	}
+
+	if err := uc.vpnUC.RevokeProvisionedVPN(ctx, result.VPNConnection); err != nil {
+		slog.Error("Failed to revoke VPN after rolled back payment", "payment_id", result.Payment.ID, "username", result.VPNConnection.MarzbanUsername, "error", err)
+	}
+}
+
+func (uc *PaymentUseCase) ProcessPaymentFailure(ctx context.Context, paymentID string) error {
+
+	return uc.finishPayment(ctx, paymentID, core.PaymentStatusFailed)
+}
+
+func (uc *PaymentUseCase) ProcessPaymentCancellation(ctx context.Context, paymentID string) error {
+
+	return uc.finishPayment(ctx, paymentID, core.PaymentStatusCancelled)
+}
+
+func (uc *PaymentUseCase) CancelPendingPayment(ctx context.Context, paymentID string) error {
+	payment, err := uc.paymentRepo.GetPaymentByID(ctx, paymentID)
+	if err != nil {
+
+		return fmt.Errorf("failed to get payment: %w", err)
+	}
+
+	err = uc.cancelAtProvider(ctx, payment)
+	if errors.Is(err, ErrPaymentAlreadyPaid) {
+		if _, err := uc.CheckPayment(ctx, paymentID); err != nil && !errors.Is(err, ErrPaymentAlreadyPaid) {
+
+			return fmt.Errorf("failed to process paid payment: %w", err)
+		}
+
+		return ErrPaymentAlreadyPaid
+	}
+	if err != nil {
+
+		return err
+	}
+
+	return uc.finishPayment(ctx, paymentID, core.PaymentStatusCancelled)
+}
+
+func (uc *PaymentUseCase) finishPayment(ctx context.Context, paymentID string, status core.PaymentStatus) error {
+
+	return uc.uow.Do(ctx, func(ctx context.Context) error {
+		payment, err := uc.paymentRepo.GetPaymentByIDForUpdate(ctx, paymentID)
+		if err != nil {
+
+			return fmt.Errorf("failed to get payment: %w", err)
+		}
+
+		if payment.IsCompleted() {
+
+			return ErrPaymentAlreadyPaid
+		}
+
+		if payment.Status == string(status) || payment.IsRefunded() || payment.IsExpired() {
+
+			return nil
+		}
+
+		return uc.paymentRepo.UpdatePaymentStatus(ctx, paymentID, string(status))
+	})
+}
+
+func (uc *PaymentUseCase) ExpirePayment(ctx context.Context, paymentID string) error {
+	payment, err := uc.paymentRepo.GetPaymentByID(ctx, paymentID)
+	if err != nil {
+
+		return fmt.Errorf("failed to get payment: %w", err)
+	}
+
+	if err := uc.cancelAtProvider(ctx, payment); err != nil {
+
+		return err
+	}
+
+	return uc.finishPayment(ctx, paymentID, core.PaymentStatusExpired)
+}
+
+func (uc *PaymentUseCase) ReconcilePendingPayments(ctx context.Context, minAge, ttl time.Duration) error {
+	now := time.Now()
+
+	payments, err := uc.paymentRepo.GetPendingPaymentsOlderThan(ctx, now.Add(-minAge))
+	if err != nil {
+
+		return fmt.Errorf("failed to get pending payments: %w", err)
+	}
+
+	var completed, closed, expired, checkErrors int
+	for _, payment := range payments {
+		if ctx.Err() != nil {
+
+			return ctx.Err()
+		}
+
+		if payment.ExternalID != "" {
+			status, err := uc.CheckPayment(ctx, payment.ID)
+			switch {
+			case errors.Is(err, ErrPaymentAlreadyPaid):
+
+				continue
+			case errors.Is(err, ErrPaymentCancelled), errors.Is(err, ErrPaymentFailed):
+				closed++
+				uc.notifyPaymentClosed(ctx, payment, "❌ Платеж не прошел",
+					fmt.Sprintf("Платеж на сумму %s был отменен платежной системой. Вы можете оформить подписку заново.", formatPaymentAmount(payment)))
+
+				continue
+			case err != nil:
+				checkErrors++
+				slog.Error("Failed to reconcile payment", "payment_id", payment.ID, "external_id", payment.ExternalID, "error", err)
+
+				continue
+			}
+
+			if status == core.PaymentStatusCompleted {
+				completed++
+
+				continue
+			}
+		}
+
+		if now.Sub(payment.CreatedAt) < ttl {
+
+			continue
+		}
+
+		err := uc.ExpirePayment(ctx, payment.ID)
+		if errors.Is(err, ErrPaymentAlreadyPaid) {
+			status, err := uc.CheckPayment(ctx, payment.ID)
+			if err == nil && status == core.PaymentStatusCompleted {
+				completed++
+			}
+
+			continue
+		}
+		if err != nil {
+			checkErrors++
+			slog.Error("Failed to expire payment", "payment_id", payment.ID, "error", err)
+
+			continue
+		}
+
+		expired++
+		uc.notifyPaymentClosed(ctx, payment, "⌛ Время оплаты истекло",
+			fmt.Sprintf("Платеж на сумму %s не был завершен вовремя и отменен. Вы можете оформить подписку заново.", formatPaymentAmount(payment)))
+	}
+
+	slog.Info("Pending payments reconciled",
+		"checked", len(payments),
+		"completed", completed,
+		"closed", closed,
+		"expired", expired,
+		"errors", checkErrors)
+
+	return nil
+}
+
+func (uc *PaymentUseCase) notifyPaymentClosed(ctx context.Context, payment *core.Payment, title, message string) {
+	notifDTO := CreateNotificationDTO{
+		UserID:  payment.UserID,
+		Type:    "payment",
+		Title:   title,
+		Message: message,
+	}
+
+	if err := uc.notifUC.CreateNotification(ctx, notifDTO); err != nil {
+		slog.Error("Failed to notify user about payment", "payment_id", payment.ID, "user_id", payment.UserID, "error", err)
+	}
+}
+
+func formatPaymentAmount(payment *core.Payment) string {
+
+	return formatAmount(payment.Currency, payment.Amount)
+}
+
+func formatAmount(currency string, amount float64) string {
+	if currency == core.CurrencyStars {
+
+		return fmt.Sprintf("%.0f ⭐", amount)
+	}
+
+	return fmt.Sprintf("%.2f ₽", amount)
+}
+
+func roundAmount(amount float64) float64 {
+
+	return math.Round(amount*100) / 100
 }
@@ -0,0 +1,106 @@
+package usecase_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/usecase"
+)
+
+func newRefundFixture(t *testing.T, pendingAmount float64) (*usecase.PaymentUseCase, *memoryRefundRepo, *core.Payment) {
+	t.Helper()
+
+	payment := &core.Payment{
+		ID:            "pay-1",
+		UserID:        testUserID,
+		Amount:        300,
+		Currency:      "RUB",
+		PaymentMethod: string(core.PaymentMethodBalance),
+		Description:   "Тариф на месяц",
+		Status:        string(core.PaymentStatusCompleted),
+		CreatedAt:     time.Now(),
+	}
+	refundRepo := newMemoryRefundRepo(&core.PaymentRefund{
+		ID:        "refund-1",
+		PaymentID: payment.ID,
+		AdminID:   1,
+		Amount:    pendingAmount,
+		Reason:    "сервер недоступен",
+		Status:    string(core.RefundStatusPending),
+		CreatedAt: time.Now(),
+	})
+	notifUC, _ := newTestNotificationUseCase()
+	balanceUC := usecase.NewBalanceUseCase(newMemoryBalanceRepo(), nil, 0)
+	uc := usecase.NewPaymentUseCase(passthroughUnitOfWork{}, newMemoryPaymentRepo(payment), refundRepo, nil, nil, nil, nil, balanceUC, nil, notifUC, nil, nil, usecase.ReceiptSettings{})
+
+	return uc, refundRepo, payment
+}
+
+func TestRefundPaymentRejectsDifferentRequestWhilePending(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount float64
+		reason string
+	}{
+		{name: "other amount", amount: 50, reason: "сервер недоступен"},
+		{name: "remaining amount", amount: 0, reason: "сервер недоступен"},
+		{name: "other reason", amount: 100, reason: "двойная оплата"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uc, refundRepo, payment := newRefundFixture(t, 100)
+
+			_, err := uc.RefundPayment(context.Background(), usecase.RefundPaymentDTO{PaymentID: payment.ID, AdminID: 1, Amount: tt.amount, Reason: tt.reason})
+			if !errors.Is(err, usecase.ErrRefundPending) {
+				t.Fatalf("expected ErrRefundPending, got %v", err)
+			}
+
+			refund, err := refundRepo.GetRefundByID(context.Background(), "refund-1")
+			if err != nil {
+				t.Fatalf("failed to get refund: %v", err)
+			}
+			if !refund.IsPending() {
+				t.Errorf("expected pending refund to stay pending, got %s", refund.Status)
+			}
+			if refunds, _ := refundRepo.GetRefundsByPaymentID(context.Background(), payment.ID); len(refunds) != 1 {
+				t.Errorf("expected no new refund, got %d refunds", len(refunds))
+			}
+		})
+	}
+}
+
+func TestRefundPaymentResumesMatchingPendingRefund(t *testing.T) {
+	tests := []struct {
+		name          string
+		pendingAmount float64
+		amount        float64
+		wantStatus    core.PaymentStatus
+	}{
+		{name: "same amount", pendingAmount: 100, amount: 100, wantStatus: core.PaymentStatusPartiallyRefunded},
+		{name: "full refund without amount", pendingAmount: 300, amount: 0, wantStatus: core.PaymentStatusRefunded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uc, refundRepo, payment := newRefundFixture(t, tt.pendingAmount)
+
+			result, err := uc.RefundPayment(context.Background(), usecase.RefundPaymentDTO{PaymentID: payment.ID, AdminID: 1, Amount: tt.amount, Reason: " сервер недоступен "})
+			if err != nil {
+				t.Fatalf("RefundPayment returned error: %v", err)
+			}
+			if result.Refund.ID != "refund-1" || result.Refund.IsPending() {
+				t.Errorf("expected pending refund to be completed, got %+v", result.Refund)
+			}
+			if result.Payment.Status != string(tt.wantStatus) {
+				t.Errorf("expected payment status %s, got %s", tt.wantStatus, result.Payment.Status)
+			}
+			if refunds, _ := refundRepo.GetRefundsByPaymentID(context.Background(), payment.ID); len(refunds) != 1 {
+				t.Errorf("expected no new refund, got %d refunds", len(refunds))
+			}
+		})
+	}
+}
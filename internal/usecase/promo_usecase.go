@@ -0,0 +1,246 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/pkg/id"
+	"3xui-bot/internal/ports"
+)
+
+const maxPromoCodeLength = 64
+
+type PromoCodeUseCase struct {
+	promoRepo ports.PromoCodeRepo
+}
+
+func NewPromoCodeUseCase(promoRepo ports.PromoCodeRepo) *PromoCodeUseCase {
+
+	return &PromoCodeUseCase{
+		promoRepo: promoRepo,
+	}
+}
+
+func NormalizePromoCode(code string) string {
+
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func (uc *PromoCodeUseCase) CreatePromoCode(ctx context.Context, dto CreatePromoCodeDTO) (*core.PromoCode, error) {
+	code := NormalizePromoCode(dto.Code)
+	if code == "" || len(code) > maxPromoCodeLength || strings.ContainsAny(code, " \t\n") {
+
+		return nil, fmt.Errorf("invalid promo code %q: %w", dto.Code, ErrInvalidInput)
+	}
+
+	switch dto.DiscountType {
+	case core.PromoDiscountPercent:
+		if dto.DiscountValue < 0 || dto.DiscountValue > 100 {
+
+			return nil, fmt.Errorf("percent discount must be between 0 and 100: %w", ErrInvalidInput)
+		}
+	case core.PromoDiscountFixed:
+		if dto.DiscountValue < 0 {
+
+			return nil, fmt.Errorf("fixed discount must not be negative: %w", ErrInvalidInput)
+		}
+	default:
+
+		return nil, fmt.Errorf("unknown discount type %q: %w", dto.DiscountType, ErrInvalidInput)
+	}
+
+	if dto.DiscountValue == 0 && dto.BonusDays <= 0 {
+
+		return nil, fmt.Errorf("promo code must give a discount or bonus days: %w", ErrInvalidInput)
+	}
+
+	if dto.BonusDays < 0 || dto.MaxUses < 0 || dto.MaxUsesPerUser < 0 {
+
+		return nil, fmt.Errorf("limits must not be negative: %w", ErrInvalidInput)
+	}
+
+	if _, err := uc.promoRepo.GetPromoCodeByCode(ctx, code); err == nil {
+
+		return nil, ErrPromoCodeAlreadyExists
+	} else if !errors.Is(err, ErrNotFound) {
+
+		return nil, fmt.Errorf("failed to check promo code: %w", err)
+	}
+
+	promo := &core.PromoCode{
+		ID:             id.Generate(),
+		Code:           code,
+		DiscountType:   string(dto.DiscountType),
+		DiscountValue:  dto.DiscountValue,
+		BonusDays:      dto.BonusDays,
+		MaxUses:        dto.MaxUses,
+		MaxUsesPerUser: dto.MaxUsesPerUser,
+		PlanIDs:        dto.PlanIDs,
+		ExpiresAt:      dto.ExpiresAt,
+		IsActive:       true,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+
+	if err := uc.promoRepo.CreatePromoCode(ctx, promo); err != nil {
+
+		return nil, err
+	}
+
+	return promo, nil
+}
+
+func (uc *PromoCodeUseCase) GetPromoCodes(ctx context.Context) ([]*core.PromoCode, error) {
+
+	return uc.promoRepo.GetPromoCodes(ctx)
+}
+
+func (uc *PromoCodeUseCase) SetPromoCodeActive(ctx context.Context, code string, isActive bool) (*core.PromoCode, error) {
+	promo, err := uc.promoRepo.GetPromoCodeByCode(ctx, NormalizePromoCode(code))
+	if err != nil {
+
+		return nil, err
+	}
+
+	promo.IsActive = isActive
+	promo.UpdatedAt = time.Now()
+
+	if err := uc.promoRepo.UpdatePromoCode(ctx, promo); err != nil {
+
+		return nil, err
+	}
+
+	return promo, nil
+}
+
+func (uc *PromoCodeUseCase) ValidatePromoCode(ctx context.Context, userID int64, code string, planID string) (*core.PromoCode, error) {
+	promo, err := uc.promoRepo.GetPromoCodeByCode(ctx, NormalizePromoCode(code))
+	if errors.Is(err, ErrNotFound) {
+
+		return nil, ErrPromoCodeInvalid
+	}
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get promo code: %w", err)
+	}
+
+	if !promo.IsActive {
+
+		return nil, ErrPromoCodeInvalid
+	}
+
+	if promo.IsExpired() {
+
+		return nil, ErrPromoCodeExpired
+	}
+
+	if promo.IsExhausted() {
+
+		return nil, ErrPromoCodeExhausted
+	}
+
+	if !promo.AppliesToPlan(planID) {
+
+		return nil, ErrPromoCodeNotApplicable
+	}
+
+	if err := uc.checkUsageLimits(ctx, promo, userID); err != nil {
+
+		return nil, err
+	}
+
+	return promo, nil
+}
+
+func (uc *PromoCodeUseCase) ReservePromoCode(ctx context.Context, promoCodeID string, userID int64) error {
+	promo, err := uc.promoRepo.GetPromoCodeByIDForUpdate(ctx, promoCodeID)
+	if err != nil {
+
+		return fmt.Errorf("failed to get promo code: %w", err)
+	}
+
+	if !promo.IsActive {
+
+		return ErrPromoCodeInvalid
+	}
+
+	if promo.IsExpired() {
+
+		return ErrPromoCodeExpired
+	}
+
+	return uc.checkUsageLimits(ctx, promo, userID)
+}
+
+func (uc *PromoCodeUseCase) checkUsageLimits(ctx context.Context, promo *core.PromoCode, userID int64) error {
+	if promo.MaxUses <= 0 && promo.MaxUsesPerUser <= 0 {
+
+		return nil
+	}
+
+	pending, pendingByUser, err := uc.promoRepo.CountPendingPromoCodePayments(ctx, promo.ID, userID)
+	if err != nil {
+
+		return err
+	}
+
+	if promo.MaxUses > 0 && promo.UsedCount+pending >= promo.MaxUses {
+
+		return ErrPromoCodeExhausted
+	}
+
+	if promo.MaxUsesPerUser > 0 {
+		used, err := uc.promoRepo.CountUserPromoCodeUsages(ctx, promo.ID, userID)
+		if err != nil {
+
+			return err
+		}
+
+		if used+pendingByUser >= promo.MaxUsesPerUser {
+
+			return ErrPromoCodeAlreadyUsed
+		}
+	}
+
+	return nil
+}
+
+func (uc *PromoCodeUseCase) RedeemPromoCode(ctx context.Context, promoCodeID string, userID int64, paymentID string) error {
+	promo, err := uc.promoRepo.GetPromoCodeByIDForUpdate(ctx, promoCodeID)
+	if err != nil {
+
+		return fmt.Errorf("failed to get promo code: %w", err)
+	}
+
+	if promo.IsExhausted() {
+		slog.Warn("Promo code redeemed over its usage limit", "promo_code", promo.Code, "payment_id", paymentID, "user_id", userID)
+	}
+
+	promo.UsedCount++
+	promo.UpdatedAt = time.Now()
+
+	if err := uc.promoRepo.UpdatePromoCode(ctx, promo); err != nil {
+
+		return fmt.Errorf("failed to update promo code: %w", err)
+	}
+
+	usage := &core.PromoCodeUsage{
+		ID:          id.Generate(),
+		PromoCodeID: promo.ID,
+		UserID:      userID,
+		PaymentID:   paymentID,
+		CreatedAt:   time.Now(),
+	}
+
+	if err := uc.promoRepo.CreatePromoCodeUsage(ctx, usage); err != nil {
+
+		return fmt.Errorf("failed to save promo code usage: %w", err)
+	}
+
+	return nil
+}
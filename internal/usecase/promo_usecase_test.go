@@ -0,0 +1,100 @@
+package usecase_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/usecase"
+)
+
+func newTestPromoCode(t *testing.T, uc *usecase.PromoCodeUseCase, maxUses, maxUsesPerUser int) *core.PromoCode {
+	t.Helper()
+
+	promo, err := uc.CreatePromoCode(context.Background(), usecase.CreatePromoCodeDTO{
+		Code:           "spring",
+		DiscountType:   core.PromoDiscountPercent,
+		DiscountValue:  20,
+		MaxUses:        maxUses,
+		MaxUsesPerUser: maxUsesPerUser,
+	})
+	if err != nil {
+		t.Fatalf("failed to create promo code: %v", err)
+	}
+
+	return promo
+}
+
+func TestReservePromoCodeCountsPendingPayments(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryPromoRepo()
+	uc := usecase.NewPromoCodeUseCase(repo)
+	promo := newTestPromoCode(t, uc, 2, 0)
+
+	if err := uc.ReservePromoCode(ctx, promo.ID, testUserID); err != nil {
+		t.Fatalf("expected first reservation to succeed, got %v", err)
+	}
+	repo.addPendingPayment(promo.ID, testUserID)
+
+	if err := uc.ReservePromoCode(ctx, promo.ID, testUserID+1); err != nil {
+		t.Fatalf("expected second reservation to succeed, got %v", err)
+	}
+	repo.addPendingPayment(promo.ID, testUserID+1)
+
+	if err := uc.ReservePromoCode(ctx, promo.ID, testUserID+2); !errors.Is(err, usecase.ErrPromoCodeExhausted) {
+		t.Fatalf("expected pending payments to exhaust the promo code, got %v", err)
+	}
+	if _, err := uc.ValidatePromoCode(ctx, testUserID+2, "SPRING", "plan-month"); !errors.Is(err, usecase.ErrPromoCodeExhausted) {
+		t.Errorf("expected quote to see the reservations, got %v", err)
+	}
+
+	repo.completePendingPayment(promo.ID, testUserID)
+	if err := uc.RedeemPromoCode(ctx, promo.ID, testUserID, "pay-1"); err != nil {
+		t.Fatalf("RedeemPromoCode returned error: %v", err)
+	}
+	if err := uc.ReservePromoCode(ctx, promo.ID, testUserID+2); !errors.Is(err, usecase.ErrPromoCodeExhausted) {
+		t.Errorf("expected redeemed and pending uses to exhaust the promo code, got %v", err)
+	}
+
+	repo.completePendingPayment(promo.ID, testUserID+1)
+	if err := uc.ReservePromoCode(ctx, promo.ID, testUserID+2); err != nil {
+		t.Errorf("expected an expired reservation to free the promo code, got %v", err)
+	}
+}
+
+func TestReservePromoCodeLimitsUsesPerUser(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryPromoRepo()
+	uc := usecase.NewPromoCodeUseCase(repo)
+	promo := newTestPromoCode(t, uc, 0, 1)
+
+	repo.addPendingPayment(promo.ID, testUserID)
+	if err := uc.ReservePromoCode(ctx, promo.ID, testUserID); !errors.Is(err, usecase.ErrPromoCodeAlreadyUsed) {
+		t.Fatalf("expected pending payment to use up the per-user limit, got %v", err)
+	}
+	if err := uc.ReservePromoCode(ctx, promo.ID, testUserID+1); err != nil {
+		t.Errorf("expected another user to reserve the promo code, got %v", err)
+	}
+
+	repo.completePendingPayment(promo.ID, testUserID)
+	if err := uc.RedeemPromoCode(ctx, promo.ID, testUserID, "pay-1"); err != nil {
+		t.Fatalf("RedeemPromoCode returned error: %v", err)
+	}
+	if _, err := uc.ValidatePromoCode(ctx, testUserID, "spring", "plan-month"); !errors.Is(err, usecase.ErrPromoCodeAlreadyUsed) {
+		t.Errorf("expected redeemed promo code to be rejected for the same user, got %v", err)
+	}
+}
+
+func TestReservePromoCodeRejectsDisabledCode(t *testing.T) {
+	ctx := context.Background()
+	uc := usecase.NewPromoCodeUseCase(newMemoryPromoRepo())
+	promo := newTestPromoCode(t, uc, 0, 0)
+
+	if _, err := uc.SetPromoCodeActive(ctx, promo.Code, false); err != nil {
+		t.Fatalf("failed to disable promo code: %v", err)
+	}
+	if err := uc.ReservePromoCode(ctx, promo.ID, testUserID); !errors.Is(err, usecase.ErrPromoCodeInvalid) {
+		t.Errorf("expected disabled promo code to be rejected at payment creation, got %v", err)
+	}
+}
@@ -0,0 +1,638 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/ports"
+)
+
+const (
+	reconcilePageSize      = 100
+	expireDriftTolerance   = time.Minute
+	orphanGracePeriod      = 15 * time.Minute
+	maxSummaryIssueDetails = 20
+)
+
+var (
+	botPanelUsername = regexp.MustCompile(`^user_\d+_`)
+	errNothingToFix  = errors.New("reconciliation issue resolved before fix")
+)
+
+type ReconciliationUseCase struct {
+	vpnRepo  ports.VPNRepo
+	subRepo  ports.SubscriptionRepo
+	panels   ports.PanelRegistry
+	vpnUC    *VPNUseCase
+	notifier ports.Notifier
+	adminIDs []int64
+
+	mu         sync.Mutex
+	orphanSeen map[string]time.Time
+}
+
+func NewReconciliationUseCase(
+	vpnRepo ports.VPNRepo,
+	subRepo ports.SubscriptionRepo,
+	panels ports.PanelRegistry,
+	vpnUC *VPNUseCase,
+	notifier ports.Notifier,
+	adminIDs []int64,
+) *ReconciliationUseCase {
+
+	return &ReconciliationUseCase{
+		vpnRepo:    vpnRepo,
+		subRepo:    subRepo,
+		panels:     panels,
+		vpnUC:      vpnUC,
+		notifier:   notifier,
+		adminIDs:   adminIDs,
+		orphanSeen: make(map[string]time.Time),
+	}
+}
+
+func (uc *ReconciliationUseCase) ReconcileAndNotify(ctx context.Context, dryRun bool) error {
+	report, err := uc.Reconcile(ctx, dryRun)
+	if err != nil {
+
+		return err
+	}
+
+	if report.IsClean() {
+
+		return nil
+	}
+
+	uc.notifyAdmins(ctx, ReconciliationSummaryText(report))
+
+	return nil
+}
+
+func (uc *ReconciliationUseCase) Reconcile(ctx context.Context, dryRun bool) (*core.ReconciliationReport, error) {
+	report := &core.ReconciliationReport{
+		DryRun:       dryRun,
+		StartedAt:    time.Now(),
+		ServerErrors: make(map[string]string),
+	}
+
+	listings := make(map[string]*panelListing)
+	for _, server := range uc.panels.Servers() {
+		if ctx.Err() != nil {
+
+			return nil, ctx.Err()
+		}
+
+		report.Servers++
+		listing, err := uc.listPanel(ctx, server.Name)
+		if err != nil {
+			slog.Error("Failed to reconcile panel server", "server", server.Name, "error", err)
+			report.ServerErrors[server.Name] = err.Error()
+			continue
+		}
+		listings[server.Name] = listing
+	}
+
+	connections, err := uc.vpnRepo.GetAllVPNConnections(ctx)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get VPN connections: %w", err)
+	}
+	report.Connections = len(connections)
+
+	connectionsByServer := make(map[string][]*core.VPNConnection)
+	for _, conn := range connections {
+		serverName := uc.connectionServerName(conn)
+		connectionsByServer[serverName] = append(connectionsByServer[serverName], conn)
+	}
+
+	subscriptions := make(map[string]*core.Subscription)
+	for _, server := range uc.panels.Servers() {
+		if ctx.Err() != nil {
+
+			return nil, ctx.Err()
+		}
+
+		serverConnections := connectionsByServer[server.Name]
+		delete(connectionsByServer, server.Name)
+
+		listing, ok := listings[server.Name]
+		if !ok {
+			continue
+		}
+
+		uc.reconcileServer(ctx, report, listing, serverConnections, subscriptions)
+	}
+
+	for serverName, orphaned := range connectionsByServer {
+		for _, conn := range orphaned {
+			uc.record(ctx, report, core.ReconciliationIssue{
+				Type:           core.IssueDanglingConnection,
+				Action:         core.ActionNone,
+				ServerName:     serverName,
+				Username:       conn.MarzbanUsername,
+				VPNID:          conn.ID,
+				SubscriptionID: conn.SubscriptionID,
+				Detail:         "сервер не настроен",
+			}, nil)
+		}
+	}
+
+	report.FinishedAt = time.Now()
+
+	slog.Info("VPN reconciliation completed",
+		"dry_run", dryRun,
+		"servers", report.Servers,
+		"panel_users", report.PanelUsers,
+		"connections", report.Connections,
+		"issues", len(report.Issues),
+		"fixed", report.FixedCount(),
+		"failed", report.FailedCount(),
+		"server_errors", len(report.ServerErrors),
+	)
+
+	return report, nil
+}
+
+type panelListing struct {
+	server   string
+	panel    ports.VPNPanel
+	users    map[string]*core.PanelUser
+	listedAt time.Time
+}
+
+func (uc *ReconciliationUseCase) listPanel(ctx context.Context, serverName string) (*panelListing, error) {
+	panel, err := uc.panels.Panel(serverName)
+	if err != nil {
+
+		return nil, err
+	}
+
+	listedAt := time.Now()
+	users, err := listAllPanelUsers(ctx, panel)
+	if err != nil {
+
+		return nil, err
+	}
+
+	return &panelListing{
+		server:   serverName,
+		panel:    panel,
+		users:    users,
+		listedAt: listedAt,
+	}, nil
+}
+
+func (uc *ReconciliationUseCase) reconcileServer(
+	ctx context.Context,
+	report *core.ReconciliationReport,
+	listing *panelListing,
+	connections []*core.VPNConnection,
+	subscriptions map[string]*core.Subscription,
+) {
+	serverName := listing.server
+	panelUsers := listing.users
+	report.PanelUsers += len(panelUsers)
+
+	for _, conn := range connections {
+		panelUser, found := panelUsers[conn.MarzbanUsername]
+		delete(panelUsers, conn.MarzbanUsername)
+
+		if !found && conn.CreatedAt.After(listing.listedAt) {
+			continue
+		}
+
+		if conn.SubscriptionID == "" {
+			if !found {
+				uc.record(ctx, report, core.ReconciliationIssue{
+					Type:       core.IssueDanglingConnection,
+					Action:     core.ActionNone,
+					ServerName: serverName,
+					Username:   conn.MarzbanUsername,
+					VPNID:      conn.ID,
+					Detail:     "пользователь удален из панели, подключение без подписки",
+				}, nil)
+			}
+			continue
+		}
+
+		sub, err := uc.subscription(ctx, conn.SubscriptionID, subscriptions)
+		if err != nil {
+			slog.Warn("Failed to load subscription for reconciliation", "vpn_id", conn.ID, "subscription_id", conn.SubscriptionID, "error", err)
+			continue
+		}
+
+		if !found {
+			uc.reconcileDangling(ctx, report, listing, conn, sub)
+			continue
+		}
+
+		uc.reconcileDrift(ctx, report, serverName, conn, panelUser, sub)
+	}
+
+	orphans := make([]string, 0, len(panelUsers))
+	for username := range panelUsers {
+		orphans = append(orphans, username)
+	}
+	sort.Strings(orphans)
+
+	recent := uc.recentOrphans(serverName, panelUsers, time.Now())
+	for _, username := range orphans {
+		issue := core.ReconciliationIssue{
+			Type:       core.IssueOrphanPanelUser,
+			Action:     core.ActionNone,
+			ServerName: serverName,
+			Username:   username,
+			Detail:     "нет записи в базе",
+		}
+		if !botPanelUsername.MatchString(username) {
+			issue.Detail = "нет записи в базе, пользователь создан не ботом"
+			uc.record(ctx, report, issue, nil)
+			continue
+		}
+
+		if recent[username] {
+			slog.Info("Skipping recently created orphan panel user", "server", serverName, "username", username)
+			continue
+		}
+
+		issue.Action = core.ActionDeletePanelUser
+		uc.record(ctx, report, issue, func() error {
+
+			return uc.deleteOrphan(ctx, serverName, listing.panel, username)
+		})
+	}
+}
+
+func (uc *ReconciliationUseCase) recentOrphans(serverName string, orphans map[string]*core.PanelUser, now time.Time) map[string]bool {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	prefix := serverName + "/"
+	for key := range uc.orphanSeen {
+		if strings.HasPrefix(key, prefix) && orphans[strings.TrimPrefix(key, prefix)] == nil {
+			delete(uc.orphanSeen, key)
+		}
+	}
+
+	recent := make(map[string]bool)
+	for username, user := range orphans {
+		createdAt := user.CreatedAt
+		if createdAt == nil {
+			firstSeen, ok := uc.orphanSeen[prefix+username]
+			if !ok {
+				firstSeen = now
+				uc.orphanSeen[prefix+username] = now
+			}
+			createdAt = &firstSeen
+		}
+
+		if now.Sub(*createdAt) < orphanGracePeriod {
+			recent[username] = true
+		}
+	}
+
+	return recent
+}
+
+func (uc *ReconciliationUseCase) deleteOrphan(ctx context.Context, serverName string, panel ports.VPNPanel, username string) error {
+	conn, err := uc.vpnRepo.GetVPNConnectionByMarzbanUsername(ctx, username)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+
+		return fmt.Errorf("failed to recheck connection row: %w", err)
+	}
+	if err == nil && uc.connectionServerName(conn) == serverName {
+		slog.Info("Orphan panel user got a connection row, keeping it", "server", serverName, "username", username)
+
+		return errNothingToFix
+	}
+
+	return panel.DeleteUser(ctx, username)
+}
+
+func (uc *ReconciliationUseCase) connectionServerName(conn *core.VPNConnection) string {
+	if conn.ServerName == "" {
+
+		return uc.panels.DefaultServer()
+	}
+
+	return conn.ServerName
+}
+
+func (uc *ReconciliationUseCase) reconcileDangling(ctx context.Context, report *core.ReconciliationReport, listing *panelListing, conn *core.VPNConnection, sub *core.Subscription) {
+	issue := core.ReconciliationIssue{
+		Type:           core.IssueDanglingConnection,
+		ServerName:     listing.server,
+		Username:       conn.MarzbanUsername,
+		VPNID:          conn.ID,
+		SubscriptionID: conn.SubscriptionID,
+	}
+
+	if subscriptionShouldBeActive(sub) {
+		issue.Action = core.ActionRecreateUser
+		issue.Detail = "пользователь удален из панели, подписка активна"
+		uc.record(ctx, report, issue, func() error {
+			if err := confirmPanelUserMissing(ctx, listing, conn.MarzbanUsername); err != nil {
+
+				return err
+			}
+
+			return uc.vpnUC.RestorePanelUser(ctx, conn, sub)
+		})
+
+		return
+	}
+
+	issue.Action = core.ActionDeleteRow
+	issue.Detail = "пользователь удален из панели, подписка неактивна"
+	uc.record(ctx, report, issue, func() error {
+		if err := confirmPanelUserMissing(ctx, listing, conn.MarzbanUsername); err != nil {
+
+			return err
+		}
+
+		return uc.vpnRepo.DeleteVPNConnection(ctx, conn.ID)
+	})
+}
+
+func confirmPanelUserMissing(ctx context.Context, listing *panelListing, username string) error {
+	_, err := listing.panel.GetUser(ctx, username)
+	switch {
+	case err == nil:
+		slog.Info("Panel user missing from listing still exists, keeping it", "server", listing.server, "username", username)
+
+		return errNothingToFix
+	case errors.Is(err, ErrPanelUserNotFound):
+
+		return nil
+	default:
+
+		return fmt.Errorf("failed to recheck panel user: %w", err)
+	}
+}
+
+func (uc *ReconciliationUseCase) reconcileDrift(ctx context.Context, report *core.ReconciliationReport, serverName string, conn *core.VPNConnection, panelUser *core.PanelUser, sub *core.Subscription) {
+	issue := core.ReconciliationIssue{
+		ServerName:     serverName,
+		Username:       conn.MarzbanUsername,
+		VPNID:          conn.ID,
+		SubscriptionID: conn.SubscriptionID,
+	}
+
+	shouldBeActive := subscriptionShouldBeActive(sub)
+	switch {
+	case shouldBeActive && expireDrifted(panelUser.ExpireAt, sub.AccessEndsAt()):
+		issue.Type = core.IssueExpireDrift
+		issue.Action = core.ActionUpdateExpire
+		issue.Detail = fmt.Sprintf("в панели %s, в подписке %s", formatReconcileTime(panelUser.ExpireAt), sub.AccessEndsAt().Format("02.01.2006 15:04"))
+		endDate := sub.AccessEndsAt()
+		uc.record(ctx, report, issue, func() error {
+
+			return uc.vpnUC.modifyPanelUser(ctx, conn, func(user *core.PanelUser) {
+				user.ExpireAt = &endDate
+				if user.Status == core.PanelUserStatusExpired || user.Status == core.PanelUserStatusDisabled {
+					user.Status = core.PanelUserStatusActive
+				}
+			})
+		})
+	case shouldBeActive && (panelUser.Status == core.PanelUserStatusDisabled || panelUser.Status == core.PanelUserStatusExpired):
+		issue.Type = core.IssueStatusDrift
+		issue.Action = core.ActionEnableUser
+		issue.Detail = fmt.Sprintf("в панели %s, подписка активна", panelUser.Status)
+		uc.record(ctx, report, issue, func() error {
+
+			return uc.vpnUC.modifyPanelUser(ctx, conn, func(user *core.PanelUser) {
+				user.Status = core.PanelUserStatusActive
+			})
+		})
+	case !shouldBeActive && panelUser.IsActive():
+		issue.Type = core.IssueStatusDrift
+		issue.Action = core.ActionDisableUser
+		issue.Detail = "в панели active, подписка неактивна"
+		uc.record(ctx, report, issue, func() error {
+
+			return uc.vpnUC.modifyPanelUser(ctx, conn, func(user *core.PanelUser) {
+				user.Status = core.PanelUserStatusDisabled
+			})
+		})
+	}
+
+	if conn.IsActive == shouldBeActive {
+
+		return
+	}
+
+	issue.Type = core.IssueStatusDrift
+	issue.Action = core.ActionUpdateRowStatus
+	issue.Detail = fmt.Sprintf("в базе is_active=%t, ожидается %t", conn.IsActive, shouldBeActive)
+	uc.record(ctx, report, issue, func() error {
+
+		return uc.vpnRepo.UpdateVPNConnectionStatus(ctx, conn.ID, shouldBeActive)
+	})
+}
+
+func (uc *ReconciliationUseCase) record(ctx context.Context, report *core.ReconciliationReport, issue core.ReconciliationIssue, fix func() error) {
+	if !report.DryRun && fix != nil && issue.Action != core.ActionNone {
+		err := fix()
+		switch {
+		case errors.Is(err, errNothingToFix):
+			issue.Action = core.ActionNone
+			issue.Detail += ", расхождение устранено до исправления"
+		case err != nil:
+			issue.Error = err.Error()
+		default:
+			issue.Fixed = true
+		}
+	}
+
+	slog.Info("Reconciliation issue",
+		"type", issue.Type,
+		"action", issue.Action,
+		"server", issue.ServerName,
+		"username", issue.Username,
+		"vpn_id", issue.VPNID,
+		"subscription_id", issue.SubscriptionID,
+		"detail", issue.Detail,
+		"fixed", issue.Fixed,
+		"error", issue.Error,
+		"dry_run", report.DryRun,
+	)
+
+	report.Issues = append(report.Issues, issue)
+}
+
+func (uc *ReconciliationUseCase) subscription(ctx context.Context, subscriptionID string, cache map[string]*core.Subscription) (*core.Subscription, error) {
+	if sub, ok := cache[subscriptionID]; ok {
+
+		return sub, nil
+	}
+
+	sub, err := uc.subRepo.GetSubscriptionByID(ctx, subscriptionID)
+	if errors.Is(err, ErrNotFound) {
+		cache[subscriptionID] = nil
+
+		return nil, nil
+	}
+	if err != nil {
+
+		return nil, err
+	}
+	cache[subscriptionID] = sub
+
+	return sub, nil
+}
+
+func (uc *ReconciliationUseCase) notifyAdmins(ctx context.Context, text string) {
+	for _, adminID := range uc.adminIDs {
+		if err := uc.notifier.Send(ctx, adminID, text, nil); err != nil {
+			slog.Error("Failed to send reconciliation summary", "admin_id", adminID, "error", err)
+		}
+	}
+}
+
+func listAllPanelUsers(ctx context.Context, panel ports.VPNPanel) (map[string]*core.PanelUser, error) {
+	users := make(map[string]*core.PanelUser)
+	for offset := 0; ; offset += reconcilePageSize {
+		page, err := panel.ListUsers(ctx, offset, reconcilePageSize)
+		if err != nil {
+
+			return nil, err
+		}
+
+		for _, user := range page.Users {
+			users[user.Username] = user
+		}
+
+		if len(page.Users) < reconcilePageSize || offset+len(page.Users) >= page.Total {
+
+			return users, nil
+		}
+	}
+}
+
+func subscriptionShouldBeActive(sub *core.Subscription) bool {
+
+	return sub != nil && sub.HasAccess()
+}
+
+func expireDrifted(panelExpire *time.Time, endDate time.Time) bool {
+	if endDate.IsZero() {
+
+		return false
+	}
+	if panelExpire == nil {
+
+		return true
+	}
+
+	diff := panelExpire.Sub(endDate)
+
+	return diff > expireDriftTolerance || diff < -expireDriftTolerance
+}
+
+func formatReconcileTime(t *time.Time) string {
+	if t == nil {
+
+		return "без срока"
+	}
+
+	return t.Format("02.01.2006 15:04")
+}
+
+func ReconciliationSummaryText(report *core.ReconciliationReport) string {
+	var text strings.Builder
+
+	text.WriteString("🔄 Сверка VPN-подключений")
+	if report.DryRun {
+		text.WriteString(" (пробный запуск, без изменений)")
+	}
+	text.WriteString(fmt.Sprintf("\n\nСерверов: %d\nПользователей в панелях: %d\nПодключений в базе: %d\n",
+		report.Servers, report.PanelUsers, report.Connections))
+
+	if report.IsClean() {
+		text.WriteString("\n✅ Расхождений не найдено")
+
+		return text.String()
+	}
+
+	text.WriteString(fmt.Sprintf("\n👻 Лишние пользователи в панелях: %d", report.Count(core.IssueOrphanPanelUser)))
+	text.WriteString(fmt.Sprintf("\n🕳 Подключения без пользователя в панели: %d", report.Count(core.IssueDanglingConnection)))
+	text.WriteString(fmt.Sprintf("\n📅 Расхождения срока действия: %d", report.Count(core.IssueExpireDrift)))
+	text.WriteString(fmt.Sprintf("\n⚙️ Расхождения статуса: %d", report.Count(core.IssueStatusDrift)))
+
+	if !report.DryRun {
+		text.WriteString(fmt.Sprintf("\n\n✅ Исправлено: %d\n❌ Ошибок: %d", report.FixedCount(), report.FailedCount()))
+	}
+
+	if len(report.ServerErrors) > 0 {
+		servers := make([]string, 0, len(report.ServerErrors))
+		for server := range report.ServerErrors {
+			servers = append(servers, server)
+		}
+		sort.Strings(servers)
+
+		text.WriteString("\n\n⚠️ Серверы, которые не удалось проверить:")
+		for _, server := range servers {
+			text.WriteString(fmt.Sprintf("\n• %s: %s", server, report.ServerErrors[server]))
+		}
+	}
+
+	if len(report.Issues) == 0 {
+
+		return text.String()
+	}
+
+	text.WriteString("\n\nДетали:")
+	for i, issue := range report.Issues {
+		if i == maxSummaryIssueDetails {
+			text.WriteString(fmt.Sprintf("\n…и еще %d", len(report.Issues)-maxSummaryIssueDetails))
+			break
+		}
+
+		text.WriteString(fmt.Sprintf("\n• %s/%s — %s → %s", issue.ServerName, issue.Username, issue.Detail, reconcileActionText(issue.Action)))
+		switch {
+		case issue.Fixed:
+			text.WriteString(" ✅")
+		case issue.Error != "":
+			text.WriteString(" ❌ " + issue.Error)
+		}
+	}
+
+	return text.String()
+}
+
+func reconcileActionText(action core.ReconciliationAction) string {
+	switch action {
+	case core.ActionDeletePanelUser:
+
+		return "удалить из панели"
+	case core.ActionRecreateUser:
+
+		return "создать заново в панели"
+	case core.ActionDeleteRow:
+
+		return "удалить запись из базы"
+	case core.ActionUpdateExpire:
+
+		return "обновить срок в панели"
+	case core.ActionEnableUser:
+
+		return "включить в панели"
+	case core.ActionDisableUser:
+
+		return "отключить в панели"
+	case core.ActionUpdateRowStatus:
+
+		return "обновить статус в базе"
+	default:
+
+		return "только отчет"
+	}
+}
@@ -0,0 +1,368 @@
+package usecase_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"3xui-bot/internal/adapters/panel"
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/ports"
+	"3xui-bot/internal/usecase"
+)
+
+const testAdminID = int64(1000)
+
+type reconcileFixture struct {
+	h        *vpnHarness
+	uc       *usecase.ReconciliationUseCase
+	notifier *recordingNotifier
+
+	expireDrift  *core.VPNConnection
+	disabled     *core.VPNConnection
+	deletedPanel *core.VPNConnection
+	inactiveSub  *core.VPNConnection
+	deadRow      *core.VPNConnection
+	activeEnd    time.Time
+}
+
+func newReconcileFixture(t *testing.T) *reconcileFixture {
+	t.Helper()
+
+	h := newVPNHarness(t)
+	notifier := newRecordingNotifier()
+	f := &reconcileFixture{
+		h:         h,
+		uc:        usecase.NewReconciliationUseCase(h.vpnRepo, h.subRepo, h.registry, h.uc, notifier, []int64{testAdminID}),
+		notifier:  notifier,
+		activeEnd: time.Now().Add(30 * 24 * time.Hour).Truncate(time.Second),
+	}
+
+	for _, id := range []string{"sub-expire", "sub-disabled", "sub-deleted", "sub-inactive", "sub-dead"} {
+		h.addSubscription(t, id, f.activeEnd)
+	}
+
+	f.expireDrift = h.createVPN(t, "sub-expire")
+	user, _ := h.server.User(f.expireDrift.MarzbanUsername)
+	staleExpire := time.Now().Add(2 * 24 * time.Hour).Unix()
+	user.Expire = &staleExpire
+	h.server.PutUser(user)
+
+	f.disabled = h.createVPN(t, "sub-disabled")
+	user, _ = h.server.User(f.disabled.MarzbanUsername)
+	user.Status = string(core.MarzbanUserStatusDisabled)
+	h.server.PutUser(user)
+
+	f.deletedPanel = h.createVPN(t, "sub-deleted")
+	h.server.RemoveUser(f.deletedPanel.MarzbanUsername)
+
+	f.inactiveSub = h.createVPN(t, "sub-inactive")
+	f.deadRow = h.createVPN(t, "sub-dead")
+	h.server.RemoveUser(f.deadRow.MarzbanUsername)
+	for _, id := range []string{"sub-inactive", "sub-dead"} {
+		sub, _ := h.subRepo.GetSubscriptionByID(context.Background(), id)
+		sub.IsActive = false
+		_ = h.subRepo.UpdateSubscription(context.Background(), sub)
+	}
+
+	orphanCreatedAt := time.Now().Add(-time.Hour).UTC().Format("2006-01-02T15:04:05")
+	h.server.PutUser(&core.MarzbanUserData{Username: "user_99_orphan", Status: "active", CreatedAt: &orphanCreatedAt})
+	h.server.PutUser(&core.MarzbanUserData{Username: "manual_client", Status: "active"})
+
+	return f
+}
+
+func issuesByAction(report *core.ReconciliationReport) map[core.ReconciliationAction][]core.ReconciliationIssue {
+	result := make(map[core.ReconciliationAction][]core.ReconciliationIssue)
+	for _, issue := range report.Issues {
+		result[issue.Action] = append(result[issue.Action], issue)
+	}
+
+	return result
+}
+
+func TestReconcileDryRunReportsWithoutChanges(t *testing.T) {
+	f := newReconcileFixture(t)
+
+	report, err := f.uc.Reconcile(context.Background(), true)
+	if err != nil {
+		t.Fatalf("Reconcile returned error: %v", err)
+	}
+
+	if got := report.Count(core.IssueOrphanPanelUser); got != 2 {
+		t.Errorf("expected 2 orphan panel users, got %d", got)
+	}
+	if got := report.Count(core.IssueDanglingConnection); got != 2 {
+		t.Errorf("expected 2 dangling connections, got %d", got)
+	}
+	if got := report.Count(core.IssueExpireDrift); got != 1 {
+		t.Errorf("expected 1 expire drift, got %d", got)
+	}
+	if got := report.Count(core.IssueStatusDrift); got != 3 {
+		t.Errorf("expected 3 status drifts, got %d", got)
+	}
+	if report.FixedCount() != 0 {
+		t.Errorf("dry run must not fix anything, fixed %d", report.FixedCount())
+	}
+
+	actions := issuesByAction(report)
+	expected := map[core.ReconciliationAction]string{
+		core.ActionDeletePanelUser: "user_99_orphan",
+		core.ActionNone:            "manual_client",
+		core.ActionRecreateUser:    f.deletedPanel.MarzbanUsername,
+		core.ActionDeleteRow:       f.deadRow.MarzbanUsername,
+		core.ActionUpdateExpire:    f.expireDrift.MarzbanUsername,
+		core.ActionEnableUser:      f.disabled.MarzbanUsername,
+		core.ActionDisableUser:     f.inactiveSub.MarzbanUsername,
+		core.ActionUpdateRowStatus: f.inactiveSub.MarzbanUsername,
+	}
+	for action, username := range expected {
+		issues := actions[action]
+		if len(issues) != 1 || issues[0].Username != username {
+			t.Errorf("expected %s for %s, got %+v", action, username, issues)
+		}
+	}
+
+	if _, ok := f.h.server.User("user_99_orphan"); !ok {
+		t.Errorf("dry run deleted orphan panel user")
+	}
+	if _, err := f.h.vpnRepo.GetVPNConnectionByID(context.Background(), f.deadRow.ID); err != nil {
+		t.Errorf("dry run deleted connection row: %v", err)
+	}
+}
+
+func TestReconcileAppliesFixes(t *testing.T) {
+	f := newReconcileFixture(t)
+	ctx := context.Background()
+
+	report, err := f.uc.Reconcile(ctx, false)
+	if err != nil {
+		t.Fatalf("Reconcile returned error: %v", err)
+	}
+	if report.FailedCount() != 0 {
+		t.Fatalf("expected all fixes to succeed, got %+v", report.Issues)
+	}
+
+	if _, ok := f.h.server.User("user_99_orphan"); ok {
+		t.Errorf("expected bot orphan to be deleted from panel")
+	}
+	if _, ok := f.h.server.User("manual_client"); !ok {
+		t.Errorf("expected foreign panel user to be kept")
+	}
+
+	restored, ok := f.h.server.User(f.deletedPanel.MarzbanUsername)
+	if !ok || restored.Status != string(core.MarzbanUserStatusActive) {
+		t.Errorf("expected deleted panel user to be recreated, got %+v", restored)
+	}
+	if _, err := f.h.vpnRepo.GetVPNConnectionByID(ctx, f.deadRow.ID); err == nil {
+		t.Errorf("expected dangling row of inactive subscription to be deleted")
+	}
+
+	user, _ := f.h.server.User(f.expireDrift.MarzbanUsername)
+	if user.Expire == nil || *user.Expire != f.activeEnd.Unix() {
+		t.Errorf("expected expire %d, got %v", f.activeEnd.Unix(), user.Expire)
+	}
+
+	user, _ = f.h.server.User(f.disabled.MarzbanUsername)
+	if user.Status != string(core.MarzbanUserStatusActive) {
+		t.Errorf("expected disabled user to be enabled, got %q", user.Status)
+	}
+
+	user, _ = f.h.server.User(f.inactiveSub.MarzbanUsername)
+	if user.Status != string(core.MarzbanUserStatusDisabled) {
+		t.Errorf("expected user of inactive subscription to be disabled, got %q", user.Status)
+	}
+	conn, _ := f.h.vpnRepo.GetVPNConnectionByID(ctx, f.inactiveSub.ID)
+	if conn.IsActive {
+		t.Errorf("expected connection of inactive subscription to be marked inactive")
+	}
+
+	second, err := f.uc.Reconcile(ctx, true)
+	if err != nil {
+		t.Fatalf("second Reconcile returned error: %v", err)
+	}
+	if len(second.Issues) != 1 || second.Issues[0].Username != "manual_client" {
+		t.Errorf("expected only the foreign orphan to remain, got %+v", second.Issues)
+	}
+}
+
+func TestReconcileKeepsRecentOrphans(t *testing.T) {
+	h := newVPNHarness(t)
+	ctx := context.Background()
+	uc := usecase.NewReconciliationUseCase(h.vpnRepo, h.subRepo, h.registry, h.uc, newRecordingNotifier(), nil)
+
+	createdAt := time.Now().UTC().Format("2006-01-02T15:04:05")
+	h.server.PutUser(&core.MarzbanUserData{Username: "user_42_fresh", Status: "active", CreatedAt: &createdAt})
+	h.server.PutUser(&core.MarzbanUserData{Username: "user_42_unknown", Status: "active"})
+
+	report, err := uc.Reconcile(ctx, false)
+	if err != nil {
+		t.Fatalf("Reconcile returned error: %v", err)
+	}
+	if got := report.Count(core.IssueOrphanPanelUser); got != 0 {
+		t.Errorf("expected recent orphans to be skipped, got %d issues", got)
+	}
+	for _, username := range []string{"user_42_fresh", "user_42_unknown"} {
+		if _, ok := h.server.User(username); !ok {
+			t.Errorf("expected recent orphan %s to be kept", username)
+		}
+	}
+}
+
+func TestReconcileDeletesUserLeftOnPreviousServer(t *testing.T) {
+	h := newVPNHarness(t)
+	ctx := context.Background()
+	uc := usecase.NewReconciliationUseCase(h.vpnRepo, h.subRepo, h.registry, h.uc, newRecordingNotifier(), nil)
+
+	h.addSubscription(t, "sub-moved", time.Now().Add(30*24*time.Hour))
+	conn := h.createVPN(t, "sub-moved")
+	if err := h.vpnRepo.UpdateVPNConnectionServer(ctx, conn.ID, "moved-away"); err != nil {
+		t.Fatalf("failed to move connection row: %v", err)
+	}
+	user, _ := h.server.User(conn.MarzbanUsername)
+	createdAt := time.Now().Add(-time.Hour).UTC().Format("2006-01-02T15:04:05")
+	user.CreatedAt = &createdAt
+	h.server.PutUser(user)
+
+	report, err := uc.Reconcile(ctx, false)
+	if err != nil {
+		t.Fatalf("Reconcile returned error: %v", err)
+	}
+
+	var orphan *core.ReconciliationIssue
+	for i := range report.Issues {
+		if report.Issues[i].Type == core.IssueOrphanPanelUser {
+			orphan = &report.Issues[i]
+		}
+	}
+	if orphan == nil || orphan.Action != core.ActionDeletePanelUser || !orphan.Fixed {
+		t.Fatalf("expected the stale copy on the old server to be deleted, got %+v", report.Issues)
+	}
+	if _, ok := h.server.User(conn.MarzbanUsername); ok {
+		t.Errorf("expected panel user left on the old server to be removed")
+	}
+	if _, err := h.vpnRepo.GetVPNConnectionByID(ctx, conn.ID); err != nil {
+		t.Errorf("expected connection row of the moved key to be kept: %v", err)
+	}
+}
+
+type skippingPanel struct {
+	ports.VPNPanel
+	skipped map[string]bool
+}
+
+func (p *skippingPanel) ListUsers(ctx context.Context, offset, limit int) (*core.PanelUsersPage, error) {
+	page, err := p.VPNPanel.ListUsers(ctx, offset, limit)
+	if err != nil {
+
+		return nil, err
+	}
+
+	users := make([]*core.PanelUser, 0, len(page.Users))
+	for _, user := range page.Users {
+		if !p.skipped[user.Username] {
+			users = append(users, user)
+		}
+	}
+	page.Users = users
+
+	return page, nil
+}
+
+func TestReconcileRechecksUsersMissingFromListing(t *testing.T) {
+	h := newVPNHarness(t)
+	ctx := context.Background()
+
+	h.addSubscription(t, "sub-active", time.Now().Add(30*24*time.Hour))
+	h.addSubscription(t, "sub-inactive", time.Now().Add(30*24*time.Hour))
+	active := h.createVPN(t, "sub-active")
+	inactive := h.createVPN(t, "sub-inactive")
+	sub, _ := h.subRepo.GetSubscriptionByID(ctx, "sub-inactive")
+	sub.IsActive = false
+	_ = h.subRepo.UpdateSubscription(ctx, sub)
+
+	defaultPanel, err := h.registry.Panel(testServerName)
+	if err != nil {
+		t.Fatalf("failed to get panel: %v", err)
+	}
+	registry := panel.NewRegistry()
+	skipping := &skippingPanel{VPNPanel: defaultPanel, skipped: map[string]bool{active.MarzbanUsername: true, inactive.MarzbanUsername: true}}
+	if err := registry.Register(core.PanelServer{Name: testServerName, Type: core.PanelTypeMarzban, Weight: 1}, skipping); err != nil {
+		t.Fatalf("failed to register panel: %v", err)
+	}
+	uc := usecase.NewReconciliationUseCase(h.vpnRepo, h.subRepo, registry, h.uc, newRecordingNotifier(), nil)
+	created := h.server.RequestCount("POST /api/user")
+
+	report, err := uc.Reconcile(ctx, false)
+	if err != nil {
+		t.Fatalf("Reconcile returned error: %v", err)
+	}
+
+	if got := report.Count(core.IssueDanglingConnection); got != 2 {
+		t.Fatalf("expected both skipped users to be reported, got %+v", report.Issues)
+	}
+	for _, issue := range report.Issues {
+		if issue.Action != core.ActionNone || issue.Fixed || issue.Error != "" {
+			t.Errorf("expected skipped user to be left alone after recheck, got %+v", issue)
+		}
+	}
+	if got := h.server.RequestCount("POST /api/user"); got != created {
+		t.Errorf("expected no panel user to be recreated, got %d create requests", got-created)
+	}
+	if _, err := h.vpnRepo.GetVPNConnectionByID(ctx, inactive.ID); err != nil {
+		t.Errorf("expected row of a user still in the panel to be kept: %v", err)
+	}
+}
+
+func TestReconcilePagesThroughPanelUsers(t *testing.T) {
+	h := newVPNHarness(t)
+	uc := usecase.NewReconciliationUseCase(h.vpnRepo, h.subRepo, h.registry, h.uc, newRecordingNotifier(), nil)
+
+	for i := 0; i < 250; i++ {
+		h.server.PutUser(&core.MarzbanUserData{Username: fmt.Sprintf("manual_%03d", i), Status: "active"})
+	}
+
+	report, err := uc.Reconcile(context.Background(), true)
+	if err != nil {
+		t.Fatalf("Reconcile returned error: %v", err)
+	}
+	if report.PanelUsers != 250 || report.Count(core.IssueOrphanPanelUser) != 250 {
+		t.Errorf("expected 250 panel users and orphans, got %d and %d", report.PanelUsers, report.Count(core.IssueOrphanPanelUser))
+	}
+	if got := h.server.RequestCount("GET /api/users"); got != 3 {
+		t.Errorf("expected 3 pages, got %d requests", got)
+	}
+}
+
+func TestReconcileAndNotifySendsSummaryToAdmins(t *testing.T) {
+	f := newReconcileFixture(t)
+
+	if err := f.uc.ReconcileAndNotify(context.Background(), true); err != nil {
+		t.Fatalf("ReconcileAndNotify returned error: %v", err)
+	}
+
+	messages := f.notifier.Messages(testAdminID)
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 admin message, got %d", len(messages))
+	}
+	if !strings.Contains(messages[0], "пробный запуск") || !strings.Contains(messages[0], "user_99_orphan") {
+		t.Errorf("unexpected summary:\n%s", messages[0])
+	}
+}
+
+func TestReconcileAndNotifySkipsCleanRun(t *testing.T) {
+	h := newVPNHarness(t)
+	notifier := newRecordingNotifier()
+	uc := usecase.NewReconciliationUseCase(h.vpnRepo, h.subRepo, h.registry, h.uc, notifier, []int64{testAdminID})
+	h.addSubscription(t, "sub-1", time.Now().Add(24*time.Hour))
+	h.createVPN(t, "sub-1")
+
+	if err := uc.ReconcileAndNotify(context.Background(), false); err != nil {
+		t.Fatalf("ReconcileAndNotify returned error: %v", err)
+	}
+	if messages := notifier.Messages(testAdminID); len(messages) != 0 {
+		t.Errorf("expected no admin messages for clean run, got %v", messages)
+	}
+}
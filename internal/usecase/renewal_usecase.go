@@ -0,0 +1,171 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"3xui-bot/internal/core"
+)
+
+type AutoRenewalUseCase struct {
+	subscriptionUC *SubscriptionUseCase
+	paymentUC      *PaymentUseCase
+	notifUC        *NotificationUseCase
+	chargeBefore   time.Duration
+	maxAttempts    int
+	retryBackoff   time.Duration
+}
+
+func NewAutoRenewalUseCase(
+	subscriptionUC *SubscriptionUseCase,
+	paymentUC *PaymentUseCase,
+	notifUC *NotificationUseCase,
+	chargeBefore time.Duration,
+	maxAttempts int,
+	retryBackoff time.Duration,
+) *AutoRenewalUseCase {
+
+	return &AutoRenewalUseCase{
+		subscriptionUC: subscriptionUC,
+		paymentUC:      paymentUC,
+		notifUC:        notifUC,
+		chargeBefore:   chargeBefore,
+		maxAttempts:    maxAttempts,
+		retryBackoff:   retryBackoff,
+	}
+}
+
+func (uc *AutoRenewalUseCase) ProcessAutoRenewals(ctx context.Context) error {
+	subscriptions, err := uc.subscriptionUC.GetSubscriptionsDueForRenewal(ctx, uc.chargeBefore)
+	if err != nil {
+
+		return fmt.Errorf("failed to get subscriptions due for renewal: %w", err)
+	}
+
+	var renewed, pending, failed int
+	for _, subscription := range subscriptions {
+		if ctx.Err() != nil {
+
+			return ctx.Err()
+		}
+
+		result, err := uc.paymentUC.ChargeSubscriptionRenewal(ctx, subscription)
+		switch {
+		case errors.Is(err, ErrRenewalPending):
+			pending++
+			uc.scheduleRetry(ctx, subscription)
+
+			continue
+		case err != nil:
+			failed++
+			uc.handleFailure(ctx, subscription, err)
+
+			continue
+		}
+
+		if !result.Payment.IsCompleted() {
+			pending++
+			uc.scheduleRetry(ctx, subscription)
+
+			continue
+		}
+
+		renewed++
+		uc.notify(ctx, subscription.UserID, "🔄 Подписка продлена",
+			fmt.Sprintf("Подписка \"%s\" автоматически продлена до %s. Списано %s (%s).",
+				result.Subscription.GetDisplayName(),
+				result.Subscription.EndDate.Format("02.01.2006"),
+				formatPaymentAmount(result.Payment),
+				renewalSourceText(result.Payment)))
+	}
+
+	slog.Info("Auto-renewals processed",
+		"due", len(subscriptions),
+		"renewed", renewed,
+		"pending", pending,
+		"failed", failed)
+
+	return nil
+}
+
+func (uc *AutoRenewalUseCase) scheduleRetry(ctx context.Context, subscription *core.Subscription) {
+	if err := uc.subscriptionUC.ScheduleRenewalRetry(ctx, subscription.ID, time.Now().Add(uc.retryBackoff)); err != nil {
+		slog.Error("Failed to schedule renewal retry", "subscription_id", subscription.ID, "error", err)
+	}
+}
+
+func (uc *AutoRenewalUseCase) handleFailure(ctx context.Context, subscription *core.Subscription, cause error) {
+	slog.Warn("Auto-renewal failed", "subscription_id", subscription.ID, "user_id", subscription.UserID, "attempt", subscription.RenewalAttempts+1, "error", cause)
+
+	if errors.Is(cause, ErrPlanNotActive) || errors.Is(cause, ErrInvalidAmount) {
+		if err := uc.subscriptionUC.DisableAutoRenew(ctx, subscription.ID); err != nil {
+			slog.Error("Failed to disable auto-renewal", "subscription_id", subscription.ID, "error", err)
+		}
+		uc.notify(ctx, subscription.UserID, "⚠️ Автопродление отключено",
+			fmt.Sprintf("Тариф подписки \"%s\" больше недоступен, поэтому автопродление отключено. Выберите новый тариф до %s.",
+				subscription.GetDisplayName(), subscription.EndDate.Format("02.01.2006")))
+
+		return
+	}
+
+	updated, err := uc.subscriptionUC.RecordRenewalFailure(ctx, subscription.ID, uc.maxAttempts, uc.retryBackoff)
+	if err != nil {
+		slog.Error("Failed to record renewal failure", "subscription_id", subscription.ID, "error", err)
+
+		return
+	}
+
+	if !updated.AutoRenew {
+		uc.notify(ctx, subscription.UserID, "❌ Автопродление отключено",
+			fmt.Sprintf("Не удалось продлить подписку \"%s\" после %d попыток: %s. Автопродление отключено, продлите подписку вручную до %s.",
+				subscription.GetDisplayName(), uc.maxAttempts, renewalFailureText(cause), subscription.EndDate.Format("02.01.2006")))
+
+		return
+	}
+
+	uc.notify(ctx, subscription.UserID, "⚠️ Не удалось продлить подписку",
+		fmt.Sprintf("Не удалось автоматически продлить подписку \"%s\": %s. Следующая попытка %s. Пополните баланс, чтобы продление прошло успешно.",
+			subscription.GetDisplayName(), renewalFailureText(cause), updated.NextRenewalAt.Format("02.01.2006 15:04")))
+}
+
+func (uc *AutoRenewalUseCase) notify(ctx context.Context, userID int64, title, message string) {
+	notifDTO := CreateNotificationDTO{
+		UserID:  userID,
+		Type:    "renewal",
+		Title:   title,
+		Message: message,
+	}
+
+	if err := uc.notifUC.CreateNotification(ctx, notifDTO); err != nil {
+		slog.Error("Failed to notify user about renewal", "user_id", userID, "error", err)
+	}
+}
+
+func renewalFailureText(err error) string {
+	switch {
+	case errors.Is(err, ErrNoRenewalPaymentMethod), errors.Is(err, ErrInsufficientBalance):
+
+		return "на балансе недостаточно средств и нет сохраненной карты"
+	case errors.Is(err, ErrPaymentFailed):
+
+		return "платеж по сохраненной карте отклонен"
+	case errors.Is(err, ErrReceiptEmailRequired):
+
+		return "не указан email для отправки чека (укажите его в профиле)"
+	default:
+
+		return "ошибка платежной системы"
+	}
+}
+
+func renewalSourceText(payment *core.Payment) string {
+	if payment.PaymentMethod == string(core.PaymentMethodBalance) {
+
+		return "с баланса"
+	}
+
+	return "с сохраненной карты"
+}
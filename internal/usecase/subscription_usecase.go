@@ -23,6 +23,11 @@ func NewSubscriptionUseCase(subRepo ports.SubscriptionRepo, planRepo ports.PlanR
 }
 
 func (uc *SubscriptionUseCase) CreateSubscription(ctx context.Context, dto CreateSubscriptionDTO) (*core.Subscription, error) {
+	status := core.StatusActive
+	if !dto.IsActive {
+		status = core.StatusInactive
+	}
+
 	newSub := &core.Subscription{
 		ID:        id.Generate(),
 		UserID:    dto.UserID,
@@ -31,6 +36,7 @@ func (uc *SubscriptionUseCase) CreateSubscription(ctx context.Context, dto Creat
 		StartDate: dto.StartDate,
 		EndDate:   dto.EndDate,
 		IsActive:  dto.IsActive,
+		Status:    status,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
@@ -100,7 +106,94 @@ func (uc *SubscriptionUseCase) ExtendSubscription(ctx context.Context, userID in
 		sub.EndDate = time.Now().AddDate(0, 0, days)
 	}
 
-	sub.IsActive = true
+	sub.Activate(time.Now())
+	sub.ResetRenewalState()
+
+	return uc.subRepo.UpdateSubscription(ctx, sub)
+}
+
+func (uc *SubscriptionUseCase) SetAutoRenew(ctx context.Context, userID int64, subscriptionID string, enabled bool) (*core.Subscription, error) {
+	sub, err := uc.subRepo.GetSubscriptionByID(ctx, subscriptionID)
+	if err != nil {
+
+		return nil, err
+	}
+
+	if sub.UserID != userID {
+
+		return nil, ErrUnauthorized
+	}
+
+	if enabled && !sub.IsActive {
+
+		return nil, ErrSubscriptionNotActive
+	}
+
+	sub.AutoRenew = enabled
+	sub.ResetRenewalState()
+	sub.UpdatedAt = time.Now()
+
+	if err := uc.subRepo.UpdateSubscription(ctx, sub); err != nil {
+
+		return nil, err
+	}
+
+	return sub, nil
+}
+
+func (uc *SubscriptionUseCase) GetSubscriptionsDueForRenewal(ctx context.Context, chargeBefore time.Duration) ([]*core.Subscription, error) {
+	now := time.Now()
+
+	return uc.subRepo.GetSubscriptionsDueForRenewal(ctx, now.Add(chargeBefore), now)
+}
+
+func (uc *SubscriptionUseCase) ScheduleRenewalRetry(ctx context.Context, subscriptionID string, at time.Time) error {
+	sub, err := uc.subRepo.GetSubscriptionByID(ctx, subscriptionID)
+	if err != nil {
+
+		return err
+	}
+
+	sub.NextRenewalAt = &at
+	sub.UpdatedAt = time.Now()
+
+	return uc.subRepo.UpdateSubscription(ctx, sub)
+}
+
+func (uc *SubscriptionUseCase) RecordRenewalFailure(ctx context.Context, subscriptionID string, maxAttempts int, backoff time.Duration) (*core.Subscription, error) {
+	sub, err := uc.subRepo.GetSubscriptionByID(ctx, subscriptionID)
+	if err != nil {
+
+		return nil, err
+	}
+
+	sub.RenewalAttempts++
+	if sub.RenewalAttempts >= maxAttempts {
+		sub.AutoRenew = false
+		sub.ResetRenewalState()
+	} else {
+		nextAttempt := time.Now().Add(backoff * time.Duration(1<<(sub.RenewalAttempts-1)))
+		sub.NextRenewalAt = &nextAttempt
+	}
+	sub.UpdatedAt = time.Now()
+
+	if err := uc.subRepo.UpdateSubscription(ctx, sub); err != nil {
+
+		return nil, err
+	}
+
+	return sub, nil
+}
+
+func (uc *SubscriptionUseCase) DisableAutoRenew(ctx context.Context, subscriptionID string) error {
+	sub, err := uc.subRepo.GetSubscriptionByID(ctx, subscriptionID)
+	if err != nil {
+
+		return err
+	}
+
+	sub.AutoRenew = false
+	sub.ResetRenewalState()
 	sub.UpdatedAt = time.Now()
 
 	return uc.subRepo.UpdateSubscription(ctx, sub)
@@ -118,12 +211,57 @@ func (uc *SubscriptionUseCase) CancelSubscription(ctx context.Context, userID in
 		return ErrUnauthorized
 	}
 
-	sub.IsActive = false
-	sub.UpdatedAt = time.Now()
+	sub.Deactivate(time.Now())
 
 	return uc.subRepo.UpdateSubscription(ctx, sub)
 }
 
+func (uc *SubscriptionUseCase) DeactivateSubscription(ctx context.Context, subscriptionID string) (*core.Subscription, error) {
+	sub, err := uc.subRepo.GetSubscriptionByID(ctx, subscriptionID)
+	if err != nil {
+
+		return nil, err
+	}
+
+	now := time.Now()
+	if sub.EndDate.After(now) {
+		sub.EndDate = now
+	}
+	sub.Deactivate(now)
+	sub.AutoRenew = false
+	sub.ResetRenewalState()
+
+	if err := uc.subRepo.UpdateSubscription(ctx, sub); err != nil {
+
+		return nil, err
+	}
+
+	return sub, nil
+}
+
+func (uc *SubscriptionUseCase) ShortenSubscription(ctx context.Context, subscriptionID string, by time.Duration) (*core.Subscription, error) {
+	sub, err := uc.subRepo.GetSubscriptionByID(ctx, subscriptionID)
+	if err != nil {
+
+		return nil, err
+	}
+
+	now := time.Now()
+	sub.EndDate = sub.EndDate.Add(-by)
+	if !sub.EndDate.After(now) {
+		sub.EndDate = now
+		sub.Deactivate(now)
+	}
+	sub.UpdatedAt = now
+
+	if err := uc.subRepo.UpdateSubscription(ctx, sub); err != nil {
+
+		return nil, err
+	}
+
+	return sub, nil
+}
+
 func (uc *SubscriptionUseCase) DeleteSubscription(ctx context.Context, userID int64, subscriptionID string) error {
 	sub, err := uc.subRepo.GetSubscriptionByID(ctx, subscriptionID)
 	if err != nil {
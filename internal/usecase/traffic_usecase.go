@@ -0,0 +1,439 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/ports"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	trafficLimitReachedPercent = 100
+	bytesInGB                  = 1024 * 1024 * 1024
+)
+
+type TrafficAddon struct {
+	GB    int
+	Price float64
+}
+
+func (a TrafficAddon) Enabled() bool {
+
+	return a.GB > 0 && a.Price > 0
+}
+
+func (a TrafficAddon) Bytes() int64 {
+
+	return int64(a.GB) * bytesInGB
+}
+
+type TrafficUseCase struct {
+	vpnRepo    ports.VPNRepo
+	subRepo    ports.SubscriptionRepo
+	planRepo   ports.PlanRepo
+	panels     ports.PanelRegistry
+	vpnUC      *VPNUseCase
+	balanceUC  *BalanceUseCase
+	notifUC    *NotificationUseCase
+	uow        ports.UnitOfWork
+	thresholds []int
+	addon      TrafficAddon
+}
+
+func NewTrafficUseCase(
+	vpnRepo ports.VPNRepo,
+	subRepo ports.SubscriptionRepo,
+	planRepo ports.PlanRepo,
+	panels ports.PanelRegistry,
+	vpnUC *VPNUseCase,
+	balanceUC *BalanceUseCase,
+	notifUC *NotificationUseCase,
+	uow ports.UnitOfWork,
+	thresholds []int,
+	addon TrafficAddon,
+) *TrafficUseCase {
+	levels := make([]int, 0, len(thresholds))
+	for _, threshold := range thresholds {
+		if threshold > 0 && threshold < trafficLimitReachedPercent {
+			levels = append(levels, threshold)
+		}
+	}
+	sort.Ints(levels)
+
+	return &TrafficUseCase{
+		vpnRepo:    vpnRepo,
+		subRepo:    subRepo,
+		planRepo:   planRepo,
+		panels:     panels,
+		vpnUC:      vpnUC,
+		balanceUC:  balanceUC,
+		notifUC:    notifUC,
+		uow:        uow,
+		thresholds: levels,
+		addon:      addon,
+	}
+}
+
+func (uc *TrafficUseCase) Addon() TrafficAddon {
+
+	return uc.addon
+}
+
+func (uc *TrafficUseCase) CheckTrafficUsage(ctx context.Context) (int, error) {
+	connections, err := uc.vpnRepo.GetAllVPNConnections(ctx)
+	if err != nil {
+
+		return 0, fmt.Errorf("failed to get VPN connections: %w", err)
+	}
+
+	defaultServer := uc.panels.DefaultServer()
+	connectionsByServer := make(map[string]map[string]*core.VPNConnection)
+	for _, conn := range connections {
+		if !conn.IsActive {
+			continue
+		}
+		serverName := conn.ServerName
+		if serverName == "" {
+			serverName = defaultServer
+		}
+		if connectionsByServer[serverName] == nil {
+			connectionsByServer[serverName] = make(map[string]*core.VPNConnection)
+		}
+		connectionsByServer[serverName][conn.MarzbanUsername] = conn
+	}
+
+	notified := 0
+	for _, server := range uc.panels.Servers() {
+		serverConnections := connectionsByServer[server.Name]
+		if len(serverConnections) == 0 {
+			continue
+		}
+
+		panel, err := uc.panels.Panel(server.Name)
+		if err != nil {
+			slog.Error("Failed to get panel for traffic check", "server", server.Name, "error", err)
+			continue
+		}
+
+		users, err := listAllPanelUsers(ctx, panel)
+		if err != nil {
+			slog.Error("Failed to list panel users for traffic check", "server", server.Name, "error", err)
+			continue
+		}
+
+		for username, user := range users {
+			if ctx.Err() != nil {
+
+				return notified, ctx.Err()
+			}
+
+			conn, ok := serverConnections[username]
+			if !ok {
+				continue
+			}
+
+			if addonExpired(conn, time.Now()) {
+				uc.expireAddon(ctx, conn, user)
+			}
+
+			if uc.checkConnection(ctx, conn, user) {
+				notified++
+			}
+		}
+	}
+
+	return notified, nil
+}
+
+func (uc *TrafficUseCase) checkConnection(ctx context.Context, conn *core.VPNConnection, user *core.PanelUser) bool {
+	level := uc.usageLevel(user)
+	if level == conn.TrafficWarningPercent {
+
+		return false
+	}
+
+	if err := uc.vpnRepo.UpdateVPNConnectionTrafficWarning(ctx, conn.ID, level); err != nil {
+		slog.Error("Failed to record traffic warning", "vpn_id", conn.ID, "level", level, "error", err)
+
+		return false
+	}
+
+	if level < conn.TrafficWarningPercent {
+		slog.Info("Traffic usage dropped below warning level", "vpn_id", conn.ID, "username", conn.MarzbanUsername, "level", level)
+
+		return false
+	}
+
+	uc.notifyUsage(ctx, conn, user, level)
+
+	return true
+}
+
+func (uc *TrafficUseCase) usageLevel(user *core.PanelUser) int {
+	if user.Status == core.PanelUserStatusLimited {
+
+		return trafficLimitReachedPercent
+	}
+
+	percent := user.UsagePercent()
+	if percent >= trafficLimitReachedPercent {
+
+		return trafficLimitReachedPercent
+	}
+
+	level := 0
+	for _, threshold := range uc.thresholds {
+		if percent >= threshold {
+			level = threshold
+		}
+	}
+
+	return level
+}
+
+func (uc *TrafficUseCase) notifyUsage(ctx context.Context, conn *core.VPNConnection, user *core.PanelUser, level int) {
+	usage := formatTrafficUsage(user)
+	resetText := trafficResetText(user.DataLimitResetStrategy, conn.CreatedAt)
+
+	title := fmt.Sprintf("📊 Использовано %d%% трафика", level)
+	message := fmt.Sprintf("По ключу \"%s\" израсходовано %s. %s", conn.GetDisplayName(), usage, resetText)
+	if level == trafficLimitReachedPercent {
+		title = "🚫 Трафик закончился"
+		message = fmt.Sprintf("Лимит трафика по ключу \"%s\" исчерпан (%s), VPN приостановлен. %s", conn.GetDisplayName(), usage, resetText)
+	}
+	if uc.addon.Enabled() && conn.SubscriptionID != "" {
+		message += fmt.Sprintf("\n\nМожно докупить %d ГБ за %.0f₽ с баланса.", uc.addon.GB, uc.addon.Price)
+	}
+
+	notifDTO := CreateNotificationDTO{
+		UserID:  conn.TelegramUserID,
+		Type:    "traffic",
+		Title:   title,
+		Message: message,
+		Markup:  uc.trafficKeyboard(conn.SubscriptionID),
+	}
+
+	if err := uc.notifUC.CreateNotification(ctx, notifDTO); err != nil {
+		slog.Error("Failed to notify user about traffic usage", "user_id", conn.TelegramUserID, "vpn_id", conn.ID, "error", err)
+	}
+}
+
+func (uc *TrafficUseCase) trafficKeyboard(subscriptionID string) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if uc.addon.Enabled() && subscriptionID != "" {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("➕ Докупить %d ГБ — %.0f₽", uc.addon.GB, uc.addon.Price), "buy_traffic_"+subscriptionID),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🔑 Мои ключи", "open_keys"),
+	))
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func (uc *TrafficUseCase) BuyTrafficAddon(ctx context.Context, userID int64, subscriptionID string) (*core.Subscription, *core.VPNConnection, error) {
+	if !uc.addon.Enabled() {
+
+		return nil, nil, ErrTrafficAddonUnavailable
+	}
+
+	subscription, err := uc.subRepo.GetSubscriptionByID(ctx, subscriptionID)
+	if err != nil {
+
+		return nil, nil, fmt.Errorf("failed to get subscription: %w", err)
+	}
+
+	if subscription.UserID != userID {
+
+		return nil, nil, ErrUnauthorized
+	}
+
+	if subscription.GetStatus() != core.StatusActive || subscription.IsExpired() {
+
+		return nil, nil, ErrSubscriptionNotActive
+	}
+
+	plan, err := uc.planRepo.GetPlanByID(ctx, subscription.PlanID)
+	if err != nil {
+
+		return nil, nil, fmt.Errorf("failed to get plan: %w", err)
+	}
+
+	if plan.DataLimitBytes() == nil {
+
+		return nil, nil, ErrTrafficAddonUnavailable
+	}
+
+	conn, user, err := uc.addonTarget(ctx, subscriptionID)
+	if err != nil {
+
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if addonExpired(conn, now) {
+		uc.expireAddon(ctx, conn, user)
+	}
+
+	var expiresAt *time.Time
+	if nextReset, ok := user.DataLimitResetStrategy.NextReset(conn.CreatedAt, now); ok {
+		expiresAt = &nextReset
+	}
+
+	description := fmt.Sprintf("Дополнительный трафик %d ГБ: %s", uc.addon.GB, subscription.GetDisplayName())
+	applied := false
+	err = uc.uow.Do(ctx, func(ctx context.Context) error {
+		if err := uc.balanceUC.Debit(ctx, userID, uc.addon.Price, core.BalanceTransactionTrafficAddon, "", description); err != nil {
+
+			return err
+		}
+
+		if err := uc.vpnRepo.AddVPNConnectionTrafficAddon(ctx, conn.ID, uc.addon.Bytes(), expiresAt); err != nil {
+
+			return fmt.Errorf("failed to record traffic add-on: %w", err)
+		}
+
+		if err := uc.vpnUC.AddConnectionTraffic(ctx, conn, uc.addon.Bytes()); err != nil {
+
+			return err
+		}
+		applied = true
+
+		return nil
+	})
+	if err != nil {
+		if applied {
+			if revertErr := uc.vpnUC.AddConnectionTraffic(ctx, conn, -uc.addon.Bytes()); revertErr != nil {
+				slog.Error("Failed to revert traffic add-on after failed purchase", "vpn_id", conn.ID, "error", revertErr)
+			}
+		}
+
+		return nil, nil, err
+	}
+
+	conn.TrafficAddonBytes += uc.addon.Bytes()
+	conn.TrafficAddonExpiresAt = expiresAt
+
+	slog.Info("Traffic add-on purchased", "subscription_id", subscriptionID, "vpn_id", conn.ID, "user_id", userID, "gb", uc.addon.GB, "price", uc.addon.Price)
+
+	return subscription, conn, nil
+}
+
+func (uc *TrafficUseCase) addonTarget(ctx context.Context, subscriptionID string) (*core.VPNConnection, *core.PanelUser, error) {
+	connections, err := uc.vpnRepo.GetVPNConnectionsBySubscriptionID(ctx, subscriptionID)
+	if err != nil {
+
+		return nil, nil, fmt.Errorf("failed to get VPN connections: %w", err)
+	}
+
+	var target *core.VPNConnection
+	var targetUser *core.PanelUser
+	for _, conn := range connections {
+		if !conn.IsActive {
+			continue
+		}
+
+		panel, err := uc.panels.Panel(conn.ServerName)
+		if err != nil {
+
+			return nil, nil, fmt.Errorf("failed to get panel for connection %s: %w", conn.ID, err)
+		}
+
+		user, err := panel.GetUser(ctx, conn.MarzbanUsername)
+		if err != nil {
+
+			return nil, nil, fmt.Errorf("failed to get panel user %s: %w", conn.MarzbanUsername, err)
+		}
+
+		if user.DataLimit == nil || *user.DataLimit <= 0 {
+			continue
+		}
+
+		if targetUser == nil || panelUserUsed(user) > panelUserUsed(targetUser) {
+			target = conn
+			targetUser = user
+		}
+	}
+
+	if target == nil {
+
+		return nil, nil, ErrTrafficAddonUnavailable
+	}
+
+	return target, targetUser, nil
+}
+
+func (uc *TrafficUseCase) expireAddon(ctx context.Context, conn *core.VPNConnection, user *core.PanelUser) {
+	if err := uc.vpnRepo.ClearVPNConnectionTrafficAddon(ctx, conn.ID); err != nil {
+		slog.Error("Failed to clear expired traffic add-on", "vpn_id", conn.ID, "error", err)
+
+		return
+	}
+
+	if err := uc.vpnUC.AddConnectionTraffic(ctx, conn, -conn.TrafficAddonBytes); err != nil {
+		slog.Error("Failed to remove expired traffic add-on from panel", "vpn_id", conn.ID, "bytes", conn.TrafficAddonBytes, "error", err)
+
+		return
+	}
+
+	if user.DataLimit != nil && *user.DataLimit > 0 {
+		limit := *user.DataLimit - conn.TrafficAddonBytes
+		if limit < 1 {
+			limit = 1
+		}
+		user.DataLimit = &limit
+	}
+
+	slog.Info("Traffic add-on expired", "vpn_id", conn.ID, "username", conn.MarzbanUsername, "bytes", conn.TrafficAddonBytes)
+	conn.TrafficAddonBytes = 0
+	conn.TrafficAddonExpiresAt = nil
+}
+
+func addonExpired(conn *core.VPNConnection, now time.Time) bool {
+
+	return conn.TrafficAddonBytes > 0 && conn.TrafficAddonExpiresAt != nil && !conn.TrafficAddonExpiresAt.After(now)
+}
+
+func panelUserUsed(user *core.PanelUser) int64 {
+	if user.DataUsed == nil {
+
+		return 0
+	}
+
+	return *user.DataUsed
+}
+
+func formatTrafficUsage(user *core.PanelUser) string {
+	var used int64
+	if user.DataUsed != nil {
+		used = *user.DataUsed
+	}
+	if user.DataLimit == nil || *user.DataLimit <= 0 {
+
+		return formatGB(used)
+	}
+
+	return fmt.Sprintf("%s из %s", formatGB(used), formatGB(*user.DataLimit))
+}
+
+func formatGB(bytes int64) string {
+
+	return fmt.Sprintf("%.1f ГБ", float64(bytes)/bytesInGB)
+}
+
+func trafficResetText(strategy core.DataLimitResetStrategy, anchor time.Time) string {
+	nextReset, ok := strategy.NextReset(anchor, time.Now())
+	if !ok {
+
+		return "Лимит не обнуляется автоматически до конца подписки."
+	}
+
+	return fmt.Sprintf("Трафик обнулится примерно %s.", nextReset.Format("02.01.2006"))
+}
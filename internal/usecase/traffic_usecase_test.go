@@ -0,0 +1,326 @@
+package usecase_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/usecase"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var testTrafficAddon = usecase.TrafficAddon{GB: 50, Price: 150}
+
+type trafficFixture struct {
+	h         *vpnHarness
+	uc        *usecase.TrafficUseCase
+	balanceUC *usecase.BalanceUseCase
+	notifier  *recordingNotifier
+}
+
+type failingCommitUnitOfWork struct{}
+
+func (failingCommitUnitOfWork) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := fn(ctx); err != nil {
+
+		return err
+	}
+
+	return errCommitFailed
+}
+
+var errCommitFailed = errors.New("commit failed")
+
+func newTrafficFixture(t *testing.T) *trafficFixture {
+	t.Helper()
+
+	h := newVPNHarness(t)
+	notifUC, notifier := newTestNotificationUseCase()
+	balanceUC := usecase.NewBalanceUseCase(newMemoryBalanceRepo(), nil, 0)
+
+	return &trafficFixture{
+		h:         h,
+		uc:        usecase.NewTrafficUseCase(h.vpnRepo, h.subRepo, newMemoryPlanRepo(h.plan), h.registry, h.uc, balanceUC, notifUC, passthroughUnitOfWork{}, []int{95, 80}, testTrafficAddon),
+		balanceUC: balanceUC,
+		notifier:  notifier,
+	}
+}
+
+func (f *trafficFixture) fund(t *testing.T, amount float64) {
+	t.Helper()
+
+	if err := f.balanceUC.Credit(context.Background(), testUserID, amount, core.BalanceTransactionTopUp, "", "test"); err != nil {
+		t.Fatalf("failed to credit balance: %v", err)
+	}
+}
+
+func (f *trafficFixture) dataLimit(t *testing.T, username string) int64 {
+	t.Helper()
+
+	user, ok := f.h.server.User(username)
+	if !ok || user.DataLimit == nil {
+		t.Fatalf("panel user %s has no data limit", username)
+	}
+
+	return *user.DataLimit
+}
+
+func (f *trafficFixture) setUsage(t *testing.T, username string, used int64, status core.MarzbanUserStatus) {
+	t.Helper()
+
+	user, ok := f.h.server.User(username)
+	if !ok {
+		t.Fatalf("panel user %s not found", username)
+	}
+	user.DataUsed = &used
+	user.Status = string(status)
+	f.h.server.PutUser(user)
+}
+
+func (f *trafficFixture) check(t *testing.T) int {
+	t.Helper()
+
+	notified, err := f.uc.CheckTrafficUsage(context.Background())
+	if err != nil {
+		t.Fatalf("CheckTrafficUsage returned error: %v", err)
+	}
+
+	return notified
+}
+
+func keyboardCallbacks(markup interface{}) []string {
+	keyboard, ok := markup.(tgbotapi.InlineKeyboardMarkup)
+	if !ok {
+
+		return nil
+	}
+
+	var callbacks []string
+	for _, row := range keyboard.InlineKeyboard {
+		for _, button := range row {
+			if button.CallbackData != nil {
+				callbacks = append(callbacks, *button.CallbackData)
+			}
+		}
+	}
+
+	return callbacks
+}
+
+func TestCheckTrafficUsageWarnsOncePerThreshold(t *testing.T) {
+	f := newTrafficFixture(t)
+	f.h.addSubscription(t, "sub-1", time.Now().Add(30*24*time.Hour))
+	conn := f.h.createVPN(t, "sub-1")
+
+	f.setUsage(t, conn.MarzbanUsername, 50*gigabyte, core.MarzbanUserStatusActive)
+	if notified := f.check(t); notified != 0 {
+		t.Fatalf("expected no warnings below thresholds, got %d", notified)
+	}
+
+	f.setUsage(t, conn.MarzbanUsername, 82*gigabyte, core.MarzbanUserStatusActive)
+	if notified := f.check(t); notified != 1 {
+		t.Fatalf("expected 80%% warning, got %d notifications", notified)
+	}
+	if notified := f.check(t); notified != 0 {
+		t.Errorf("expected repeated check to stay silent, got %d", notified)
+	}
+
+	f.setUsage(t, conn.MarzbanUsername, 96*gigabyte, core.MarzbanUserStatusActive)
+	if notified := f.check(t); notified != 1 {
+		t.Fatalf("expected 95%% warning, got %d notifications", notified)
+	}
+
+	messages := f.notifier.Messages(testUserID)
+	if len(messages) != 2 || !strings.Contains(messages[0], "80%") || !strings.Contains(messages[1], "95%") {
+		t.Fatalf("unexpected warnings: %v", messages)
+	}
+
+	row, _ := f.h.vpnRepo.GetVPNConnectionByID(context.Background(), conn.ID)
+	if row.TrafficWarningPercent != 95 {
+		t.Errorf("expected stored warning level 95, got %d", row.TrafficWarningPercent)
+	}
+}
+
+func TestCheckTrafficUsageNotifiesLimitedUsers(t *testing.T) {
+	f := newTrafficFixture(t)
+	f.h.addSubscription(t, "sub-1", time.Now().Add(30*24*time.Hour))
+	conn := f.h.createVPN(t, "sub-1")
+
+	f.setUsage(t, conn.MarzbanUsername, 100*gigabyte, core.MarzbanUserStatusLimited)
+	if notified := f.check(t); notified != 1 {
+		t.Fatalf("expected limit notification, got %d", notified)
+	}
+
+	messages := f.notifier.Messages(testUserID)
+	if len(messages) != 1 || !strings.Contains(messages[0], "Трафик закончился") {
+		t.Fatalf("unexpected notifications: %v", messages)
+	}
+	callbacks := keyboardCallbacks(f.notifier.Markups(testUserID)[0])
+	if len(callbacks) == 0 || callbacks[0] != "buy_traffic_sub-1" {
+		t.Errorf("expected buy traffic button first, got %v", callbacks)
+	}
+
+	f.setUsage(t, conn.MarzbanUsername, 0, core.MarzbanUserStatusActive)
+	if notified := f.check(t); notified != 0 {
+		t.Errorf("expected reset to stay silent, got %d", notified)
+	}
+	row, _ := f.h.vpnRepo.GetVPNConnectionByID(context.Background(), conn.ID)
+	if row.TrafficWarningPercent != 0 {
+		t.Errorf("expected warning level to be cleared after reset, got %d", row.TrafficWarningPercent)
+	}
+
+	f.setUsage(t, conn.MarzbanUsername, 85*gigabyte, core.MarzbanUserStatusActive)
+	if notified := f.check(t); notified != 1 {
+		t.Errorf("expected warnings to restart after reset, got %d", notified)
+	}
+}
+
+func TestBuyTrafficAddonRaisesLimit(t *testing.T) {
+	f := newTrafficFixture(t)
+	ctx := context.Background()
+	f.h.addSubscription(t, "sub-1", time.Now().Add(30*24*time.Hour))
+	conn := f.h.createVPN(t, "sub-1")
+	f.setUsage(t, conn.MarzbanUsername, 100*gigabyte, core.MarzbanUserStatusLimited)
+
+	if _, _, err := f.uc.BuyTrafficAddon(ctx, testUserID, "sub-1"); !errors.Is(err, usecase.ErrInsufficientBalance) {
+		t.Fatalf("expected ErrInsufficientBalance, got %v", err)
+	}
+
+	if err := f.balanceUC.Credit(ctx, testUserID, 200, core.BalanceTransactionTopUp, "", "test"); err != nil {
+		t.Fatalf("failed to credit balance: %v", err)
+	}
+	if _, _, err := f.uc.BuyTrafficAddon(ctx, testUserID+1, "sub-1"); !errors.Is(err, usecase.ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized for another user, got %v", err)
+	}
+	if _, _, err := f.uc.BuyTrafficAddon(ctx, testUserID, "sub-1"); err != nil {
+		t.Fatalf("BuyTrafficAddon returned error: %v", err)
+	}
+
+	user, _ := f.h.server.User(conn.MarzbanUsername)
+	if user.DataLimit == nil || *user.DataLimit != 150*gigabyte {
+		t.Errorf("expected data limit of 150GB, got %v", user.DataLimit)
+	}
+	if user.Status != string(core.MarzbanUserStatusActive) {
+		t.Errorf("expected panel user to be active again, got %q", user.Status)
+	}
+
+	balance, _ := f.balanceUC.GetBalance(ctx, testUserID)
+	if balance != 50 {
+		t.Errorf("expected balance 50 after purchase, got %.2f", balance)
+	}
+}
+
+func TestBuyTrafficAddonWithoutConnectionsDoesNotCharge(t *testing.T) {
+	f := newTrafficFixture(t)
+	ctx := context.Background()
+	f.h.addSubscription(t, "sub-1", time.Now().Add(30*24*time.Hour))
+	conn := f.h.createVPN(t, "sub-1")
+	if err := f.h.vpnRepo.UpdateVPNConnectionStatus(ctx, conn.ID, false); err != nil {
+		t.Fatalf("failed to deactivate connection: %v", err)
+	}
+	f.fund(t, 200)
+
+	if _, _, err := f.uc.BuyTrafficAddon(ctx, testUserID, "sub-1"); !errors.Is(err, usecase.ErrTrafficAddonUnavailable) {
+		t.Fatalf("expected ErrTrafficAddonUnavailable, got %v", err)
+	}
+
+	balance, _ := f.balanceUC.GetBalance(ctx, testUserID)
+	if balance != 200 {
+		t.Errorf("expected balance to stay 200, got %.2f", balance)
+	}
+}
+
+func TestBuyTrafficAddonRaisesOnlyMostUsedKey(t *testing.T) {
+	f := newTrafficFixture(t)
+	ctx := context.Background()
+	f.h.addSubscription(t, "sub-1", time.Now().Add(30*24*time.Hour))
+	idle := f.h.createVPN(t, "sub-1")
+	busy := f.h.createVPN(t, "sub-1")
+	f.setUsage(t, idle.MarzbanUsername, 10*gigabyte, core.MarzbanUserStatusActive)
+	f.setUsage(t, busy.MarzbanUsername, 100*gigabyte, core.MarzbanUserStatusLimited)
+	f.fund(t, 150)
+
+	_, target, err := f.uc.BuyTrafficAddon(ctx, testUserID, "sub-1")
+	if err != nil {
+		t.Fatalf("BuyTrafficAddon returned error: %v", err)
+	}
+	if target.ID != busy.ID {
+		t.Errorf("expected add-on on the most used key %s, got %s", busy.ID, target.ID)
+	}
+
+	if limit := f.dataLimit(t, busy.MarzbanUsername); limit != 150*gigabyte {
+		t.Errorf("expected busy key limit of 150GB, got %d", limit)
+	}
+	if limit := f.dataLimit(t, idle.MarzbanUsername); limit != 100*gigabyte {
+		t.Errorf("expected idle key limit to stay 100GB, got %d", limit)
+	}
+
+	row, _ := f.h.vpnRepo.GetVPNConnectionByID(ctx, busy.ID)
+	if row.TrafficAddonBytes != testTrafficAddon.Bytes() || row.TrafficAddonExpiresAt != nil {
+		t.Errorf("expected add-on of %d bytes without expiry on a no_reset plan, got %d until %v", testTrafficAddon.Bytes(), row.TrafficAddonBytes, row.TrafficAddonExpiresAt)
+	}
+}
+
+func TestBuyTrafficAddonRevertsPanelWhenCommitFails(t *testing.T) {
+	h := newVPNHarness(t)
+	ctx := context.Background()
+	notifUC, _ := newTestNotificationUseCase()
+	balanceUC := usecase.NewBalanceUseCase(newMemoryBalanceRepo(), nil, 0)
+	uc := usecase.NewTrafficUseCase(h.vpnRepo, h.subRepo, newMemoryPlanRepo(h.plan), h.registry, h.uc, balanceUC, notifUC, failingCommitUnitOfWork{}, []int{95, 80}, testTrafficAddon)
+
+	h.addSubscription(t, "sub-1", time.Now().Add(30*24*time.Hour))
+	conn := h.createVPN(t, "sub-1")
+	if err := balanceUC.Credit(ctx, testUserID, 200, core.BalanceTransactionTopUp, "", "test"); err != nil {
+		t.Fatalf("failed to credit balance: %v", err)
+	}
+
+	if _, _, err := uc.BuyTrafficAddon(ctx, testUserID, "sub-1"); !errors.Is(err, errCommitFailed) {
+		t.Fatalf("expected commit error, got %v", err)
+	}
+
+	user, _ := h.server.User(conn.MarzbanUsername)
+	if user.DataLimit == nil || *user.DataLimit != 100*gigabyte {
+		t.Errorf("expected panel limit to be reverted to 100GB, got %v", user.DataLimit)
+	}
+}
+
+func TestCheckTrafficUsageRemovesAddonAfterReset(t *testing.T) {
+	f := newTrafficFixture(t)
+	ctx := context.Background()
+	f.h.plan.DataLimitResetStrategy = core.DataLimitResetMonth
+	f.h.addSubscription(t, "sub-1", time.Now().Add(60*24*time.Hour))
+	conn := f.h.createVPN(t, "sub-1")
+	f.fund(t, 150)
+
+	if _, _, err := f.uc.BuyTrafficAddon(ctx, testUserID, "sub-1"); err != nil {
+		t.Fatalf("BuyTrafficAddon returned error: %v", err)
+	}
+
+	row, _ := f.h.vpnRepo.GetVPNConnectionByID(ctx, conn.ID)
+	if row.TrafficAddonExpiresAt == nil || !row.TrafficAddonExpiresAt.After(time.Now()) {
+		t.Fatalf("expected add-on to expire at the next reset, got %v", row.TrafficAddonExpiresAt)
+	}
+
+	f.check(t)
+	if limit := f.dataLimit(t, conn.MarzbanUsername); limit != 150*gigabyte {
+		t.Fatalf("expected add-on to stay until the reset, got limit %d", limit)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	if err := f.h.vpnRepo.AddVPNConnectionTrafficAddon(ctx, conn.ID, 0, &past); err != nil {
+		t.Fatalf("failed to move add-on expiry: %v", err)
+	}
+	f.check(t)
+
+	if limit := f.dataLimit(t, conn.MarzbanUsername); limit != 100*gigabyte {
+		t.Errorf("expected limit back at 100GB after the reset, got %d", limit)
+	}
+	row, _ = f.h.vpnRepo.GetVPNConnectionByID(ctx, conn.ID)
+	if row.TrafficAddonBytes != 0 || row.TrafficAddonExpiresAt != nil {
+		t.Errorf("expected add-on to be cleared, got %d until %v", row.TrafficAddonBytes, row.TrafficAddonExpiresAt)
+	}
+}
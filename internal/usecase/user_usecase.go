@@ -2,6 +2,8 @@ package usecase
 
 import (
 	"context"
+	"net/mail"
+	"strings"
 	"time"
 
 	"3xui-bot/internal/core"
@@ -65,6 +67,42 @@ func (uc *UserUseCase) UpdateUser(ctx context.Context, user *core.User) error {
 	return uc.userRepo.UpdateUser(ctx, user)
 }
 
+func (uc *UserUseCase) SetEmail(ctx context.Context, userID int64, email string) (*core.User, error) {
+	email, err := NormalizeEmail(email)
+	if err != nil {
+
+		return nil, err
+	}
+
+	user, err := uc.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+
+		return nil, err
+	}
+
+	user.Email = email
+	user.UpdatedAt = time.Now()
+
+	if err := uc.userRepo.UpdateUser(ctx, user); err != nil {
+
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func NormalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || len(email) > 255 {
+
+		return "", ErrInvalidEmail
+	}
+
+	return email, nil
+}
+
 func (uc *UserUseCase) ActivateTrial(ctx context.Context, userID int64) (bool, error) {
 	user, err := uc.userRepo.GetUserByID(ctx, userID)
 	if err != nil {
@@ -2,8 +2,10 @@ package usecase
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"sort"
 	"time"
 
 	"3xui-bot/internal/core"
@@ -12,24 +14,27 @@ import (
 )
 
 type VPNUseCase struct {
-	vpnRepo     ports.VPNRepo
-	marzbanRepo ports.Marzban
-	subRepo     ports.SubscriptionRepo
-	planRepo    ports.PlanRepo
+	vpnRepo  ports.VPNRepo
+	panels   ports.PanelRegistry
+	selector *PanelSelector
+	subRepo  ports.SubscriptionRepo
+	planRepo ports.PlanRepo
 }
 
 func NewVPNUseCase(
 	vpnRepo ports.VPNRepo,
-	marzbanRepo ports.Marzban,
+	panels ports.PanelRegistry,
+	selector *PanelSelector,
 	subRepo ports.SubscriptionRepo,
 	planRepo ports.PlanRepo,
 ) *VPNUseCase {
 
 	return &VPNUseCase{
-		vpnRepo:     vpnRepo,
-		marzbanRepo: marzbanRepo,
-		subRepo:     subRepo,
-		planRepo:    planRepo,
+		vpnRepo:  vpnRepo,
+		panels:   panels,
+		selector: selector,
+		subRepo:  subRepo,
+		planRepo: planRepo,
 	}
 }
 
@@ -78,8 +83,73 @@ func (uc *VPNUseCase) GetActiveVPNConnections(ctx context.Context, telegramUserI
 	return uc.vpnRepo.GetActiveVPNConnections(ctx, telegramUserID)
 }
 
+func (uc *VPNUseCase) GetUserKeys(ctx context.Context, telegramUserID int64) ([]*core.VPNConnection, error) {
+	connections, err := uc.vpnRepo.GetVPNConnectionsByTelegramUserID(ctx, telegramUserID)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get VPN connections: %w", err)
+	}
+
+	for _, conn := range connections {
+		if conn.ServerName == "" {
+			conn.ServerName = uc.panels.DefaultServer()
+		}
+	}
+
+	return connections, nil
+}
+
+func (uc *VPNUseCase) GetLocations(ctx context.Context) ([]core.ServerLocation, error) {
+	counts, err := uc.selector.ConnectionsByServer(ctx)
+	if err != nil {
+
+		return nil, err
+	}
+
+	servers := uc.panels.Servers()
+	locations := make([]core.ServerLocation, 0, len(servers))
+	for _, server := range servers {
+		locations = append(locations, core.ServerLocation{Server: server, Users: counts[server.Name]})
+	}
+
+	return locations, nil
+}
+
+func (uc *VPNUseCase) CreateUserKey(ctx context.Context, userID int64, subscriptionID, serverName string) (*core.VPNConnection, error) {
+	sub, err := uc.subRepo.GetSubscriptionByID(ctx, subscriptionID)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get subscription: %w", err)
+	}
+	if sub.UserID != userID {
+
+		return nil, ErrNotFound
+	}
+	if !sub.HasAccess() {
+
+		return nil, ErrSubscriptionNotActive
+	}
+
+	existing, err := uc.vpnRepo.GetVPNConnectionsBySubscriptionID(ctx, subscriptionID)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get VPN connections: %w", err)
+	}
+	if len(existing) > 0 {
+
+		return nil, ErrVPNConfigLimitReached
+	}
+
+	return uc.CreateVPNForSubscriptionOnServer(ctx, userID, subscriptionID, serverName)
+}
+
 func (uc *VPNUseCase) CreateVPNForSubscription(ctx context.Context, userID int64, subscriptionID string) (*core.VPNConnection, error) {
-	slog.Info("Creating VPN for subscription", "user_id", userID, "subscription_id", subscriptionID)
+
+	return uc.CreateVPNForSubscriptionOnServer(ctx, userID, subscriptionID, "")
+}
+
+func (uc *VPNUseCase) CreateVPNForSubscriptionOnServer(ctx context.Context, userID int64, subscriptionID, serverName string) (*core.VPNConnection, error) {
+	slog.Info("Creating VPN for subscription", "user_id", userID, "subscription_id", subscriptionID, "server", serverName)
 
 	sub, err := uc.subRepo.GetSubscriptionByID(ctx, subscriptionID)
 	if err != nil {
@@ -94,50 +164,38 @@ func (uc *VPNUseCase) CreateVPNForSubscription(ctx context.Context, userID int64
 		return nil, fmt.Errorf("failed to get plan: %w", err)
 	}
 
-	inbounds, err := uc.marzbanRepo.GetInbounds(ctx)
+	server, err := uc.resolveServer(ctx, serverName)
 	if err != nil {
-		slog.Warn("Failed to get inbounds from Marzban, will try without specific inbounds", "error", err)
-		inbounds = nil
-	}
 
-	userInbounds := uc.buildUserInbounds(inbounds)
-	slog.Debug("Built user inbounds", "inbounds", userInbounds)
+		return nil, err
+	}
 
-	marzbanUsername := fmt.Sprintf("user_%d_%s", userID, id.GenerateShort())
+	panel, err := uc.panels.Panel(server.Name)
+	if err != nil {
 
-	var expireTimestamp *int64
-	if !sub.EndDate.IsZero() {
-		timestamp := sub.EndDate.Unix()
-		expireTimestamp = &timestamp
+		return nil, fmt.Errorf("failed to get panel: %w", err)
 	}
 
-	dataLimit := int64(100 * 1024 * 1024 * 1024)
+	slog.Info("VPN server selected", "server", server.Name, "region", server.Region, "subscription_id", subscriptionID)
 
-	marzbanUser := &core.MarzbanUserData{
-		Username:  marzbanUsername,
-		DataLimit: &dataLimit,
-		Expire:    expireTimestamp,
-		Status:    "active",
-		Note:      fmt.Sprintf("User %d - %s", userID, plan.Name),
-		Proxies: map[string]interface{}{
-			"vless": map[string]interface{}{},
-		},
-		Inbounds: userInbounds,
-	}
+	marzbanUsername := fmt.Sprintf("user_%d_%s", userID, id.GenerateShort())
+	panelUser := newPanelUser(marzbanUsername, userID, sub, plan, uc.panelUserInbounds(ctx, server, panel, plan))
 
-	_, err = uc.marzbanRepo.CreateUser(ctx, marzbanUser)
+	_, err = panel.CreateUser(ctx, panelUser)
 	if err != nil {
-		slog.Error("Failed to create user in Marzban", "username", marzbanUsername, "error", err)
+		slog.Error("Failed to create user in panel", "server", server.Name, "panel", panel.Type(), "username", marzbanUsername, "error", err)
 
-		return nil, fmt.Errorf("failed to create user in Marzban: %w", err)
+		return nil, fmt.Errorf("failed to create user in panel: %w", err)
 	}
 
-	slog.Debug("Marzban user created", "username", marzbanUsername)
+	slog.Debug("Panel user created", "server", server.Name, "panel", panel.Type(), "username", marzbanUsername)
 
 	vpnConn := &core.VPNConnection{
 		ID:              id.Generate(),
 		TelegramUserID:  userID,
+		SubscriptionID:  subscriptionID,
 		MarzbanUsername: marzbanUsername,
+		ServerName:      server.Name,
 		Name:            fmt.Sprintf("VPN - %s", plan.Name),
 		IsActive:        true,
 		CreatedAt:       time.Now(),
@@ -145,7 +203,7 @@ func (uc *VPNUseCase) CreateVPNForSubscription(ctx context.Context, userID int64
 	}
 
 	if err := uc.vpnRepo.CreateVPNConnection(ctx, vpnConn); err != nil {
-		_ = uc.marzbanRepo.DeleteUser(ctx, marzbanUsername)
+		_ = panel.DeleteUser(ctx, marzbanUsername)
 
 		return nil, fmt.Errorf("failed to create VPN connection: %w", err)
 	}
@@ -153,6 +211,443 @@ func (uc *VPNUseCase) CreateVPNForSubscription(ctx context.Context, userID int64
 	return vpnConn, nil
 }
 
+func (uc *VPNUseCase) RestorePanelUser(ctx context.Context, conn *core.VPNConnection, sub *core.Subscription) error {
+	plan, err := uc.planRepo.GetPlanByID(ctx, sub.PlanID)
+	if err != nil {
+
+		return fmt.Errorf("failed to get plan: %w", err)
+	}
+
+	server, err := uc.connectionServer(conn)
+	if err != nil {
+
+		return err
+	}
+
+	panel, err := uc.panels.Panel(server.Name)
+	if err != nil {
+
+		return fmt.Errorf("failed to get panel: %w", err)
+	}
+
+	panelUser := newPanelUser(conn.MarzbanUsername, conn.TelegramUserID, sub, plan, uc.panelUserInbounds(ctx, server, panel, plan))
+	if _, err := panel.CreateUser(ctx, panelUser); err != nil {
+
+		return fmt.Errorf("failed to recreate user in panel: %w", err)
+	}
+
+	slog.Info("Panel user restored", "server", server.Name, "username", conn.MarzbanUsername, "vpn_id", conn.ID)
+
+	return nil
+}
+
+func (uc *VPNUseCase) connectionServer(conn *core.VPNConnection) (core.PanelServer, error) {
+	serverName := conn.ServerName
+	if serverName == "" {
+		serverName = uc.panels.DefaultServer()
+	}
+
+	for _, server := range uc.panels.Servers() {
+		if server.Name == serverName {
+
+			return server, nil
+		}
+	}
+
+	return core.PanelServer{}, fmt.Errorf("%w: %s", ErrServerNotFound, serverName)
+}
+
+func newPanelUser(username string, userID int64, sub *core.Subscription, plan *core.Plan, inbounds map[string][]string) *core.PanelUser {
+	var expireAt *time.Time
+	if !sub.EndDate.IsZero() {
+		endDate := sub.AccessEndsAt()
+		expireAt = &endDate
+	}
+
+	return &core.PanelUser{
+		Username:               username,
+		DataLimit:              plan.DataLimitBytes(),
+		DataLimitResetStrategy: plan.ResetStrategy(),
+		DeviceLimit:            plan.DeviceLimit,
+		TemplateID:             plan.MarzbanTemplateID,
+		ExpireAt:               expireAt,
+		Status:                 core.PanelUserStatusActive,
+		Note:                   fmt.Sprintf("User %d - %s", userID, plan.Name),
+		Proxies:                planProxies(plan, inbounds),
+		Inbounds:               inbounds,
+	}
+}
+
+func planProxies(plan *core.Plan, inbounds map[string][]string) map[string]interface{} {
+	protocols := make([]string, 0, len(inbounds))
+	for protocol := range inbounds {
+		protocols = append(protocols, protocol)
+	}
+	if len(protocols) == 0 {
+		protocols = plan.Protocols
+	}
+	if len(protocols) == 0 {
+		protocols = []string{"vless"}
+	}
+
+	proxies := make(map[string]interface{}, len(protocols))
+	for _, protocol := range protocols {
+		proxies[protocol] = map[string]interface{}{}
+	}
+
+	return proxies
+}
+
+func (uc *VPNUseCase) MoveVPNConnection(ctx context.Context, userID int64, vpnID, serverName string) (*core.VPNConnection, error) {
+	conn, err := uc.vpnRepo.GetVPNConnectionByID(ctx, vpnID)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get VPN connection: %w", err)
+	}
+	if conn.TelegramUserID != userID {
+
+		return nil, ErrNotFound
+	}
+
+	currentServer := conn.ServerName
+	if currentServer == "" {
+		currentServer = uc.panels.DefaultServer()
+	}
+	if currentServer == serverName {
+
+		return nil, ErrSameServer
+	}
+
+	target, err := uc.availableServer(ctx, serverName)
+	if err != nil {
+
+		return nil, err
+	}
+
+	oldPanel, err := uc.panelFor(conn)
+	if err != nil {
+
+		return nil, err
+	}
+
+	current, err := oldPanel.GetUser(ctx, conn.MarzbanUsername)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get panel user %s: %w", conn.MarzbanUsername, err)
+	}
+
+	newPanel, err := uc.panels.Panel(target.Name)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get panel: %w", err)
+	}
+
+	sub, err := uc.subRepo.GetSubscriptionByID(ctx, conn.SubscriptionID)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get subscription: %w", err)
+	}
+
+	plan, err := uc.planRepo.GetPlanByID(ctx, sub.PlanID)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get plan: %w", err)
+	}
+
+	movedPlan := *plan
+	if len(current.Proxies) > 0 {
+		movedPlan.Protocols = make([]string, 0, len(current.Proxies))
+		for protocol := range current.Proxies {
+			movedPlan.Protocols = append(movedPlan.Protocols, protocol)
+		}
+		sort.Strings(movedPlan.Protocols)
+	}
+
+	inbounds := uc.panelUserInbounds(ctx, target, newPanel, &movedPlan)
+	moved := &core.PanelUser{
+		Username:               conn.MarzbanUsername,
+		Status:                 current.Status,
+		ExpireAt:               current.ExpireAt,
+		DataLimit:              remainingDataLimit(current),
+		DataLimitResetStrategy: current.DataLimitResetStrategy,
+		DeviceLimit:            current.DeviceLimit,
+		TemplateID:             plan.MarzbanTemplateID,
+		Note:                   current.Note,
+		Proxies:                planProxies(&movedPlan, inbounds),
+		Inbounds:               inbounds,
+	}
+	if moved.Status == "" {
+		moved.Status = core.PanelUserStatusActive
+	}
+
+	if _, err := newPanel.CreateUser(ctx, moved); err != nil {
+
+		return nil, fmt.Errorf("failed to create user in panel: %w", err)
+	}
+
+	if err := uc.vpnRepo.UpdateVPNConnectionServer(ctx, conn.ID, target.Name); err != nil {
+		_ = newPanel.DeleteUser(ctx, conn.MarzbanUsername)
+
+		return nil, fmt.Errorf("failed to update VPN connection server: %w", err)
+	}
+
+	if err := oldPanel.DeleteUser(ctx, conn.MarzbanUsername); err != nil {
+		slog.Warn("Failed to delete moved user from old server", "username", conn.MarzbanUsername, "server", currentServer, "error", err)
+	}
+
+	slog.Info("VPN connection moved", "vpn_id", conn.ID, "from", currentServer, "to", target.Name)
+
+	conn.ServerName = target.Name
+
+	return conn, nil
+}
+
+func remainingDataLimit(user *core.PanelUser) *int64 {
+	if user.DataLimit == nil || *user.DataLimit <= 0 || user.DataUsed == nil {
+
+		return user.DataLimit
+	}
+	if strategy := user.DataLimitResetStrategy; strategy != "" && strategy != core.DataLimitResetNone {
+
+		return user.DataLimit
+	}
+
+	remaining := *user.DataLimit - *user.DataUsed
+	if remaining < 1 {
+		remaining = 1
+	}
+
+	return &remaining
+}
+
+func (uc *VPNUseCase) resolveServer(ctx context.Context, serverName string) (core.PanelServer, error) {
+	if serverName != "" {
+
+		return uc.availableServer(ctx, serverName)
+	}
+
+	server, err := uc.selector.Select(ctx, "")
+	if err != nil {
+
+		return core.PanelServer{}, fmt.Errorf("failed to select VPN server: %w", err)
+	}
+
+	return server, nil
+}
+
+func (uc *VPNUseCase) availableServer(ctx context.Context, serverName string) (core.PanelServer, error) {
+	locations, err := uc.GetLocations(ctx)
+	if err != nil {
+
+		return core.PanelServer{}, err
+	}
+
+	for _, location := range locations {
+		if location.Server.Name != serverName {
+			continue
+		}
+		if location.IsFull() {
+
+			return core.PanelServer{}, ErrServerFull
+		}
+
+		return location.Server, nil
+	}
+
+	return core.PanelServer{}, fmt.Errorf("%w: %s", ErrServerNotFound, serverName)
+}
+
+func (uc *VPNUseCase) panelUserInbounds(ctx context.Context, server core.PanelServer, panel ports.VPNPanel, plan *core.Plan) map[string][]string {
+	inbounds, err := panel.GetInbounds(ctx)
+	if err != nil {
+		slog.Warn("Failed to get inbounds from panel, will try without specific inbounds", "server", server.Name, "panel", panel.Type(), "error", err)
+		inbounds = nil
+	}
+
+	userInbounds := uc.buildUserInbounds(inbounds, plan)
+	if len(inbounds) > 0 && len(userInbounds) == 0 {
+		slog.Warn("No panel inbounds match plan, falling back to plan protocols", "server", server.Name, "plan_id", plan.ID, "protocols", plan.Protocols, "inbound_tags", plan.InboundTags)
+	}
+	slog.Debug("Built user inbounds", "server", server.Name, "plan_id", plan.ID, "inbounds", userInbounds)
+
+	return userInbounds
+}
+
+func (uc *VPNUseCase) RevokeProvisionedVPN(ctx context.Context, conn *core.VPNConnection) error {
+	panel, err := uc.panelFor(conn)
+	if err != nil {
+
+		return err
+	}
+
+	if err := panel.DeleteUser(ctx, conn.MarzbanUsername); err != nil {
+
+		return fmt.Errorf("failed to delete user from panel: %w", err)
+	}
+
+	return nil
+}
+
+func (uc *VPNUseCase) DisableSubscriptionVPNs(ctx context.Context, subscriptionID string) error {
+	connections, err := uc.vpnRepo.GetVPNConnectionsBySubscriptionID(ctx, subscriptionID)
+	if err != nil {
+
+		return fmt.Errorf("failed to get VPN connections: %w", err)
+	}
+
+	for _, conn := range connections {
+		err := uc.modifyPanelUser(ctx, conn, func(user *core.PanelUser) {
+			user.Status = core.PanelUserStatusDisabled
+		})
+		if err != nil {
+
+			return err
+		}
+
+		if err := uc.vpnRepo.UpdateVPNConnectionStatus(ctx, conn.ID, false); err != nil {
+
+			return fmt.Errorf("failed to update VPN connection status: %w", err)
+		}
+
+		slog.Info("VPN disabled", "subscription_id", subscriptionID, "username", conn.MarzbanUsername)
+	}
+
+	return nil
+}
+
+func (uc *VPNUseCase) DeleteSubscriptionVPNs(ctx context.Context, subscriptionID string) (int, error) {
+	connections, err := uc.vpnRepo.GetVPNConnectionsBySubscriptionID(ctx, subscriptionID)
+	if err != nil {
+
+		return 0, fmt.Errorf("failed to get VPN connections: %w", err)
+	}
+
+	deleted := 0
+	for _, conn := range connections {
+		if err := uc.RevokeProvisionedVPN(ctx, conn); err != nil && !errors.Is(err, ErrPanelUserNotFound) {
+
+			return deleted, err
+		}
+
+		if err := uc.vpnRepo.DeleteVPNConnection(ctx, conn.ID); err != nil {
+
+			return deleted, fmt.Errorf("failed to delete VPN connection: %w", err)
+		}
+
+		deleted++
+		slog.Info("VPN deleted", "subscription_id", subscriptionID, "username", conn.MarzbanUsername)
+	}
+
+	return deleted, nil
+}
+
+func (uc *VPNUseCase) AddConnectionTraffic(ctx context.Context, conn *core.VPNConnection, bytes int64) error {
+	err := uc.modifyPanelUser(ctx, conn, func(user *core.PanelUser) {
+		if user.DataLimit == nil || *user.DataLimit <= 0 {
+
+			return
+		}
+		limit := *user.DataLimit + bytes
+		if limit < 1 {
+			limit = 1
+		}
+		user.DataLimit = &limit
+		if bytes > 0 && user.Status == core.PanelUserStatusLimited {
+			user.Status = core.PanelUserStatusActive
+		}
+	})
+	if err != nil {
+
+		return err
+	}
+
+	slog.Info("VPN traffic limit changed", "vpn_id", conn.ID, "username", conn.MarzbanUsername, "bytes", bytes)
+
+	return nil
+}
+
+func (uc *VPNUseCase) UpdateSubscriptionVPNExpire(ctx context.Context, subscriptionID string, expireAt time.Time) error {
+	connections, err := uc.vpnRepo.GetVPNConnectionsBySubscriptionID(ctx, subscriptionID)
+	if err != nil {
+
+		return fmt.Errorf("failed to get VPN connections: %w", err)
+	}
+
+	for _, conn := range connections {
+		err := uc.modifyPanelUser(ctx, conn, func(user *core.PanelUser) {
+			user.ExpireAt = &expireAt
+		})
+		if err != nil {
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (uc *VPNUseCase) ActivateSubscriptionVPNs(ctx context.Context, subscriptionID string, expireAt time.Time) error {
+	connections, err := uc.vpnRepo.GetVPNConnectionsBySubscriptionID(ctx, subscriptionID)
+	if err != nil {
+
+		return fmt.Errorf("failed to get VPN connections: %w", err)
+	}
+
+	for _, conn := range connections {
+		err := uc.modifyPanelUser(ctx, conn, func(user *core.PanelUser) {
+			user.Status = core.PanelUserStatusActive
+			user.ExpireAt = &expireAt
+		})
+		if err != nil {
+
+			return err
+		}
+
+		if !conn.IsActive {
+			if err := uc.vpnRepo.UpdateVPNConnectionStatus(ctx, conn.ID, true); err != nil {
+
+				return fmt.Errorf("failed to update VPN connection status: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (uc *VPNUseCase) modifyPanelUser(ctx context.Context, conn *core.VPNConnection, modify func(user *core.PanelUser)) error {
+	panel, err := uc.panelFor(conn)
+	if err != nil {
+
+		return err
+	}
+
+	username := conn.MarzbanUsername
+	user, err := panel.GetUser(ctx, username)
+	if err != nil {
+
+		return fmt.Errorf("failed to get panel user %s: %w", username, err)
+	}
+
+	modify(user)
+
+	if _, err := panel.UpdateUser(ctx, username, user); err != nil {
+
+		return fmt.Errorf("failed to update panel user %s: %w", username, err)
+	}
+
+	return nil
+}
+
+func (uc *VPNUseCase) panelFor(conn *core.VPNConnection) (ports.VPNPanel, error) {
+	panel, err := uc.panels.Panel(conn.ServerName)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get panel for connection %s: %w", conn.ID, err)
+	}
+
+	return panel, nil
+}
+
 func (uc *VPNUseCase) GetUserVPNWithStats(ctx context.Context, userID int64) ([]*core.VPNConnection, error) {
 	connections, err := uc.vpnRepo.GetVPNConnectionsByTelegramUserID(ctx, userID)
 	if err != nil {
@@ -161,17 +656,46 @@ func (uc *VPNUseCase) GetUserVPNWithStats(ctx context.Context, userID int64) ([]
 	}
 
 	for _, conn := range connections {
-		marzbanData, err := uc.marzbanRepo.GetUser(ctx, conn.MarzbanUsername)
+		panel, err := uc.panelFor(conn)
 		if err != nil {
+			slog.Warn("VPN connection owned by unknown server", "vpn_id", conn.ID, "server", conn.ServerName, "error", err)
 			conn.IsActive = false
 			continue
 		}
 
-		conn.ExpireAt = marzbanData.ExpireAt()
-		conn.DataLimitBytes = marzbanData.DataLimit
-		conn.DataUsedBytes = marzbanData.DataUsed
-		conn.Status = marzbanData.Status
-		conn.ProtocolConfig = marzbanData.Proxies
+		panelUser, err := panel.GetUser(ctx, conn.MarzbanUsername)
+		if err != nil {
+			conn.IsActive = false
+			continue
+		}
+
+		applyPanelStats(conn, panelUser)
+	}
+
+	return connections, nil
+}
+
+func (uc *VPNUseCase) GetSubscriptionVPNWithStats(ctx context.Context, subscriptionID string) ([]*core.VPNConnection, error) {
+	connections, err := uc.vpnRepo.GetVPNConnectionsBySubscriptionID(ctx, subscriptionID)
+	if err != nil {
+
+		return nil, fmt.Errorf("failed to get VPN connections: %w", err)
+	}
+
+	for _, conn := range connections {
+		panel, err := uc.panelFor(conn)
+		if err != nil {
+			slog.Warn("VPN connection owned by unknown server", "vpn_id", conn.ID, "server", conn.ServerName, "error", err)
+			continue
+		}
+
+		panelUser, err := panel.GetUser(ctx, conn.MarzbanUsername)
+		if err != nil {
+			slog.Warn("Failed to get panel user for subscription", "vpn_id", conn.ID, "subscription_id", subscriptionID, "error", err)
+			continue
+		}
+
+		applyPanelStats(conn, panelUser)
 	}
 
 	return connections, nil
@@ -184,21 +708,33 @@ func (uc *VPNUseCase) GetVPNConnectionWithStats(ctx context.Context, vpnID strin
 		return nil, fmt.Errorf("failed to get VPN connection: %w", err)
 	}
 
-	marzbanData, err := uc.marzbanRepo.GetUser(ctx, connection.MarzbanUsername)
+	panel, err := uc.panelFor(connection)
+	if err != nil {
+
+		return nil, err
+	}
+
+	panelUser, err := panel.GetUser(ctx, connection.MarzbanUsername)
 	if err != nil {
 
-		return nil, fmt.Errorf("failed to get Marzban user data: %w", err)
+		return nil, fmt.Errorf("failed to get panel user data: %w", err)
 	}
 
-	connection.ExpireAt = marzbanData.ExpireAt()
-	connection.DataLimitBytes = marzbanData.DataLimit
-	connection.DataUsedBytes = marzbanData.DataUsed
-	connection.Status = marzbanData.Status
-	connection.ProtocolConfig = marzbanData.Proxies
+	applyPanelStats(connection, panelUser)
 
 	return connection, nil
 }
 
+func applyPanelStats(connection *core.VPNConnection, panelUser *core.PanelUser) {
+	connection.ExpireAt = panelUser.ExpireAt
+	connection.DataLimitBytes = panelUser.DataLimit
+	connection.DataUsedBytes = panelUser.DataUsed
+	connection.Status = string(panelUser.Status)
+	connection.ProtocolConfig = panelUser.Proxies
+	connection.SubscriptionURL = panelUser.SubscriptionURL
+	connection.Links = panelUser.Links
+}
+
 func (uc *VPNUseCase) DeleteVPNConnectionFull(ctx context.Context, vpnID string) error {
 	conn, err := uc.vpnRepo.GetVPNConnectionByID(ctx, vpnID)
 	if err != nil {
@@ -206,9 +742,15 @@ func (uc *VPNUseCase) DeleteVPNConnectionFull(ctx context.Context, vpnID string)
 		return fmt.Errorf("failed to get VPN connection: %w", err)
 	}
 
-	if err := uc.marzbanRepo.DeleteUser(ctx, conn.MarzbanUsername); err != nil {
+	panel, err := uc.panelFor(conn)
+	if err != nil {
+
+		return err
+	}
+
+	if err := panel.DeleteUser(ctx, conn.MarzbanUsername); err != nil {
 
-		return fmt.Errorf("failed to delete user from Marzban: %w", err)
+		return fmt.Errorf("failed to delete user from panel: %w", err)
 	}
 
 	if err := uc.vpnRepo.DeleteVPNConnection(ctx, vpnID); err != nil {
@@ -226,21 +768,35 @@ func (uc *VPNUseCase) SyncVPNStatus(ctx context.Context, vpnID string) error {
 		return fmt.Errorf("failed to get VPN connection: %w", err)
 	}
 
-	marzbanData, err := uc.marzbanRepo.GetUser(ctx, conn.MarzbanUsername)
+	panel, err := uc.panelFor(conn)
 	if err != nil {
 
-		return fmt.Errorf("failed to get Marzban data: %w", err)
+		return err
+	}
+
+	panelUser, err := panel.GetUser(ctx, conn.MarzbanUsername)
+	if err != nil {
+
+		return fmt.Errorf("failed to get panel data: %w", err)
+	}
+
+	isActive := panelUser.IsActive()
+	if conn.IsActive == isActive {
+
+		return nil
 	}
 
-	isActive := marzbanData.Status == "active"
-	if conn.IsActive != isActive {
-		_ = isActive
+	if err := uc.vpnRepo.UpdateVPNConnectionStatus(ctx, conn.ID, isActive); err != nil {
+
+		return fmt.Errorf("failed to update VPN connection status: %w", err)
 	}
 
+	slog.Info("VPN connection status synced from panel", "vpn_id", conn.ID, "server", conn.ServerName, "status", panelUser.Status, "is_active", isActive)
+
 	return nil
 }
 
-func (uc *VPNUseCase) buildUserInbounds(inbounds []map[string]interface{}) map[string][]string {
+func (uc *VPNUseCase) buildUserInbounds(inbounds []core.PanelInbound, plan *core.Plan) map[string][]string {
 	if len(inbounds) == 0 {
 
 		return make(map[string][]string)
@@ -249,14 +805,18 @@ func (uc *VPNUseCase) buildUserInbounds(inbounds []map[string]interface{}) map[s
 	inboundsByProtocol := make(map[string][]string)
 
 	for _, inbound := range inbounds {
-		tag, hasTag := inbound["tag"].(string)
-		protocol, hasProtocol := inbound["protocol"].(string)
+		if inbound.Tag == "" {
+			continue
+		}
 
-		if hasTag && hasProtocol {
-			inboundsByProtocol[protocol] = append(inboundsByProtocol[protocol], tag)
-		} else if hasTag {
-			inboundsByProtocol["vless"] = append(inboundsByProtocol["vless"], tag)
+		protocol := inbound.Protocol
+		if protocol == "" {
+			protocol = "vless"
 		}
+		if !plan.AllowsProtocol(protocol) || !plan.AllowsInbound(inbound.Tag) {
+			continue
+		}
+		inboundsByProtocol[protocol] = append(inboundsByProtocol[protocol], inbound.Tag)
 	}
 
 	slog.Debug("Inbounds grouped by protocol", "groups", inboundsByProtocol)
@@ -264,7 +824,45 @@ func (uc *VPNUseCase) buildUserInbounds(inbounds []map[string]interface{}) map[s
 	return inboundsByProtocol
 }
 
-func (uc *VPNUseCase) DeactivateExpiredVPNs(ctx context.Context) error {
+func (uc *VPNUseCase) DeactivateExpiredVPNs(ctx context.Context, gracePeriod time.Duration, batchSize int) (int, error) {
+	cutoff := time.Now().Add(-gracePeriod)
+	afterID := ""
+	deactivated := 0
 
-	return nil
+	for {
+		connections, err := uc.vpnRepo.GetExpiredActiveVPNConnections(ctx, cutoff, afterID, batchSize)
+		if err != nil {
+
+			return deactivated, fmt.Errorf("failed to get expired VPN connections: %w", err)
+		}
+
+		for _, conn := range connections {
+			if ctx.Err() != nil {
+
+				return deactivated, ctx.Err()
+			}
+			afterID = conn.ID
+
+			err := uc.modifyPanelUser(ctx, conn, func(user *core.PanelUser) {
+				user.Status = core.PanelUserStatusDisabled
+			})
+			if err != nil && !errors.Is(err, ErrPanelUserNotFound) {
+				slog.Error("Failed to disable expired VPN", "vpn_id", conn.ID, "username", conn.MarzbanUsername, "error", err)
+				continue
+			}
+
+			if err := uc.vpnRepo.UpdateVPNConnectionStatus(ctx, conn.ID, false); err != nil {
+				slog.Error("Failed to update VPN connection status", "vpn_id", conn.ID, "error", err)
+				continue
+			}
+
+			deactivated++
+			slog.Info("Expired VPN disabled", "subscription_id", conn.SubscriptionID, "username", conn.MarzbanUsername)
+		}
+
+		if len(connections) < batchSize {
+
+			return deactivated, nil
+		}
+	}
 }
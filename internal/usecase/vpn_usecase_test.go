@@ -0,0 +1,615 @@
+package usecase_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"3xui-bot/internal/adapters/marzban"
+	"3xui-bot/internal/adapters/marzban/marzbantest"
+	"3xui-bot/internal/adapters/panel"
+	"3xui-bot/internal/core"
+	"3xui-bot/internal/usecase"
+)
+
+const (
+	testUserID     = int64(42)
+	testServerName = "default"
+	gigabyte       = int64(1024 * 1024 * 1024)
+)
+
+type vpnHarness struct {
+	server   *marzbantest.Server
+	registry *panel.Registry
+	vpnRepo  *memoryVPNRepo
+	subRepo  *memorySubscriptionRepo
+	uc       *usecase.VPNUseCase
+	plan     *core.Plan
+}
+
+func newVPNHarness(t *testing.T, opts ...marzbantest.Option) *vpnHarness {
+	t.Helper()
+
+	server, err := marzbantest.NewServer(opts...)
+	if err != nil {
+		t.Fatalf("failed to start fake Marzban server: %v", err)
+	}
+	t.Cleanup(func() {
+		if unexpected := server.UnexpectedRequests(); len(unexpected) > 0 {
+			t.Errorf("client called routes missing from marzban.json: %v", unexpected)
+		}
+		server.Close()
+	})
+
+	client := marzban.NewMarzbanRepository(server.URL, marzbantest.DefaultUsername, marzbantest.DefaultPassword)
+
+	registry := panel.NewRegistry()
+	err = registry.Register(core.PanelServer{Name: testServerName, Type: core.PanelTypeMarzban, Weight: 1}, marzban.NewPanel(client, server.URL))
+	if err != nil {
+		t.Fatalf("failed to register panel: %v", err)
+	}
+
+	plan := &core.Plan{ID: "plan-month", Name: "Месяц", Days: 30, IsActive: true, TrafficLimitGB: 100, Protocols: []string{"vless"}}
+	vpnRepo := newMemoryVPNRepo()
+	subRepo := newMemorySubscriptionRepo()
+	vpnRepo.subscriptions = subRepo
+	selector := usecase.NewPanelSelector(registry, vpnRepo, usecase.PanelSelectionLeastUsers, "")
+
+	return &vpnHarness{
+		server:   server,
+		registry: registry,
+		vpnRepo:  vpnRepo,
+		subRepo:  subRepo,
+		uc:       usecase.NewVPNUseCase(vpnRepo, registry, selector, subRepo, newMemoryPlanRepo(plan)),
+		plan:     plan,
+	}
+}
+
+func (h *vpnHarness) addSubscription(t *testing.T, id string, endDate time.Time) *core.Subscription {
+	t.Helper()
+
+	sub := &core.Subscription{
+		ID:        id,
+		UserID:    testUserID,
+		PlanID:    h.plan.ID,
+		StartDate: time.Now(),
+		EndDate:   endDate,
+		IsActive:  true,
+	}
+	if err := h.subRepo.CreateSubscription(context.Background(), sub); err != nil {
+		t.Fatalf("failed to create subscription: %v", err)
+	}
+
+	return sub
+}
+
+func (h *vpnHarness) createVPN(t *testing.T, subscriptionID string) *core.VPNConnection {
+	t.Helper()
+
+	conn, err := h.uc.CreateVPNForSubscription(context.Background(), testUserID, subscriptionID)
+	if err != nil {
+		t.Fatalf("CreateVPNForSubscription returned error: %v", err)
+	}
+
+	return conn
+}
+
+func (h *vpnHarness) addServer(t *testing.T, server core.PanelServer) *marzbantest.Server {
+	t.Helper()
+
+	return registerFakePanel(t, h.registry, server)
+}
+
+func registerFakePanel(t *testing.T, registry *panel.Registry, server core.PanelServer) *marzbantest.Server {
+	t.Helper()
+
+	fake, err := marzbantest.NewServer()
+	if err != nil {
+		t.Fatalf("failed to start fake Marzban server: %v", err)
+	}
+	t.Cleanup(fake.Close)
+
+	client := marzban.NewMarzbanRepository(fake.URL, marzbantest.DefaultUsername, marzbantest.DefaultPassword)
+	server.Type = core.PanelTypeMarzban
+	if err := registry.Register(server, marzban.NewPanel(client, fake.URL)); err != nil {
+		t.Fatalf("failed to register panel: %v", err)
+	}
+
+	return fake
+}
+
+func newTestNotificationUseCase() (*usecase.NotificationUseCase, *recordingNotifier) {
+	notifier := newRecordingNotifier()
+	notifUC := usecase.NewNotificationUseCase(newMemoryNotificationRepo(), newMemoryUserRepo(&core.User{TelegramID: testUserID}), notifier)
+
+	return notifUC, notifier
+}
+
+type recordingNotifier struct {
+	mu       sync.Mutex
+	messages map[int64][]string
+	markups  map[int64][]interface{}
+}
+
+func newRecordingNotifier() *recordingNotifier {
+
+	return &recordingNotifier{messages: make(map[int64][]string), markups: make(map[int64][]interface{})}
+}
+
+func (n *recordingNotifier) Send(ctx context.Context, chatID int64, text string, markup interface{}) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.messages[chatID] = append(n.messages[chatID], text)
+	n.markups[chatID] = append(n.markups[chatID], markup)
+
+	return nil
+}
+
+func (n *recordingNotifier) SendWithParseMode(ctx context.Context, chatID int64, text string, parseMode string, markup interface{}) error {
+
+	return n.Send(ctx, chatID, text, markup)
+}
+
+func (n *recordingNotifier) EditMessage(ctx context.Context, chatID int64, messageID int, text string, markup interface{}) error {
+
+	return nil
+}
+
+func (n *recordingNotifier) DeleteMessage(ctx context.Context, chatID int64, messageID int) error {
+
+	return nil
+}
+
+func (n *recordingNotifier) SendPhoto(ctx context.Context, chatID int64, photoFileID string, caption string, markup interface{}) error {
+
+	return n.Send(ctx, chatID, caption, markup)
+}
+
+func (n *recordingNotifier) SendPhotoFromReader(ctx context.Context, chatID int64, photoReader io.Reader, caption string, markup interface{}) error {
+
+	return n.Send(ctx, chatID, caption, markup)
+}
+
+func (n *recordingNotifier) SendPhotoFromFile(ctx context.Context, chatID int64, photoPath string, caption string, markup interface{}) error {
+
+	return n.Send(ctx, chatID, caption, markup)
+}
+
+func (n *recordingNotifier) SendPhotoFromFileWithParseMode(ctx context.Context, chatID int64, photoPath string, caption string, parseMode string, markup interface{}) error {
+
+	return n.Send(ctx, chatID, caption, markup)
+}
+
+func (n *recordingNotifier) EditMessagePhoto(ctx context.Context, chatID int64, messageID int, photoFileID string, caption string, markup interface{}) error {
+
+	return nil
+}
+
+func (n *recordingNotifier) Messages(chatID int64) []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]string(nil), n.messages[chatID]...)
+}
+
+func (n *recordingNotifier) Markups(chatID int64) []interface{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]interface{}(nil), n.markups[chatID]...)
+}
+
+func TestCreateVPNForSubscription(t *testing.T) {
+	h := newVPNHarness(t)
+	endDate := time.Now().Add(30 * 24 * time.Hour).Truncate(time.Second)
+	h.addSubscription(t, "sub-1", endDate)
+
+	conn := h.createVPN(t, "sub-1")
+
+	if !strings.HasPrefix(conn.MarzbanUsername, "user_42_") {
+		t.Errorf("unexpected panel username %q", conn.MarzbanUsername)
+	}
+	if conn.ServerName != testServerName {
+		t.Errorf("expected server %q, got %q", testServerName, conn.ServerName)
+	}
+
+	stored, err := h.vpnRepo.GetVPNConnectionByID(context.Background(), conn.ID)
+	if err != nil {
+		t.Fatalf("connection was not stored: %v", err)
+	}
+	if stored.SubscriptionID != "sub-1" || !stored.IsActive {
+		t.Errorf("unexpected stored connection: %+v", stored)
+	}
+
+	user, ok := h.server.User(conn.MarzbanUsername)
+	if !ok {
+		t.Fatalf("panel user %s was not created", conn.MarzbanUsername)
+	}
+	if user.Status != string(core.MarzbanUserStatusActive) {
+		t.Errorf("expected active panel user, got %q", user.Status)
+	}
+	if user.Expire == nil || *user.Expire != endDate.Unix() {
+		t.Errorf("expected expire %d, got %v", endDate.Unix(), user.Expire)
+	}
+	if got := user.Inbounds["vless"]; len(got) != 1 || got[0] != "VLESS TCP REALITY" {
+		t.Errorf("expected vless inbound from panel, got %v", user.Inbounds)
+	}
+	if len(user.Links) == 0 || user.SubscriptionURL == "" {
+		t.Errorf("expected links and subscription url, got %+v", user)
+	}
+}
+
+func TestCreateVPNForSubscriptionFollowsPlanSettings(t *testing.T) {
+	h := newVPNHarness(t)
+	h.plan.TrafficLimitGB = 50
+	h.plan.DataLimitResetStrategy = core.DataLimitResetMonth
+	h.plan.Protocols = []string{"vmess"}
+	h.addSubscription(t, "sub-1", time.Now().Add(24*time.Hour))
+
+	conn := h.createVPN(t, "sub-1")
+
+	user, _ := h.server.User(conn.MarzbanUsername)
+	if user.DataLimit == nil || *user.DataLimit != 50*gigabyte {
+		t.Errorf("expected 50GB data limit, got %v", user.DataLimit)
+	}
+	if user.DataLimitResetStrategy != string(core.DataLimitResetMonth) {
+		t.Errorf("expected month reset strategy, got %q", user.DataLimitResetStrategy)
+	}
+	if _, ok := user.Proxies["vless"]; ok || len(user.Proxies) != 1 {
+		t.Errorf("expected only vmess proxy, got %v", user.Proxies)
+	}
+	if got := user.Inbounds["vmess"]; len(user.Inbounds) != 1 || len(got) != 1 || got[0] != "VMess WS" {
+		t.Errorf("expected only vmess inbound, got %v", user.Inbounds)
+	}
+}
+
+func TestCreateVPNForSubscriptionFiltersInboundTags(t *testing.T) {
+	h := newVPNHarness(t)
+	h.plan.TrafficLimitGB = 0
+	h.plan.Protocols = nil
+	h.plan.InboundTags = []string{"VMess WS"}
+	h.addSubscription(t, "sub-1", time.Now().Add(24*time.Hour))
+
+	conn := h.createVPN(t, "sub-1")
+
+	user, _ := h.server.User(conn.MarzbanUsername)
+	if user.DataLimit != nil && *user.DataLimit != 0 {
+		t.Errorf("expected unlimited traffic, got %d", *user.DataLimit)
+	}
+	if user.DataLimitResetStrategy != string(core.DataLimitResetNone) {
+		t.Errorf("expected no_reset strategy, got %q", user.DataLimitResetStrategy)
+	}
+	if _, ok := user.Inbounds["vless"]; ok {
+		t.Errorf("expected vless inbound to be filtered out, got %v", user.Inbounds)
+	}
+	if _, ok := user.Proxies["vmess"]; !ok || len(user.Proxies) != 1 {
+		t.Errorf("expected only vmess proxy, got %v", user.Proxies)
+	}
+}
+
+func TestCreateVPNForSubscriptionAppliesUserTemplate(t *testing.T) {
+	h := newVPNHarness(t)
+	templateLimit := 20 * gigabyte
+	templateID := h.server.PutUserTemplate(core.MarzbanUserTemplate{
+		DataLimit: &templateLimit,
+		Inbounds:  map[string][]string{"vless": {"VLESS TCP REALITY"}},
+	})
+	h.plan.MarzbanTemplateID = templateID
+	h.plan.Protocols = []string{"vmess"}
+	h.addSubscription(t, "sub-1", time.Now().Add(24*time.Hour))
+
+	conn := h.createVPN(t, "sub-1")
+
+	user, _ := h.server.User(conn.MarzbanUsername)
+	if user.DataLimit == nil || *user.DataLimit != templateLimit {
+		t.Errorf("expected template data limit, got %v", user.DataLimit)
+	}
+	if _, ok := user.Proxies["vless"]; !ok || len(user.Proxies) != 1 {
+		t.Errorf("expected template vless proxy, got %v", user.Proxies)
+	}
+	if got := h.server.RequestCount("GET /api/user_template/{template_id}"); got != 1 {
+		t.Errorf("expected template to be fetched once, got %d", got)
+	}
+}
+
+func TestCreateVPNForSubscriptionRelogsInAfterTokenExpiry(t *testing.T) {
+	h := newVPNHarness(t)
+	h.addSubscription(t, "sub-1", time.Now().Add(24*time.Hour))
+	h.addSubscription(t, "sub-2", time.Now().Add(24*time.Hour))
+
+	h.createVPN(t, "sub-1")
+	h.server.ExpireTokens()
+	h.createVPN(t, "sub-2")
+
+	if got := h.server.RequestCount("POST /api/admin/token"); got != 2 {
+		t.Errorf("expected 2 logins, got %d", got)
+	}
+	if got := len(h.server.Users()); got != 2 {
+		t.Errorf("expected 2 panel users, got %d", got)
+	}
+}
+
+func TestCreateVPNForSubscriptionReplaysRejectedToken(t *testing.T) {
+	h := newVPNHarness(t)
+	h.addSubscription(t, "sub-1", time.Now().Add(24*time.Hour))
+	h.server.InjectFault("POST /api/user", http.StatusUnauthorized, 1)
+
+	conn := h.createVPN(t, "sub-1")
+
+	if _, ok := h.server.User(conn.MarzbanUsername); !ok {
+		t.Fatalf("panel user %s was not created after replay", conn.MarzbanUsername)
+	}
+	if got := h.server.RequestCount("POST /api/user"); got != 2 {
+		t.Errorf("expected create to be replayed once, got %d requests", got)
+	}
+}
+
+func TestCreateVPNForSubscriptionRetriesInboundsOnServerError(t *testing.T) {
+	h := newVPNHarness(t)
+	h.addSubscription(t, "sub-1", time.Now().Add(24*time.Hour))
+	h.server.InjectFault("GET /api/inbounds", http.StatusBadGateway, 2)
+
+	conn := h.createVPN(t, "sub-1")
+
+	if got := h.server.RequestCount("GET /api/inbounds"); got != 3 {
+		t.Errorf("expected 3 inbound requests, got %d", got)
+	}
+	user, _ := h.server.User(conn.MarzbanUsername)
+	if len(user.Inbounds["vless"]) == 0 {
+		t.Errorf("expected inbounds after retry, got %v", user.Inbounds)
+	}
+}
+
+func TestCreateVPNForSubscriptionPanelUnavailable(t *testing.T) {
+	h := newVPNHarness(t)
+	h.addSubscription(t, "sub-1", time.Now().Add(24*time.Hour))
+	h.server.InjectFault("POST /api/user", http.StatusServiceUnavailable, 1)
+
+	_, err := h.uc.CreateVPNForSubscription(context.Background(), testUserID, "sub-1")
+	if !errors.Is(err, usecase.ErrPanelUnavailable) {
+		t.Fatalf("expected ErrPanelUnavailable, got %v", err)
+	}
+
+	connections, _ := h.vpnRepo.GetVPNConnectionsBySubscriptionID(context.Background(), "sub-1")
+	if len(connections) != 0 {
+		t.Errorf("expected no stored connections, got %d", len(connections))
+	}
+	if got := len(h.server.Users()); got != 0 {
+		t.Errorf("expected no panel users, got %d", got)
+	}
+}
+
+func TestGetUserVPNWithStats(t *testing.T) {
+	h := newVPNHarness(t)
+	h.addSubscription(t, "sub-1", time.Now().Add(24*time.Hour))
+	conn := h.createVPN(t, "sub-1")
+
+	if err := h.server.AddUsage(conn.MarzbanUsername, "", 5*gigabyte); err != nil {
+		t.Fatalf("failed to add usage: %v", err)
+	}
+
+	connections, err := h.uc.GetUserVPNWithStats(context.Background(), testUserID)
+	if err != nil {
+		t.Fatalf("GetUserVPNWithStats returned error: %v", err)
+	}
+	if len(connections) != 1 {
+		t.Fatalf("expected 1 connection, got %d", len(connections))
+	}
+
+	got := connections[0]
+	if got.DataUsedBytes == nil || *got.DataUsedBytes != 5*gigabyte {
+		t.Errorf("expected 5GB used, got %v", got.DataUsedBytes)
+	}
+	if got.DataLimitBytes == nil || *got.DataLimitBytes <= 0 {
+		t.Errorf("expected data limit, got %v", got.DataLimitBytes)
+	}
+	if got.Status != string(core.PanelUserStatusActive) || !got.IsActive {
+		t.Errorf("expected active connection, got status %q active %v", got.Status, got.IsActive)
+	}
+	if got.ExpireAt == nil {
+		t.Errorf("expected expire date from panel")
+	}
+}
+
+func TestGetSubscriptionVPNWithStatsResolvesShareLinks(t *testing.T) {
+	h := newVPNHarness(t)
+	h.plan.Protocols = []string{"vless", "vmess"}
+	h.addSubscription(t, "sub-1", time.Now().Add(24*time.Hour))
+	h.createVPN(t, "sub-1")
+
+	connections, err := h.uc.GetSubscriptionVPNWithStats(context.Background(), "sub-1")
+	if err != nil {
+		t.Fatalf("GetSubscriptionVPNWithStats returned error: %v", err)
+	}
+	if len(connections) != 1 {
+		t.Fatalf("expected 1 connection, got %d", len(connections))
+	}
+
+	got := connections[0]
+	if !strings.HasPrefix(got.SubscriptionURL, h.server.URL+"/sub/") {
+		t.Errorf("expected absolute subscription url on panel host, got %q", got.SubscriptionURL)
+	}
+	schemes := make(map[string]bool)
+	for _, link := range got.Links {
+		scheme, _, _ := strings.Cut(link, "://")
+		schemes[scheme] = true
+	}
+	if !schemes["vless"] || !schemes["vmess"] {
+		t.Errorf("expected vless and vmess share links, got %v", got.Links)
+	}
+}
+
+func TestGetUserVPNWithStatsLimitedUser(t *testing.T) {
+	h := newVPNHarness(t)
+	h.addSubscription(t, "sub-1", time.Now().Add(24*time.Hour))
+	conn := h.createVPN(t, "sub-1")
+
+	user, _ := h.server.User(conn.MarzbanUsername)
+	if err := h.server.AddUsage(conn.MarzbanUsername, "", *user.DataLimit); err != nil {
+		t.Fatalf("failed to add usage: %v", err)
+	}
+
+	connections, err := h.uc.GetUserVPNWithStats(context.Background(), testUserID)
+	if err != nil {
+		t.Fatalf("GetUserVPNWithStats returned error: %v", err)
+	}
+	if got := connections[0].Status; got != string(core.MarzbanUserStatusLimited) {
+		t.Errorf("expected limited status, got %q", got)
+	}
+}
+
+func TestGetUserVPNWithStatsMissingPanelUser(t *testing.T) {
+	h := newVPNHarness(t)
+	h.addSubscription(t, "sub-1", time.Now().Add(24*time.Hour))
+	h.createVPN(t, "sub-1")
+
+	orphan := &core.VPNConnection{
+		ID:              "orphan",
+		TelegramUserID:  testUserID,
+		SubscriptionID:  "sub-1",
+		MarzbanUsername: "user_42_missing",
+		ServerName:      testServerName,
+		IsActive:        true,
+		CreatedAt:       time.Now().Add(time.Minute),
+	}
+	if err := h.vpnRepo.CreateVPNConnection(context.Background(), orphan); err != nil {
+		t.Fatalf("failed to store orphan connection: %v", err)
+	}
+
+	connections, err := h.uc.GetUserVPNWithStats(context.Background(), testUserID)
+	if err != nil {
+		t.Fatalf("GetUserVPNWithStats returned error: %v", err)
+	}
+	if len(connections) != 2 {
+		t.Fatalf("expected 2 connections, got %d", len(connections))
+	}
+	if !connections[0].IsActive {
+		t.Errorf("expected provisioned connection to stay active")
+	}
+	if connections[1].IsActive {
+		t.Errorf("expected connection without panel user to be inactive")
+	}
+}
+
+func TestGetUserVPNWithStatsSlowPanel(t *testing.T) {
+	h := newVPNHarness(t)
+	h.addSubscription(t, "sub-1", time.Now().Add(24*time.Hour))
+	h.createVPN(t, "sub-1")
+	h.server.SetLatency(time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	connections, err := h.uc.GetUserVPNWithStats(ctx, testUserID)
+	if err != nil {
+		t.Fatalf("GetUserVPNWithStats returned error: %v", err)
+	}
+	if connections[0].IsActive || connections[0].DataUsedBytes != nil {
+		t.Errorf("expected connection without stats when panel times out, got %+v", connections[0])
+	}
+}
+
+func TestDeleteVPNConnectionFull(t *testing.T) {
+	h := newVPNHarness(t)
+	h.addSubscription(t, "sub-1", time.Now().Add(24*time.Hour))
+	conn := h.createVPN(t, "sub-1")
+
+	if err := h.uc.DeleteVPNConnectionFull(context.Background(), conn.ID); err != nil {
+		t.Fatalf("DeleteVPNConnectionFull returned error: %v", err)
+	}
+
+	if _, ok := h.server.User(conn.MarzbanUsername); ok {
+		t.Errorf("expected panel user to be deleted")
+	}
+	if _, err := h.vpnRepo.GetVPNConnectionByID(context.Background(), conn.ID); !errors.Is(err, usecase.ErrNotFound) {
+		t.Errorf("expected connection to be deleted, got %v", err)
+	}
+}
+
+func TestDeleteVPNConnectionFullMissingPanelUser(t *testing.T) {
+	h := newVPNHarness(t)
+	h.addSubscription(t, "sub-1", time.Now().Add(24*time.Hour))
+	conn := h.createVPN(t, "sub-1")
+
+	h.server.RemoveUser(conn.MarzbanUsername)
+
+	if err := h.uc.DeleteVPNConnectionFull(context.Background(), conn.ID); err != nil {
+		t.Fatalf("DeleteVPNConnectionFull returned error: %v", err)
+	}
+
+	if _, err := h.vpnRepo.GetVPNConnectionByID(context.Background(), conn.ID); !errors.Is(err, usecase.ErrNotFound) {
+		t.Errorf("expected connection to be deleted, got %v", err)
+	}
+}
+
+func TestDeleteVPNConnectionFullPanelError(t *testing.T) {
+	h := newVPNHarness(t)
+	h.addSubscription(t, "sub-1", time.Now().Add(24*time.Hour))
+	conn := h.createVPN(t, "sub-1")
+	h.server.InjectFault("DELETE /api/user/{username}", http.StatusInternalServerError, 3)
+
+	err := h.uc.DeleteVPNConnectionFull(context.Background(), conn.ID)
+	if !errors.Is(err, usecase.ErrPanelUnavailable) {
+		t.Fatalf("expected ErrPanelUnavailable, got %v", err)
+	}
+
+	if _, ok := h.server.User(conn.MarzbanUsername); !ok {
+		t.Errorf("expected panel user to survive failed delete")
+	}
+	if _, err := h.vpnRepo.GetVPNConnectionByID(context.Background(), conn.ID); err != nil {
+		t.Errorf("expected connection to be kept after panel error, got %v", err)
+	}
+}
+
+func TestMoveVPNConnectionCarriesLimitAndStatus(t *testing.T) {
+	cases := []struct {
+		name       string
+		strategy   core.DataLimitResetStrategy
+		used       int64
+		wantLimit  int64
+		wantStatus core.MarzbanUserStatus
+	}{
+		{"monthly plan keeps full cap", core.DataLimitResetMonth, 60 * gigabyte, 100 * gigabyte, core.MarzbanUserStatusActive},
+		{"one-off plan carries remaining traffic", core.DataLimitResetNone, 60 * gigabyte, 40 * gigabyte, core.MarzbanUserStatusActive},
+		{"limited user stays limited", core.DataLimitResetMonth, 100 * gigabyte, 100 * gigabyte, core.MarzbanUserStatusLimited},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newVPNHarness(t)
+			ctx := context.Background()
+			h.plan.DataLimitResetStrategy = tc.strategy
+			target := h.addServer(t, core.PanelServer{Name: "second", Weight: 1})
+
+			h.addSubscription(t, "sub-1", time.Now().Add(30*24*time.Hour))
+			conn := h.createVPN(t, "sub-1")
+			if err := h.server.AddUsage(conn.MarzbanUsername, "", tc.used); err != nil {
+				t.Fatalf("failed to add usage: %v", err)
+			}
+
+			if _, err := h.uc.MoveVPNConnection(ctx, testUserID, conn.ID, "second"); err != nil {
+				t.Fatalf("MoveVPNConnection returned error: %v", err)
+			}
+
+			moved, ok := target.User(conn.MarzbanUsername)
+			if !ok {
+				t.Fatalf("expected user on the target server")
+			}
+			if moved.DataLimit == nil || *moved.DataLimit != tc.wantLimit {
+				t.Errorf("expected data limit %d, got %v", tc.wantLimit, moved.DataLimit)
+			}
+			if moved.Status != string(tc.wantStatus) {
+				t.Errorf("expected status %s, got %s", tc.wantStatus, moved.Status)
+			}
+			if _, ok := h.server.User(conn.MarzbanUsername); ok {
+				t.Errorf("expected user to be removed from the old server")
+			}
+		})
+	}
+}
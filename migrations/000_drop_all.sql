@@ -4,11 +4,18 @@
 -- Этот файл удаляет все таблицы для чистой миграции
 
 -- Удаляем таблицы в обратном порядке (из-за foreign key constraints)
+DROP TABLE IF EXISTS scheduler_locks CASCADE;
 DROP TABLE IF EXISTS notifications CASCADE;
 DROP TABLE IF EXISTS referral_links CASCADE;
 DROP TABLE IF EXISTS referrals CASCADE;
 DROP TABLE IF EXISTS vpn_connections CASCADE;
+DROP TABLE IF EXISTS payment_refunds CASCADE;
+DROP TABLE IF EXISTS balance_transactions CASCADE;
+DROP TABLE IF EXISTS saved_payment_methods CASCADE;
+DROP TABLE IF EXISTS promo_code_usages CASCADE;
 DROP TABLE IF EXISTS payments CASCADE;
+DROP TABLE IF EXISTS promo_codes CASCADE;
+DROP TABLE IF EXISTS subscription_reminders CASCADE;
 DROP TABLE IF EXISTS subscriptions CASCADE;
 DROP TABLE IF EXISTS plans CASCADE;
 DROP TABLE IF EXISTS users CASCADE;
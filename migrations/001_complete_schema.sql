@@ -6,6 +6,7 @@ CREATE TABLE IF NOT EXISTS users (
     language_code VARCHAR(10),
     is_blocked BOOLEAN DEFAULT FALSE,
     has_trial BOOLEAN DEFAULT FALSE, -- Использовал ли пользователь пробный период
+    email VARCHAR(255), -- Email для отправки фискальных чеков
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
@@ -15,8 +16,15 @@ CREATE TABLE IF NOT EXISTS plans (
     name VARCHAR(255) NOT NULL,
     description TEXT,
     price DECIMAL(10,2) NOT NULL,
+    stars_price INTEGER NOT NULL DEFAULT 0, -- Цена в Telegram Stars (0 - оплата Stars недоступна)
     days INTEGER NOT NULL,
     is_active BOOLEAN DEFAULT TRUE,
+    traffic_limit_gb INTEGER NOT NULL DEFAULT 100, -- Лимит трафика в ГБ (0 - безлимит)
+    data_limit_reset_strategy VARCHAR(20) NOT NULL DEFAULT 'no_reset', -- no_reset, day, week, month, year
+    protocols TEXT[] NOT NULL DEFAULT '{vless}', -- Пустой массив - все протоколы панели
+    inbound_tags TEXT[] NOT NULL DEFAULT '{}', -- Пустой массив - все inbound'ы разрешенных протоколов
+    device_limit INTEGER NOT NULL DEFAULT 0, -- Лимит одновременных IP (0 - без ограничений)
+    marzban_template_id INTEGER, -- ID user_template в Marzban (NULL - не использовать)
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
@@ -29,6 +37,38 @@ CREATE TABLE IF NOT EXISTS subscriptions (
     start_date TIMESTAMP WITH TIME ZONE NOT NULL,
     end_date TIMESTAMP WITH TIME ZONE NOT NULL,
     is_active BOOLEAN DEFAULT TRUE,
+    auto_renew BOOLEAN NOT NULL DEFAULT FALSE, -- Автопродление включено пользователем
+    renewal_attempts INTEGER NOT NULL DEFAULT 0, -- Неудачные попытки автопродления подряд
+    next_renewal_at TIMESTAMP WITH TIME ZONE, -- Не раньше этого времени повторить попытку
+    status VARCHAR(20) NOT NULL DEFAULT 'active', -- Состояние жизненного цикла: active, grace, suspended, expired, inactive
+    grace_ends_at TIMESTAMP WITH TIME ZONE, -- Окончание льготного периода (ключи работают до этого времени)
+    suspended_at TIMESTAMP WITH TIME ZONE, -- Когда подписка приостановлена после льготного периода
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Отправленные напоминания об окончании подписки (защита от повторной отправки)
+CREATE TABLE IF NOT EXISTS subscription_reminders (
+    subscription_id VARCHAR(50) NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    offset_hours INTEGER NOT NULL, -- За сколько часов до окончания отправлено напоминание (0 и меньше - в льготный период)
+    end_date TIMESTAMP WITH TIME ZONE NOT NULL, -- Дата окончания, о которой напомнили
+    sent_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (subscription_id, offset_hours, end_date)
+);
+
+-- Промокоды (скидка в процентах или фиксированной суммой, бонусные дни)
+CREATE TABLE IF NOT EXISTS promo_codes (
+    id VARCHAR(50) PRIMARY KEY,
+    code VARCHAR(64) NOT NULL UNIQUE, -- Код в верхнем регистре
+    discount_type VARCHAR(20) NOT NULL DEFAULT 'percent', -- percent или fixed
+    discount_value DECIMAL(10,2) NOT NULL DEFAULT 0,
+    bonus_days INTEGER NOT NULL DEFAULT 0,
+    max_uses INTEGER NOT NULL DEFAULT 0, -- 0 - без ограничений
+    max_uses_per_user INTEGER NOT NULL DEFAULT 1, -- 0 - без ограничений
+    used_count INTEGER NOT NULL DEFAULT 0,
+    plan_ids TEXT[] NOT NULL DEFAULT '{}', -- Пустой список - действует на все тарифы
+    expires_at TIMESTAMP WITH TIME ZONE,
+    is_active BOOLEAN DEFAULT TRUE,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
@@ -36,15 +76,67 @@ CREATE TABLE IF NOT EXISTS subscriptions (
 CREATE TABLE IF NOT EXISTS payments (
     id VARCHAR(50) PRIMARY KEY,
     user_id BIGINT NOT NULL REFERENCES users(telegram_id) ON DELETE CASCADE,
+    plan_id VARCHAR(50) REFERENCES plans(id), -- Оплачиваемый тариф
+    subscription_id VARCHAR(50) REFERENCES subscriptions(id) ON DELETE SET NULL, -- Подписка, созданная платежом
     amount DECIMAL(10,2) NOT NULL,
     currency VARCHAR(3) DEFAULT 'RUB',
     payment_method VARCHAR(255),
+    purpose VARCHAR(20) NOT NULL DEFAULT 'subscription', -- subscription, extension или topup
+    external_id VARCHAR(255) UNIQUE, -- ID платежа у платежного провайдера
+    idempotency_key VARCHAR(64) UNIQUE, -- Ключ идемпотентности запросов к провайдеру
+    promo_code_id VARCHAR(50) REFERENCES promo_codes(id) ON DELETE SET NULL, -- Примененный промокод
+    discount_amount DECIMAL(10,2) NOT NULL DEFAULT 0, -- Скидка по промокоду
+    bonus_days INTEGER NOT NULL DEFAULT 0, -- Бонусные дни по промокоду
     description TEXT,
     status VARCHAR(50) DEFAULT 'pending',
+    receipt_email VARCHAR(255), -- Email покупателя, указанный в чеке
+    receipt_status VARCHAR(20), -- pending, succeeded, canceled (NULL - чек не формировался)
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Использования промокодов (фиксируются после успешной оплаты)
+CREATE TABLE IF NOT EXISTS promo_code_usages (
+    id VARCHAR(50) PRIMARY KEY,
+    promo_code_id VARCHAR(50) NOT NULL REFERENCES promo_codes(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(telegram_id) ON DELETE CASCADE,
+    payment_id VARCHAR(50) NOT NULL UNIQUE REFERENCES payments(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Сохраненные способы оплаты для автопродления (один на пользователя)
+CREATE TABLE IF NOT EXISTS saved_payment_methods (
+    user_id BIGINT PRIMARY KEY REFERENCES users(telegram_id) ON DELETE CASCADE,
+    provider_method_id VARCHAR(255) NOT NULL, -- ID способа оплаты у платежного провайдера
+    method VARCHAR(50) NOT NULL, -- card, sbp
+    title VARCHAR(255) NOT NULL DEFAULT '', -- Маска карты для показа пользователю
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Журнал операций по балансу (баланс = сумма всех операций пользователя)
+CREATE TABLE IF NOT EXISTS balance_transactions (
+    id VARCHAR(50) PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(telegram_id) ON DELETE CASCADE,
+    amount DECIMAL(10,2) NOT NULL, -- Положительная - зачисление, отрицательная - списание
+    type VARCHAR(30) NOT NULL, -- topup, payment, referral_reward, refund, traffic_addon
+    payment_id VARCHAR(50) REFERENCES payments(id) ON DELETE SET NULL,
+    description TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Журнал возвратов (кто, сколько и почему вернул)
+CREATE TABLE IF NOT EXISTS payment_refunds (
+    id VARCHAR(50) PRIMARY KEY,
+    payment_id VARCHAR(50) NOT NULL REFERENCES payments(id) ON DELETE CASCADE,
+    admin_id BIGINT NOT NULL, -- Telegram ID администратора
+    amount DECIMAL(10,2) NOT NULL,
+    reason TEXT NOT NULL,
+    external_refund_id VARCHAR(255), -- ID возврата у платежного провайдера
+    status VARCHAR(20) NOT NULL DEFAULT 'succeeded', -- pending, succeeded
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
 -- =============================================================================
 -- VPN ПОДКЛЮЧЕНИЯ (MARZBAN)
 -- =============================================================================
@@ -53,9 +145,14 @@ CREATE TABLE IF NOT EXISTS payments (
 CREATE TABLE IF NOT EXISTS vpn_connections (
     id VARCHAR(50) PRIMARY KEY,
     telegram_user_id BIGINT NOT NULL REFERENCES users(telegram_id) ON DELETE CASCADE,
+    subscription_id VARCHAR(50) REFERENCES subscriptions(id) ON DELETE SET NULL, -- Подписка, к которой относится ключ
     marzban_username VARCHAR(100) NOT NULL UNIQUE, -- Username в Marzban
+    server_name VARCHAR(64), -- Сервер (панель) из реестра, на котором создан ключ (NULL - сервер по умолчанию)
     name VARCHAR(255), -- Локальное имя подключения
     is_active BOOLEAN DEFAULT TRUE, -- Флаг активности в нашей системе
+    traffic_warning_percent INTEGER NOT NULL DEFAULT 0, -- Последний порог трафика, о котором предупредили (100 - лимит исчерпан)
+    traffic_addon_bytes BIGINT NOT NULL DEFAULT 0, -- Докупленный трафик, добавленный к лимиту ключа в панели
+    traffic_addon_expires_at TIMESTAMP WITH TIME ZONE, -- Когда докупленный трафик сгорает (ближайшее обнуление; NULL - до конца подписки)
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
@@ -98,6 +195,19 @@ CREATE TABLE IF NOT EXISTS notifications (
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
 
+-- =============================================================================
+-- ПЛАНИРОВЩИК
+-- =============================================================================
+
+-- Аренда фоновых задач (одна реплика бота выполняет задачу за тик)
+CREATE TABLE IF NOT EXISTS scheduler_locks (
+    job_name VARCHAR(100) PRIMARY KEY,
+    holder VARCHAR(255) NOT NULL, -- Идентификатор реплики, удерживающей аренду
+    locked_until TIMESTAMP WITH TIME ZONE NOT NULL, -- После этого времени аренду может забрать другая реплика
+    last_run_at TIMESTAMP WITH TIME ZONE, -- Время последнего запуска задачи любой репликой
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
 -- =============================================================================
 -- ИНДЕКСЫ
 -- =============================================================================
@@ -109,15 +219,28 @@ CREATE TABLE IF NOT EXISTS notifications (
 CREATE INDEX IF NOT EXISTS idx_subscriptions_user_id ON subscriptions(user_id);
 CREATE INDEX IF NOT EXISTS idx_subscriptions_plan_id ON subscriptions(plan_id);
 CREATE INDEX IF NOT EXISTS idx_subscriptions_active ON subscriptions(user_id, is_active, end_date);
+CREATE INDEX IF NOT EXISTS idx_subscriptions_auto_renew ON subscriptions(end_date) WHERE auto_renew = TRUE;
+CREATE INDEX IF NOT EXISTS idx_subscriptions_expiry ON subscriptions(end_date) WHERE is_active = TRUE;
+CREATE INDEX IF NOT EXISTS idx_subscriptions_suspended ON subscriptions(suspended_at) WHERE status = 'suspended';
+CREATE INDEX IF NOT EXISTS idx_subscription_reminders_end_date ON subscription_reminders(end_date);
 
 -- Индексы для платежей
 CREATE INDEX IF NOT EXISTS idx_payments_user_id ON payments(user_id);
 CREATE INDEX IF NOT EXISTS idx_payments_status ON payments(status);
+CREATE INDEX IF NOT EXISTS idx_payments_status_created_at ON payments(status, created_at);
+CREATE INDEX IF NOT EXISTS idx_payments_subscription_id ON payments(subscription_id);
+CREATE INDEX IF NOT EXISTS idx_payment_refunds_payment_id ON payment_refunds(payment_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payment_refunds_pending ON payment_refunds(payment_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_balance_transactions_user_id ON balance_transactions(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_promo_code_usages_promo_user ON promo_code_usages(promo_code_id, user_id);
+CREATE INDEX IF NOT EXISTS idx_payments_pending_promo ON payments(promo_code_id, user_id) WHERE status IN ('pending', 'waiting_for_capture');
 
 -- Индексы для VPN подключений
 CREATE INDEX IF NOT EXISTS idx_vpn_connections_telegram_user_id ON vpn_connections(telegram_user_id);
+CREATE INDEX IF NOT EXISTS idx_vpn_connections_subscription_id ON vpn_connections(subscription_id);
 CREATE INDEX IF NOT EXISTS idx_vpn_connections_marzban_username ON vpn_connections(marzban_username);
 CREATE INDEX IF NOT EXISTS idx_vpn_connections_is_active ON vpn_connections(is_active);
+CREATE INDEX IF NOT EXISTS idx_vpn_connections_server_name ON vpn_connections(server_name);
 
 -- Индексы для рефералов
 CREATE INDEX IF NOT EXISTS idx_referrals_referrer_id ON referrals(referrer_id);
@@ -128,6 +251,7 @@ CREATE INDEX IF NOT EXISTS idx_referral_links_link ON referral_links(link);
 -- Индексы для уведомлений
 CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications(user_id);
 CREATE INDEX IF NOT EXISTS idx_notifications_is_read ON notifications(is_read);
+CREATE INDEX IF NOT EXISTS idx_notifications_read_created_at ON notifications(created_at) WHERE is_read = TRUE;
 
 -- =============================================================================
 -- КОММЕНТАРИИ
@@ -137,32 +261,81 @@ CREATE INDEX IF NOT EXISTS idx_notifications_is_read ON notifications(is_read);
 COMMENT ON TABLE users IS 'Пользователи Telegram';
 COMMENT ON TABLE plans IS 'Тарифные планы подписок';
 COMMENT ON TABLE subscriptions IS 'Подписки пользователей';
+COMMENT ON TABLE subscription_reminders IS 'Отправленные напоминания об окончании подписки (после продления end_date меняется, и напоминания отправляются заново)';
 COMMENT ON TABLE payments IS 'Платежи пользователей';
+COMMENT ON TABLE payment_refunds IS 'Журнал возвратов по платежам';
+COMMENT ON TABLE saved_payment_methods IS 'Сохраненные у платежного провайдера способы оплаты для автопродления';
+COMMENT ON TABLE balance_transactions IS 'Журнал операций по внутреннему балансу пользователей';
+COMMENT ON TABLE promo_codes IS 'Промокоды и скидочные купоны';
+COMMENT ON TABLE promo_code_usages IS 'Использования промокодов пользователями';
 COMMENT ON TABLE vpn_connections IS 'VPN подключения пользователей в Marzban (только связи и локальные данные)';
 COMMENT ON TABLE referrals IS 'Реферальные связи между пользователями';
 COMMENT ON TABLE referral_links IS 'Реферальные ссылки пользователей';
 COMMENT ON TABLE notifications IS 'Уведомления для пользователей';
+COMMENT ON TABLE scheduler_locks IS 'Аренда фоновых задач планировщика; истекшую аренду упавшей реплики забирает другая';
 
 -- Комментарии к ключевым полям
 COMMENT ON COLUMN users.telegram_id IS 'Уникальный ID пользователя в Telegram';
 COMMENT ON COLUMN users.has_trial IS 'Использовал ли пользователь пробный период (ограничение: один раз)';
+COMMENT ON COLUMN users.email IS 'Email пользователя для фискальных чеков (54-ФЗ)';
 COMMENT ON COLUMN users.created_at IS 'Дата создания аккаунта пользователя';
 
 COMMENT ON COLUMN plans.price IS 'Цена плана в рублях';
+COMMENT ON COLUMN plans.stars_price IS 'Цена плана в Telegram Stars (XTR)';
 COMMENT ON COLUMN plans.days IS 'Количество дней действия плана';
+COMMENT ON COLUMN plans.traffic_limit_gb IS 'Лимит трафика пользователя панели в ГБ (0 - безлимит)';
+COMMENT ON COLUMN plans.data_limit_reset_strategy IS 'Стратегия сброса трафика в панели';
+COMMENT ON COLUMN plans.protocols IS 'Протоколы, которые выдаются пользователю панели';
+COMMENT ON COLUMN plans.inbound_tags IS 'Теги inbound, доступные по плану';
+COMMENT ON COLUMN plans.device_limit IS 'Лимит IP/устройств (поддерживается только 3x-ui)';
+COMMENT ON COLUMN plans.marzban_template_id IS 'Шаблон пользователя Marzban, переопределяет лимит трафика и inbound';
 
 COMMENT ON COLUMN subscriptions.name IS 'Название подписки (задается пользователем)';
 COMMENT ON COLUMN subscriptions.start_date IS 'Дата начала подписки';
 COMMENT ON COLUMN subscriptions.end_date IS 'Дата окончания подписки';
+COMMENT ON COLUMN subscriptions.auto_renew IS 'Списывать оплату автоматически перед окончанием подписки';
+COMMENT ON COLUMN subscriptions.renewal_attempts IS 'Количество неудачных попыток автопродления подряд';
+COMMENT ON COLUMN subscriptions.next_renewal_at IS 'Время следующей попытки автопродления после неудачи (NULL - по расписанию)';
+COMMENT ON COLUMN subscriptions.status IS 'Состояние: active - действует, grace - льготный период после окончания, suspended - ключи отключены, expired - пользователи панели удалены, inactive - отключена вручную';
+COMMENT ON COLUMN subscriptions.grace_ends_at IS 'Окончание льготного периода, до которого продлен срок пользователей панели';
+COMMENT ON COLUMN subscriptions.suspended_at IS 'Время приостановки; через заданное число дней пользователи панели удаляются';
 
 COMMENT ON COLUMN payments.amount IS 'Сумма платежа в рублях';
 COMMENT ON COLUMN payments.currency IS 'Валюта платежа';
-COMMENT ON COLUMN payments.status IS 'Статус платежа: pending, completed, failed, cancelled';
+COMMENT ON COLUMN payments.status IS 'Статус платежа: pending, waiting_for_capture, completed, failed, cancelled, refunded, partially_refunded, expired';
+COMMENT ON COLUMN payments.external_id IS 'ID платежа у платежного провайдера (YooKassa) или telegram_payment_charge_id для Stars';
+COMMENT ON COLUMN payments.plan_id IS 'Тариф, который активируется после оплаты';
+COMMENT ON COLUMN payments.subscription_id IS 'Подписка, активированная этим платежом';
+COMMENT ON COLUMN payment_refunds.admin_id IS 'Telegram ID администратора, выполнившего возврат';
+COMMENT ON COLUMN payment_refunds.reason IS 'Причина возврата';
+COMMENT ON COLUMN payment_refunds.status IS 'pending - возврат записан, но провайдер еще не подтвердил; id записи используется как ключ идемпотентности';
+COMMENT ON COLUMN payments.idempotency_key IS 'Ключ идемпотентности для повторных запросов к провайдеру';
+COMMENT ON COLUMN payments.purpose IS 'Назначение платежа: subscription - новая подписка, extension - продление, topup - пополнение баланса';
+COMMENT ON COLUMN payments.promo_code_id IS 'Промокод, примененный к платежу';
+COMMENT ON COLUMN payments.discount_amount IS 'Размер скидки по промокоду (amount уже учитывает скидку)';
+COMMENT ON COLUMN payments.bonus_days IS 'Дополнительные дни подписки по промокоду';
+COMMENT ON COLUMN payments.receipt_email IS 'Email, на который отправлен фискальный чек';
+COMMENT ON COLUMN payments.receipt_status IS 'Статус регистрации чека у провайдера: pending, succeeded, canceled';
+
+COMMENT ON COLUMN saved_payment_methods.provider_method_id IS 'ID сохраненного способа оплаты у провайдера (payment_method.id в YooKassa)';
+
+COMMENT ON COLUMN balance_transactions.amount IS 'Сумма операции в рублях: положительная - зачисление, отрицательная - списание';
+COMMENT ON COLUMN balance_transactions.type IS 'Тип операции: topup, payment, referral_reward, refund, traffic_addon';
+
+COMMENT ON COLUMN promo_codes.discount_type IS 'Тип скидки: percent - процент от цены, fixed - фиксированная сумма в рублях';
+COMMENT ON COLUMN promo_codes.max_uses IS 'Общий лимит использований (0 - без ограничений)';
+COMMENT ON COLUMN promo_codes.max_uses_per_user IS 'Лимит использований на пользователя (0 - без ограничений)';
+COMMENT ON COLUMN promo_codes.plan_ids IS 'Тарифы, на которые действует промокод (пустой список - все тарифы)';
+COMMENT ON COLUMN promo_codes.expires_at IS 'Дата окончания действия промокода (NULL - бессрочно)';
 
 COMMENT ON COLUMN vpn_connections.telegram_user_id IS 'ID пользователя Telegram';
 COMMENT ON COLUMN vpn_connections.marzban_username IS 'Уникальный username в Marzban API';
+COMMENT ON COLUMN vpn_connections.server_name IS 'Имя сервера из реестра панелей, которому принадлежит пользователь';
 COMMENT ON COLUMN vpn_connections.name IS 'Локальное имя подключения для пользователя';
 COMMENT ON COLUMN vpn_connections.is_active IS 'Флаг активности подключения в нашей системе';
+COMMENT ON COLUMN vpn_connections.traffic_warning_percent IS 'Последний порог использования трафика (%), о котором уведомлен пользователь; сбрасывается после обнуления трафика';
+COMMENT ON COLUMN vpn_connections.traffic_addon_bytes IS 'Сколько докупленного трафика сейчас добавлено к лимиту ключа в панели';
+COMMENT ON COLUMN vpn_connections.traffic_addon_expires_at IS 'Момент ближайшего обнуления трафика, после которого докупленный трафик снимается с лимита; NULL - действует до конца подписки';
 
 COMMENT ON COLUMN referrals.referrer_id IS 'ID пользователя, который пригласил';
 COMMENT ON COLUMN referrals.referee_id IS 'ID пользователя, которого пригласили';
@@ -175,16 +348,17 @@ COMMENT ON COLUMN notifications.is_read IS 'Прочитано ли уведом
 -- =============================================================================
 
 -- Заполнение таблицы планов базовыми данными
-INSERT INTO plans (id, name, description, price, days, is_active) VALUES
-('plan_1w', '1 неделя', 'Пробная подписка на 1 неделю', 25.00, 7, true),
-('plan_1m', '1 месяц', 'Подписка на 1 месяц', 100.00, 30, true),
-('plan_3m', '3 месяца', 'Подписка на 3 месяца', 250.00, 90, true),
-('plan_6m', '6 месяцев', 'Подписка на 6 месяцев', 450.00, 180, true),
-('plan_12m', '12 месяцев', 'Подписка на 12 месяцев', 800.00, 365, true)
+INSERT INTO plans (id, name, description, price, stars_price, days, is_active) VALUES
+('plan_1w', '1 неделя', 'Пробная подписка на 1 неделю', 25.00, 15, 7, true),
+('plan_1m', '1 месяц', 'Подписка на 1 месяц', 100.00, 60, 30, true),
+('plan_3m', '3 месяца', 'Подписка на 3 месяца', 250.00, 150, 90, true),
+('plan_6m', '6 месяцев', 'Подписка на 6 месяцев', 450.00, 260, 180, true),
+('plan_12m', '12 месяцев', 'Подписка на 12 месяцев', 800.00, 460, 365, true)
 ON CONFLICT (id) DO UPDATE SET
     name = EXCLUDED.name,
     description = EXCLUDED.description,
     price = EXCLUDED.price,
+    stars_price = EXCLUDED.stars_price,
     days = EXCLUDED.days,
     is_active = EXCLUDED.is_active,
     updated_at = CURRENT_TIMESTAMP;
@@ -7,14 +7,20 @@
 -- DELETE FROM plans;
 
 -- Базовые планы подписки
-INSERT INTO plans (id, name, description, price, days, is_active, created_at, updated_at) VALUES
+INSERT INTO plans (id, name, description, price, stars_price, days, is_active, traffic_limit_gb, data_limit_reset_strategy, protocols, inbound_tags, device_limit, created_at, updated_at) VALUES
     (
         'trial',
         '🎁 Пробный период',
         'Бесплатный пробный период на 3 дня для новых пользователей',
         0.00,
+        0,
         3,
         true,
+        10,
+        'no_reset',
+        '{vless}',
+        '{}',
+        1,
         CURRENT_TIMESTAMP,
         CURRENT_TIMESTAMP
     ),
@@ -23,8 +29,14 @@ INSERT INTO plans (id, name, description, price, days, is_active, created_at, up
         '📅 Недельная подписка',
         '7 дней безлимитного VPN со скоростью до 100 Мбит/с',
         99.00,
+        60,
         7,
         true,
+        0,
+        'no_reset',
+        '{vless}',
+        '{}',
+        3,
         CURRENT_TIMESTAMP,
         CURRENT_TIMESTAMP
     ),
@@ -33,8 +45,14 @@ INSERT INTO plans (id, name, description, price, days, is_active, created_at, up
         '📅 Месячная подписка',
         '30 дней безлимитного VPN со скоростью до 100 Мбит/с',
         299.00,
+        170,
         30,
         true,
+        0,
+        'no_reset',
+        '{vless,vmess}',
+        '{}',
+        3,
         CURRENT_TIMESTAMP,
         CURRENT_TIMESTAMP
     ),
@@ -43,8 +61,14 @@ INSERT INTO plans (id, name, description, price, days, is_active, created_at, up
         '📆 Квартальная подписка',
         '90 дней VPN со скидкой 15% • Экономия 135₽',
         749.00,
+        420,
         90,
         true,
+        300,
+        'month',
+        '{vless,vmess}',
+        '{}',
+        5,
         CURRENT_TIMESTAMP,
         CURRENT_TIMESTAMP
     ),
@@ -53,8 +77,14 @@ INSERT INTO plans (id, name, description, price, days, is_active, created_at, up
         '🎯 Годовая подписка',
         '365 дней VPN со скидкой 30% • Экономия 1089₽ • Самое выгодное!',
         2499.00,
+        1400,
         365,
         true,
+        300,
+        'month',
+        '{vless,vmess}',
+        '{}',
+        5,
         CURRENT_TIMESTAMP,
         CURRENT_TIMESTAMP
     )
@@ -62,8 +92,14 @@ ON CONFLICT (id) DO UPDATE SET
     name = EXCLUDED.name,
     description = EXCLUDED.description,
     price = EXCLUDED.price,
+    stars_price = EXCLUDED.stars_price,
     days = EXCLUDED.days,
     is_active = EXCLUDED.is_active,
+    traffic_limit_gb = EXCLUDED.traffic_limit_gb,
+    data_limit_reset_strategy = EXCLUDED.data_limit_reset_strategy,
+    protocols = EXCLUDED.protocols,
+    inbound_tags = EXCLUDED.inbound_tags,
+    device_limit = EXCLUDED.device_limit,
     updated_at = CURRENT_TIMESTAMP;
 
 -- Вывод добавленных планов
@@ -71,7 +107,10 @@ SELECT
     id,
     name,
     price || ' ₽' as price,
+    stars_price || ' ⭐' as stars_price,
     days || ' дней' as duration,
+    CASE WHEN traffic_limit_gb = 0 THEN '∞' ELSE traffic_limit_gb || ' ГБ' END as traffic,
+    array_to_string(protocols, ', ') as protocols,
     CASE WHEN is_active THEN '✓' ELSE '✗' END as active
 FROM plans
 ORDER BY days ASC;